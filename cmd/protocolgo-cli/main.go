@@ -0,0 +1,22 @@
+// 只有命令行模式的 protocolgo, 不依赖图形界面, 可以在没有 cgo 和 X11 的环境(如 CI)中编译
+package main
+
+import (
+	"flag"
+	"os"
+	"protocolgo/src/cli"
+	"protocolgo/src/utils"
+)
+
+func main() {
+	logLevel := flag.String("loglevel", cli.DefaultLogLevel, "sets log level. trace/debug/info/warn/error/fatal/panic")
+	flag.Usage = func() {
+		cli.PrintUsage(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// 日志只输出到 stderr, 不影响命令在 stdout 的输出
+	utils.InitCliLogger(*logLevel)
+	os.Exit(cli.Run(flag.Args()))
+}
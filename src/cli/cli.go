@@ -0,0 +1,754 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"protocolgo/src/logic"
+	"protocolgo/src/model"
+	"protocolgo/src/utils"
+
+	"github.com/beevik/etree"
+	"github.com/sirupsen/logrus"
+)
+
+// 命令行退出码
+const (
+	ExitOk    = 0 // 成功
+	ExitFail  = 1 // 执行失败, 或 validate/diff 发现了问题
+	ExitUsage = 2 // 参数错误, diff 读取文件失败时也返回该值
+)
+
+// 命令行模式默认的日志级别, 日志输出到 stderr, 不影响命令在 stdout 的输出
+const DefaultLogLevel = "warn"
+
+// 子命令
+type stCommand struct {
+	Name  string
+	Usage string
+	Run   func(args []string) int
+}
+
+func getCommandList() []stCommand {
+	return []stCommand{
+		{"gen-proto", "gen-proto [-config file] [-xml file] [-out dir] [-syntax s] [-order o]  根据协议 xml 生成 proto 文件", RunGenProto},
+		{"gen-pb", "gen-pb [-config file] [-xml file] [-proto dir] [-out dir] [-mode m]  调用 protoc 或直接调用插件生成 pb 文件", RunGenPb},
+		{"gen-descriptor", "gen-descriptor [-config file] [-xml file] [-out file]  不调用 protoc 生成 FileDescriptorSet", RunGenDescriptor},
+		{"toolchain", "toolchain [-config file]                         检查 protoc 和插件的路径及版本", RunToolchain},
+		{"validate", "validate [-config file] [-xml file] [-syntax s] [-fix]   检查协议 xml 的合法性", RunValidate},
+		{"diff", "diff <old.xml> <new.xml>                         对比两个协议 xml 的差异", RunDiff},
+		{"compat", "compat [-safe] <old.xml> <new.xml>               检查两个协议 xml 的线上兼容性", RunCompat},
+		{"gen-dispatch", "gen-dispatch [-config file] [-xml file] [-out dir]  根据协议名前缀生成每个服务器的 go 分发代码", RunGenDispatch},
+		{"msgid", "msgid [-config file] [-xml file] [-check]       分配并检查协议的消息 ID", RunMsgId},
+		{"import", "import [-config file] [-xml file] [-out file] <proto file|dir>  将已有 proto 导入到协议 xml", RunImport},
+		{"rename", "rename [-config file] [-xml file] [-value v] [-dry-run] <type> <new name>  重命名类型或枚举值并修改所有引用", RunRename},
+		{"delete", "delete [-config file] [-xml file] [-cascade] [-replace t] [-dry-run] <type>  检查引用后删除单元或嵌套类型", RunDelete},
+	}
+}
+
+// 命令行入口, 返回进程退出码
+func Run(args []string) int {
+	if len(args) == 0 {
+		PrintUsage(os.Stderr)
+		return ExitUsage
+	}
+	for _, command := range getCommandList() {
+		if command.Name == args[0] {
+			return command.Run(args[1:])
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		PrintUsage(os.Stdout)
+		return ExitOk
+	}
+	fmt.Fprintln(os.Stderr, "unknown command:", args[0])
+	PrintUsage(os.Stderr)
+	return ExitUsage
+}
+
+// 打印用法
+func PrintUsage(writer io.Writer) {
+	fmt.Fprintln(writer, "Usage: protocolgo [-loglevel level] <command> [options]")
+	fmt.Fprintln(writer, "command:")
+	for _, command := range getCommandList() {
+		fmt.Fprintln(writer, "  "+command.Usage)
+	}
+}
+
+func getDefaultConfigPath() string {
+	return utils.GetWorkRootPath() + "/data/config.xml"
+}
+
+func getDefaultXmlPath() string {
+	return utils.GetWorkRootPath() + "/data/protocolgo.xml"
+}
+
+// 读取配置, 失败时返回 nil
+func loadConfig(filename string) *logic.CoreManager {
+	if !logic.PathExists(filename) {
+		logrus.Error("[cli] config file is not exist. filename:", filename)
+		return nil
+	}
+	coremgr := &logic.CoreManager{}
+	coremgr.Config = etree.NewDocument()
+	if err := coremgr.Config.ReadFromFile(filename); err != nil {
+		logrus.Error("[cli] read config failed. err:", err, ", filename:", filename)
+		return nil
+	}
+	coremgr.ConfigXmlFilePath = filename
+	return coremgr
+}
+
+// 读取协议 xml, 失败时返回 nil
+func loadSchema(filename string) *model.Schema {
+	schema, err := model.LoadSchemaFromFile(filename)
+	if err != nil {
+		logrus.Error("[cli] read xml failed. err:", err, ", filename:", filename)
+		return nil
+	}
+	return schema
+}
+
+// 保存协议 xml, 与界面中保存相同, 先按配置文件中的 backup 备份原文件, 再原子地写入
+func saveSchema(schema *model.Schema, filename string, strConfig string) bool {
+	data, err := schema.ToBytes()
+	if err != nil {
+		logrus.Error("[cli] save xml failed. err:", err, ", filename:", filename)
+		return false
+	}
+	backupConfig := logic.NewBackupConfig()
+	if logic.PathExists(strConfig) {
+		coremgr := loadConfig(strConfig)
+		if coremgr == nil {
+			return false
+		}
+		_, backupConfig = coremgr.GetBackupConfig()
+	}
+	if err := logic.SaveWithBackup(backupConfig, filename, data); err != nil {
+		logrus.Error("[cli] save xml failed. err:", err, ", filename:", filename)
+		return false
+	}
+	return true
+}
+
+// 读取生成配置, 配置文件不存在时使用默认配置. strSyntax 不为空时覆盖配置中的语法
+func loadGenConfig(filename string, strSyntax string) (logic.StGenConfig, bool) {
+	genConfig := logic.NewGenConfig()
+	if logic.PathExists(filename) {
+		coremgr := loadConfig(filename)
+		if coremgr == nil {
+			return genConfig, false
+		}
+		genConfig = coremgr.GetGenConfig()
+	}
+	if strSyntax != "" {
+		if !logic.IsValidSyntax(strSyntax) {
+			fmt.Fprintln(os.Stderr, "invalid syntax:", strSyntax, ", should be one of", logic.GetSyntaxList())
+			return genConfig, false
+		}
+		genConfig.Syntax = strSyntax
+	}
+	return genConfig, true
+}
+
+// 解析子命令参数, 不允许多余的位置参数
+func parseFlags(flagSet *flag.FlagSet, args []string, nArg int) bool {
+	if err := flagSet.Parse(args); err != nil {
+		return false
+	}
+	if flagSet.NArg() != nArg {
+		fmt.Fprintln(os.Stderr, "invalid arguments:", flagSet.Args())
+		flagSet.Usage()
+		return false
+	}
+	return true
+}
+
+// gen-proto: 根据协议 xml 生成 proto 文件
+func RunGenProto(args []string) int {
+	flagSet := flag.NewFlagSet("gen-proto", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	strOut := flagSet.String("out", "", "output dir of proto files, default is genproto in config")
+	strSyntax := flagSet.String("syntax", "", "proto2, proto3 or editions, default is syntax of genproto in config")
+	strOrder := flagSet.String("order", "", "xml, name or topo, default is order of genproto in config")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+
+	genConfig, isOk := loadGenConfig(*strConfig, *strSyntax)
+	if !isOk {
+		return ExitUsage
+	}
+	if *strOrder != "" {
+		if !logic.IsValidOrder(*strOrder) {
+			fmt.Fprintln(os.Stderr, "invalid order:", *strOrder, ", should be one of", logic.GetOrderList())
+			return ExitUsage
+		}
+		genConfig.Order = *strOrder
+	}
+	strProtoPath := *strOut
+	if strProtoPath == "" {
+		coremgr := loadConfig(*strConfig)
+		if coremgr == nil {
+			return ExitFail
+		}
+		isSuccess, strPath := coremgr.GetGenProtoPath()
+		if !isSuccess {
+			logrus.Error("[cli] gen-proto failed for GetGenProtoPath.")
+			return ExitFail
+		}
+		strProtoPath = strPath
+	}
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	// 配置了 msgid 时从锁文件读取消息 ID 并输出消息 ID 枚举, 不写锁文件
+	if logic.PathExists(*strConfig) {
+		coremgr := loadConfig(*strConfig)
+		if coremgr == nil {
+			return ExitFail
+		}
+		registry, errorList := coremgr.LoadMsgIds(schema, *strXml)
+		if len(errorList) > 0 {
+			for _, strError := range errorList {
+				fmt.Fprintln(os.Stderr, strError)
+			}
+			return ExitFail
+		}
+		if registry != nil {
+			_, msgIdConfig := coremgr.GetMsgIdConfig()
+			genConfig.MsgIdEnum = registry.ToEnum(msgIdConfig.EnumName)
+		}
+	}
+	if !logic.GenProto(schema, strProtoPath, genConfig) {
+		return ExitFail
+	}
+	fmt.Println("gen-proto done. output:", strProtoPath)
+	return ExitOk
+}
+
+// gen-pb: 调用 protoc 将 proto 文件生成 pb 文件, 找不到 protoc 或 mode 为 builtin 时由协议 xml 直接调用插件
+func RunGenPb(args []string) int {
+	flagSet := flag.NewFlagSet("gen-pb", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file, used when protoc is not used")
+	strProto := flagSet.String("proto", "", "input dir of proto files, default is genproto in config")
+	strOut := flagSet.String("out", "", "output dir of pb files, default is genpb in config")
+	strMode := flagSet.String("mode", "", "auto, protoc or builtin, default is mode of genpb in config")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+	if *strMode != "" && !logic.IsValidPbMode(*strMode) {
+		fmt.Fprintln(os.Stderr, "invalid mode:", *strMode, ", should be one of", logic.GetPbModeList())
+		return ExitUsage
+	}
+
+	strProtoPath := *strProto
+	strPbPath := *strOut
+	var pbConfig logic.StPbConfig
+	genConfig := logic.NewGenConfig()
+	var coremgr *logic.CoreManager
+	// 输入输出目录都已指定时, 配置文件可以不存在, 此时使用默认的 pb 配置
+	if strProtoPath == "" || strPbPath == "" || logic.PathExists(*strConfig) {
+		coremgr = loadConfig(*strConfig)
+		if coremgr == nil {
+			return ExitFail
+		}
+		pbConfig = coremgr.GetPbConfig()
+		if *strMode != "" {
+			pbConfig.Mode = *strMode
+		}
+		// 内置生成不读取 proto 文件
+		if strProtoPath == "" && pbConfig.Mode != logic.PbModeBuiltin {
+			isSuccess, strPath := coremgr.GetGenProtoPath()
+			if !isSuccess {
+				logrus.Error("[cli] gen-pb failed for GetGenProtoPath.")
+				return ExitFail
+			}
+			strProtoPath = strPath
+		}
+		if strPbPath == "" {
+			isSuccess, strPath := coremgr.GetGenPbPath()
+			if !isSuccess {
+				logrus.Error("[cli] gen-pb failed for GetGenPbPath.")
+				return ExitFail
+			}
+			strPbPath = strPath
+		}
+	}
+	if *strMode != "" {
+		pbConfig.Mode = *strMode
+	}
+	// 内置生成需要协议 xml, 输出与 gen-proto 一致
+	if pbConfig.Mode != logic.PbModeProtoc && logic.PathExists(*strXml) {
+		schema := loadSchema(*strXml)
+		if schema == nil {
+			return ExitFail
+		}
+		if coremgr != nil {
+			var errorList []string
+			genConfig, errorList = coremgr.GetGenConfigWithMsgId(schema, *strXml)
+			if len(errorList) > 0 {
+				for _, strError := range errorList {
+					fmt.Fprintln(os.Stderr, strError)
+				}
+				return ExitFail
+			}
+		}
+		pbConfig.Source = &logic.StPbSource{Schema: schema, GenConfig: genConfig}
+	}
+	// 工具版本过低时只提示, 找不到的工具在生成结果中报告
+	report := logic.CheckToolchain(pbConfig)
+	if report.GetLevel() != logic.ToolLevel_Ok {
+		fmt.Fprintln(os.Stderr, report.String())
+	}
+	isSuccess, resultList := logic.GenPbFromProto(strProtoPath, strPbPath, pbConfig)
+	fmt.Print(logic.GetPbResultText(resultList))
+	if !isSuccess {
+		fmt.Fprintln(os.Stderr, "gen-pb failed.")
+		return ExitFail
+	}
+	fmt.Println("gen-pb done. output:", strPbPath)
+	return ExitOk
+}
+
+// gen-descriptor: 由协议 xml 生成 FileDescriptorSet, 文件的拆分和选项与 gen-proto 一致
+func RunGenDescriptor(args []string) int {
+	flagSet := flag.NewFlagSet("gen-descriptor", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	strOut := flagSet.String("out", "", "output file, default is descriptorset in genpb output dir")
+	strSyntax := flagSet.String("syntax", "", "proto2, proto3 or editions, default is syntax of genproto in config")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+
+	genConfig, isOk := loadGenConfig(*strConfig, *strSyntax)
+	if !isOk {
+		return ExitUsage
+	}
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	strOutFile := *strOut
+	if logic.PathExists(*strConfig) {
+		coremgr := loadConfig(*strConfig)
+		if coremgr == nil {
+			return ExitFail
+		}
+		registry, errorList := coremgr.LoadMsgIds(schema, *strXml)
+		if len(errorList) > 0 {
+			for _, strError := range errorList {
+				fmt.Fprintln(os.Stderr, strError)
+			}
+			return ExitFail
+		}
+		if registry != nil {
+			_, msgIdConfig := coremgr.GetMsgIdConfig()
+			genConfig.MsgIdEnum = registry.ToEnum(msgIdConfig.EnumName)
+		}
+		if strOutFile == "" {
+			pbConfig := coremgr.GetPbConfig()
+			isSuccess, strPath := coremgr.GetGenPbPath()
+			if isSuccess && pbConfig.DescriptorSet != "" {
+				strOutFile = strPath + "/" + pbConfig.DescriptorSet
+			}
+		}
+	}
+	if strOutFile == "" {
+		fmt.Fprintln(os.Stderr, "output file is not set, use -out or descriptorset of genpb in config")
+		return ExitUsage
+	}
+	fileList, errorList := logic.BuildFileDescriptors(schema, genConfig)
+	if len(errorList) > 0 {
+		for _, strError := range errorList {
+			fmt.Fprintln(os.Stderr, strError)
+		}
+		return ExitFail
+	}
+	if !logic.WriteDescriptorSet(fileList, strOutFile) {
+		return ExitFail
+	}
+	fmt.Println("gen-descriptor done. output:", strOutFile)
+	return ExitOk
+}
+
+// gen-dispatch: 生成每个服务器的 go 分发代码
+func RunGenDispatch(args []string) int {
+	flagSet := flag.NewFlagSet("gen-dispatch", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, gendispatch and msgid are used")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	strOut := flagSet.String("out", "", "output dir of go files, default is gendispatch in config")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+
+	coremgr := loadConfig(*strConfig)
+	if coremgr == nil {
+		return ExitFail
+	}
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	isSuccess, fileNames, errorList := coremgr.GenDispatchFromSchema(schema, *strXml, *strOut)
+	for _, strError := range errorList {
+		fmt.Fprintln(os.Stderr, strError)
+	}
+	if !isSuccess {
+		return ExitFail
+	}
+	fmt.Println("gen-dispatch done. files:", strings.Join(fileNames, ", "))
+	return ExitOk
+}
+
+// toolchain: 输出 protoc 和插件的查找结果, 有找不到的工具时返回 ExitFail
+func RunToolchain(args []string) int {
+	flagSet := flag.NewFlagSet("toolchain", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, genpb is used")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+
+	var pbConfig logic.StPbConfig
+	if logic.PathExists(*strConfig) {
+		coremgr := loadConfig(*strConfig)
+		if coremgr == nil {
+			return ExitFail
+		}
+		pbConfig = coremgr.GetPbConfig()
+	}
+	report := logic.CheckToolchain(pbConfig)
+	fmt.Println(report.String())
+	if report.GetLevel() == logic.ToolLevel_Error {
+		return ExitFail
+	}
+	return ExitOk
+}
+
+// validate: 检查协议 xml, 有错误时返回 ExitFail
+func RunValidate(args []string) int {
+	flagSet := flag.NewFlagSet("validate", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, syntax of genproto is used")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	strSyntax := flagSet.String("syntax", "", "proto2, proto3 or editions, default is syntax of genproto in config")
+	isFix := flagSet.Bool("fix", false, "fix enum-zero and enum-value-scope problems and write the xml back")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+
+	genConfig, isOk := loadGenConfig(*strConfig, *strSyntax)
+	if !isOk {
+		return ExitUsage
+	}
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	diagList := logic.DiagnoseSchema(schema, genConfig)
+	if *isFix {
+		fixed, failed := logic.FixDiagnostics(schema, diagList)
+		for _, strFixed := range fixed {
+			fmt.Println("[fixed] " + strFixed)
+		}
+		for _, strFailed := range failed {
+			fmt.Fprintln(os.Stderr, "[unfixed] "+strFailed)
+		}
+		if len(fixed) > 0 {
+			if !saveSchema(schema, *strXml, *strConfig) {
+				return ExitFail
+			}
+			diagList = logic.DiagnoseSchema(schema, genConfig)
+		}
+	}
+	for _, diag := range diagList {
+		fmt.Println(diag.String())
+	}
+	nError, nWarn := logic.CountDiagnostics(diagList)
+	if nError > 0 {
+		fmt.Fprintln(os.Stderr, "validate failed.", nError, "error(s),", nWarn, "warning(s).")
+		return ExitFail
+	}
+	fmt.Println("validate done. no error,", nWarn, "warning(s).")
+	return ExitOk
+}
+
+// diff: 对比两个协议 xml, 有差异时返回 ExitFail
+func RunDiff(args []string) int {
+	flagSet := flag.NewFlagSet("diff", flag.ContinueOnError)
+	if !parseFlags(flagSet, args, 2) {
+		return ExitUsage
+	}
+
+	docList := []*etree.Document{}
+	for _, filename := range flagSet.Args() {
+		// 先按 Schema 解析一次, 保证文件格式合法
+		if loadSchema(filename) == nil {
+			return ExitUsage
+		}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromFile(filename); err != nil {
+			logrus.Error("[cli] read xml failed. err:", err, ", filename:", filename)
+			return ExitUsage
+		}
+		docList = append(docList, doc)
+	}
+	coremgr := &logic.CoreManager{}
+	diffDoc := coremgr.GetDocumentDiff(docList[0], docList[1])
+	nCount := 0
+	for _, cataElem := range diffDoc.ChildElements() {
+		for _, unitElem := range cataElem.ChildElements() {
+			fmt.Println("[" + unitElem.SelectAttrValue("opertype", "") + "]" + cataElem.Tag + "." + unitElem.Tag)
+			nCount++
+		}
+	}
+	if nCount > 0 {
+		return ExitFail
+	}
+	return ExitOk
+}
+
+// compat: 检查两个协议 xml 的兼容性, 有不兼容的变化时返回 ExitFail
+func RunCompat(args []string) int {
+	flagSet := flag.NewFlagSet("compat", flag.ContinueOnError)
+	bShowSafe := flagSet.Bool("safe", false, "also print safe changes")
+	if !parseFlags(flagSet, args, 2) {
+		return ExitUsage
+	}
+
+	oldSchema := loadSchema(flagSet.Arg(0))
+	newSchema := loadSchema(flagSet.Arg(1))
+	if oldSchema == nil || newSchema == nil {
+		return ExitUsage
+	}
+	changeList := logic.CheckCompatibility(oldSchema, newSchema)
+	for _, change := range changeList {
+		if *bShowSafe || change.Level == logic.CompatLevel_Breaking {
+			fmt.Println(change.String())
+		}
+	}
+	if logic.HasBreakingChange(changeList) {
+		fmt.Fprintln(os.Stderr, "found breaking change(s).")
+		return ExitFail
+	}
+	return ExitOk
+}
+
+// msgid: 为协议分配消息 ID 并写入锁文件, -check 时只检查, 锁文件需要更新或有冲突时返回 ExitFail
+func RunMsgId(args []string) int {
+	flagSet := flag.NewFlagSet("msgid", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, msgid is used")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	bCheck := flagSet.Bool("check", false, "only check, do not write the lock file")
+	if !parseFlags(flagSet, args, 0) {
+		return ExitUsage
+	}
+
+	coremgr := loadConfig(*strConfig)
+	if coremgr == nil {
+		return ExitFail
+	}
+	isEnable, _ := coremgr.GetMsgIdConfig()
+	if !isEnable {
+		fmt.Fprintln(os.Stderr, "msgid is not configed in", *strConfig)
+		return ExitFail
+	}
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	var registry *logic.StMsgIdRegistry
+	var errorList []string
+	if *bCheck {
+		registry, errorList = coremgr.LoadMsgIds(schema, *strXml)
+	} else {
+		registry, errorList = coremgr.UpdateMsgIds(schema, *strXml)
+	}
+	if registry == nil {
+		fmt.Fprintln(os.Stderr, strings.Join(errorList, "\n"))
+		return ExitFail
+	}
+	for _, entry := range registry.Entries {
+		if !entry.Removed {
+			fmt.Println(entry.Id, entry.Pair, entry.Name)
+		}
+	}
+	for _, strError := range errorList {
+		fmt.Fprintln(os.Stderr, strError)
+	}
+	if len(errorList) > 0 {
+		return ExitFail
+	}
+	return ExitOk
+}
+
+// import: 将 proto 文件或目录导入到协议 xml, 有无法表示的内容时仍然写入, 但返回 ExitFail
+func RunImport(args []string) int {
+	flagSet := flag.NewFlagSet("import", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, servershort is used to detect protocols")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file to import into, created if not exist")
+	strOut := flagSet.String("out", "", "output xml file, default is the same as -xml")
+	if !parseFlags(flagSet, args, 1) {
+		return ExitUsage
+	}
+
+	coremgr := loadConfig(*strConfig)
+	if coremgr == nil {
+		return ExitFail
+	}
+	schema := model.NewSchema()
+	if logic.PathExists(*strXml) {
+		if schema = loadSchema(*strXml); schema == nil {
+			return ExitFail
+		}
+	}
+	isSuccess, reports := logic.ImportProto(schema, flagSet.Arg(0), coremgr.IsProtocolName, coremgr.GetGenConfig())
+	for _, strReport := range reports {
+		fmt.Println(strReport)
+	}
+	if !isSuccess {
+		fmt.Fprintln(os.Stderr, "import failed.")
+		return ExitFail
+	}
+	strOutPath := *strOut
+	if strOutPath == "" {
+		strOutPath = *strXml
+	}
+	schema.EnsureCategories()
+	if !saveSchema(schema, strOutPath, *strConfig) {
+		return ExitFail
+	}
+	fmt.Println("import done. output:", strOutPath)
+	if len(reports) > 0 {
+		fmt.Fprintln(os.Stderr, len(reports), "construct(s) could not be represented.")
+		return ExitFail
+	}
+	return ExitOk
+}
+
+// rename: 重命名类型或枚举值, 并修改所有引用. -dry-run 时只输出修改
+func RunRename(args []string) int {
+	flagSet := flag.NewFlagSet("rename", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, backup is used")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	strValue := flagSet.String("value", "", "enum value to rename, the type must be an enum")
+	isDryRun := flagSet.Bool("dry-run", false, "print the changes without writing the xml")
+	if !parseFlags(flagSet, args, 2) {
+		return ExitUsage
+	}
+
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	var isOk bool
+	var result logic.StRenameResult
+	var strError string
+	if *strValue == "" {
+		isOk, result, strError = logic.RenameType(schema, flagSet.Arg(0), flagSet.Arg(1))
+	} else {
+		isOk, result, strError = logic.RenameEnumValue(schema, flagSet.Arg(0), *strValue, flagSet.Arg(1))
+	}
+	if !isOk {
+		fmt.Fprintln(os.Stderr, "rename failed.", strError)
+		return ExitFail
+	}
+	// 协议改名时消息 ID 锁文件中沿用原来的 ID
+	var coremgr *logic.CoreManager
+	if *strValue == "" && schema.FindCategory(flagSet.Arg(0)) == model.CategoryProtocol && logic.PathExists(*strConfig) {
+		if coremgr = loadConfig(*strConfig); coremgr == nil {
+			return ExitFail
+		}
+		if strNote := coremgr.GetMsgIdRenameNote(flagSet.Arg(0), flagSet.Arg(1)); strNote != "" {
+			result.Notes = append(result.Notes, strNote)
+		}
+		coremgr.MsgIdRenames = append(coremgr.MsgIdRenames, [2]string{flagSet.Arg(0), flagSet.Arg(1)})
+	}
+	for _, change := range result.Changes {
+		fmt.Println(change.String())
+	}
+	for _, strNote := range result.Notes {
+		fmt.Println("[note] " + strNote)
+	}
+	if *isDryRun {
+		fmt.Println("rename dry run.", len(result.Changes), "change(s) in", len(result.GetAffectedUnits()), "unit(s).")
+		return ExitOk
+	}
+	if !saveSchema(result.Schema, *strXml, *strConfig) {
+		return ExitFail
+	}
+	if coremgr != nil {
+		if _, errorList := coremgr.UpdateMsgIds(result.Schema, *strXml); len(errorList) > 0 {
+			fmt.Fprintln(os.Stderr, strings.Join(errorList, "\n"))
+			return ExitFail
+		}
+	}
+	fmt.Println("rename done.", len(result.Changes), "change(s) in", len(result.GetAffectedUnits()), "unit(s).")
+	return ExitOk
+}
+
+// delete: 删除顶层单元或嵌套类型. 仍被引用时默认不删除, -cascade 同时删除引用的字段, -replace 将引用的字段改为另一个类型
+func RunDelete(args []string) int {
+	flagSet := flag.NewFlagSet("delete", flag.ContinueOnError)
+	strConfig := flagSet.String("config", getDefaultConfigPath(), "config xml file, backup is used")
+	strXml := flagSet.String("xml", getDefaultXmlPath(), "protocol xml file")
+	isCascade := flagSet.Bool("cascade", false, "delete the fields that use the type")
+	strReplace := flagSet.String("replace", "", "change the fields that use the type to this type")
+	isDryRun := flagSet.Bool("dry-run", false, "print the changes without writing the xml")
+	if !parseFlags(flagSet, args, 1) {
+		return ExitUsage
+	}
+	if *isCascade && *strReplace != "" {
+		fmt.Fprintln(os.Stderr, "-cascade and -replace can not be used together")
+		return ExitUsage
+	}
+
+	schema := loadSchema(*strXml)
+	if schema == nil {
+		return ExitFail
+	}
+	strPath := flagSet.Arg(0)
+	strCategory := ""
+	if !strings.Contains(strPath, ".") {
+		strCategory = schema.FindCategory(strPath)
+		if strCategory == "" {
+			fmt.Fprintln(os.Stderr, "delete failed.", strPath, "is not found")
+			return ExitFail
+		}
+	}
+	mode := logic.DeleteMode_Refuse
+	if *isCascade {
+		mode = logic.DeleteMode_Cascade
+	} else if *strReplace != "" {
+		mode = logic.DeleteMode_Replace
+	}
+	isOk, result, strError := logic.DeleteType(schema, strCategory, strPath, mode, *strReplace)
+	if !isOk {
+		for _, dependent := range result.Dependents {
+			fmt.Fprintln(os.Stderr, "[used] "+dependent.String())
+		}
+		fmt.Fprintln(os.Stderr, "delete failed.", strError)
+		if mode == logic.DeleteMode_Refuse && len(result.Dependents) > 0 {
+			fmt.Fprintln(os.Stderr, "use -cascade to delete these fields or -replace to change their type")
+		}
+		return ExitFail
+	}
+	for _, change := range result.Changes {
+		fmt.Println(change.String())
+	}
+	if *isDryRun {
+		fmt.Println("delete dry run.", strPath, "and", len(result.Changes), "field change(s).")
+		return ExitOk
+	}
+	if !saveSchema(result.Schema, *strXml, *strConfig) {
+		return ExitFail
+	}
+	fmt.Println("delete done.", strPath, "and", len(result.Changes), "field change(s).")
+	return ExitOk
+}
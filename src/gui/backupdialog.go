@@ -0,0 +1,80 @@
+package gui
+
+import (
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sirupsen/logrus"
+)
+
+// 保存修改到文件, 失败时提示, 此时原文件和未保存的修改都不变
+func (stapp *StApp) SaveProtoXml() {
+	if !stapp.CoreMgr.SaveProtoXmlToFile() {
+		dialog.ShowInformation("Error!", "Save failed, the xml file is not changed. See the log for details.", *stapp.Window)
+	}
+}
+
+// 从备份恢复: 列出打开的 xml 的所有备份, 选择后预览与已保存文件的差异, 恢复为未保存的修改
+func (stapp *StApp) ShowRestoreBackupDialog() {
+	backupList := stapp.CoreMgr.GetBackupList()
+	if len(backupList) == 0 {
+		dialog.ShowInformation("Restore backup", "No backup of the opened xml. A backup is written each time the xml is saved.", *stapp.Window)
+		return
+	}
+	previewLabel := widget.NewLabel("Select a backup to see the changes.")
+	selectedIndex := -1
+	var buttonRestore *widget.Button
+	list := widget.NewList(
+		func() int { return len(backupList) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			backup := backupList[id]
+			item.(*widget.Label).SetText(backup.Time.Format("2006-01-02 15:04:05") + "    " + strconv.FormatInt(backup.Size, 10) + " bytes")
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		selectedIndex = id
+		isOk, changeList := stapp.CoreMgr.GetBackupChanges(backupList[id].Path)
+		if !isOk {
+			previewLabel.SetText("Can not read " + backupList[id].Path)
+			buttonRestore.Disable()
+			return
+		}
+		if len(changeList) == 0 {
+			previewLabel.SetText("Same as the saved xml.")
+			buttonRestore.Disable()
+			return
+		}
+		previewLabel.SetText(strconv.Itoa(len(changeList)) + " unit(s) differ from the saved xml:\n" + strings.Join(changeList, "\n"))
+		buttonRestore.Enable()
+	}
+
+	var customDialog *dialog.CustomDialog
+	buttonRestore = widget.NewButton("Restore", func() {
+		if selectedIndex < 0 {
+			return
+		}
+		strPath := backupList[selectedIndex].Path
+		logrus.Info("ShowRestoreBackupDialog. restore:", strPath)
+		if !stapp.CoreMgr.RestoreBackup(strPath) {
+			dialog.ShowInformation("Error!", "Restore "+strPath+" failed.", *stapp.Window)
+			return
+		}
+		customDialog.Hide()
+		dialog.ShowInformation("Restored", "The backup is restored as unsaved changes. Save to write the xml file, or undo to go back.", *stapp.Window)
+	})
+	buttonRestore.Disable()
+	buttonCancel := widget.NewButton("Cancel", func() {
+		customDialog.Hide()
+	})
+	split := container.NewHSplit(list, container.NewVScroll(previewLabel))
+	split.Offset = 0.4
+	content := container.NewBorder(nil, container.NewHBox(buttonRestore, buttonCancel), nil, nil, split)
+	customDialog = dialog.NewCustomWithoutButtons("Restore backup", content, *stapp.Window)
+	customDialog.Resize(fyne.NewSize(900, 600))
+	customDialog.Show()
+}
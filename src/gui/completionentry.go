@@ -1,4 +1,4 @@
-package logic
+package gui
 
 import (
 	"fyne.io/fyne/v2"
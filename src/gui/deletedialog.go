@@ -0,0 +1,87 @@
+package gui
+
+import (
+	"protocolgo/src/logic"
+	"protocolgo/src/model"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// 删除单元或嵌套类型(path 含 .). 没有被引用时确认后删除;
+// 仍被引用时列出引用的字段, 可以取消, 同时删除这些字段, 或将它们改为另一个类型. 删除成功后调用 onDeleted
+func (stapp *StApp) ShowDeleteDialog(tabletype logic.ETableType, path string, onDeleted func()) {
+	doDelete := func(mode logic.EDeleteMode, replaceType string) bool {
+		isOk, result, strError := stapp.CoreMgr.DeleteUnit(tabletype, path, mode, replaceType)
+		if !isOk {
+			dialog.ShowInformation("Error!", "Delete "+path+" failed: "+strError, *stapp.Window)
+			return false
+		}
+		if len(result.Changes) > 0 {
+			lines := []string{"Deleted " + path + ", " + strconv.Itoa(len(result.Changes)) + " field(s) changed:"}
+			for _, change := range result.Changes {
+				lines = append(lines, "    "+change.String())
+			}
+			dialog.ShowInformation("Deleted", strings.Join(lines, "\n"), *stapp.Window)
+		}
+		if onDeleted != nil {
+			onDeleted()
+		}
+		return true
+	}
+
+	dependents := stapp.CoreMgr.GetDeleteDependents(tabletype, path)
+	if len(dependents) == 0 {
+		dialog.ShowConfirm("Confirmation", "Are you sure to delete "+path+"?", func(isConfirm bool) {
+			if isConfirm {
+				doDelete(logic.DeleteMode_Refuse, "")
+			}
+		}, *stapp.Window)
+		return
+	}
+
+	lines := []string{path + " is still used by " + strconv.Itoa(len(dependents)) + " field(s):"}
+	for _, dependent := range dependents {
+		lines = append(lines, "    "+dependent.String())
+	}
+	// 替换的类型: 标量和除被删除类型外的所有类型
+	options := model.GetScalarTypes()
+	for _, strName := range stapp.CoreMgr.GetSchema().GetTypeNames() {
+		if strName != path && !strings.HasPrefix(strName, path+".") {
+			options = append(options, strName)
+		}
+	}
+	selectReplace := widget.NewSelect(options, nil)
+	selectReplace.PlaceHolder = "(select the replace type)"
+
+	var customDialog *dialog.CustomDialog
+	buttonCancel := widget.NewButton("Cancel", func() {
+		customDialog.Hide()
+	})
+	buttonCascade := widget.NewButton("Delete fields", func() {
+		if doDelete(logic.DeleteMode_Cascade, "") {
+			customDialog.Hide()
+		}
+	})
+	buttonReplace := widget.NewButton("Replace type", func() {
+		if selectReplace.Selected == "" {
+			dialog.ShowInformation("Error!", "Select the replace type first.", *stapp.Window)
+			return
+		}
+		if doDelete(logic.DeleteMode_Replace, selectReplace.Selected) {
+			customDialog.Hide()
+		}
+	})
+	bottom := container.NewVBox(
+		container.NewBorder(nil, nil, widget.NewLabel("Replace with:"), nil, selectReplace),
+		container.NewHBox(buttonCancel, buttonCascade, buttonReplace),
+	)
+	content := container.NewBorder(nil, bottom, nil, nil, container.NewVScroll(widget.NewLabel(strings.Join(lines, "\n"))))
+	customDialog = dialog.NewCustomWithoutButtons("Delete "+path, content, *stapp.Window)
+	customDialog.Resize(fyne.NewSize(700, 500))
+	customDialog.Show()
+}
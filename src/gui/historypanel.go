@@ -0,0 +1,115 @@
+package gui
+
+import (
+	"protocolgo/src/logic"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sirupsen/logrus"
+)
+
+// 历史列表中打开 xml 时的状态
+const historyOpenedName = "(opened xml)"
+
+// 添加快捷键, Ctrl+Z 撤销, Ctrl+Y 重做
+func (stapp *StApp) AddHistoryShortcuts() {
+	(*stapp.Window).Canvas().AddShortcut(&desktop.CustomShortcut{
+		KeyName:  fyne.KeyZ,
+		Modifier: fyne.KeyModifierControl,
+	}, func(shortcut fyne.Shortcut) {
+		stapp.UndoEdit()
+	})
+	(*stapp.Window).Canvas().AddShortcut(&desktop.CustomShortcut{
+		KeyName:  fyne.KeyY,
+		Modifier: fyne.KeyModifierControl,
+	}, func(shortcut fyne.Shortcut) {
+		stapp.RedoEdit()
+	})
+}
+
+// 撤销最近一次修改
+func (stapp *StApp) UndoEdit() {
+	isOk, strName := stapp.CoreMgr.Undo()
+	logrus.Info("UndoEdit. isOk:", isOk, ", name:", strName)
+	stapp.onHistoryChanged()
+}
+
+// 重做最近一次撤销的修改
+func (stapp *StApp) RedoEdit() {
+	isOk, strName := stapp.CoreMgr.Redo()
+	logrus.Info("RedoEdit. isOk:", isOk, ", name:", strName)
+	stapp.onHistoryChanged()
+}
+
+// 撤销或重做后刷新依赖数据的页签, 列表通过数据绑定自动刷新
+func (stapp *StApp) onHistoryChanged() {
+	if stapp.refreshProblems != nil {
+		stapp.refreshProblems()
+	}
+}
+
+// 历史列表中第 index 项的展示文本, 0 为打开 xml 时的状态, 当前状态加 > 前缀, 已撤销的加 (undone) 后缀
+func getHistoryItemText(history *logic.StHistory, index int) string {
+	strName := historyOpenedName
+	if index > 0 {
+		strName = strconv.Itoa(index) + ". " + history.Commands[index-1].Name
+	}
+	if index == history.Cursor {
+		return "> " + strName
+	}
+	if index > history.Cursor {
+		return "   " + strName + " (undone)"
+	}
+	return "   " + strName
+}
+
+// 历史列表: 列出本次打开后的所有修改, 点击一项撤销或重做到该项之后的状态
+func (stapp *StApp) ShowHistoryDialog() {
+	history := &stapp.CoreMgr.History
+	summary := widget.NewLabel("")
+	var list *widget.List
+	list = widget.NewList(
+		func() int { return len(history.Commands) + 1 },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			label := item.(*widget.Label)
+			label.TextStyle = fyne.TextStyle{Bold: id == history.Cursor}
+			label.SetText(getHistoryItemText(history, id))
+		},
+	)
+	refresh := func() {
+		summary.SetText(strconv.Itoa(history.Cursor) + " of " + strconv.Itoa(len(history.Commands)) + " change(s) applied. Click a change to go back to it.")
+		list.Refresh()
+	}
+	list.OnSelected = func(id widget.ListItemID) {
+		list.Unselect(id)
+		if id == history.Cursor || len(history.Commands) == 0 {
+			return
+		}
+		stapp.CoreMgr.GoToHistory(id)
+		stapp.onHistoryChanged()
+		refresh()
+	}
+	refresh()
+
+	buttonUndo := widget.NewButton("Undo", func() {
+		stapp.UndoEdit()
+		refresh()
+	})
+	buttonRedo := widget.NewButton("Redo", func() {
+		stapp.RedoEdit()
+		refresh()
+	})
+	var customDialog *dialog.CustomDialog
+	buttonClose := widget.NewButton("Close", func() {
+		customDialog.Hide()
+	})
+	content := container.NewBorder(summary, container.NewHBox(buttonUndo, buttonRedo, buttonClose), nil, nil, list)
+	customDialog = dialog.NewCustomWithoutButtons("History", content, *stapp.Window)
+	customDialog.Resize(fyne.NewSize(700, 500))
+	customDialog.Show()
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"protocolgo/src/logic"
+	"protocolgo/src/model"
 	"protocolgo/src/utils"
 	"sort"
 	"strconv"
@@ -18,7 +19,6 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
-	"github.com/beevik/etree"
 	"github.com/flopp/go-findfont"
 	"github.com/goki/freetype/truetype"
 	"github.com/sirupsen/logrus"
@@ -31,6 +31,9 @@ type StApp struct {
 	Window  *fyne.Window      // 主窗口.
 	CoreMgr logic.CoreManager // 管理器
 	tables  *container.AppTabs
+
+	problems        []logic.StDiagnostic // 问题页签展示的诊断
+	refreshProblems func()               // 重新诊断并刷新问题页签
 }
 
 // 生成UI
@@ -52,6 +55,8 @@ func (stapp *StApp) MakeUI() {
 	stapp.CreateMainContainer()
 	// 设置退出策略
 	stapp.SetOnClose()
+	// 有未保存的自动保存日志时提示恢复
+	stapp.OfferJournalRestore()
 
 }
 
@@ -180,11 +185,13 @@ func (stapp *StApp) CreateMenuItem() {
 				return
 			}
 			xml_file_path := reader.URI().Path()
-			// 设定 当前打开的xml文件路径
-			stapp.CoreMgr.SetCurrXmlFilePath(xml_file_path)
-			// 读取到内存
-			stapp.CoreMgr.ReadXmlFromReader(reader)
+			// 读取到内存, 检查通过后才关闭现在打开的 xml 并切换路径
+			if !stapp.CoreMgr.ReadXmlFromReader(reader, xml_file_path) {
+				dialog.ShowInformation("Error!", "Can not read "+xml_file_path+", it is not a valid protocol xml.", *stapp.Window)
+				return
+			}
 			logrus.Info("Open xml file done.file path:", xml_file_path)
+			stapp.OfferJournalRestore()
 		}, *stapp.Window)
 		file_picker.Resize(fyne.NewSize(1100, 800))
 		file_picker.SetFilter(storage.NewExtensionFileFilter([]string{".txt", ".xml"}))
@@ -237,12 +244,132 @@ func (stapp *StApp) CreateMenuItem() {
 			}, *stapp.Window)
 
 	})
+	// 导入 proto 文件
+	importProtoMenuItem := fyne.NewMenuItem("import proto..", func() {
+		file_picker := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				logrus.Info("Failed to NewFileOpen:", err)
+				return
+			}
+			if reader == nil {
+				logrus.Info("User canceled to import proto.")
+				return
+			}
+			reader.Close()
+			stapp.ImportProto(reader.URI().Path())
+		}, *stapp.Window)
+		file_picker.Resize(fyne.NewSize(1100, 800))
+		file_picker.SetFilter(storage.NewExtensionFileFilter([]string{".proto"}))
+		file_picker.Show()
+	})
+	// 导入 proto 目录
+	importProtoDirMenuItem := fyne.NewMenuItem("import proto dir..", func() {
+		folder_picker := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err != nil {
+				logrus.Info("Failed to NewFolderOpen:", err)
+				return
+			}
+			if uri == nil {
+				logrus.Info("User canceled to import proto dir.")
+				return
+			}
+			stapp.ImportProto(uri.Path())
+		}, *stapp.Window)
+		folder_picker.Resize(fyne.NewSize(1100, 800))
+		folder_picker.Show()
+	})
 	// 创建一个一级菜单
-	fileMenu := fyne.NewMenu("File", newMenuItem, openMenuItem, saveMenuItem)
+	// 从备份恢复
+	restoreBackupMenuItem := fyne.NewMenuItem("restore backup..", func() {
+		stapp.ShowRestoreBackupDialog()
+	})
+	fileMenu := fyne.NewMenu("File", newMenuItem, openMenuItem, saveMenuItem, restoreBackupMenuItem, fyne.NewMenuItemSeparator(), importProtoMenuItem, importProtoDirMenuItem)
+	// 撤销/重做
+	undoMenuItem := fyne.NewMenuItem("undo (Ctrl+Z)", func() {
+		stapp.UndoEdit()
+	})
+	redoMenuItem := fyne.NewMenuItem("redo (Ctrl+Y)", func() {
+		stapp.RedoEdit()
+	})
+	historyMenuItem := fyne.NewMenuItem("history..", func() {
+		stapp.ShowHistoryDialog()
+	})
+	editMenu := fyne.NewMenu("Edit", undoMenuItem, redoMenuItem, fyne.NewMenuItemSeparator(), historyMenuItem)
 	// 创建菜单栏
-	menu := fyne.NewMainMenu(fileMenu)
+	menu := fyne.NewMainMenu(fileMenu, editMenu)
 
 	(*stapp.Window).SetMainMenu(menu)
+	stapp.AddHistoryShortcuts()
+}
+
+// 导入 proto 并展示无法表示的内容, 导入的单元需要保存后才写入文件
+func (stapp *StApp) ImportProto(protoPath string) {
+	isSuccess, reports := stapp.CoreMgr.ImportProto(protoPath)
+	if !isSuccess {
+		dialog.ShowInformation("Error!", "Import proto failed:\n"+strings.Join(reports, "\n"), *stapp.Window)
+		return
+	}
+	logrus.Info("Import proto done. protoPath:", protoPath)
+	if len(reports) == 0 {
+		dialog.ShowInformation("Imported", "Import proto done. Save to write the xml file.", *stapp.Window)
+		return
+	}
+	reportList := widget.NewList(
+		func() int { return len(reports) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) { item.(*widget.Label).SetText(reports[id]) },
+	)
+	reportDialog := dialog.NewCustom("Imported, "+strconv.Itoa(len(reports))+" construct(s) could not be represented", "OK", reportList, *stapp.Window)
+	reportDialog.Resize(fyne.NewSize(1000, 600))
+	reportDialog.Show()
+}
+
+// 保存修改到文件. 有不兼容的变化时列出所有变化, 勾选确认后才能保存
+func (stapp *StApp) SaveWithCompatCheck() {
+	changeList := stapp.CoreMgr.GetCompatReport()
+	if !logic.HasBreakingChange(changeList) {
+		stapp.SaveProtoXml()
+		return
+	}
+	changeTextList := []string{}
+	for _, change := range changeList {
+		changeTextList = append(changeTextList, change.String())
+	}
+	logrus.Warn("SaveWithCompatCheck found breaking changes:\n", strings.Join(changeTextList, "\n"))
+	changeListWidget := widget.NewList(
+		func() int { return len(changeTextList) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) { item.(*widget.Label).SetText(changeTextList[id]) },
+	)
+
+	var compatDialog *dialog.CustomDialog
+	saveButton := widget.NewButton("Save anyway", func() {
+		logrus.Warn("SaveWithCompatCheck: save with breaking changes by override.")
+		compatDialog.Hide()
+		stapp.SaveProtoXml()
+	})
+	saveButton.Importance = widget.DangerImportance
+	saveButton.Disable()
+	overrideCheck := widget.NewCheck("I know these changes break compatibility, save anyway", func(bChecked bool) {
+		if bChecked {
+			saveButton.Enable()
+		} else {
+			saveButton.Disable()
+		}
+	})
+	cancelButton := widget.NewButton("Cancel", func() {
+		compatDialog.Hide()
+	})
+	dialogContent := container.NewBorder(
+		widget.NewLabel("Breaking changes found, the other side using the saved protocol may fail to parse:"),
+		container.NewVBox(overrideCheck, container.NewCenter(container.NewHBox(cancelButton, saveButton))),
+		nil,
+		nil,
+		changeListWidget,
+	)
+	compatDialog = dialog.NewCustomWithoutButtons("Compatibility check", dialogContent, *stapp.Window)
+	compatDialog.Resize(fyne.NewSize(1000, 600))
+	compatDialog.Show()
 }
 
 // 创建主体布局
@@ -257,7 +384,14 @@ func (stapp *StApp) CreateMainContainer() {
 		container.NewTabItem("Data", stapp.CreateTab(logic.TableType_Data)),
 		container.NewTabItem("Ptc", stapp.CreateTab(logic.TableType_Protocol)),
 		container.NewTabItem("Rpc", stapp.CreateTab(logic.TableType_RPC)),
+		container.NewTabItem(ProblemsTabName, stapp.CreateProblemsTab()),
 	)
+	// 切换到问题页签时重新诊断
+	stapp.tables.OnSelected = func(tab *container.TabItem) {
+		if tab.Text == ProblemsTabName && stapp.refreshProblems != nil {
+			stapp.refreshProblems()
+		}
+	}
 
 	// 使用垂直布局将上部和下部容器组合在一起
 	mainContainer := container.NewBorder(
@@ -284,7 +418,7 @@ func (stapp *StApp) CreateTopSearchContainer() fyne.CanvasObject {
 	// 	logrus.Info("You have searched for:", input)
 	// })
 	searchFields := stapp.CoreMgr.GetAllSearchName()
-	searchEntry := logic.NewCompletionEntry(searchFields)
+	searchEntry := NewCompletionEntry(searchFields)
 	searchEntry.SetPlaceHolder("Search here")
 	searchEntry.SetMinRowsVisible(2)
 	// 设置默认值
@@ -392,7 +526,7 @@ func (stapp *StApp) CreateTabListInstruction(tabletype logic.ETableType) fyne.Ca
 	var button *widget.Button
 	if tabletype == logic.TableType_Main {
 		button = widget.NewButton("Save to File", func() {
-			stapp.CoreMgr.SaveProtoXmlToFile()
+			stapp.SaveWithCompatCheck()
 		})
 	} else {
 		button = widget.NewButton("Add new", func() {
@@ -406,29 +540,52 @@ func (stapp *StApp) CreateTabListInstruction(tabletype logic.ETableType) fyne.Ca
 
 }
 
-// 创建新Message的编辑页面
+// 创建新Message的编辑页面, 嵌套类型的 unitname 为 Outer.Inner 形式的全名
 func (stapp *StApp) EditUnit(tabletype logic.ETableType, unitname string) {
+	parentPath := ""
+	if index := strings.LastIndex(unitname, "."); index >= 0 {
+		parentPath = unitname[:index]
+	}
+	stapp.EditNestedUnit(tabletype, parentPath, unitname, nil)
+}
+
+// 编辑页面, parentPath 不为空时编辑该消息下的嵌套类型, 新建时 unitname 为空. 保存成功后调用 onSaved
+func (stapp *StApp) EditNestedUnit(tabletype logic.ETableType, parentPath string, unitname string, onSaved func()) {
+	strTitle := unitname
+	if unitname == "" && parentPath != "" {
+		strTitle = parentPath + "."
+	}
 	dialogContent := container.NewGridWithRows(4)
-	customDialog := dialog.NewCustomWithoutButtons(stapp.CoreMgr.GetEditTableTitle(tabletype, unitname), container.NewVScroll(dialogContent), *stapp.Window)
+	customDialog := dialog.NewCustomWithoutButtons(stapp.CoreMgr.GetEditTableTitle(tabletype, strTitle), container.NewVScroll(dialogContent), *stapp.Window)
 	customDialog.Resize(fyne.NewSize(1100, 800))
 
 	subtabletype := logic.SubTableType_None
 	if tabletype == logic.TableType_RPC {
 		subtabletype = logic.SubTableType_RpcReq
 	}
-	inputInfoContainer, unitReq := stapp.GetUnitDetailContainer(customDialog, tabletype, subtabletype, unitname)
+	inputInfoContainer, unitReq := stapp.GetUnitDetailContainer(customDialog, tabletype, subtabletype, parentPath, unitname)
 	dialogContent.Add(inputInfoContainer)
 
 	var inputInfoContainer2 *fyne.Container
-	var unitAck *logic.StUnitContainer
+	var unitAck *StUnitContainer
 	if tabletype == logic.TableType_RPC {
 		subtabletype = logic.SubTableType_RpcAck
-		inputInfoContainer2, unitAck = stapp.GetUnitDetailContainer(customDialog, tabletype, subtabletype, unitname)
+		inputInfoContainer2, unitAck = stapp.GetUnitDetailContainer(customDialog, tabletype, subtabletype, parentPath, unitname)
 		logrus.Debug("[EditUnit] debug unit name. tabletype:", tabletype, ",unitname:", unitname, ",unitname:", unitname)
 	}
 
+	// 已有的消息可以编辑嵌套类型
+	if !unitReq.IsCreatNew && tabletype != logic.TableType_Enum {
+		if tabletype == logic.TableType_RPC {
+			inputInfoContainer.Add(stapp.GetNestedUnitContainer(unitname + model.RpcTypeReq))
+			inputInfoContainer2.Add(stapp.GetNestedUnitContainer(unitname + model.RpcTypeAck))
+		} else {
+			inputInfoContainer.Add(stapp.GetNestedUnitContainer(unitname))
+		}
+	}
+
 	// 获取取消/保存按钮组,以及依赖列表
-	refCancelSaveButtons, referencesList := stapp.GetUnitDetailButtons(unitReq, unitAck, customDialog)
+	refCancelSaveButtons, referencesList := stapp.GetUnitDetailButtons(unitReq, unitAck, customDialog, onSaved)
 	// dialogContent.Add(referencesList)
 	if tabletype == logic.TableType_RPC {
 		inputInfoContainer2.Add(container.NewCenter(refCancelSaveButtons))
@@ -452,14 +609,54 @@ func (stapp *StApp) EditUnit(tabletype logic.ETableType, unitname string) {
 	customDialog.Show()
 }
 
-func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, tabletype logic.ETableType, subtabletype logic.ESubTableType, unitname string) (*fyne.Container, *logic.StUnitContainer) {
+// 嵌套类型列表, msgPath 为所在消息的全名
+func (stapp *StApp) GetNestedUnitContainer(msgPath string) *fyne.Container {
+	nestedBox := container.NewVBox()
+	var refreshNested func()
+	refreshNested = func() {
+		nestedBox.Objects = nil
+		msg := stapp.CoreMgr.GetSchema().FindMessageByPath(msgPath)
+		if msg == nil {
+			nestedBox.Refresh()
+			return
+		}
+		addRow := func(tabletype logic.ETableType, strKind string, name string) {
+			strPath := msgPath + "." + name
+			editButton := widget.NewButton("Edit", func() {
+				stapp.EditNestedUnit(tabletype, msgPath, strPath, refreshNested)
+			})
+			deleteButton := widget.NewButton("Delete", func() {
+				stapp.ShowDeleteDialog(tabletype, strPath, refreshNested)
+			})
+			nestedBox.Add(container.NewBorder(nil, nil, nil, container.NewHBox(editButton, deleteButton), widget.NewLabel(strKind+"  "+name)))
+		}
+		for _, enum := range msg.Enums {
+			addRow(logic.TableType_Enum, model.NestedTypeEnum, enum.Name)
+		}
+		for _, nested := range msg.Messages {
+			addRow(logic.TableType_Data, model.NestedTypeMessage, nested.Name)
+		}
+		nestedBox.Refresh()
+	}
+	refreshNested()
+
+	addEnumButton := widget.NewButton("Add nested enum", func() {
+		stapp.EditNestedUnit(logic.TableType_Enum, msgPath, "", refreshNested)
+	})
+	addMessageButton := widget.NewButton("Add nested message", func() {
+		stapp.EditNestedUnit(logic.TableType_Data, msgPath, "", refreshNested)
+	})
+	return container.NewVBox(widget.NewLabel(msgPath+" nested types:"), nestedBox, container.NewHBox(addEnumButton, addMessageButton))
+}
+
+func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, tabletype logic.ETableType, subtabletype logic.ESubTableType, parentPath string, unitname string) (*fyne.Container, *StUnitContainer) {
 	bCreateNew := false // 是否是新的节点
-	etreeRow := stapp.CoreMgr.GetEtreeElem(tabletype, subtabletype, unitname)
-	if unitname == "" || etreeRow == nil {
+	stUnit, bFound := stapp.CoreMgr.GetStUnit(tabletype, subtabletype, unitname)
+	if unitname == "" || !bFound {
 		bCreateNew = true
 		logrus.Info("[EditUnit] Create new unit. tabletype:", tabletype, ",customDialog:", customDialog)
 	} else {
-		logrus.Info("[EditUnit] Edit old unit. tabletype:", tabletype, ", Tag:", etreeRow.Tag, ",customDialog:", customDialog)
+		logrus.Info("[EditUnit] Edit old unit. tabletype:", tabletype, ", UnitName:", stUnit.UnitName, ",customDialog:", customDialog)
 	}
 
 	// 创建输入信息的容器
@@ -479,7 +676,7 @@ func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, ta
 	inputUnitName := widget.NewEntry()
 	inputUnitName.SetPlaceHolder("Enter name...")
 	if !bCreateNew {
-		inputUnitName.SetText(unitname)
+		inputUnitName.SetText(stUnit.UnitName)
 		inputUnitName.TextStyle.Bold = true
 		// inputUnitName.Disable()
 	}
@@ -533,18 +730,44 @@ func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, ta
 	inputUnitComment.SetMinRowsVisible(3)
 	inputInfoContainer.Add(inputUnitComment)
 	if !bCreateNew {
-		for _, child := range etreeRow.Child {
-			// 检查该子元素是否为注释
-			if comment, ok := child.(*etree.Comment); ok {
-				inputUnitComment.SetText(comment.Data)
-				break
-			}
-		}
+		inputUnitComment.SetText(stUnit.UnitComment)
 	}
 
 	nEntryIndex := 0
 	// 在外部定义一个列表来保存每一行的组件
-	rowList := new([]logic.StRowUnit)
+	rowList := new([]StRowUnit)
+	var stUnitContainer StUnitContainer
+
+	// 保留的序号和名字, 以及相对文件删除了, 保存时会被保留的字段
+	reserved := new([]model.Reserved)
+	if !bCreateNew {
+		*reserved = append(*reserved, stUnit.Reserved...)
+	}
+	reservedBox := container.NewVBox()
+	var refreshReserved func()
+	refreshReserved = func() {
+		reservedBox.RemoveAll()
+		for i, reservedItem := range *reserved {
+			nIndex := i
+			reservedBox.Add(container.NewHBox(
+				widget.NewLabel("reserved: "+GetReservedText(reservedItem)),
+				widget.NewButton("Delete", func() {
+					*reserved = append((*reserved)[:nIndex], (*reserved)[nIndex+1:]...)
+					refreshReserved()
+				}),
+			))
+		}
+		if !bCreateNew {
+			stCurrUnit := stUnitContainer.GetStUnit()
+			stCurrUnit.SubTableType = subtabletype
+			for _, removed := range stapp.CoreMgr.GetRemovedFields(stCurrUnit) {
+				if len(model.AddReserved(*reserved, removed)) != len(*reserved) {
+					reservedBox.Add(widget.NewLabel("reserved: " + GetReservedText(removed) + " (removed, reserved on save)"))
+				}
+			}
+		}
+		reservedBox.Refresh()
+	}
 
 	// 创建一个"Add" 按钮，点击后在VBox中添加新的Entry
 	attrBox := container.NewVBox()
@@ -552,38 +775,13 @@ func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, ta
 	// 先展示老的字段
 	// 遍历子元素
 	if !bCreateNew {
-		for _, child := range etreeRow.ChildElements() {
-			var rowUnit logic.StStrRowUnit
-			entryOption := child.SelectAttr("EntryOption")
-			if entryOption != nil {
-				rowUnit.EntryOption = entryOption.Value
-			}
-			entryType := child.SelectAttr("EntryType")
-			if entryType != nil {
-				rowUnit.EntryType = entryType.Value
-			}
-			entryName := child.SelectAttr("EntryName")
-			if entryName != nil {
-				rowUnit.EntryName = entryName.Value
-			}
-			entryDefault := child.SelectAttr("EntryDefault")
-			if entryDefault != nil {
-				rowUnit.EntryDefault = entryDefault.Value
-			}
-			entryContent := child.SelectAttr("EntryComment")
-			if entryContent != nil {
-				rowUnit.EntryComment = entryContent.Value
-			}
-			entryIndex := child.SelectAttr("EntryIndex")
-			if entryIndex != nil {
-				rowUnit.EntryIndex = entryIndex.Value
-				index, err := strconv.Atoi(rowUnit.EntryIndex)
-				if err == nil && index > nEntryIndex {
-					nEntryIndex = index
-				}
+		for _, rowUnit := range stUnit.RowList {
+			index, err := strconv.Atoi(rowUnit.EntryIndex)
+			if err == nil && index > nEntryIndex {
+				nEntryIndex = index
 			}
 			logrus.Info("[EditUnit] Add old row info to list. tabletype:", tabletype, ",rowUnit:", rowUnit)
-			stapp.CreateRowForEditUnit(tabletype, rowUnit, attrBox, rowList)
+			stapp.CreateRowForEditUnit(tabletype, rowUnit, attrBox, rowList, refreshReserved)
 		}
 	}
 
@@ -592,9 +790,13 @@ func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, ta
 		if tabletype != logic.TableType_Enum {
 			nEntryIndex = nEntryIndex + 1
 		}
-		stapp.CreateRowForEditUnit(tabletype, logic.StStrRowUnit{
+		// 跳过保留的序号
+		for model.FindReservedIndex(*reserved, strconv.Itoa(nEntryIndex)) != nil {
+			nEntryIndex = nEntryIndex + 1
+		}
+		stapp.CreateRowForEditUnit(tabletype, model.Field{
 			EntryIndex: strconv.Itoa(nEntryIndex),
-		}, attrBox, rowList)
+		}, attrBox, rowList, refreshReserved)
 
 		if tabletype == logic.TableType_Enum {
 			nEntryIndex = nEntryIndex + 1
@@ -604,13 +806,19 @@ func (stapp *StApp) GetUnitDetailContainer(customDialog *dialog.CustomDialog, ta
 	// 创建可以新增列的container
 	attrBoader := container.NewBorder(nil, nil, nil, addButton, attrBox)
 	inputInfoContainer.Add(attrBoader)
+	inputInfoContainer.Add(reservedBox)
 
-	var stUnitContainer logic.StUnitContainer
 	stUnitContainer.UnitNameEntry = inputUnitName
 	stUnitContainer.UnitCommentEntry = inputUnitComment
 	stUnitContainer.TableType = tabletype
 	stUnitContainer.RowList = rowList
 	stUnitContainer.IsCreatNew = bCreateNew
+	stUnitContainer.ParentPath = parentPath
+	stUnitContainer.Reserved = reserved
+	if !bCreateNew {
+		stUnitContainer.OriginName = stUnit.UnitName
+	}
+	refreshReserved()
 
 	// inputInfoContainer.Add(container.NewCenter(buttons))
 	return inputInfoContainer, &stUnitContainer
@@ -694,16 +902,18 @@ func (stapp *StApp) GetRemoteSshUI(customDialog *dialog.CustomDialog) *fyne.Cont
 	return sshInfoContainer
 }
 
-func (stapp *StApp) GetUnitDetailButtons(stUnitReq *logic.StUnitContainer, stUnitAck *logic.StUnitContainer, customDialog *dialog.CustomDialog) (*fyne.Container, *fyne.Container) {
+func (stapp *StApp) GetUnitDetailButtons(stUnitReq *StUnitContainer, stUnitAck *StUnitContainer, customDialog *dialog.CustomDialog, onSaved func()) (*fyne.Container, *fyne.Container) {
 	var referencesList *fyne.Container
-	referencesList = stapp.CreateEntryReferenceListCanvas(stUnitReq.UnitNameEntry.Text)
+	stReqUnit := stUnitReq.GetStUnit()
+	referencesList = stapp.CreateEntryReferenceListCanvas(stReqUnit.GetTypePath())
 	referencesList.Hide()
 	bShowReferenceList := false
 
 	// 增加关闭,保存按钮
 	buttons := container.NewHBox(
 		widget.NewButton("References", func() {
-			referencesList = stapp.CreateEntryReferenceListCanvas(stUnitReq.UnitNameEntry.Text)
+			stReqUnit := stUnitReq.GetStUnit()
+			referencesList = stapp.CreateEntryReferenceListCanvas(stReqUnit.GetTypePath())
 			referencesList.Hide()
 			// Cancel logic goes here
 			if bShowReferenceList {
@@ -744,21 +954,41 @@ func (stapp *StApp) GetUnitDetailButtons(stUnitReq *logic.StUnitContainer, stUni
 				return
 			}
 
+			// 修改了已有 enum/data/protocol 的名字, 重命名并修改所有引用, 而不是新建一个单元
+			if stUnitAck == nil && stUnitReq.OriginName != "" && stUnitReq.OriginName != stReqUnit.UnitName {
+				stapp.RenameAndSaveUnits(stUnits, stUnitReq.OriginName, func() {
+					customDialog.Hide()
+					if onSaved != nil {
+						onSaved()
+					}
+				})
+				return
+			}
+
 			if !stapp.CoreMgr.AddUpdateUnits(stUnits) {
 				logrus.Error("[CreateNewMessage] AddUpdateUnits failed.")
 				return
 			}
 
 			customDialog.Hide()
+			if onSaved != nil {
+				onSaved()
+			}
 		}),
 	)
 	return buttons, referencesList
 }
 
-func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit logic.StStrRowUnit, attrBox *fyne.Container, rowList *[]logic.StRowUnit) {
+// onDelete 在删除该行后调用, 可以为 nil
+func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit model.Field, attrBox *fyne.Container, rowList *[]StRowUnit, onDelete func()) {
 	var entryOption *widget.Select
+	var entryKeyType *widget.Select
+	var entryOneof *widget.Entry
+	var entryDefaultValue *widget.Entry
+	containerOption := container.NewGridWithRows(1)
+	genConfig := stapp.CoreMgr.GetGenConfig()
 	// var entryType *widget.Entry
-	var entryTypeSelect *logic.CompletionEntry
+	var entryTypeSelect *CompletionEntry
 	var entryDefault *widget.Select
 	containerDefaultComment := container.NewGridWithRows(1)
 	entryName := widget.NewEntry()
@@ -766,16 +996,41 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 	entryIndex := widget.NewEntry()
 
 	if tabletype == logic.TableType_Protocol || tabletype == logic.TableType_Data || tabletype == logic.TableType_RPC {
-		entryOption = widget.NewSelect([]string{"optional", "repeated"}, nil)
+		entryOption = widget.NewSelect(genConfig.GetFieldOptions(), nil)
 		if strRowUnit.EntryOption == "" {
-			entryOption.Selected = "optional"
+			entryOption.Selected = model.OptionOptional
 		} else {
 			entryOption.Selected = strRowUnit.EntryOption
 		}
+		// map 的 key 类型, 只在选择 map 时出现
+		entryKeyType = widget.NewSelect(model.GetMapKeyTypes(), nil)
+		entryKeyType.PlaceHolder = "key type"
+		if strRowUnit.EntryKeyType != "" {
+			entryKeyType.Selected = strRowUnit.EntryKeyType
+		}
+		// 所属的 oneof, 同名的行生成在同一个 oneof 中
+		entryOneof = widget.NewEntry()
+		entryOneof.SetPlaceHolder("oneof...")
+		entryOneof.SetText(strRowUnit.EntryOneof)
+		refreshOption := func() {
+			containerOption.Objects = []fyne.CanvasObject{entryOption}
+			if entryOption.Selected == model.OptionMap {
+				containerOption.Add(entryKeyType)
+			}
+			containerOption.Add(entryOneof)
+			containerOption.Refresh()
+		}
+		refreshOption()
+		entryOption.OnChanged = func(str string) {
+			refreshOption()
+		}
 
 		// 枚举默认值
 		entryDefault = widget.NewSelect([]string{}, nil)
 		// entryDefault.SetPlaceHolder("Default Value...")
+		// 标量默认值, 只有 proto2/editions 支持
+		entryDefaultValue = widget.NewEntry()
+		entryDefaultValue.SetPlaceHolder("default...")
 
 		// 输入框
 		// entryType = widget.NewEntry()
@@ -786,7 +1041,7 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 
 		// 搜索框
 		searchFields := stapp.CoreMgr.GetAllUseableEntryTypeWithProtoType()
-		entryTypeSelect = logic.NewCompletionEntry(searchFields)
+		entryTypeSelect = NewCompletionEntry(searchFields)
 		entryTypeSelect.ShowMouseMenu(true) // 不依靠鼠标事件了,这个没用了.
 		entryTypeSelect.SetPlaceHolder("Enter filed type...")
 		if strRowUnit.EntryType != "" {
@@ -812,6 +1067,13 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 			if stapp.CoreMgr.SearchTableListWithName(str) == logic.TableType_Enum {
 				entryDefault.SetOptions(stapp.CoreMgr.GetVarListOfEnum(entryTypeSelect.Text))
 				containerDefaultComment.Add(entryDefault)
+			} else {
+				entryDefault.ClearSelected()
+				if genConfig.HasDefaultValue() && stapp.CoreMgr.CheckProtoType(str) {
+					containerDefaultComment.Add(entryDefaultValue)
+				} else {
+					entryDefaultValue.SetText("")
+				}
 			}
 			containerDefaultComment.Add(entryComment)
 			containerDefaultComment.Refresh()
@@ -852,8 +1114,8 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 		oneRowKeyValue := container.NewHSplit(entryTypeSelect, entryName)
 		oneRowKeyValue.Offset = 0.35
 
-		oneRowOptionKeyValue := container.NewHSplit(entryOption, oneRowKeyValue)
-		oneRowOptionKeyValue.Offset = 0.15
+		oneRowOptionKeyValue := container.NewHSplit(containerOption, oneRowKeyValue)
+		oneRowOptionKeyValue.Offset = 0.25
 
 		oneRowOptionKeyValueIndex := container.NewHSplit(oneRowOptionKeyValue, entryIndex)
 		oneRowOptionKeyValueIndex.Offset = 0.95
@@ -864,6 +1126,9 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 				entryDefault.SetSelected(strRowUnit.EntryDefault)
 			}
 			containerDefaultComment.Add(entryDefault)
+		} else if genConfig.HasDefaultValue() && stapp.CoreMgr.CheckProtoType(entryTypeSelect.Text) {
+			entryDefaultValue.SetText(strRowUnit.EntryDefault)
+			containerDefaultComment.Add(entryDefaultValue)
 		}
 		containerDefaultComment.Add(entryComment)
 		oneRow = container.NewHSplit(oneRowOptionKeyValueIndex, containerDefaultComment)
@@ -871,13 +1136,16 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 	}
 
 	// 创建一个新的RowComponents实例并保存到列表中,加入列表,方便获取数值
-	stRow := logic.StRowUnit{
-		EntryIndex:   entryIndex,
-		EntryOption:  entryOption,
-		EntryType:    entryTypeSelect,
-		EntryName:    entryName,
-		EntryDefault: entryDefault,
-		EntryComment: entryComment,
+	stRow := StRowUnit{
+		EntryIndex:        entryIndex,
+		EntryOption:       entryOption,
+		EntryKeyType:      entryKeyType,
+		EntryOneof:        entryOneof,
+		EntryType:         entryTypeSelect,
+		EntryName:         entryName,
+		EntryDefault:      entryDefault,
+		EntryComment:      entryComment,
+		EntryDefaultValue: entryDefaultValue,
 	}
 
 	var deleteFunc func() // 声明删除操作函数
@@ -896,6 +1164,9 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 		*rowList = stRow.RemoveElementFromSlice(*rowList, stRow)
 		attrBox.Remove(oneRowWithDeleteButton)
 		attrBox.Refresh()
+		if onDelete != nil {
+			onDelete()
+		}
 		// customDialog.Refresh()
 	}
 	attrBox.Add(oneRowWithDeleteButton)
@@ -905,7 +1176,7 @@ func (stapp *StApp) CreateRowForEditUnit(tabletype logic.ETableType, strRowUnit
 }
 
 // 创建详情页
-func (stapp *StApp) CreateEntryTypeInfo(entry *logic.CompletionEntry, pe *fyne.PointEvent) {
+func (stapp *StApp) CreateEntryTypeInfo(entry *CompletionEntry, pe *fyne.PointEvent) {
 
 	// 如果是proto type 则不创建
 	if stapp.CoreMgr.CheckProtoType(entry.Text) {
@@ -947,7 +1218,7 @@ func (stapp *StApp) CreateEntryTypeInfo(entry *logic.CompletionEntry, pe *fyne.P
 
 }
 
-func (stapp *StApp) CreateEntryReferenceListSingle(entry *logic.CompletionEntry, pe *fyne.PointEvent) {
+func (stapp *StApp) CreateEntryReferenceListSingle(entry *CompletionEntry, pe *fyne.PointEvent) {
 	canvas := fyne.CurrentApp().Driver().CanvasForObject((*stapp.Window).Content())
 	pPopUp := widget.NewPopUp(stapp.CreateEntryReferenceListCanvas(entry.Text), canvas)
 	// 设置窗口大小
@@ -999,7 +1270,7 @@ func (stapp *StApp) DestoryEntryTypeInfo(pPopUp **widget.PopUp) {
 // 检查 StUnits
 func (stapp *StApp) CheckStUnits(stUnits logic.StUnits) bool {
 	// 检查 name 的合法性
-	if stUnits.UnitListName == "" || strings.Contains(stUnits.UnitListName, " ") || utils.CheckPositiveInteger(stUnits.UnitListName) || utils.CheckStartWithNum(stUnits.UnitListName) {
+	if !logic.CheckUnitName(stUnits.UnitListName) {
 		logrus.Error("CheckStUnits failed. invalid MsgName: ", stUnits.UnitListName)
 		dialog.ShowInformation("Error!", "The name is invalid", *stapp.Window)
 		return false
@@ -1008,81 +1279,96 @@ func (stapp *StApp) CheckStUnits(stUnits logic.StUnits) bool {
 	for _, stUnit := range stUnits.UnitList {
 		if !stapp.CheckStUnit(stUnit) {
 			logrus.Error("CheckStUnits failed. invalid stUnit, name : ", stUnit.UnitName, ", subtabletype:", stUnit.SubTableType)
-			dialog.ShowInformation("Error!", "The name is invalid", *stapp.Window)
 			return false
 		}
 	}
 	return true
 }
 
-// 检查 StUnit
+// 检查 StUnit, 规则见 logic.CheckStUnit
 func (stapp *StApp) CheckStUnit(stUnit logic.StUnit) bool {
-	// 检查 name 的合法性
-	if stUnit.UnitName == "" || strings.Contains(stUnit.UnitName, " ") || utils.CheckPositiveInteger(stUnit.UnitName) || utils.CheckStartWithNum(stUnit.UnitName) {
-		logrus.Error("CheckStUnit failed. invalid MsgName: ", stUnit.UnitName)
-		dialog.ShowInformation("Error!", "The name is invalid", *stapp.Window)
-		return false
-	}
-
-	// 检查 name 是否已经存在
-	if stUnit.IsCreatNew && stapp.CoreMgr.CheckExistSameName(stUnit.UnitName) {
-		logrus.Error("CheckStUnit failed. Repeated MsgName: ", stUnit.UnitName)
-		dialog.ShowInformation("Error!", "The name["+stUnit.UnitName+"] is already exist.", *stapp.Window)
+	isOk, strError := logic.CheckStUnit(stapp.CoreMgr.GetSchema(), stapp.CoreMgr.GetGenConfig(), stUnit)
+	if !isOk {
+		dialog.ShowInformation("Error!", strError, *stapp.Window)
 		return false
 	}
+	return true
+}
 
-	for _, rowComponents := range stUnit.RowList {
-		// 检查 EntryIndex 的合法性
-		if rowComponents.EntryIndex != nil && (rowComponents.EntryIndex.Text == "") {
-			logrus.Error("CheckStUnit failed. EntryIndex: ", rowComponents.EntryIndex.Text)
-			dialog.ShowInformation("Error!", "Index["+rowComponents.EntryIndex.Text+"], the EntryIndex is invalid", *stapp.Window)
-			return false
+// 展示 protoc 和插件的诊断, 每个工具一行. onContinue 不为 nil 时可以继续生成, 有找不到的工具时不能继续
+func (stapp *StApp) ShowToolchainReport(report logic.StToolchainReport, onContinue func()) {
+	toolList := append([]logic.StToolInfo{report.Protoc}, report.Plugins...)
+	form := container.NewVBox()
+	for _, toolInfo := range toolList {
+		strVersion := toolInfo.Version
+		if strVersion == "" {
+			strVersion = "-"
 		}
-
-		// 检查索引值合法性
-		if stUnit.TableType == logic.TableType_Enum {
-			if !utils.CheckNaturalInteger(rowComponents.EntryIndex.Text) {
-				logrus.Error("CheckStUnit failed. EntryName: ", rowComponents.EntryName.Text)
-				dialog.ShowInformation("Error!", "Index["+rowComponents.EntryIndex.Text+"], the EntryIndex is invalid", *stapp.Window)
-				return false
-			}
-		} else if stUnit.TableType == logic.TableType_Protocol {
-			if !utils.CheckPositiveInteger(rowComponents.EntryIndex.Text) {
-				logrus.Error("CheckStUnit failed. EntryName: ", rowComponents.EntryName.Text)
-				dialog.ShowInformation("Error!", "EntryName["+rowComponents.EntryName.Text+"], the EntryIndex is invalid", *stapp.Window)
-				return false
-			}
+		if toolInfo.MinVersion != "" {
+			strVersion += " (min " + toolInfo.MinVersion + ")"
 		}
-		// 检查 类型 的合法性
-		if rowComponents.EntryType != nil && (rowComponents.EntryType.Text == "" || strings.Contains(rowComponents.EntryType.Text, " ") || rowComponents.EntryType.Text == stUnit.UnitName || utils.CheckPositiveInteger(rowComponents.EntryType.Text) || utils.CheckStartWithNum(rowComponents.EntryType.Text)) {
-			logrus.Error("CheckStUnit failed. EntryType: ", rowComponents.EntryType.Text)
-			dialog.ShowInformation("Error!", "Index["+rowComponents.EntryIndex.Text+"], the EntryType is invalid", *stapp.Window)
-			return false
+		title := widget.NewLabelWithStyle("["+toolInfo.GetLevelText()+"] "+toolInfo.Name+"  "+strVersion, fyne.TextAlignLeading, fyne.TextStyle{Bold: toolInfo.Level != logic.ToolLevel_Ok})
+		strDetail := toolInfo.Source
+		if toolInfo.Path != "" {
+			strDetail += ": " + toolInfo.Path
 		}
-		// 检查 变量名 的合法性
-		if rowComponents.EntryName != nil && (rowComponents.EntryName.Text == "" || strings.Contains(rowComponents.EntryName.Text, " ") || utils.CheckPositiveInteger(rowComponents.EntryName.Text) || utils.CheckStartWithNum(rowComponents.EntryName.Text)) {
-			logrus.Error("CheckStUnit failed. EntryName: ", rowComponents.EntryName.Text)
-			dialog.ShowInformation("Error!", "Index["+rowComponents.EntryIndex.Text+"], the EntryName is invalid", *stapp.Window)
-			return false
+		if toolInfo.Message != "" {
+			strDetail += "\n" + toolInfo.Message
 		}
-		// 检查默认值合法性
-		if rowComponents.EntryDefault != nil && stapp.CoreMgr.SearchTableListWithName(rowComponents.EntryType.Text) == logic.TableType_Enum && (rowComponents.EntryDefault.Selected == "" || strings.Contains(rowComponents.EntryDefault.Selected, " ") || utils.CheckPositiveInteger(rowComponents.EntryDefault.Selected) || utils.CheckStartWithNum(rowComponents.EntryDefault.Selected)) {
-			logrus.Error("CheckStUnit failed. EntryIndex: ", rowComponents.EntryIndex.Text, ",EntryName: ", rowComponents.EntryName.Text)
-			dialog.ShowInformation("Error!", "Index["+rowComponents.EntryIndex.Text+"], the EntryDefault is invalid", *stapp.Window)
-			return false
+		detail := widget.NewLabel(strDetail)
+		detail.Wrapping = fyne.TextWrapWord
+		form.Add(title)
+		form.Add(detail)
+		form.Add(widget.NewSeparator())
+	}
+	form.Add(widget.NewLabel("search order: path in config.xml genpb -> data dir -> PATH"))
+	scroll := container.NewVScroll(form)
+	scroll.SetMinSize(fyne.NewSize(700, 400))
+	if onContinue == nil || report.GetLevel() == logic.ToolLevel_Error {
+		strTitle := "Toolchain"
+		if onContinue != nil {
+			strTitle = "Generate pb failed, tool not found"
 		}
+		dialog.ShowCustom(strTitle, "Close", scroll, *stapp.Window)
+		return
 	}
+	dialog.ShowCustomConfirm("Toolchain warnings", "Continue", "Cancel", scroll, func(isContinue bool) {
+		if isContinue {
+			onContinue()
+		}
+	}, *stapp.Window)
+}
 
-	if !logic.CheckFieldNameList(stUnit.RowList) {
-		dialog.ShowInformation("Error!", " The field name ara duplicate", *stapp.Window)
-		return false
+// 按生成目标展示生成 pb 的结果, 每个目标一项, 展开后显示生成的文件和 protoc 的输出
+func (stapp *StApp) ShowPbResults(isSuccess bool, resultList []logic.StPbTargetResult) {
+	items := []*widget.AccordionItem{}
+	for _, result := range resultList {
+		strTitle := result.Target.Name + ": failed"
+		if result.Success {
+			strTitle = result.Target.Name + ": ok, " + strconv.Itoa(len(result.Files)) + " file(s)"
+		}
+		strDetail := "output: " + result.Target.Out + "\n" + strings.Join(result.Files, "\n")
+		if strOutput := strings.TrimSpace(result.Output); strOutput != "" {
+			strDetail += "\n\n" + strOutput
+		}
+		detail := widget.NewLabel(strDetail)
+		detail.Wrapping = fyne.TextWrapWord
+		items = append(items, widget.NewAccordionItem(strTitle, detail))
 	}
-
-	if !logic.CheckFieldIndexList(stUnit.RowList) {
-		dialog.ShowInformation("Error!", " The field index ara duplicate", *stapp.Window)
-		return false
+	accordion := widget.NewAccordion(items...)
+	// 失败的目标默认展开
+	for i, result := range resultList {
+		if !result.Success {
+			accordion.Open(i)
+		}
 	}
-	return true
+	scroll := container.NewVScroll(accordion)
+	scroll.SetMinSize(fyne.NewSize(600, 400))
+	strTitle := "Generate pb done"
+	if !isSuccess {
+		strTitle = "Generate pb failed"
+	}
+	dialog.ShowCustom(strTitle, "Close", scroll, *stapp.Window)
 }
 
 // 创建list的说明和button
@@ -1098,7 +1384,24 @@ func (stapp *StApp) CreateButtomCanvas(tabletype logic.ETableType) fyne.CanvasOb
 				dialog.ShowInformation("Error!", "Generate proto file failed for GetGenProtoPath.", *stapp.Window)
 				return
 			}
-			logic.GenProto(stapp.CoreMgr.FileEtree, strProtoPath)
+			schema := stapp.CoreMgr.GetFileSchema()
+			genConfig, errorList := stapp.CoreMgr.GetGenConfigWithMsgId(schema, stapp.CoreMgr.ProtoXmlFilePath)
+			if len(errorList) > 0 {
+				dialog.ShowInformation("Error!", "Generate proto file failed for msgid:\n"+strings.Join(errorList, "\n"), *stapp.Window)
+				return
+			}
+			if !logic.GenProto(schema, strProtoPath, genConfig) {
+				dialog.ShowInformation("Error!", "Generate proto file failed, please check the log.", *stapp.Window)
+				return
+			}
+		})
+		buttonGenDispatch := widget.NewButton("Generate dispatch", func() {
+			isSuccess, _, errorList := stapp.CoreMgr.GenDispatchFromSchema(stapp.CoreMgr.GetFileSchema(), stapp.CoreMgr.ProtoXmlFilePath, "")
+			if !isSuccess {
+				logrus.Error("Generate dispatch failed for ", errorList)
+				dialog.ShowInformation("Error!", "Generate dispatch failed:\n"+strings.Join(errorList, "\n"), *stapp.Window)
+				return
+			}
 		})
 		buttonGenProtoToPb := widget.NewButton("Generate pb", func() {
 			// stapp.CoreMgr.SaveProtoXmlToFile()
@@ -1114,15 +1417,35 @@ func (stapp *StApp) CreateButtomCanvas(tabletype logic.ETableType) fyne.CanvasOb
 				dialog.ShowInformation("Error!", "Generate pb file failed for GetGenPbPath.", *stapp.Window)
 				return
 			}
-			isSuccess, strError := logic.GenPbFromProto(strProtoPath, strPbPath)
-			if !isSuccess {
-				logrus.Error("Generate pb file failed for ", strError)
-				dialog.ShowInformation("Error!", "Generate pb file failed for "+strError, *stapp.Window)
+			pbConfig, errorList := stapp.CoreMgr.GetPbConfigWithSource(stapp.CoreMgr.GetFileSchema(), stapp.CoreMgr.ProtoXmlFilePath)
+			if len(errorList) > 0 {
+				dialog.ShowInformation("Error!", "Generate pb file failed for msgid:\n"+strings.Join(errorList, "\n"), *stapp.Window)
 				return
 			}
+			genPb := func() {
+				isSuccess, resultList := logic.GenPbFromProto(strProtoPath, strPbPath, pbConfig)
+				if !isSuccess {
+					logrus.Error("Generate pb file failed. results:\n", logic.GetPbResultText(resultList))
+				}
+				stapp.ShowPbResults(isSuccess, resultList)
+			}
+			// 生成前检查工具, 有问题时先展示诊断
+			report := logic.CheckToolchain(pbConfig)
+			if report.GetLevel() == logic.ToolLevel_Ok {
+				genPb()
+				return
+			}
+			logrus.Warn("Generate pb toolchain problems:\n", report.String())
+			stapp.ShowToolchainReport(report, genPb)
 		})
 		// 使用HBox将searchEntry和searchButton安排在同一行，并使用HSplit来设置比例
-		buttomContainer := container.NewHBox(container.NewStack(label), buttonGenProto, buttonGenProtoToPb)
+		buttonToolchain := widget.NewButton("Toolchain", func() {
+			stapp.ShowToolchainReport(logic.CheckToolchain(stapp.CoreMgr.GetPbConfig()), nil)
+		})
+		buttonRename := widget.NewButton("Rename", func() {
+			stapp.ShowRenameDialog()
+		})
+		buttomContainer := container.NewHBox(container.NewStack(label), buttonGenProto, buttonGenProtoToPb, buttonGenDispatch, buttonToolchain, buttonRename)
 		// buttomContainer.Offset = 0.75 //设置searchEntry 占 3/4， searchButton 占 1/4
 		return buttomContainer
 	}
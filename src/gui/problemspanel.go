@@ -0,0 +1,98 @@
+package gui
+
+import (
+	"protocolgo/src/logic"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sirupsen/logrus"
+)
+
+// 问题页签的标题
+const ProblemsTabName = "Problems"
+
+// 创建问题页签: 列出当前协议的所有诊断, 点击一项打开对应的单元
+func (stapp *StApp) CreateProblemsTab() fyne.CanvasObject {
+	summary := widget.NewLabel("")
+	list := widget.NewList(
+		func() int { return len(stapp.problems) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			label := item.(*widget.Label)
+			diag := stapp.problems[id]
+			label.TextStyle = fyne.TextStyle{Bold: diag.Severity == logic.DiagSeverity_Error}
+			strText := diag.String()
+			if diag.IsFixable() {
+				strText += " [auto-fix]"
+			}
+			label.SetText(strText)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		list.Unselect(id)
+		if id < 0 || id >= len(stapp.problems) {
+			return
+		}
+		stapp.OpenDiagnostic(stapp.problems[id])
+	}
+	stapp.refreshProblems = func() {
+		stapp.problems = logic.DiagnoseSchema(stapp.CoreMgr.GetSchema(), stapp.CoreMgr.GetGenConfig())
+		nError, nWarn := logic.CountDiagnostics(stapp.problems)
+		summary.SetText(strconv.Itoa(nError) + " error(s), " + strconv.Itoa(nWarn) + " warning(s). Click a problem to open the unit.")
+		list.Refresh()
+	}
+	stapp.refreshProblems()
+
+	buttonRefresh := widget.NewButton("Refresh", func() {
+		stapp.refreshProblems()
+	})
+	buttonFix := widget.NewButton("Fix all", func() {
+		stapp.FixProblems()
+	})
+	topContainer := container.NewHSplit(container.NewStack(summary), container.NewGridWithColumns(2, buttonFix, buttonRefresh))
+	topContainer.Offset = 0.75
+	return container.NewBorder(topContainer, nil, nil, nil, list)
+}
+
+// 确认后自动修复所有可修复的诊断, 修复结果需要保存
+func (stapp *StApp) FixProblems() {
+	stapp.refreshProblems()
+	fixList := []string{}
+	for _, diag := range stapp.problems {
+		if diag.IsFixable() {
+			fixList = append(fixList, diag.String())
+		}
+	}
+	if len(fixList) == 0 {
+		dialog.ShowInformation("Fix all", "No problem can be fixed automatically.", *stapp.Window)
+		return
+	}
+	strMessage := "Fix the following problems?\n" + strings.Join(fixList, "\n") +
+		"\n\nMissing zero values are added as <Enum>_None = 0, conflicting enum values are prefixed with the enum name."
+	dialog.ShowConfirm("Fix all", strMessage, func(isConfirm bool) {
+		if !isConfirm {
+			return
+		}
+		fixed, failed := stapp.CoreMgr.FixDiagnostics(stapp.problems)
+		stapp.refreshProblems()
+		strResult := strconv.Itoa(len(fixed)) + " problem(s) fixed. Save to write the xml file.\n" + strings.Join(fixed, "\n")
+		if len(failed) > 0 {
+			strResult += "\n\nCan not fix:\n" + strings.Join(failed, "\n")
+		}
+		dialog.ShowInformation("Fix all", strResult, *stapp.Window)
+	}, *stapp.Window)
+}
+
+// 打开诊断所在的单元, 保存后刷新问题列表
+func (stapp *StApp) OpenDiagnostic(diag logic.StDiagnostic) {
+	logrus.Info("OpenDiagnostic: ", diag.String())
+	parentPath := ""
+	if index := strings.LastIndex(diag.Unit, "."); index >= 0 {
+		parentPath = diag.Unit[:index]
+	}
+	stapp.EditNestedUnit(diag.TableType, parentPath, diag.Unit, stapp.refreshProblems)
+}
@@ -0,0 +1,40 @@
+package gui
+
+import (
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sirupsen/logrus"
+)
+
+// 打开的 xml 有自动保存日志时, 列出其中未保存的修改, 提示恢复或放弃
+func (stapp *StApp) OfferJournalRestore() {
+	hasChanges, changeList := stapp.CoreMgr.GetJournalChanges()
+	if !hasChanges {
+		return
+	}
+	logrus.Info("OfferJournalRestore. unsaved changes:", changeList)
+	changeListWidget := widget.NewList(
+		func() int { return len(changeList) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) { item.(*widget.Label).SetText(changeList[id]) },
+	)
+	strMessage := "Found " + strconv.Itoa(len(changeList)) + " unsaved change(s) from the last session. Restore them?"
+	content := container.NewBorder(widget.NewLabel(strMessage), nil, nil, nil, changeListWidget)
+	restoreDialog := dialog.NewCustomConfirm("Restore unsaved changes", "Restore", "Discard", content, func(isRestore bool) {
+		if !isRestore {
+			stapp.CoreMgr.DiscardJournal()
+			return
+		}
+		if !stapp.CoreMgr.RestoreJournal() {
+			dialog.ShowInformation("Error!", "Restore the unsaved changes failed.", *stapp.Window)
+			return
+		}
+		dialog.ShowInformation("Restored", strconv.Itoa(len(changeList))+" change(s) restored. Save to write the xml file.", *stapp.Window)
+	}, *stapp.Window)
+	restoreDialog.Resize(fyne.NewSize(700, 500))
+	restoreDialog.Show()
+}
@@ -0,0 +1,145 @@
+package gui
+
+import (
+	"protocolgo/src/logic"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/sirupsen/logrus"
+)
+
+// 重命名类型本身时枚举值下拉框的选项
+const renameTypeOption = "(type itself)"
+
+// 重命名预览的展示文本: 受影响的单元, 每处修改和注意事项
+func GetRenamePreviewText(result logic.StRenameResult) string {
+	lines := []string{"Affected units: " + strings.Join(result.GetAffectedUnits(), ", "), "", strconv.Itoa(len(result.Changes)) + " change(s):"}
+	for _, change := range result.Changes {
+		lines = append(lines, "    "+change.String())
+	}
+	for _, strNote := range result.Notes {
+		lines = append(lines, "", "Note: "+strNote)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Main 页签的重命名: 选择类型(及枚举值), 输入新名字, 预览受影响的单元后执行
+func (stapp *StApp) ShowRenameDialog() {
+	schema := stapp.CoreMgr.GetSchema()
+	if schema == nil {
+		dialog.ShowInformation("Error!", "Open the xml first.", *stapp.Window)
+		return
+	}
+	labelRefs := widget.NewLabel("")
+	previewLabel := widget.NewLabel("Select a type, enter the new name and click Preview.")
+	entryNewName := widget.NewEntry()
+	entryNewName.SetPlaceHolder("Enter new name...")
+	selectValue := widget.NewSelect([]string{renameTypeOption}, nil)
+	selectValue.SetSelected(renameTypeOption)
+	selectType := widget.NewSelect(schema.GetTypeNames(), nil)
+
+	var buttonApply *widget.Button
+	getValueName := func() string {
+		if selectValue.Selected == renameTypeOption {
+			return ""
+		}
+		return selectValue.Selected
+	}
+	// 输入变化后需要重新预览
+	resetPreview := func() {
+		buttonApply.Disable()
+		previewLabel.SetText("Click Preview to see the affected units.")
+	}
+	selectType.OnChanged = func(strPath string) {
+		options := []string{renameTypeOption}
+		if enum := schema.FindEnumByPath(strPath); enum != nil {
+			options = append(options, enum.GetValueNames()...)
+		}
+		selectValue.Options = options
+		selectValue.SetSelected(renameTypeOption)
+		labelRefs.SetText("used by " + strconv.Itoa(len(stapp.CoreMgr.GetFieldReferences(strPath))) + " field(s)")
+		resetPreview()
+	}
+	selectValue.OnChanged = func(string) {
+		resetPreview()
+	}
+	entryNewName.OnChanged = func(string) {
+		resetPreview()
+	}
+
+	var customDialog *dialog.CustomDialog
+	buttonPreview := widget.NewButton("Preview", func() {
+		isOk, result, strError := stapp.CoreMgr.Rename(selectType.Selected, getValueName(), entryNewName.Text, false)
+		if !isOk {
+			buttonApply.Disable()
+			previewLabel.SetText("Can not rename: " + strError)
+			return
+		}
+		previewLabel.SetText(GetRenamePreviewText(result))
+		buttonApply.Enable()
+	})
+	buttonApply = widget.NewButton("Apply", func() {
+		isOk, result, strError := stapp.CoreMgr.Rename(selectType.Selected, getValueName(), entryNewName.Text, true)
+		if !isOk {
+			previewLabel.SetText("Can not rename: " + strError)
+			buttonApply.Disable()
+			return
+		}
+		customDialog.Hide()
+		dialog.ShowInformation("Renamed", strconv.Itoa(len(result.Changes))+" change(s) applied. Save to write the xml file.", *stapp.Window)
+	})
+	buttonApply.Disable()
+	buttonCancel := widget.NewButton("Cancel", func() {
+		customDialog.Hide()
+	})
+
+	form := container.NewVBox(
+		container.NewBorder(nil, nil, widget.NewLabel("Type:"), labelRefs, selectType),
+		container.NewBorder(nil, nil, widget.NewLabel("Enum value:"), nil, selectValue),
+		container.NewBorder(nil, nil, widget.NewLabel("New name:"), nil, entryNewName),
+	)
+	content := container.NewBorder(form, container.NewHBox(buttonPreview, buttonApply, buttonCancel), nil, nil, container.NewVScroll(previewLabel))
+	customDialog = dialog.NewCustomWithoutButtons("Rename", content, *stapp.Window)
+	customDialog.Resize(fyne.NewSize(800, 600))
+	customDialog.Show()
+}
+
+// 编辑已有的单元时修改了名字: 预览后先按原名保存编辑的内容, 再重命名并修改所有引用
+func (stapp *StApp) RenameAndSaveUnits(stUnits logic.StUnits, originName string, onSaved func()) {
+	stUnit := stUnits.UnitList[0]
+	strNewName := stUnit.UnitName
+	strOldPath := originName
+	if stUnit.ParentPath != "" {
+		strOldPath = stUnit.ParentPath + "." + originName
+	}
+	isOk, result, strError := stapp.CoreMgr.Rename(strOldPath, "", strNewName, false)
+	if !isOk {
+		dialog.ShowInformation("Error!", "Rename "+strOldPath+" failed: "+strError, *stapp.Window)
+		return
+	}
+	previewLabel := widget.NewLabel(GetRenamePreviewText(result))
+	confirmDialog := dialog.NewCustomConfirm("Rename "+strOldPath+" to "+strNewName, "Apply", "Cancel", container.NewVScroll(previewLabel), func(isConfirm bool) {
+		if !isConfirm {
+			return
+		}
+		stUnits.UnitListName = originName
+		stUnits.UnitList[0].UnitName = originName
+		if !stapp.CoreMgr.AddUpdateUnits(stUnits) {
+			logrus.Error("[RenameAndSaveUnits] AddUpdateUnits failed. originName:", originName)
+			return
+		}
+		if isOk, _, strError := stapp.CoreMgr.Rename(strOldPath, "", strNewName, true); !isOk {
+			dialog.ShowInformation("Error!", "The unit is saved, but rename failed: "+strError, *stapp.Window)
+			return
+		}
+		if onSaved != nil {
+			onSaved()
+		}
+	}, *stapp.Window)
+	confirmDialog.Resize(fyne.NewSize(700, 500))
+	confirmDialog.Show()
+}
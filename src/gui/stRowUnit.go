@@ -0,0 +1,96 @@
+package gui
+
+import (
+	"strings"
+
+	"protocolgo/src/logic"
+	"protocolgo/src/model"
+
+	"fyne.io/fyne/v2/widget"
+)
+
+// 编辑页中 Unit 的一行控件
+type StRowUnit struct {
+	EntryIndex   *widget.Entry
+	EntryOption  *widget.Select
+	EntryKeyType *widget.Select // map 的 key 类型
+	// EntryType   *widget.Entry
+	EntryType    *CompletionEntry
+	EntryName    *widget.Entry
+	EntryDefault *widget.Select
+	EntryComment *widget.Entry
+	EntryOneof   *widget.Entry // 所属的 oneof
+	// 标量默认值, 枚举默认值使用 EntryDefault
+	EntryDefaultValue *widget.Entry
+}
+
+// 编辑页中 Unit 的控件集合
+type StUnitContainer struct {
+	UnitNameEntry    *widget.Entry
+	UnitCommentEntry *widget.Entry
+	TableType        logic.ETableType
+	RowList          *[]StRowUnit
+	IsCreatNew       bool
+	ParentPath       string            // 嵌套类型所在消息的全名
+	Reserved         *[]model.Reserved // 保留的序号和名字
+	OriginName       string            // 编辑已有单元时的原名, 新建时为空
+}
+
+// 保留项的展示文本, 如 3 hp
+func GetReservedText(reserved model.Reserved) string {
+	return strings.TrimSpace(reserved.EntryIndex + " " + reserved.EntryName)
+}
+
+func (editrow *StRowUnit) RemoveElementFromSlice(s []StRowUnit, elementToBeDeleted StRowUnit) []StRowUnit {
+	for i, element := range s {
+		// 使用适当的比较来确定哪一个元素应被删除
+		if element == elementToBeDeleted {
+			return append(s[:i], s[i+1:]...)
+		}
+	}
+	return s
+}
+
+// 从控件中读取一行数据
+func (editrow *StRowUnit) GetField() model.Field {
+	var field model.Field
+	if editrow.EntryOption != nil {
+		field.EntryOption = editrow.EntryOption.Selected
+	}
+	if editrow.EntryKeyType != nil && field.IsMap() {
+		field.EntryKeyType = editrow.EntryKeyType.Selected
+	}
+	if editrow.EntryType != nil {
+		field.EntryType = editrow.EntryType.Text
+	}
+	field.EntryName = editrow.EntryName.Text
+	field.EntryIndex = editrow.EntryIndex.Text
+	if editrow.EntryDefault != nil {
+		field.EntryDefault = editrow.EntryDefault.Selected
+	}
+	if field.EntryDefault == "" && editrow.EntryDefaultValue != nil {
+		field.EntryDefault = editrow.EntryDefaultValue.Text
+	}
+	field.EntryComment = editrow.EntryComment.Text
+	if editrow.EntryOneof != nil {
+		field.EntryOneof = strings.TrimSpace(editrow.EntryOneof.Text)
+	}
+	return field
+}
+
+func (stUnitContainer *StUnitContainer) GetStUnit() logic.StUnit {
+	var stUnit logic.StUnit
+	stUnit.UnitName = stUnitContainer.UnitNameEntry.Text
+	stUnit.UnitComment = stUnitContainer.UnitCommentEntry.Text
+	stUnit.TableType = stUnitContainer.TableType
+	stUnit.RowList = []model.Field{}
+	for _, row := range *stUnitContainer.RowList {
+		stUnit.RowList = append(stUnit.RowList, row.GetField())
+	}
+	stUnit.IsCreatNew = stUnitContainer.IsCreatNew
+	stUnit.ParentPath = stUnitContainer.ParentPath
+	if stUnitContainer.Reserved != nil {
+		stUnit.Reserved = append([]model.Reserved{}, *stUnitContainer.Reserved...)
+	}
+	return stUnit
+}
@@ -71,17 +71,19 @@ func (m *TableListLabel) TappedSecondary(e *fyne.PointEvent) {
 		}))
 	} else {
 		popUpContent.Add(widget.NewButton("Delete", func() {
-			dialog.NewConfirm("Confirmation", "Are you sure to delete?", func(response bool) {
-				if response { // if 'Yes' clicked
-					logrus.Info("User confirm to delete: "+msg+",tabletype:", m.tabletype)
-					msg, _ := m.data.Get()
-					// 去除字符串中的[],以及其中的字符
-					re := regexp.MustCompile(`\[.*?\]`)
-					msg = re.ReplaceAllString(msg, "")
-					m.app.CoreMgr.DeleteCurrUnit(m.tabletype, msg)
+			popUp.Hide() // 隐藏窗口
+			msg, _ := m.data.Get()
+			// 去除字符串中的[],以及其中的字符
+			re := regexp.MustCompile(`\[.*?\]`)
+			msg = re.ReplaceAllString(msg, "")
+			logrus.Info("Delete clicked. Item: "+msg+",tabletype:", m.tabletype)
+			// 先检查引用, 删除失败时提示原因. 列表通过数据绑定自动刷新, 删除后刷新问题页签
+			m.app.ShowDeleteDialog(m.tabletype, msg, func() {
+				logrus.Info("Delete done. Item: "+msg+",tabletype:", m.tabletype)
+				if m.app.refreshProblems != nil {
+					m.app.refreshProblems()
 				}
-				popUp.Hide() // 隐藏窗口
-			}, *m.app.Window).Show()
+			})
 		}))
 	}
 
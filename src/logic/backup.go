@@ -0,0 +1,169 @@
+package logic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 默认保留的备份数
+const DefaultBackupCount = 5
+
+// 备份文件名中的时间格式, 如 protocolgo.20240102-150405.000.xml
+const backupTimeLayout = "20060102-150405.000"
+
+// 保存协议 xml 时的备份配置
+type StBackupConfig struct {
+	Dir   string // 备份目录, 为空时为协议 xml 同目录下的 backup
+	Count int    // 保留的备份数, 为 0 时不备份
+}
+
+// 一个备份文件
+type StBackupFile struct {
+	Path string
+	Time time.Time
+	Size int64
+}
+
+func NewBackupConfig() StBackupConfig {
+	return StBackupConfig{Count: DefaultBackupCount}
+}
+
+// 获取协议 xml 的备份目录
+func (backupConfig *StBackupConfig) GetBackupDir(xmlPath string) string {
+	if backupConfig.Dir != "" {
+		return backupConfig.Dir
+	}
+	return filepath.Join(filepath.Dir(xmlPath), "backup")
+}
+
+// 拆分协议 xml 的文件名为备份文件名的前缀和后缀, 如 protocolgo. 和 .xml
+func getBackupNameParts(xmlPath string) (string, string) {
+	strExt := filepath.Ext(xmlPath)
+	return strings.TrimSuffix(filepath.Base(xmlPath), strExt) + ".", strExt
+}
+
+// 写入文件: 先写入同目录的临时文件并 fsync, 再重命名为目标文件, 写入失败时原文件不变
+func WriteFileAtomic(filename string, data []byte) error {
+	strDir := filepath.Dir(filename)
+	tempFile, err := os.CreateTemp(strDir, "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	strTempPath := tempFile.Name()
+	// 失败时删除临时文件
+	isOk := false
+	defer func() {
+		if !isOk {
+			os.Remove(strTempPath)
+		}
+	}()
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	// 保持原文件的权限
+	fileMode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		fileMode = info.Mode().Perm()
+	}
+	if err := os.Chmod(strTempPath, fileMode); err != nil {
+		return err
+	}
+	if err := os.Rename(strTempPath, filename); err != nil {
+		return err
+	}
+	isOk = true
+	// 确保重命名写入磁盘, 部分系统不支持对目录 fsync, 忽略错误
+	if dir, err := os.Open(strDir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// 保存协议 xml: 内容有变化时先将原文件备份到备份目录, 并只保留最新的 Count 个备份, 再原子地写入新内容
+func SaveWithBackup(backupConfig StBackupConfig, xmlPath string, data []byte) error {
+	if backupConfig.Count > 0 {
+		if oldData, err := os.ReadFile(xmlPath); err == nil && !bytes.Equal(oldData, data) {
+			strBackupPath, err := backupFile(backupConfig, xmlPath, oldData)
+			if err != nil {
+				// 备份失败时不保存, 防止丢失原文件
+				logrus.Error("[SaveWithBackup] backup failed. err:", err, ", xmlPath:", xmlPath)
+				return err
+			}
+			logrus.Info("[SaveWithBackup] backup done. path:", strBackupPath)
+		}
+	}
+	if err := WriteFileAtomic(xmlPath, data); err != nil {
+		logrus.Error("[SaveWithBackup] write failed. err:", err, ", xmlPath:", xmlPath)
+		return err
+	}
+	pruneBackups(backupConfig, xmlPath)
+	return nil
+}
+
+// 写入一个带时间的备份, 返回备份文件路径
+func backupFile(backupConfig StBackupConfig, xmlPath string, data []byte) (string, error) {
+	strDir := backupConfig.GetBackupDir(xmlPath)
+	if err := os.MkdirAll(strDir, 0755); err != nil {
+		return "", err
+	}
+	strPrefix, strExt := getBackupNameParts(xmlPath)
+	strPath := filepath.Join(strDir, strPrefix+time.Now().Format(backupTimeLayout)+strExt)
+	return strPath, WriteFileAtomic(strPath, data)
+}
+
+// 删除超出数量的旧备份
+func pruneBackups(backupConfig StBackupConfig, xmlPath string) {
+	if backupConfig.Count <= 0 {
+		return
+	}
+	backupList := GetBackupList(backupConfig, xmlPath)
+	for i := backupConfig.Count; i < len(backupList); i++ {
+		if err := os.Remove(backupList[i].Path); err != nil {
+			logrus.Warn("[pruneBackups] remove failed. err:", err, ", path:", backupList[i].Path)
+		}
+	}
+}
+
+// 获取协议 xml 的所有备份, 最新的在前
+func GetBackupList(backupConfig StBackupConfig, xmlPath string) []StBackupFile {
+	result := []StBackupFile{}
+	strDir := backupConfig.GetBackupDir(xmlPath)
+	entries, err := os.ReadDir(strDir)
+	if err != nil {
+		return result
+	}
+	strPrefix, strExt := getBackupNameParts(xmlPath)
+	for _, entry := range entries {
+		strName := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(strName, strPrefix) || !strings.HasSuffix(strName, strExt) {
+			continue
+		}
+		strTime := strings.TrimSuffix(strings.TrimPrefix(strName, strPrefix), strExt)
+		backupTime, err := time.ParseInLocation(backupTimeLayout, strTime, time.Local)
+		if err != nil {
+			continue
+		}
+		backup := StBackupFile{Path: filepath.Join(strDir, strName), Time: backupTime}
+		if info, err := entry.Info(); err == nil {
+			backup.Size = info.Size()
+		}
+		result = append(result, backup)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Time.After(result[j].Time) })
+	return result
+}
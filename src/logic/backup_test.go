@@ -0,0 +1,130 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	strDir := t.TempDir()
+	strPath := filepath.Join(strDir, "protocolgo.xml")
+	if err := WriteFileAtomic(strPath, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		// 覆盖时保持原文件的权限
+		os.Chmod(strPath, 0600)
+	}
+	if err := WriteFileAtomic(strPath, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(strPath); string(data) != "new" {
+		t.Errorf("got %q, want new", data)
+	}
+	if info, err := os.Stat(strPath); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0600) {
+		t.Errorf("mode is not kept: %v %v", info.Mode(), err)
+	}
+
+	// 失败时不留下临时文件
+	if err := WriteFileAtomic(filepath.Join(strDir, "missing", "protocolgo.xml"), []byte("new")); err == nil {
+		t.Error("write to missing dir")
+	}
+	if entries, _ := os.ReadDir(strDir); len(entries) != 1 {
+		t.Errorf("got %d files, want 1", len(entries))
+	}
+}
+
+func TestSaveWithBackup(t *testing.T) {
+	strDir := t.TempDir()
+	strPath := filepath.Join(strDir, "protocolgo.xml")
+	backupConfig := NewBackupConfig()
+	steps := []struct {
+		name      string
+		data      string
+		wantCount int
+	}{
+		// 新文件没有需要备份的内容
+		{"new file", "v1", 0},
+		{"same content", "v1", 0},
+		{"changed", "v2", 1},
+		{"changed again", "v3", 2},
+	}
+	for _, step := range steps {
+		// 备份文件名精确到毫秒
+		time.Sleep(2 * time.Millisecond)
+		if err := SaveWithBackup(backupConfig, strPath, []byte(step.data)); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if data, _ := os.ReadFile(strPath); string(data) != step.data {
+			t.Errorf("%s: got %q, want %q", step.name, data, step.data)
+		}
+		if backupList := GetBackupList(backupConfig, strPath); len(backupList) != step.wantCount {
+			t.Errorf("%s: got %d backups, want %d", step.name, len(backupList), step.wantCount)
+		}
+	}
+	// 最新的备份是保存前的内容
+	backupList := GetBackupList(backupConfig, strPath)
+	if data, _ := os.ReadFile(backupList[0].Path); string(data) != "v2" {
+		t.Errorf("latest backup got %q, want v2", data)
+	}
+	if filepath.Dir(backupList[0].Path) != filepath.Join(strDir, "backup") || backupList[0].Size != 2 {
+		t.Errorf("latest backup got %+v", backupList[0])
+	}
+
+	// Count 为 0 时不备份
+	backupConfig = StBackupConfig{Dir: filepath.Join(strDir, "other")}
+	if err := SaveWithBackup(backupConfig, strPath, []byte("v4")); err != nil {
+		t.Fatal(err)
+	}
+	if PathExists(backupConfig.Dir) {
+		t.Error("backup without count")
+	}
+
+	// 备份失败时不保存
+	strFile := filepath.Join(strDir, "file")
+	os.WriteFile(strFile, nil, 0644)
+	backupConfig = StBackupConfig{Dir: filepath.Join(strFile, "backup"), Count: 1}
+	if err := SaveWithBackup(backupConfig, strPath, []byte("v5")); err == nil {
+		t.Error("save without backup dir")
+	}
+	if data, _ := os.ReadFile(strPath); string(data) != "v4" {
+		t.Errorf("got %q after backup failed, want v4", data)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	strDir := t.TempDir()
+	strPath := filepath.Join(strDir, "protocolgo.xml")
+	backupConfig := StBackupConfig{Dir: filepath.Join(strDir, "backup"), Count: 2}
+	os.MkdirAll(backupConfig.Dir, 0755)
+	nameList := []string{
+		"protocolgo.20240101-100000.000.xml",
+		"protocolgo.20240103-100000.000.xml",
+		"protocolgo.20240102-100000.000.xml",
+		"protocolgo.20240102-100000.001.xml",
+		// 其他文件不删除
+		"other.20240101-100000.000.xml",
+		"protocolgo.backup.xml",
+	}
+	for _, strName := range nameList {
+		os.WriteFile(filepath.Join(backupConfig.Dir, strName), nil, 0644)
+	}
+	pruneBackups(backupConfig, strPath)
+
+	got := []string{}
+	for _, backup := range GetBackupList(backupConfig, strPath) {
+		got = append(got, filepath.Base(backup.Path))
+	}
+	if want := []string{"protocolgo.20240103-100000.000.xml", "protocolgo.20240102-100000.001.xml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, strName := range nameList[4:] {
+		if !PathExists(filepath.Join(backupConfig.Dir, strName)) {
+			t.Errorf("%s is removed", strName)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package logic
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+	"protocolgo/src/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 检查名字是否合法: 非空, 不含空格, 不以数字开头
+func CheckUnitName(name string) bool {
+	return !(name == "" || strings.Contains(name, " ") || utils.CheckPositiveInteger(name) || utils.CheckStartWithNum(name))
+}
+
+// 检查 StUnit, 失败时返回错误描述. genConfig 决定允许的字段选项和默认值
+func CheckStUnit(schema *model.Schema, genConfig StGenConfig, stUnit StUnit) (bool, string) {
+	// 检查 name 的合法性
+	if !CheckUnitName(stUnit.UnitName) {
+		logrus.Error("CheckStUnit failed. invalid MsgName: ", stUnit.UnitName)
+		return false, "The name is invalid"
+	}
+
+	// 检查 name 是否已经存在, 嵌套类型只在所在消息内查重
+	if stUnit.ParentPath != "" {
+		var parent *model.Message
+		if schema != nil {
+			parent = schema.FindMessageByPath(stUnit.ParentPath)
+		}
+		if parent == nil {
+			logrus.Error("CheckStUnit failed. Parent is not exist. ParentPath: ", stUnit.ParentPath)
+			return false, "The parent[" + stUnit.ParentPath + "] is not exist."
+		}
+		if stUnit.UnitName == parent.Name || stUnit.UnitName == parent.Name+model.RpcTypeReq || stUnit.UnitName == parent.Name+model.RpcTypeAck {
+			logrus.Error("CheckStUnit failed. Nested name is the same as parent: ", stUnit.UnitName)
+			return false, "The name[" + stUnit.UnitName + "] is the same as parent."
+		}
+		if stUnit.IsCreatNew && parent.HasNested(stUnit.UnitName) {
+			logrus.Error("CheckStUnit failed. Repeated nested name: ", stUnit.UnitName)
+			return false, "The name[" + stUnit.UnitName + "] is already exist in " + stUnit.ParentPath + "."
+		}
+	} else if stUnit.IsCreatNew && schema != nil && schema.FindCategory(stUnit.UnitName) != "" {
+		logrus.Error("CheckStUnit failed. Repeated MsgName: ", stUnit.UnitName)
+		return false, "The name[" + stUnit.UnitName + "] is already exist."
+	}
+
+	// 已有单元的嵌套类型名, 字段名不能与之相同
+	var nestedHolder *model.Message
+	if !stUnit.IsCreatNew && stUnit.TableType != TableType_Enum && schema != nil {
+		nestedHolder = schema.FindMessageByPath(stUnit.GetTypePath())
+	}
+
+	for _, rowComponents := range stUnit.RowList {
+		// 枚举没有类型和默认值
+		bHasType := stUnit.TableType != TableType_Enum
+		// 检查 类型 的合法性, 嵌套类型可以用 . 分隔的全名
+		if bHasType && (!CheckTypeName(rowComponents.EntryType) || rowComponents.EntryType == stUnit.UnitName) {
+			logrus.Error("CheckStUnit failed. EntryType: ", rowComponents.EntryType)
+			return false, "Index[" + rowComponents.EntryIndex + "], the EntryType is invalid"
+		}
+		// 检查字段选项是否被当前语法支持
+		if bHasType && rowComponents.EntryOption != "" && !genConfig.IsValidFieldOption(rowComponents.EntryOption) {
+			logrus.Error("CheckStUnit failed. EntryOption: ", rowComponents.EntryOption, ", syntax: ", genConfig.Syntax)
+			return false, "Index[" + rowComponents.EntryIndex + "], the EntryOption[" + rowComponents.EntryOption + "] is not supported by " + genConfig.Syntax
+		}
+		// 检查 map 的 key 类型, value 类型与普通字段相同
+		if bHasType && rowComponents.IsMap() && !model.IsMapKeyType(rowComponents.EntryKeyType) {
+			logrus.Error("CheckStUnit failed. EntryKeyType: ", rowComponents.EntryKeyType)
+			return false, "Index[" + rowComponents.EntryIndex + "], the map key type is invalid"
+		}
+		// oneof 中的字段不能是 repeated 或 map
+		if bHasType && rowComponents.IsOneof() {
+			if !CheckUnitName(rowComponents.EntryOneof) {
+				logrus.Error("CheckStUnit failed. EntryOneof: ", rowComponents.EntryOneof)
+				return false, "Index[" + rowComponents.EntryIndex + "], the oneof name is invalid"
+			}
+			if rowComponents.EntryOption == model.OptionRepeated || rowComponents.EntryOption == model.OptionRequired || rowComponents.IsMap() {
+				logrus.Error("CheckStUnit failed. repeated, required or map in oneof. EntryName: ", rowComponents.EntryName)
+				return false, "Index[" + rowComponents.EntryIndex + "], the field in oneof can not be repeated, required or map"
+			}
+		}
+		// 检查 变量名 的合法性
+		if !CheckUnitName(rowComponents.EntryName) {
+			logrus.Error("CheckStUnit failed. EntryName: ", rowComponents.EntryName)
+			return false, "Index[" + rowComponents.EntryIndex + "], the EntryName is invalid"
+		}
+		if nestedHolder != nil && nestedHolder.HasNested(rowComponents.EntryName) {
+			logrus.Error("CheckStUnit failed. EntryName is the same as nested type: ", rowComponents.EntryName)
+			return false, "Index[" + rowComponents.EntryIndex + "], the EntryName is the same as nested type"
+		}
+		// 检查默认值合法性
+		bIsEnum := bHasType && schema != nil && schema.ResolveEnum(stUnit.GetTypePath(), rowComponents.EntryType) != nil
+		if bIsEnum && !CheckUnitName(rowComponents.EntryDefault) {
+			logrus.Error("CheckStUnit failed. EntryIndex: ", rowComponents.EntryIndex, ",EntryName: ", rowComponents.EntryName)
+			return false, "Index[" + rowComponents.EntryIndex + "], the EntryDefault is invalid"
+		}
+		// 非枚举的默认值只有 proto2/editions 的单个标量字段支持
+		if bHasType && !bIsEnum && rowComponents.EntryDefault != "" {
+			if !genConfig.HasDefaultValue() {
+				logrus.Error("CheckStUnit failed. EntryDefault is not supported. EntryName: ", rowComponents.EntryName, ", syntax: ", genConfig.Syntax)
+				return false, "Index[" + rowComponents.EntryIndex + "], the EntryDefault is not supported by " + genConfig.Syntax
+			}
+			if !model.IsScalarType(rowComponents.EntryType) || rowComponents.EntryOption == model.OptionRepeated || rowComponents.IsMap() {
+				logrus.Error("CheckStUnit failed. EntryDefault on non scalar field. EntryName: ", rowComponents.EntryName)
+				return false, "Index[" + rowComponents.EntryIndex + "], only single scalar or enum field can have EntryDefault"
+			}
+			if !CheckDefaultValue(rowComponents.EntryType, rowComponents.EntryDefault) {
+				logrus.Error("CheckStUnit failed. EntryDefault: ", rowComponents.EntryDefault, ", EntryType: ", rowComponents.EntryType)
+				return false, "Index[" + rowComponents.EntryIndex + "], the EntryDefault does not match the EntryType"
+			}
+		}
+	}
+
+	if !CheckFieldNameList(stUnit.RowList, stUnit.Reserved) {
+		return false, " The field name ara duplicate or reserved"
+	}
+
+	// oneof 与字段共用名字空间, 字段序号在整个消息内唯一
+	fieldNames := map[string]bool{}
+	for _, row := range stUnit.RowList {
+		fieldNames[row.EntryName] = true
+	}
+	for _, row := range stUnit.RowList {
+		if row.IsOneof() && fieldNames[row.EntryOneof] {
+			logrus.Error("CheckStUnit failed. oneof name is the same as field: ", row.EntryOneof)
+			return false, "The oneof name[" + row.EntryOneof + "] is the same as a field name"
+		}
+	}
+
+	// proto3 的枚举第一个值必须为 0, 枚举值名在所在的 package 或消息内唯一
+	if stUnit.TableType == TableType_Enum {
+		if genConfig.RequiresEnumZero() && (len(stUnit.RowList) == 0 || !isZeroIndex(stUnit.RowList[0].EntryIndex)) {
+			logrus.Error("CheckStUnit failed. the first enum value is not 0. UnitName: ", stUnit.UnitName, ", syntax: ", genConfig.Syntax)
+			return false, "The first enum value must be 0 in " + genConfig.Syntax
+		}
+		if schema != nil {
+			scopeNames := GetEnumScopeNames(schema, stUnit.ParentPath, stUnit.UnitName)
+			for _, row := range stUnit.RowList {
+				if strUsed, ok := scopeNames[row.EntryName]; ok {
+					logrus.Error("CheckStUnit failed. enum value is used in scope: ", row.EntryName, ", used by: ", strUsed)
+					return false, "The enum value[" + row.EntryName + "] is already used by " + strUsed + ", enum values are scoped to the package or message"
+				}
+			}
+		}
+	}
+
+	// 序号的范围, 重复和保留与诊断使用同一套规则, 该单元有错误级别的诊断时不能保存
+	diagList, isOk := DiagnoseStUnit(schema, genConfig, stUnit)
+	if !isOk {
+		return false, "Check the unit failed, copy the schema failed"
+	}
+	for _, diag := range diagList {
+		if diag.Severity == DiagSeverity_Error {
+			logrus.Error("CheckStUnit failed. ", diag.String())
+			return false, diag.String()
+		}
+	}
+	return true, ""
+}
+
+// 将单元写入协议的副本后诊断, 只返回该单元的诊断. 复制协议失败时返回 false
+func DiagnoseStUnit(schema *model.Schema, genConfig StGenConfig, stUnit StUnit) ([]StDiagnostic, bool) {
+	checkSchema := &model.Schema{}
+	if schema != nil {
+		var err error
+		if checkSchema, err = schema.Clone(); err != nil {
+			logrus.Error("DiagnoseStUnit failed. Clone failed: ", err)
+			return nil, false
+		}
+	}
+	if stUnit.ParentPath != "" {
+		parent := checkSchema.FindMessageByPath(stUnit.ParentPath)
+		if parent == nil {
+			logrus.Error("DiagnoseStUnit failed. Parent is not exist. ParentPath: ", stUnit.ParentPath)
+			return nil, false
+		}
+		if stUnit.TableType == TableType_Enum {
+			parent.PutNestedEnum(stUnit.ToEnum())
+		} else {
+			msg := stUnit.ToMessage()
+			keepNested(msg, parent.FindNestedMessage(stUnit.UnitName))
+			parent.PutNestedMessage(msg)
+		}
+	} else if stUnit.TableType == TableType_Enum {
+		checkSchema.PutEnum(stUnit.ToEnum())
+	} else if stUnit.TableType == TableType_RPC {
+		rpc := checkSchema.FindRpc(stUnit.UnitName)
+		if rpc == nil {
+			rpc = &model.Rpc{Name: stUnit.UnitName}
+			checkSchema.PutRpc(rpc)
+		}
+		msg := stUnit.ToMessage()
+		if stUnit.SubTableType == SubTableType_RpcReq {
+			keepNested(msg, rpc.Req)
+			rpc.Req = msg
+		} else {
+			keepNested(msg, rpc.Ack)
+			rpc.Ack = msg
+		}
+	} else {
+		strRoot := GetEtreeRootName(stUnit.TableType)
+		msg := stUnit.ToMessage()
+		keepNested(msg, checkSchema.FindMessage(strRoot, stUnit.UnitName))
+		checkSchema.PutMessage(strRoot, msg)
+	}
+
+	result := []StDiagnostic{}
+	strTypePath := stUnit.GetTypePath()
+	for _, diag := range DiagnoseSchema(checkSchema, genConfig) {
+		if diag.UnitPath == strTypePath {
+			result = append(result, diag)
+		}
+	}
+	return result, true
+}
+
+// 检查标量默认值是否与类型匹配, string/bytes 不限制
+func CheckDefaultValue(strType string, strValue string) bool {
+	var err error
+	switch strType {
+	case "bool":
+		return strValue == "true" || strValue == "false"
+	case "string", "bytes":
+		return true
+	}
+	// 数字不支持 Go 的 _ 分隔和 0b/0o 前缀, 只有十进制, 0x 十六进制和 0 开头的八进制
+	if !checkNumberLiteral(strValue) {
+		return false
+	}
+	switch strType {
+	case "float", "double":
+		return floatLiteralRegexp.MatchString(strValue)
+	case "int32", "sint32", "sfixed32":
+		_, err = strconv.ParseInt(strValue, 0, 32)
+	case "int64", "sint64", "sfixed64":
+		_, err = strconv.ParseInt(strValue, 0, 64)
+	case "uint32", "fixed32":
+		_, err = strconv.ParseUint(strValue, 0, 32)
+	case "uint64", "fixed64":
+		_, err = strconv.ParseUint(strValue, 0, 64)
+	}
+	return err == nil
+}
+
+// protobuf 的浮点数写法: 十进制小数或整数, 可带指数, 以及 inf 和 nan, 只能带负号
+var floatLiteralRegexp = regexp.MustCompile(`^-?(inf|nan|(\d+\.\d*|\.\d+)([eE][+-]?\d+)?|\d+([eE][+-]?\d+)?)$`)
+
+// 检查数字默认值中是否有 protobuf 不支持的写法
+func checkNumberLiteral(strValue string) bool {
+	if strings.Contains(strValue, "_") {
+		return false
+	}
+	strLower := strings.ToLower(strings.TrimLeft(strValue, "+-"))
+	return !strings.HasPrefix(strLower, "0b") && !strings.HasPrefix(strLower, "0o")
+}
+
+// 检查类型名是否合法, 允许 Outer.Inner 形式的全名
+func CheckTypeName(name string) bool {
+	for _, part := range strings.Split(strings.TrimPrefix(name, "."), ".") {
+		if !CheckUnitName(part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+func TestCheckDefaultValue(t *testing.T) {
+	tests := []struct {
+		strType string
+		value   string
+		want    bool
+	}{
+		{"string", "foo_bar", true},
+		{"bytes", "0b1", true},
+		{"bool", "true", true},
+		{"bool", "1", false},
+		{"int32", "-12", true},
+		{"int32", "0x10", true},
+		{"int32", "010", true},
+		{"int32", "1_000", false},
+		{"int32", "0b1", false},
+		{"int32", "0o7", false},
+		{"int32", "2147483648", false},
+		{"uint32", "-1", false},
+		{"uint64", "18446744073709551615", true},
+		{"double", "1.5", true},
+		{"double", "-.5e-3", true},
+		{"double", "1.", true},
+		{"double", "2E10", true},
+		{"double", "10", true},
+		{"float", "inf", true},
+		{"float", "-inf", true},
+		{"float", "nan", true},
+		{"float", "Infinity", false},
+		{"float", "+Inf", false},
+		{"float", "+1.5", false},
+		{"float", "0x1p-2", false},
+		{"float", "1_000.5", false},
+		{"float", "1e", false},
+		{"float", "", false},
+	}
+	for _, test := range tests {
+		if got := CheckDefaultValue(test.strType, test.value); got != test.want {
+			t.Errorf("%s %q: got %v, want %v", test.strType, test.value, got, test.want)
+		}
+	}
+}
+
+func TestCheckStUnit(t *testing.T) {
+	schema := loadTestSchema(t, testSchemaXml)
+	genConfig := NewGenConfig()
+	setIndex := func(stUnit StUnit, i int, index string) StUnit {
+		stUnit.RowList[i].EntryIndex = index
+		return stUnit
+	}
+	role := func() StUnit {
+		return StUnitFromMessage(TableType_Data, SubTableType_None, schema.FindMessageByPath("Role"))
+	}
+	login := func() StUnit {
+		return StUnitFromMessage(TableType_Protocol, SubTableType_None, schema.FindMessageByPath("CS_Login"))
+	}
+	itemType := func() StUnit { return StUnitFromEnum(schema.FindEnumByPath("ItemType")) }
+	getRoleReq := func() StUnit {
+		return StUnitFromMessage(TableType_RPC, SubTableType_RpcReq, schema.FindRpc("CS_GetRole").Req)
+	}
+	slot := func() StUnit {
+		stUnit := StUnitFromMessage(TableType_Data, SubTableType_None, schema.FindMessageByPath("Bag.Slot"))
+		stUnit.ParentPath = "Bag"
+		return stUnit
+	}
+	tests := []struct {
+		name      string
+		stUnit    StUnit
+		wantError string // 为空时检查通过
+	}{
+		{"data unchanged", role(), ""},
+		{"data number 0", setIndex(role(), 0, "0"), "(number-range)"},
+		{"data number in implementation range", setIndex(role(), 0, "19000"), "(number-impl)"},
+		{"data number duplicate", setIndex(role(), 0, "2"), "(duplicate-number)"},
+		{"protocol number too large", setIndex(login(), 0, "536870912"), "(number-range)"},
+		{"protocol number is not a number", setIndex(login(), 0, "a"), "(number-range)"},
+		{"rpc number in implementation range", setIndex(getRoleReq(), 0, "19999"), "(number-impl)"},
+		{"nested number duplicate", setIndex(slot(), 1, "1"), "(duplicate-number)"},
+		{"enum reserved value", setIndex(itemType(), 1, "6"), "(reserved)"},
+		// 负数枚举值只是警告
+		{"enum negative value", setIndex(itemType(), 1, "-1"), ""},
+		{"new data", StUnit{UnitName: "Guild", TableType: TableType_Data, IsCreatNew: true, RowList: []model.Field{
+			{EntryOption: "optional", EntryType: "int32", EntryName: "id", EntryIndex: "1"},
+			{EntryOption: "optional", EntryType: "Role", EntryName: "leader", EntryIndex: "1"},
+		}}, "Guild.leader: field number 1 is already used by id"},
+	}
+	for _, test := range tests {
+		isOk, strError := CheckStUnit(schema, genConfig, test.stUnit)
+		if test.wantError == "" {
+			if !isOk {
+				t.Errorf("%s: got error %q", test.name, strError)
+			}
+		} else if isOk || !strings.Contains(strError, test.wantError) {
+			t.Errorf("%s: got %v %q, want error %q", test.name, isOk, strError, test.wantError)
+		}
+	}
+	// 检查在副本上进行, 不修改协议
+	if strings.Contains(getSchemaText(t, schema), "Guild") {
+		t.Error("CheckStUnit changed the schema")
+	}
+}
@@ -0,0 +1,385 @@
+package logic
+
+import (
+	"strings"
+
+	"protocolgo/src/model"
+)
+
+// 兼容性变化的级别
+type ECompatLevel int
+
+const (
+	CompatLevel_Safe     ECompatLevel = iota + 1 // 线上兼容, 新旧两端可以互通
+	CompatLevel_Breaking                         // 不兼容, 新旧两端互通会出错
+)
+
+// 一条兼容性变化
+type StCompatChange struct {
+	Level    ECompatLevel
+	UnitPath string // 单元全名, 嵌套类型为 Outer.Inner, rpc 为 XxxReq/XxxAck
+	Field    string // 字段名, 单元本身的变化为空
+	Message  string
+}
+
+// 变化的展示文本, 如 [breaking] Item.id: field number changed
+func (change *StCompatChange) String() string {
+	strLevel := "[safe] "
+	if change.Level == CompatLevel_Breaking {
+		strLevel = "[breaking] "
+	}
+	strName := change.UnitPath
+	if change.Field != "" {
+		strName += "." + change.Field
+	}
+	return strLevel + strName + ": " + change.Message
+}
+
+// 是否包含不兼容的变化
+func HasBreakingChange(changeList []StCompatChange) bool {
+	for _, change := range changeList {
+		if change.Level == CompatLevel_Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// 线上编码相同, 可以互相转换的标量类型组
+var compatTypeGroups = [][]string{
+	{"int32", "uint32", "int64", "uint64", "bool"},
+	{"sint32", "sint64"},
+	{"fixed32", "sfixed32"},
+	{"fixed64", "sfixed64"},
+	{"string", "bytes"},
+}
+
+func getCompatTypeGroup(strType string) int {
+	for i, group := range compatTypeGroups {
+		for _, v := range group {
+			if v == strType {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// 对比已保存和修改后的 Schema, 返回每个变化的兼容性
+func CheckCompatibility(oldSchema *model.Schema, newSchema *model.Schema) []StCompatChange {
+	result := []StCompatChange{}
+	if oldSchema == nil || newSchema == nil {
+		return result
+	}
+	checker := &stCompatChecker{
+		oldSchema:  oldSchema,
+		newSchema:  newSchema,
+		oldTypeMap: oldSchema.GetTypeMap(),
+		newTypeMap: newSchema.GetTypeMap(),
+		renameMap:  map[string]string{},
+	}
+	checker.matchRenamedTypes()
+	renamedMap := map[string]bool{}
+	for _, strNewPath := range checker.renameMap {
+		renamedMap[strNewPath] = true
+	}
+	oldSchema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		strNewPath := checker.getNewPath(path)
+		// 类型名不在线上编码中, 改名兼容. 随外层类型改名的嵌套类型不单独报告
+		strParent := getCompatParentPath(path)
+		if strNewPath != path && (strParent == "" || checker.getNewPath(strParent)+path[len(strParent):] != strNewPath) {
+			checker.add(CompatLevel_Safe, path, "", "renamed to "+strNewPath)
+		}
+		if enum != nil {
+			newEnum := newSchema.FindEnumByPath(strNewPath)
+			if newEnum == nil {
+				checker.add(CompatLevel_Breaking, path, "", "enum removed")
+				return
+			}
+			checker.checkEnum(path, enum, newEnum)
+			return
+		}
+		newMsg := newSchema.FindMessageByPath(strNewPath)
+		if newMsg == nil {
+			checker.add(CompatLevel_Breaking, path, "", "message removed")
+			return
+		}
+		checker.checkMessage(path, strNewPath, msg, newMsg)
+	})
+	newSchema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		if !checker.oldTypeMap[path] && !renamedMap[path] {
+			checker.add(CompatLevel_Safe, path, "", "added")
+		}
+	})
+	return checker.result
+}
+
+type stCompatChecker struct {
+	oldSchema  *model.Schema
+	newSchema  *model.Schema
+	oldTypeMap map[string]bool
+	newTypeMap map[string]bool
+	renameMap  map[string]string // 改名的类型, 旧全名 -> 新全名
+	result     []StCompatChange
+}
+
+// 获取外层类型的全名, 顶层类型返回空
+func getCompatParentPath(path string) string {
+	if index := strings.LastIndex(path, "."); index >= 0 {
+		return path[:index]
+	}
+	return ""
+}
+
+// 获取旧类型在修改后的全名, 没有改名时返回原名
+func (checker *stCompatChecker) getNewPath(path string) string {
+	if strNewPath, ok := checker.renameMap[path]; ok {
+		return strNewPath
+	}
+	return path
+}
+
+// 类型的结构签名: 枚举为值的序号和名字, 消息为字段的序号, 名字, 修饰和标量类型. 引用的消息和枚举可能随之改名, 不比较类型名
+func getCompatSignature(enum *model.Enum, msg *model.Message) string {
+	var builder strings.Builder
+	if enum != nil {
+		builder.WriteString("enum")
+		for _, value := range enum.Values {
+			builder.WriteString(";" + value.EntryIndex + " " + value.EntryName)
+		}
+		return builder.String()
+	}
+	builder.WriteString("message")
+	for _, field := range msg.Fields {
+		strType := ""
+		if model.IsScalarType(field.EntryType) {
+			strType = field.EntryType
+		}
+		builder.WriteString(";" + field.EntryIndex + " " + field.EntryName + " " + field.EntryOption + " " + field.EntryKeyType + " " + strType + " " + field.EntryOneof)
+	}
+	return builder.String()
+}
+
+// 对比删除和新增的类型, 找出改名的类型: 外层类型改名时同名的嵌套类型随之改名;
+// 否则在同一作用域中, 结构签名相同且双方都唯一的删除和新增类型视为改名
+func (checker *stCompatChecker) matchRenamedTypes() {
+	type stCompatType struct {
+		path      string
+		parent    string
+		signature string
+	}
+	removedList := []stCompatType{}
+	checker.oldSchema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		if !checker.newTypeMap[path] {
+			removedList = append(removedList, stCompatType{path, getCompatParentPath(path), getCompatSignature(enum, msg)})
+		}
+	})
+	addedList := []stCompatType{}
+	checker.newSchema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		if !checker.oldTypeMap[path] {
+			addedList = append(addedList, stCompatType{path, getCompatParentPath(path), getCompatSignature(enum, msg)})
+		}
+	})
+	// 删除的类型按遍历顺序处理, 外层类型在嵌套类型之前
+	for _, removed := range removedList {
+		strNewParent := checker.getNewPath(removed.parent)
+		if strNewParent != removed.parent {
+			strNewPath := strNewParent + removed.path[len(removed.parent):]
+			if checker.newTypeMap[strNewPath] && !checker.oldTypeMap[strNewPath] {
+				checker.renameMap[removed.path] = strNewPath
+				continue
+			}
+		}
+		var matched []string
+		for _, added := range addedList {
+			if added.parent == strNewParent && added.signature == removed.signature {
+				matched = append(matched, added.path)
+			}
+		}
+		nSameRemoved := 0
+		for _, other := range removedList {
+			if checker.getNewPath(other.parent) == strNewParent && other.signature == removed.signature {
+				nSameRemoved++
+			}
+		}
+		if len(matched) == 1 && nSameRemoved == 1 {
+			checker.renameMap[removed.path] = matched[0]
+		}
+	}
+}
+
+func (checker *stCompatChecker) add(level ECompatLevel, path string, field string, message string) {
+	checker.result = append(checker.result, StCompatChange{Level: level, UnitPath: path, Field: field, Message: message})
+}
+
+// 枚举值按序号编码, 改名只影响 json/text 格式
+func (checker *stCompatChecker) checkEnum(path string, oldEnum *model.Enum, newEnum *model.Enum) {
+	for _, oldValue := range oldEnum.Values {
+		newValue := findFieldByIndex(newEnum.Values, oldValue.EntryIndex)
+		if newValue != nil {
+			if newValue.EntryName != oldValue.EntryName {
+				checker.add(CompatLevel_Breaking, path, oldValue.EntryName, "renamed to "+newValue.EntryName+", breaks json/text format")
+			}
+			continue
+		}
+		if newValue = newEnum.FindValue(oldValue.EntryName); newValue != nil {
+			checker.add(CompatLevel_Breaking, path, oldValue.EntryName, "value changed from "+oldValue.EntryIndex+" to "+newValue.EntryIndex)
+		} else {
+			checker.add(CompatLevel_Breaking, path, oldValue.EntryName, "enum value "+oldValue.EntryIndex+" removed")
+		}
+	}
+	for _, newValue := range newEnum.Values {
+		if findFieldByIndex(oldEnum.Values, newValue.EntryIndex) == nil && oldEnum.FindValue(newValue.EntryName) == nil {
+			checker.add(CompatLevel_Safe, path, newValue.EntryName, "enum value "+newValue.EntryIndex+" added")
+		}
+	}
+}
+
+// 字段按序号编码, 先按序号对应, 找不到再按名字对应. newPath 为改名后的全名, 用于解析新字段的类型
+func (checker *stCompatChecker) checkMessage(path string, newPath string, oldMsg *model.Message, newMsg *model.Message) {
+	for _, oldField := range oldMsg.Fields {
+		newField := findFieldByIndex(newMsg.Fields, oldField.EntryIndex)
+		if newField == nil {
+			if newField = newMsg.FindField(oldField.EntryName); newField != nil {
+				checker.add(CompatLevel_Breaking, path, oldField.EntryName, "field number changed from "+oldField.EntryIndex+" to "+newField.EntryIndex)
+			} else if model.FindReservedIndex(newMsg.Reserved, oldField.EntryIndex) != nil {
+				checker.add(CompatLevel_Safe, path, oldField.EntryName, "field "+oldField.EntryIndex+" removed and reserved")
+			} else {
+				checker.add(CompatLevel_Breaking, path, oldField.EntryName, "field "+oldField.EntryIndex+" removed without reserved, the number may be reused")
+			}
+			continue
+		}
+		// 名字和类型都变了, 视为删除旧字段后重用了它的序号
+		if newField.EntryName != oldField.EntryName && newField.GetProtoType() != oldField.GetProtoType() {
+			checker.add(CompatLevel_Breaking, path, oldField.EntryName, "field "+oldField.EntryIndex+" removed and the number reused by "+newField.EntryName)
+			continue
+		}
+		checker.checkField(path, newPath, oldMsg, newMsg, oldField, *newField)
+	}
+	for _, newField := range newMsg.Fields {
+		if findFieldByIndex(oldMsg.Fields, newField.EntryIndex) != nil || oldMsg.FindField(newField.EntryName) != nil {
+			continue
+		}
+		if newField.EntryOption == model.OptionRequired {
+			checker.add(CompatLevel_Breaking, path, newField.EntryName, "required field "+newField.EntryIndex+" added")
+		} else {
+			checker.add(CompatLevel_Safe, path, newField.EntryName, "field "+newField.EntryIndex+" added")
+		}
+	}
+}
+
+// 对比序号相同的两个字段
+func (checker *stCompatChecker) checkField(path string, newPath string, oldMsg *model.Message, newMsg *model.Message, oldField model.Field, newField model.Field) {
+	strName := oldField.EntryName
+	if newField.EntryName != oldField.EntryName {
+		checker.add(CompatLevel_Breaking, path, strName, "renamed to "+newField.EntryName+", breaks json/text format")
+	}
+	bOldRepeated := oldField.EntryOption == model.OptionRepeated || oldField.IsMap()
+	bNewRepeated := newField.EntryOption == model.OptionRepeated || newField.IsMap()
+	if bOldRepeated != bNewRepeated {
+		checker.add(CompatLevel_Breaking, path, strName, "changed between repeated and singular")
+	} else if oldField.IsMap() != newField.IsMap() {
+		checker.add(CompatLevel_Breaking, path, strName, "changed between map and repeated")
+	} else if (oldField.EntryOption == model.OptionRequired) != (newField.EntryOption == model.OptionRequired) {
+		checker.add(CompatLevel_Breaking, path, strName, "changed from "+oldField.EntryOption+" to "+newField.EntryOption)
+	}
+	if oldField.IsMap() && newField.IsMap() && oldField.EntryKeyType != newField.EntryKeyType {
+		checker.checkType(path, strName, "map key", oldField.EntryKeyType, newField.EntryKeyType)
+	}
+	checker.checkType(path, strName, "type", checker.resolveOld(path, oldField.EntryType), checker.resolveNew(newPath, newField.EntryType))
+	if oldField.EntryOneof != newField.EntryOneof {
+		// 只有单个字段移入新的 oneof 是兼容的, 移入已有的 oneof 或多个字段一起移入时, 同时设置的字段只保留一个
+		if oldField.EntryOneof == "" {
+			if hasOneof(oldMsg, newField.EntryOneof) {
+				checker.add(CompatLevel_Breaking, path, strName, "moved into existing oneof "+newField.EntryOneof+", data may be lost")
+			} else if getMovedIntoOneofCount(oldMsg, newMsg, newField.EntryOneof) > 1 {
+				checker.add(CompatLevel_Breaking, path, strName, "moved into oneof "+newField.EntryOneof+" with other fields, data may be lost")
+			} else {
+				checker.add(CompatLevel_Safe, path, strName, "moved into oneof "+newField.EntryOneof)
+			}
+		} else {
+			checker.add(CompatLevel_Breaking, path, strName, "moved out of oneof "+oldField.EntryOneof)
+		}
+	}
+	if oldField.EntryDefault != newField.EntryDefault && !bNewRepeated {
+		checker.add(CompatLevel_Safe, path, strName, "default changed from "+oldField.EntryDefault+" to "+newField.EntryDefault)
+	}
+}
+
+// 消息中是否有字段属于 oneof
+func hasOneof(msg *model.Message, strOneof string) bool {
+	for _, field := range msg.Fields {
+		if field.EntryOneof == strOneof {
+			return true
+		}
+	}
+	return false
+}
+
+// 修改前不在 oneof 中, 修改后移入 strOneof 的字段数
+func getMovedIntoOneofCount(oldMsg *model.Message, newMsg *model.Message, strOneof string) int {
+	nCount := 0
+	for _, newField := range newMsg.Fields {
+		if newField.EntryOneof != strOneof {
+			continue
+		}
+		if oldField := findFieldByIndex(oldMsg.Fields, newField.EntryIndex); oldField != nil && oldField.EntryOneof == "" {
+			nCount++
+		}
+	}
+	return nCount
+}
+
+// 对比类型, 标量类型按编码分组, 枚举与 int32 组兼容, 消息类型只比较全名, 改名的类型按新名字比较
+func (checker *stCompatChecker) checkType(path string, strName string, strWhat string, oldType string, newType string) {
+	if oldType == newType || checker.getNewPath(oldType) == newType {
+		return
+	}
+	strMessage := strWhat + " changed from " + oldType + " to " + newType
+	oldGroup := checker.getTypeGroup(oldType, checker.oldSchema)
+	newGroup := checker.getTypeGroup(newType, checker.newSchema)
+	if oldGroup >= 0 && oldGroup == newGroup {
+		if oldGroup == getCompatTypeGroup("string") {
+			checker.add(CompatLevel_Safe, path, strName, strMessage+", bytes must be valid utf-8")
+		} else {
+			checker.add(CompatLevel_Safe, path, strName, strMessage+", values out of range may be truncated")
+		}
+		return
+	}
+	checker.add(CompatLevel_Breaking, path, strName, strMessage)
+}
+
+// 获取类型的编码分组, 枚举与 int32 同组, 消息类型返回 -1
+func (checker *stCompatChecker) getTypeGroup(strType string, schema *model.Schema) int {
+	if model.IsScalarType(strType) {
+		return getCompatTypeGroup(strType)
+	}
+	if schema.FindEnumByPath(strType) != nil {
+		return getCompatTypeGroup("int32")
+	}
+	return -1
+}
+
+// 解析为全名, 解析失败时返回原名
+func (checker *stCompatChecker) resolveOld(scope string, strType string) string {
+	if strPath := model.ResolveTypeName(checker.oldTypeMap, scope, strType); strPath != "" {
+		return strPath
+	}
+	return strType
+}
+
+func (checker *stCompatChecker) resolveNew(scope string, strType string) string {
+	if strPath := model.ResolveTypeName(checker.newTypeMap, scope, strType); strPath != "" {
+		return strPath
+	}
+	return strType
+}
+
+func findFieldByIndex(fieldList []model.Field, strIndex string) *model.Field {
+	for i := range fieldList {
+		if fieldList[i].EntryIndex == strIndex {
+			return &fieldList[i]
+		}
+	}
+	return nil
+}
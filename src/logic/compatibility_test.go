@@ -0,0 +1,210 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+// 用 RenameType 改名, 结果写回 schema
+func renameForTest(path string, newName string) func(schema *model.Schema) {
+	return func(schema *model.Schema) {
+		if isOk, result, _ := RenameType(schema, path, newName); isOk {
+			*schema = *result.Schema
+		}
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(schema *model.Schema)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			modify: func(schema *model.Schema) {},
+			want:   []string{},
+		},
+		{
+			name: "field added",
+			modify: func(schema *model.Schema) {
+				role := schema.FindMessageByPath("Role")
+				role.Fields = append(role.Fields, model.Field{EntryOption: model.OptionOptional, EntryType: "string", EntryName: "name", EntryIndex: "3"})
+			},
+			want: []string{"[safe] Role.name: field 3 added"},
+		},
+		{
+			name: "required field added",
+			modify: func(schema *model.Schema) {
+				role := schema.FindMessageByPath("Role")
+				role.Fields = append(role.Fields, model.Field{EntryOption: model.OptionRequired, EntryType: "string", EntryName: "name", EntryIndex: "3"})
+			},
+			want: []string{"[breaking] Role.name: required field 3 added"},
+		},
+		{
+			name: "field removed and reserved",
+			modify: func(schema *model.Schema) {
+				role := schema.FindMessageByPath("Role")
+				role.Fields = role.Fields[:1]
+				role.Reserved = model.AddReserved(role.Reserved, model.Reserved{EntryIndex: "2", EntryName: "bag"})
+			},
+			want: []string{"[safe] Role.bag: field 2 removed and reserved"},
+		},
+		{
+			name: "field removed without reserved",
+			modify: func(schema *model.Schema) {
+				role := schema.FindMessageByPath("Role")
+				role.Fields = role.Fields[:1]
+			},
+			want: []string{"[breaking] Role.bag: field 2 removed without reserved, the number may be reused"},
+		},
+		{
+			name: "field number changed",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Role").Fields[1].EntryIndex = "5"
+			},
+			want: []string{"[breaking] Role.bag: field number changed from 2 to 5"},
+		},
+		{
+			name: "field number reused",
+			modify: func(schema *model.Schema) {
+				field := &schema.FindMessageByPath("Role").Fields[0]
+				field.EntryName = "title"
+				field.EntryType = "string"
+			},
+			want: []string{"[breaking] Role.id: field 1 removed and the number reused by title"},
+		},
+		{
+			name: "renamed",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Role").Fields[0].EntryName = "uid"
+			},
+			want: []string{"[breaking] Role.id: renamed to uid, breaks json/text format"},
+		},
+		{
+			name: "compatible type",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Role").Fields[0].EntryType = "int64"
+			},
+			want: []string{"[safe] Role.id: type changed from int32 to int64, values out of range may be truncated"},
+		},
+		{
+			name: "incompatible type",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Role").Fields[0].EntryType = "sint32"
+			},
+			want: []string{"[breaking] Role.id: type changed from int32 to sint32"},
+		},
+		{
+			name: "repeated",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Role").Fields[0].EntryOption = model.OptionRepeated
+			},
+			want: []string{"[breaking] Role.id: changed between repeated and singular"},
+		},
+		{
+			name: "moved out of oneof",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Bag").Fields[3].EntryOneof = ""
+			},
+			want: []string{"[breaking] Bag.role_id: moved out of oneof owner"},
+		},
+		{
+			name: "moved into a new oneof",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Role").Fields[0].EntryOneof = "who"
+			},
+			want: []string{"[safe] Role.id: moved into oneof who"},
+		},
+		{
+			name: "moved into an existing oneof",
+			modify: func(schema *model.Schema) {
+				schema.FindMessageByPath("Bag").Fields[2].EntryOneof = "owner"
+			},
+			want: []string{"[breaking] Bag.kind: moved into existing oneof owner, data may be lost"},
+		},
+		{
+			name: "several fields moved into a new oneof",
+			modify: func(schema *model.Schema) {
+				role := schema.FindMessageByPath("Role")
+				role.Fields[0].EntryOneof = "who"
+				role.Fields[1].EntryOneof = "who"
+			},
+			want: []string{
+				"[breaking] Role.id: moved into oneof who with other fields, data may be lost",
+				"[breaking] Role.bag: moved into oneof who with other fields, data may be lost",
+			},
+		},
+		{
+			name:   "enum renamed",
+			modify: renameForTest("ItemType", "ItemKind"),
+			want:   []string{"[safe] ItemType: renamed to ItemKind"},
+		},
+		{
+			name:   "data renamed",
+			modify: renameForTest("Bag", "Pack"),
+			want:   []string{"[safe] Bag: renamed to Pack"},
+		},
+		{
+			name:   "nested message renamed",
+			modify: renameForTest("Bag.Slot", "Cell"),
+			want:   []string{"[safe] Bag.Slot: renamed to Bag.Cell"},
+		},
+		{
+			name:   "protocol renamed",
+			modify: renameForTest("CS_Login", "CS_SignIn"),
+			want:   []string{"[safe] CS_Login: renamed to CS_SignIn"},
+		},
+		{
+			name: "renamed with a changed field",
+			modify: func(schema *model.Schema) {
+				renameForTest("Role", "Player")(schema)
+				schema.FindMessageByPath("Player").Fields[0].EntryType = "sint32"
+			},
+			want: []string{
+				"[breaking] Role: message removed",
+				"[breaking] CS_GetRoleAck.role: type changed from Role to Player",
+				"[safe] Player: added",
+			},
+		},
+		{
+			name: "enum value removed",
+			modify: func(schema *model.Schema) {
+				enum := schema.FindEnumByPath("ItemType")
+				enum.Values = enum.Values[:1]
+			},
+			want: []string{"[breaking] ItemType.ItemType_Weapon: enum value 1 removed"},
+		},
+		{
+			name: "enum value renamed",
+			modify: func(schema *model.Schema) {
+				schema.FindEnumByPath("Bag.Kind").Values[0].EntryName = "Kind_Unknown"
+			},
+			want: []string{"[breaking] Bag.Kind.Kind_None: renamed to Kind_Unknown, breaks json/text format"},
+		},
+		{
+			name: "message removed",
+			modify: func(schema *model.Schema) {
+				schema.RemoveUnit(model.CategoryProtocol, "CS_Login")
+			},
+			want: []string{"[breaking] CS_Login: message removed"},
+		},
+	}
+	oldSchema := loadTestSchema(t, testSchemaXml)
+	for _, test := range tests {
+		newSchema, err := oldSchema.Clone()
+		if err != nil {
+			t.Fatalf("Clone failed. err: %v", err)
+		}
+		test.modify(newSchema)
+		got := []string{}
+		for _, change := range CheckCompatibility(oldSchema, newSchema) {
+			got = append(got, change.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
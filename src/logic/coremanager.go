@@ -3,8 +3,11 @@ package logic
 import (
 	"bytes"
 	"io"
+	"path/filepath"
+	"strconv"
 	"strings"
 
+	"protocolgo/src/model"
 	"protocolgo/src/utils"
 
 	"fyne.io/fyne/v2/data/binding"
@@ -33,20 +36,26 @@ const (
 )
 
 type CoreManager struct {
-	FileEtree         *etree.Document     // 保存到文件的 etree
-	ChangedEtree      *etree.Document     // 变化的 etree
-	ChangedShowEtree  *etree.Document     // 包含源数据和变化数据,用于展示的 etree
-	ProtoXmlFilePath  string              // 打开的 proto Xml 文件路径
-	Config            *etree.Document     // 配置数据
-	ConfigXmlFilePath string              // 打开的配置xml文件路径
-	MainTableList     binding.StringList  // Main 数据源
-	EnumTableList     binding.StringList  // enum 数据源
-	DataTableList     binding.StringList  // data 数据源
-	PtcTableList      binding.StringList  // ptc 数据源
-	RpcTableList      binding.StringList  // rpc 数据源
-	SearchMap         map[string]string   // 所有可搜索元素到列表名字的映射
-	SearchBuffer      []string            // 所有可所有元素列表
-	References        map[string][]string // 字段的依赖列表
+	FileEtree         *etree.Document             // 保存到文件的 etree
+	ChangedEtree      *etree.Document             // 变化的 etree
+	ChangedShowEtree  *etree.Document             // 包含源数据和变化数据,用于展示的 etree
+	ShowSchema        *model.Schema               // ChangedShowEtree 对应的数据模型
+	ProtoXmlFilePath  string                      // 打开的 proto Xml 文件路径
+	Config            *etree.Document             // 配置数据
+	ConfigXmlFilePath string                      // 打开的配置xml文件路径
+	MainTableList     binding.StringList          // Main 数据源
+	EnumTableList     binding.StringList          // enum 数据源
+	DataTableList     binding.StringList          // data 数据源
+	PtcTableList      binding.StringList          // ptc 数据源
+	RpcTableList      binding.StringList          // rpc 数据源
+	SearchMap         map[string]string           // 所有可搜索元素到列表名字的映射
+	SearchBuffer      []string                    // 所有可所有元素列表
+	References        map[string][]string         // 字段的依赖列表
+	FieldReferences   map[string][]model.FieldRef // 字段级的依赖列表, 类型全名 -> 引用它的字段
+	DepGraph          *model.DepGraph             // 顶层单元的依赖图, 包括间接依赖和循环引用
+	History           StHistory                   // ShowSchema 的撤销/重做历史
+	Journal           StJournal                   // 未保存修改的自动保存日志
+	MsgIdRenames      [][2]string                 // 未保存的协议改名, 旧名/新名, 保存时新名字沿用旧名字的消息 ID
 
 	SshClient *ssh.Client // ssh 连接
 }
@@ -65,6 +74,8 @@ func (Stapp *CoreManager) Init() {
 	// 读取协议xml文件
 	Stapp.ProtoXmlFilePath = utils.GetWorkRootPath() + "/data/protocolgo.xml"
 	Stapp.ReadXmlFromFile(Stapp.ProtoXmlFilePath)
+	// 定时写入未保存修改的自动保存日志
+	Stapp.Journal.StartAutosave(JournalInterval)
 
 	logrus.Info("Init CoreManager done. xml file path:", Stapp.ProtoXmlFilePath)
 }
@@ -83,44 +94,66 @@ func (Stapp *CoreManager) CreateNewXml() {
 	// 同时创建修改的 etree
 	Stapp.ChangedEtree = etree.NewDocument()
 	Stapp.ChangedShowEtree = Stapp.FileEtree.Copy()
+	Stapp.History.Clear()
+	Stapp.MsgIdRenames = nil
+	Stapp.Journal.Flush()
+	Stapp.Journal.Reset(Stapp.ProtoXmlFilePath)
+	// 同步数据模型和列表, 之后的编辑基于新的 xml
+	Stapp.SyncListWithETree()
 	logrus.Info("CreateNewXml done.")
 }
 
-func (Stapp *CoreManager) ReadXmlFromReader(reader io.Reader) {
-	// 如果现在打开的xml不为空,则先保存现在打开的xml
-	if nil != Stapp.FileEtree {
-		Stapp.CloseCurrProtoXmlFile()
+// 读取协议 xml, filename 为它的路径. 读取并检查通过后才关闭现在打开的 xml 并切换路径,
+// 内容无法解析时返回 false, 现在打开的 xml 和路径都不变
+func (Stapp *CoreManager) ReadXmlFromReader(reader io.Reader, filename string) bool {
+	doc := etree.NewDocument()
+	if _, err := doc.ReadFrom(reader); err != nil {
+		logrus.Error("ReadXmlFromReader failed. err:", err, ",filename:", filename)
+		return false
 	}
-	Stapp.FileEtree = etree.NewDocument()
-	if _, err := Stapp.FileEtree.ReadFrom(reader); err != nil {
-		logrus.Error("ReadXmlFromReader failed. err:", err)
-		panic(err)
+	if !Stapp.OpenProtoXmlDocument(doc, filename) {
+		return false
 	}
-	// 同时创建修改的 etree
-	Stapp.ChangedEtree = etree.NewDocument()
-	Stapp.ChangedShowEtree = Stapp.FileEtree.Copy()
-
-	Stapp.SyncListWithETree()
 	logrus.Info("ReadXmlFromReader done.")
+	return true
 }
 
-func (Stapp *CoreManager) ReadXmlFromFile(filename string) {
-	// 如果现在打开的xml不为空,则先保存现在打开的xml
+// 读取协议 xml 文件, 内容无法解析时返回 false, 现在打开的 xml 和路径都不变
+func (Stapp *CoreManager) ReadXmlFromFile(filename string) bool {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(filename); err != nil {
+		logrus.Error("ReadXmlFromFile failed. err:", err, ",filename:", filename)
+		return false
+	}
+	if !Stapp.OpenProtoXmlDocument(doc, filename) {
+		return false
+	}
+	logrus.Info("ReadXmlFromFile done.")
+	return true
+}
+
+// 使用读取的文档作为打开的 xml, filename 为它的路径. 文档无法解析为协议时返回 false, 不做任何修改;
+// 否则关闭现在打开的 xml(未保存的修改留在它的自动保存日志中), 再切换到新的路径
+func (Stapp *CoreManager) OpenProtoXmlDocument(doc *etree.Document, filename string) bool {
+	if _, err := model.SchemaFromDocument(doc); err != nil {
+		logrus.Error("OpenProtoXmlDocument failed. err:", err, ", filename:", filename)
+		return false
+	}
 	if nil != Stapp.FileEtree {
 		Stapp.CloseCurrProtoXmlFile()
 	}
-	Stapp.FileEtree = etree.NewDocument()
-	if err := Stapp.FileEtree.ReadFromFile(filename); err != nil {
-		logrus.Error("ReadXmlFromFile failed. err:", err, ",filename:", filename)
-		panic(err)
-	}
+	Stapp.ProtoXmlFilePath = filename
+	// 之后的自动保存日志记录到新的 xml 旁边
+	Stapp.Journal.Reset(Stapp.ProtoXmlFilePath)
 
+	Stapp.FileEtree = doc
 	// 同时创建修改的 etree
 	Stapp.ChangedEtree = etree.NewDocument()
 	Stapp.ChangedShowEtree = Stapp.FileEtree.Copy()
+	Stapp.History.Clear()
+	Stapp.MsgIdRenames = nil
 
-	Stapp.SyncListWithETree()
-	logrus.Info("ReadXmlFromFile done.")
+	return Stapp.SyncListWithETree()
 }
 
 func (Stapp *CoreManager) SaveToProtoXmlFile() bool {
@@ -129,7 +162,14 @@ func (Stapp *CoreManager) SaveToProtoXmlFile() bool {
 		return false
 	}
 	Stapp.FileEtree.Indent(4)
-	Stapp.FileEtree.WriteToFile(Stapp.ProtoXmlFilePath)
+	data, err := Stapp.FileEtree.WriteToBytes()
+	if err == nil {
+		err = WriteFileAtomic(Stapp.ProtoXmlFilePath, data)
+	}
+	if err != nil {
+		logrus.Error("SaveToProtoXmlFile failed. err:", err, ", ProtoXmlFilePath:", Stapp.ProtoXmlFilePath)
+		return false
+	}
 	logrus.Info("SaveToProtoXmlFile done. ProtoXmlFilePath:", Stapp.ProtoXmlFilePath)
 	return true
 }
@@ -139,6 +179,8 @@ func (Stapp *CoreManager) CloseCurrProtoXmlFile() {
 		logrus.Info("Need not close the proto xml. Stapp.FileEtree is nil.")
 		return
 	}
+	// 未保存的修改留在自动保存日志中, 下次打开时可以恢复
+	Stapp.Journal.Flush()
 	Stapp.SaveToProtoXmlFile()
 	Stapp.ProtoXmlFilePath = ""
 	logrus.Info("CloseCurrProtoXmlFile done.")
@@ -150,16 +192,17 @@ func (Stapp *CoreManager) SetCurrXmlFilePath(currFilePath string) {
 	logrus.Info("SetCurrXmlFilePath done.currFilePath:", currFilePath)
 }
 
-// 读取配置
-func (Stapp *CoreManager) ReadConfigFromFile(filename string) {
-	// 如果现在打开的xml不为空,则先保存现在打开的xml
-	Stapp.Config = etree.NewDocument()
-	if err := Stapp.Config.ReadFromFile(filename); err != nil {
-		logrus.Error("ReadConfigFromFile failed. err:", err)
-		panic(err)
+// 读取配置, 读取失败时返回 false, 不影响现在的配置
+func (Stapp *CoreManager) ReadConfigFromFile(filename string) bool {
+	config := etree.NewDocument()
+	if err := config.ReadFromFile(filename); err != nil {
+		logrus.Error("ReadConfigFromFile failed. err:", err, ", filename:", filename)
+		return false
 	}
+	Stapp.Config = config
 	Stapp.ConfigXmlFilePath = filename
 	logrus.Info("ReadConfigFromFile done. filename:", filename)
+	return true
 }
 
 // 保存配置
@@ -397,6 +440,378 @@ func (Stapp *CoreManager) GetGenProtoPath() (bool, string) {
 	return true, strFilePath
 }
 
+// 读取生成 proto 的配置, 未配置或配置错误时使用默认的 proto3
+func (Stapp *CoreManager) GetGenConfig() StGenConfig {
+	genConfig := NewGenConfig()
+	if nil == Stapp.Config {
+		return genConfig
+	}
+	genConfig.ServiceName = Stapp.GetRpcServiceName
+	genConfig.PairName = Stapp.GetProtoPairName
+	configGenProto := Stapp.Config.FindElement("config/genproto")
+	if configGenProto == nil {
+		return genConfig
+	}
+	strSyntax := configGenProto.SelectAttrValue("syntax", "")
+	if strSyntax != "" {
+		if !IsValidSyntax(strSyntax) {
+			logrus.Error("[GetGenConfig] invalid syntax:", strSyntax, ", use ", genConfig.Syntax)
+		} else {
+			genConfig.Syntax = strSyntax
+		}
+	}
+	genConfig.Edition = configGenProto.SelectAttrValue("edition", "")
+	strOrder := configGenProto.SelectAttrValue("order", "")
+	if strOrder != "" {
+		if !IsValidOrder(strOrder) {
+			logrus.Error("[GetGenConfig] invalid order:", strOrder, ", use ", genConfig.Order)
+		} else {
+			genConfig.Order = strOrder
+		}
+	}
+	strSplit := configGenProto.SelectAttrValue("split", "")
+	if strSplit != "" {
+		if !IsValidSplit(strSplit) {
+			logrus.Error("[GetGenConfig] invalid split:", strSplit, ", use ", genConfig.Split)
+		} else {
+			genConfig.Split = strSplit
+		}
+	}
+	// 每个输出文件的 package 和选项
+	genConfig.FileOptions = map[string]StFileOption{}
+	for _, configFileOption := range configGenProto.SelectElements("fileoption") {
+		strFile := configFileOption.SelectAttrValue("file", "")
+		if strFile == "" {
+			logrus.Error("[GetGenConfig] fileoption without file is ignored.")
+			continue
+		}
+		fileOption := StFileOption{
+			Package:         configFileOption.SelectAttrValue("package", ""),
+			GoPackage:       configFileOption.SelectAttrValue("go_package", ""),
+			JavaPackage:     configFileOption.SelectAttrValue("java_package", ""),
+			CsharpNamespace: configFileOption.SelectAttrValue("csharp_namespace", ""),
+			EnumOptions:     map[string]bool{},
+		}
+		for _, configOption := range configFileOption.SelectElements("option") {
+			strName := configOption.SelectAttrValue("name", "")
+			if strName == "" {
+				logrus.Error("[GetGenConfig] option without name is ignored. file:", strFile)
+				continue
+			}
+			fileOption.Options = setFileOption(fileOption.Options, [2]string{strName, configOption.SelectAttrValue("value", "")})
+			if configOption.SelectAttrValue("enum", "") == "true" {
+				fileOption.EnumOptions[strName] = true
+			}
+		}
+		genConfig.FileOptions[strFile] = fileOption
+	}
+	for _, strError := range genConfig.CheckFileOptions() {
+		logrus.Error("[GetGenConfig] ", strError)
+	}
+	return genConfig
+}
+
+// 按协议名前缀对应的源/目标服务器获取 rpc 所属的 service 名, 如 CS_Xxx 为 ClientGameServerService.
+// 无法识别时返回空字符串
+func (Stapp *CoreManager) GetRpcServiceName(rpcName string) string {
+	isSuccess, firstName, secondName := Stapp.DetectFullNameByProtoName(rpcName)
+	if !isSuccess {
+		return ""
+	}
+	strFirst := GetServiceIdentifier(firstName)
+	strSecond := GetServiceIdentifier(secondName)
+	if strFirst == "" || strSecond == "" {
+		return ""
+	}
+	return strFirst + strSecond + "Service"
+}
+
+// 获取协议名中的服务器对前缀, 如 CS_Xxx 为 CS, 前缀无法对应到配置的服务器时返回空字符串
+func (Stapp *CoreManager) GetProtoPairName(protoName string) string {
+	isSuccess, firstName, secondName := Stapp.DetectFullNameByProtoName(protoName)
+	if !isSuccess || firstName == "" || secondName == "" {
+		return ""
+	}
+	return protoName[:strings.Index(protoName, "_")]
+}
+
+// 从服务器全名中提取可作为标识符的部分, 如 场景服[GameServer] 为 GameServer
+func GetServiceIdentifier(fullName string) string {
+	if start := strings.Index(fullName, "["); start >= 0 {
+		if end := strings.Index(fullName[start:], "]"); end > 0 {
+			fullName = fullName[start+1 : start+end]
+		}
+	}
+	var builder strings.Builder
+	for _, c := range fullName {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && builder.Len() > 0) {
+			builder.WriteRune(c)
+		}
+	}
+	return builder.String()
+}
+
+// 获取消息 ID 的配置, 未配置 msgid 时返回 false, 此时不分配消息 ID
+func (Stapp *CoreManager) GetMsgIdConfig() (bool, StMsgIdConfig) {
+	msgIdConfig := NewMsgIdConfig()
+	if nil == Stapp.Config {
+		return false, msgIdConfig
+	}
+	configMsgId := Stapp.Config.FindElement("config/msgid")
+	if configMsgId == nil {
+		return false, msgIdConfig
+	}
+	strLockFile := configMsgId.SelectAttrValue("lockfile", "")
+	if strLockFile != "" && !filepath.IsAbs(strLockFile) {
+		strLockFile = utils.GetWorkRootPath() + "/" + strLockFile
+	}
+	msgIdConfig.LockFile = strLockFile
+	if nStart, err := strconv.Atoi(configMsgId.SelectAttrValue("start", "")); err == nil && nStart > 0 {
+		msgIdConfig.Start = nStart
+	}
+	if nSize, err := strconv.Atoi(configMsgId.SelectAttrValue("rangesize", "")); err == nil && nSize > 0 {
+		msgIdConfig.RangeSize = nSize
+	}
+	if strEnumName := configMsgId.SelectAttrValue("enumname", ""); strEnumName != "" {
+		if !CheckUnitName(strEnumName) {
+			logrus.Error("[GetMsgIdConfig] invalid enumname:", strEnumName, ", use ", msgIdConfig.EnumName)
+		} else {
+			msgIdConfig.EnumName = strEnumName
+		}
+	}
+	for _, configRange := range configMsgId.SelectElements("range") {
+		strPair := configRange.SelectAttrValue("pair", "")
+		nStart, errStart := strconv.Atoi(configRange.SelectAttrValue("start", ""))
+		nEnd, errEnd := strconv.Atoi(configRange.SelectAttrValue("end", ""))
+		if strPair == "" || errStart != nil || errEnd != nil || nStart > nEnd {
+			logrus.Error("[GetMsgIdConfig] invalid range is ignored. pair:", strPair)
+			continue
+		}
+		msgIdConfig.Ranges = append(msgIdConfig.Ranges, StMsgIdRange{Pair: strPair, Start: nStart, End: nEnd})
+	}
+	return true, msgIdConfig
+}
+
+// 获取保存协议 xml 时的备份配置, 来自 config.xml 的 backup. 未配置时使用默认配置
+func (Stapp *CoreManager) GetBackupConfig() (bool, StBackupConfig) {
+	backupConfig := NewBackupConfig()
+	if nil == Stapp.Config {
+		return false, backupConfig
+	}
+	configBackup := Stapp.Config.FindElement("config/backup")
+	if configBackup == nil {
+		return false, backupConfig
+	}
+	strDir := configBackup.SelectAttrValue("dir", "")
+	if strDir != "" && !filepath.IsAbs(strDir) {
+		strDir = utils.GetWorkRootPath() + "/" + strDir
+	}
+	backupConfig.Dir = strDir
+	if strCount := configBackup.SelectAttrValue("count", ""); strCount != "" {
+		if nCount, err := strconv.Atoi(strCount); err != nil || nCount < 0 {
+			logrus.Error("[GetBackupConfig] invalid count:", strCount, ", use ", backupConfig.Count)
+		} else {
+			backupConfig.Count = nCount
+		}
+	}
+	return true, backupConfig
+}
+
+// 为协议 xml 中的协议分配消息 ID, 有变化时写回锁文件. 未配置 msgid 时返回 nil.
+// 返回分配失败和冲突检查的错误
+func (Stapp *CoreManager) UpdateMsgIds(schema *model.Schema, xmlPath string) (*StMsgIdRegistry, []string) {
+	isEnable, msgIdConfig := Stapp.GetMsgIdConfig()
+	if !isEnable || nil == schema {
+		return nil, nil
+	}
+	strLockPath := msgIdConfig.GetLockPath(xmlPath)
+	registry, isOk := LoadMsgIdRegistry(strLockPath)
+	if !isOk {
+		return nil, []string{"read msgid lock file failed: " + strLockPath}
+	}
+	// 改名的协议沿用原来的 ID, 改名后又撤销的不处理
+	bRenamed := false
+	for _, rename := range Stapp.MsgIdRenames {
+		if schema.FindMessage(model.CategoryProtocol, rename[1]) != nil && schema.FindMessage(model.CategoryProtocol, rename[0]) == nil &&
+			registry.RenameEntry(rename[0], rename[1], Stapp.GetProtoPairName(rename[1])) {
+			bRenamed = true
+		}
+	}
+	bChanged, errorList := registry.Assign(schema, Stapp.GetProtoPairName, msgIdConfig)
+	bChanged = bChanged || bRenamed
+	errorList = append(errorList, registry.Check()...)
+	if bChanged && !registry.SaveToFile(strLockPath) {
+		errorList = append(errorList, "write msgid lock file failed: "+strLockPath)
+	}
+	for _, strError := range errorList {
+		logrus.Error("[UpdateMsgIds] ", strError)
+	}
+	return registry, errorList
+}
+
+// 只读地加载消息 ID 锁文件, 不写回. 锁文件缺少协议的消息 ID 时返回错误, 需要先执行 msgid 或保存协议 xml.
+// 未配置 msgid 时返回 nil
+func (Stapp *CoreManager) LoadMsgIds(schema *model.Schema, xmlPath string) (*StMsgIdRegistry, []string) {
+	isEnable, msgIdConfig := Stapp.GetMsgIdConfig()
+	if !isEnable || nil == schema {
+		return nil, nil
+	}
+	strLockPath := msgIdConfig.GetLockPath(xmlPath)
+	registry, isOk := LoadMsgIdRegistry(strLockPath)
+	if !isOk {
+		return nil, []string{"read msgid lock file failed: " + strLockPath}
+	}
+	// 只在内存中分配, 用于判断锁文件是否过期
+	bChanged, errorList := registry.Assign(schema, Stapp.GetProtoPairName, msgIdConfig)
+	if bChanged {
+		errorList = append(errorList, "msgid lock file is out of date, run msgid first: "+strLockPath)
+	}
+	errorList = append(errorList, registry.Check()...)
+	for _, strError := range errorList {
+		logrus.Error("[LoadMsgIds] ", strError)
+	}
+	return registry, errorList
+}
+
+// 协议改名对消息 ID 的影响, 未配置 msgid 时为空. 服务器对不变时保存后沿用原来的 ID, 否则分配新的 ID
+func (Stapp *CoreManager) GetMsgIdRenameNote(oldName string, newName string) string {
+	if isEnable, _ := Stapp.GetMsgIdConfig(); !isEnable {
+		return ""
+	}
+	if Stapp.GetProtoPairName(oldName) != Stapp.GetProtoPairName(newName) {
+		return "protocol " + newName + " gets a new message id when saved, the server pair is changed"
+	}
+	return "protocol " + newName + " keeps the message id of " + oldName + " when saved"
+}
+
+// 获取生成 proto 的配置, 配置了 msgid 时从锁文件读取消息 ID 并输出消息 ID 枚举
+func (Stapp *CoreManager) GetGenConfigWithMsgId(schema *model.Schema, xmlPath string) (StGenConfig, []string) {
+	genConfig := Stapp.GetGenConfig()
+	registry, errorList := Stapp.LoadMsgIds(schema, xmlPath)
+	if registry != nil {
+		_, msgIdConfig := Stapp.GetMsgIdConfig()
+		genConfig.MsgIdEnum = registry.ToEnum(msgIdConfig.EnumName)
+	}
+	return genConfig, errorList
+}
+
+// 获取生成 go 分发代码的配置和输出路径, 来自 config.xml 的 gendispatch. 输出目录不存在时会被创建
+func (Stapp *CoreManager) GetDispatchConfig() (bool, StDispatchConfig, string) {
+	dispatchConfig := StDispatchConfig{Package: DefaultDispatchPackage}
+	if nil == Stapp.Config {
+		return false, dispatchConfig, ""
+	}
+	configDispatch := Stapp.Config.FindElement("config/gendispatch")
+	if configDispatch == nil {
+		logrus.Error("[GetDispatchConfig] read config failed. gendispatch is not exist.")
+		return false, dispatchConfig, ""
+	}
+	if strPackage := configDispatch.SelectAttrValue("package", ""); strPackage != "" {
+		if !CheckUnitName(strPackage) || strings.Contains(strPackage, ".") {
+			logrus.Error("[GetDispatchConfig] invalid package:", strPackage)
+			return false, dispatchConfig, ""
+		}
+		dispatchConfig.Package = strPackage
+	}
+	strFilePath := configDispatch.SelectAttrValue("absoluteoutputpath", "")
+	if strFilePath == "" {
+		strRelativePath := configDispatch.SelectAttrValue("relativeoutputpath", "")
+		if strRelativePath == "" {
+			logrus.Error("[GetDispatchConfig] read outputpath failed. outputpath is not configed.")
+			return false, dispatchConfig, ""
+		}
+		strFilePath = utils.GetWorkRootPath() + "/" + strRelativePath
+	}
+	return true, dispatchConfig, strFilePath
+}
+
+// 根据协议名前缀生成每个服务器的 go 分发代码, 消息 ID 来自消息 ID 锁文件. 返回生成的文件名和错误
+func (Stapp *CoreManager) GenDispatchFromSchema(schema *model.Schema, xmlPath string, outputPath string) (bool, []string, []string) {
+	isSuccess, dispatchConfig, strConfigPath := Stapp.GetDispatchConfig()
+	if !isSuccess {
+		return false, nil, []string{"read gendispatch config failed"}
+	}
+	if outputPath == "" {
+		outputPath = strConfigPath
+	}
+	registry, errorList := Stapp.LoadMsgIds(schema, xmlPath)
+	if registry == nil {
+		return false, nil, append(errorList, "msgid is not configed, dispatch code needs message ids")
+	}
+	if len(errorList) > 0 {
+		return false, nil, errorList
+	}
+	dispatchList, errorList := GetDispatchList(schema, registry, Stapp.GetGenConfig(), Stapp.DetectFullNameByProtoName)
+	if len(errorList) > 0 {
+		return false, nil, errorList
+	}
+	isSuccess, fileNames := GenDispatch(dispatchList, outputPath, dispatchConfig)
+	if !isSuccess {
+		return false, fileNames, []string{"write dispatch code failed, output: " + outputPath}
+	}
+	return true, fileNames, nil
+}
+
+// 获取生成 pb 的配置, 来自 config.xml 的 genpb
+func (Stapp *CoreManager) GetPbConfig() StPbConfig {
+	var pbConfig StPbConfig
+	if nil == Stapp.Config {
+		return pbConfig
+	}
+	configGenPb := Stapp.Config.FindElement("config/genpb")
+	if configGenPb == nil {
+		return pbConfig
+	}
+	pbConfig.Grpc = strings.ToLower(configGenPb.SelectAttrValue("grpc", "")) == "true"
+	pbConfig.GoModule = configGenPb.SelectAttrValue("gomodule", "")
+	pbConfig.Protoc = configGenPb.SelectAttrValue("protoc", "")
+	pbConfig.ProtocMinVersion = configGenPb.SelectAttrValue("protocminversion", "")
+	pbConfig.Mode = configGenPb.SelectAttrValue("mode", PbModeAuto)
+	if !IsValidPbMode(pbConfig.Mode) {
+		logrus.Error("[GetPbConfig] invalid mode:", pbConfig.Mode, ", auto is used.")
+		pbConfig.Mode = PbModeAuto
+	}
+	pbConfig.DescriptorSet = configGenPb.SelectAttrValue("descriptorset", "")
+	// 生成目标, enable 为 false 的不执行
+	for _, configTarget := range configGenPb.SelectElements("target") {
+		if strings.ToLower(configTarget.SelectAttrValue("enable", "true")) == "false" {
+			continue
+		}
+		target := StPbTarget{
+			Name:   configTarget.SelectAttrValue("name", ""),
+			Plugin: configTarget.SelectAttrValue("plugin", ""),
+			Out:    configTarget.SelectAttrValue("out", ""),
+			Opt:    configTarget.SelectAttrValue("opt", ""),
+
+			Path:       configTarget.SelectAttrValue("path", ""),
+			MinVersion: configTarget.SelectAttrValue("minversion", ""),
+		}
+		if target.Name == "" {
+			target.Name = target.Plugin
+		}
+		if target.Plugin == "" || strings.ContainsAny(target.Plugin, " =/\\") {
+			logrus.Error("[GetPbConfig] target with invalid plugin is ignored. name:", target.Name, ", plugin:", target.Plugin)
+			continue
+		}
+		pbConfig.Targets = append(pbConfig.Targets, target)
+	}
+	return pbConfig
+}
+
+// 获取生成 pb 的配置, 不是 protoc 方式时附带内置生成使用的协议, 消息 ID 来自锁文件
+func (Stapp *CoreManager) GetPbConfigWithSource(schema *model.Schema, xmlPath string) (StPbConfig, []string) {
+	pbConfig := Stapp.GetPbConfig()
+	if pbConfig.Mode == PbModeProtoc || nil == schema {
+		return pbConfig, nil
+	}
+	genConfig, errorList := Stapp.GetGenConfigWithMsgId(schema, xmlPath)
+	if len(errorList) > 0 {
+		return pbConfig, errorList
+	}
+	pbConfig.Source = &StPbSource{Schema: schema, GenConfig: genConfig}
+	return pbConfig, nil
+}
+
 // 获取 pb 产生路径
 func (Stapp *CoreManager) GetGenPbPath() (bool, string) {
 	configElement := Stapp.Config.FindElement("config")
@@ -473,20 +888,45 @@ func (Stapp *CoreManager) SaveProtoXmlToFile() bool {
 		return false
 	}
 
+	// 保存时才将删除的字段序号和名字记录为保留, 保存前删除后又加回的字段不受影响
+	saveSchema := Stapp.GetSaveSchema()
+	if saveSchema == nil {
+		logrus.Error("SaveProtoXmlToFile failed. copy schema failed.")
+		return false
+	}
+	fileEtree := saveSchema.ToDocument()
+	fileEtree.Indent(4)
+	data, err := fileEtree.WriteToBytes()
+	if err != nil {
+		logrus.Error("SaveProtoXmlToFile failed. err:", err)
+		return false
+	}
+	// 先备份原文件, 再写入临时文件后替换, 失败时原文件和未保存的修改都不变
+	_, backupConfig := Stapp.GetBackupConfig()
+	if err := SaveWithBackup(backupConfig, Stapp.ProtoXmlFilePath, data); err != nil {
+		logrus.Error("SaveProtoXmlToFile failed. err:", err, ", ProtoXmlFilePath:", Stapp.ProtoXmlFilePath)
+		return false
+	}
+
 	// 将修改同步到File
-	Stapp.FileEtree = Stapp.ChangedShowEtree.Copy()
+	Stapp.FileEtree = fileEtree
+	Stapp.ChangedShowEtree = fileEtree.Copy()
 	// 同步列表
 	Stapp.SyncListWithETree()
 
-	Stapp.FileEtree.Indent(4)
-	Stapp.FileEtree.WriteToFile(Stapp.ProtoXmlFilePath)
+	// 已保存, 删除自动保存日志
+	Stapp.Journal.Update(nil)
+	Stapp.Journal.Flush()
+	// 保存后为新协议分配消息 ID, 删除的协议的 ID 不再使用
+	Stapp.UpdateMsgIds(Stapp.GetFileSchema(), Stapp.ProtoXmlFilePath)
+	Stapp.MsgIdRenames = nil
 	logrus.Info("SaveProtoXmlToFile done. ProtoXmlFilePath:", Stapp.ProtoXmlFilePath)
 	return true
 }
 
 // Add/Update StUnits
 func (Stapp *CoreManager) AddUpdateUnits(stUnits StUnits) bool {
-	if nil == Stapp.ChangedShowEtree {
+	if nil == Stapp.ChangedShowEtree || nil == Stapp.ShowSchema {
 		logrus.Error("AddUpdateUnits failed. Stapp.ChangedShowEtree is nil, open the xml")
 		return false
 	}
@@ -497,119 +937,172 @@ func (Stapp *CoreManager) AddUpdateUnits(stUnits StUnits) bool {
 	}
 	// 获取第一个unit
 	stUnit := stUnits.UnitList[0]
+	before := Stapp.CopyShowSchema()
 
-	strRoot := Stapp.GetEtreeRootName(stUnit.TableType)
-
-	// 先查找是否有枚举的分类
-	msg_catagory := Stapp.ChangedShowEtree.FindElement(strRoot)
-	if msg_catagory == nil {
-		msg_catagory = Stapp.ChangedShowEtree.CreateElement(strRoot)
-	}
-
-	// 处理 rpc
-	if stUnit.TableType == TableType_RPC {
-		unitlist := msg_catagory.FindElement(stUnits.UnitListName)
-		if unitlist != nil {
-			msg_catagory.RemoveChild(msg_catagory.SelectElement(stUnits.UnitListName))
+	if stUnit.ParentPath != "" {
+		// 嵌套类型, 写入所在的消息
+		parent := Stapp.ShowSchema.FindMessageByPath(stUnit.ParentPath)
+		if parent == nil {
+			logrus.Error("AddUpdateUnits failed. can not find parent. ParentPath:", stUnit.ParentPath, ", UnitName:", stUnit.UnitName)
+			return false
+		}
+		if stUnit.TableType == TableType_Enum {
+			parent.PutNestedEnum(stUnit.ToEnum())
+		} else {
+			msg := stUnit.ToMessage()
+			keepNested(msg, parent.FindNestedMessage(stUnit.UnitName))
+			parent.PutNestedMessage(msg)
+		}
+	} else if stUnit.TableType == TableType_Enum {
+		Stapp.ShowSchema.PutEnum(stUnit.ToEnum())
+	} else if stUnit.TableType == TableType_Data || stUnit.TableType == TableType_Protocol {
+		strRoot := GetEtreeRootName(stUnit.TableType)
+		msg := stUnit.ToMessage()
+		keepNested(msg, Stapp.ShowSchema.FindMessage(strRoot, stUnit.UnitName))
+		Stapp.ShowSchema.PutMessage(strRoot, msg)
+	} else if stUnit.TableType == TableType_RPC {
+		// 处理 rpc, 请求和回包保存在同一个单元下
+		rpc := &model.Rpc{Name: stUnit.UnitName}
+		oldRpc := Stapp.ShowSchema.FindRpc(stUnits.UnitListName)
+		if oldRpc == nil {
+			oldRpc = &model.Rpc{}
 		}
-		unitlist = msg_catagory.CreateElement(stUnit.UnitName)
 		for _, rpcStUnit := range stUnits.UnitList {
-			if !Stapp.SaveSingleUnitToElem(unitlist, rpcStUnit) {
-				logrus.Error("AddUpdateUnits failed. rpcStUnit UnitName:", rpcStUnit.UnitName)
+			rpcStUnit.UnitName = stUnit.UnitName
+			if rpcStUnit.SubTableType == SubTableType_RpcReq {
+				rpc.Req = rpcStUnit.ToMessage()
+				keepNested(rpc.Req, oldRpc.Req)
+			} else if rpcStUnit.SubTableType == SubTableType_RpcAck {
+				rpc.Ack = rpcStUnit.ToMessage()
+				keepNested(rpc.Ack, oldRpc.Ack)
+			} else {
+				logrus.Error("AddUpdateUnits failed. rpcStUnit.SubTableType is invalid. UnitName:", rpcStUnit.UnitName)
 				return false
 			}
 		}
-	} else {
-		if !Stapp.SaveSingleUnitToElem(msg_catagory, stUnit) {
-			logrus.Error("AddUpdateUnits failed. stUnit.UnitName:", stUnit.UnitName)
-			return false
+		if stUnits.UnitListName != stUnit.UnitName {
+			Stapp.ShowSchema.RemoveUnit(model.CategoryRpc, stUnits.UnitListName)
 		}
+		Stapp.ShowSchema.PutRpc(rpc)
+	} else {
+		logrus.Error("AddUpdateUnits failed. invalid TableType:", stUnit.TableType, ", UnitName:", stUnit.UnitName)
+		return false
 	}
 
-	// Stapp.EnumTableList.Append(editMsg.MsgName)
-	Stapp.SyncListWithETree()
+	Stapp.ApplyShowSchema()
+	if stUnit.ParentPath != "" {
+		Stapp.RecordHistory("save "+stUnit.ParentPath+"."+stUnit.UnitName, before)
+	} else {
+		Stapp.RecordHistory("save "+stUnit.UnitName, before)
+	}
 
 	logrus.Info("AddUpdateUnits from stUnit done. UnitName:", stUnit.UnitName)
 	return true
 }
 
-// 保存单个Unit
-func (Stapp *CoreManager) SaveSingleUnitToElem(mount_point *etree.Element, stUnit StUnit) bool {
-	// 查找是否有对应的key
-	unit := mount_point.FindElement(stUnit.UnitName)
-	if unit != nil {
-		isNeedRemove := true
-		if stUnit.TableType == TableType_RPC {
-			isNeedRemove = false
-			rpcType := unit.SelectAttr("RpcType")
-			if rpcType == nil {
-				logrus.Error("SaveSingleUnitToElem failed. stUnit.TableType is invalid.")
-				return false
-			}
-			if rpcType.Value == "Req" && stUnit.SubTableType == SubTableType_RpcReq {
-				isNeedRemove = true
-			}
-			if rpcType.Value == "Ack" && stUnit.SubTableType == SubTableType_RpcAck {
-				isNeedRemove = true
-			}
-		}
-		if isNeedRemove {
-			mount_point.RemoveChild(mount_point.SelectElement(stUnit.UnitName))
-		}
+// 编辑单元时只修改字段, 保留原有的嵌套类型
+func keepNested(msg *model.Message, oldMsg *model.Message) {
+	if oldMsg == nil {
+		return
+	}
+	msg.Enums = oldMsg.Enums
+	msg.Messages = oldMsg.Messages
+}
+
+// 删除嵌套类型, path 为嵌套类型的全名, 仍被其他字段引用时不删除
+func (Stapp *CoreManager) DeleteNestedUnit(path string) bool {
+	if !strings.Contains(path, ".") {
+		logrus.Error("DeleteNestedUnit failed. invalid path:", path)
+		return false
+	}
+	isOk, _, _ := Stapp.DeleteUnit(TableType_None, path, DeleteMode_Refuse, "")
+	return isOk
+}
 
+// 将 ShowSchema 的修改写回 ChangedShowEtree, 并同步列表
+func (Stapp *CoreManager) ApplyShowSchema() bool {
+	if nil == Stapp.ShowSchema {
+		logrus.Error("ApplyShowSchema failed. Stapp.ShowSchema is nil, open the xml")
+		return false
 	}
-	unit = mount_point.CreateElement(stUnit.UnitName)
-	if stUnit.TableType == TableType_RPC {
-		if stUnit.SubTableType == SubTableType_RpcReq {
-			unit.CreateAttr("RpcType", "Req")
-		} else if stUnit.SubTableType == SubTableType_RpcAck {
-			unit.CreateAttr("RpcType", "Ack")
-		} else {
-			logrus.Error("SaveSingleUnitToElem failed. stUnit.SubTableType is invalid.")
-		}
+	Stapp.ChangedShowEtree = Stapp.ShowSchema.ToDocument()
+	isOk := Stapp.SyncListWithETree()
+	Stapp.UpdateJournal()
+	return isOk
+}
+
+// 获取要保存到文件的数据模型: ShowSchema 的副本, 相对文件中已保存版本被删除的字段序号和名字记录为保留
+func (Stapp *CoreManager) GetSaveSchema() *model.Schema {
+	schema := Stapp.CopyShowSchema()
+	if schema == nil {
+		return nil
 	}
-	if stUnit.UnitComment != "" {
-		unit.CreateComment(stUnit.UnitComment)
+	if fileSchema := Stapp.GetFileSchema(); fileSchema != nil {
+		AddRemovedAsReserved(fileSchema, schema)
 	}
+	return schema
+}
 
-	for _, row := range stUnit.RowList {
-		enum_atom := unit.CreateElement(stUnit.UnitName)
-		if row.EntryOption != nil {
-			enum_atom.CreateAttr("EntryOption", row.EntryOption.Selected)
+// 获取 stUnit 相对文件中已保存版本被删除的字段
+func (Stapp *CoreManager) GetRemovedFields(stUnit StUnit) []model.Reserved {
+	fileSchema := Stapp.GetFileSchema()
+	if fileSchema == nil {
+		return nil
+	}
+	if stUnit.TableType == TableType_Enum {
+		if fileEnum := fileSchema.FindEnumByPath(stUnit.GetTypePath()); fileEnum != nil {
+			return GetRemovedFields(fileEnum.Values, stUnit.RowList, false)
 		}
+	} else if fileMsg := fileSchema.FindMessageByPath(stUnit.GetTypePath()); fileMsg != nil {
+		return GetRemovedFields(fileMsg.Fields, stUnit.RowList, true)
+	}
+	return nil
+}
 
-		if row.EntryType != nil {
-			enum_atom.CreateAttr("EntryType", row.EntryType.Text)
-		}
-		enum_atom.CreateAttr("EntryName", row.EntryName.Text)
-		enum_atom.CreateAttr("EntryIndex", row.EntryIndex.Text)
-		if row.EntryDefault != nil {
-			enum_atom.CreateAttr("EntryDefault", row.EntryDefault.Selected)
-		}
-		enum_atom.CreateAttr("EntryComment", row.EntryComment.Text)
+// 自动修复可修复的诊断并写回 ChangedShowEtree, 返回已修复和无法修复的描述
+func (Stapp *CoreManager) FixDiagnostics(diagList []StDiagnostic) ([]string, []string) {
+	if nil == Stapp.ShowSchema {
+		logrus.Error("FixDiagnostics failed. Stapp.ShowSchema is nil, open the xml")
+		return []string{}, []string{"the xml is not opened"}
 	}
-	return true
+	before := Stapp.CopyShowSchema()
+	fixed, failed := FixDiagnostics(Stapp.ShowSchema, diagList)
+	if len(fixed) > 0 {
+		Stapp.ApplyShowSchema()
+		Stapp.RecordHistory("fix "+strconv.Itoa(len(fixed))+" problem(s)", before)
+	}
+	logrus.Info("FixDiagnostics done. fixed:", len(fixed), ", failed:", len(failed))
+	return fixed, failed
+}
+
+// 获取展示用的数据模型
+func (Stapp *CoreManager) GetSchema() *model.Schema {
+	return Stapp.ShowSchema
+}
+
+// 对比已保存的文件, 获取当前修改保存后的兼容性报告
+func (Stapp *CoreManager) GetCompatReport() []StCompatChange {
+	return CheckCompatibility(Stapp.GetFileSchema(), Stapp.GetSaveSchema())
+}
+
+// 获取已保存到文件的数据模型
+func (Stapp *CoreManager) GetFileSchema() *model.Schema {
+	schema, err := model.SchemaFromDocument(Stapp.FileEtree)
+	if err != nil {
+		logrus.Error("GetFileSchema failed. err:", err)
+		return nil
+	}
+	return schema
 }
 
 // Revert StUnit
 func (Stapp *CoreManager) RevertUnitFromChanged(eTableType ETableType, strUnitName string) bool {
-	if nil == Stapp.ChangedShowEtree || nil == Stapp.ChangedEtree {
+	if nil == Stapp.ShowSchema || nil == Stapp.ChangedEtree {
 		logrus.Error("RevertUnitFromChanged failed. Stapp.ChangedShowEtree is nil, open the xml")
 		return false
 	}
 
-	strRoot := Stapp.GetEtreeRootName(eTableType)
-
-	// 先在 ChangedShow 中找是否有对应的分类
-	show_catagory := Stapp.ChangedShowEtree.FindElement(strRoot)
-	if show_catagory == nil {
-		show_catagory = Stapp.ChangedShowEtree.CreateElement(strRoot)
-	}
-	// 在 ChangedShow 查找是否有对应的key
-	show_unit := show_catagory.FindElement(strUnitName)
-	if show_unit != nil {
-		show_catagory.RemoveChild(show_catagory.SelectElement(strUnitName))
-	}
+	strRoot := GetEtreeRootName(eTableType)
 
 	// 在变化项中定位元素
 	// 先查找是否有枚举的分类
@@ -631,59 +1124,104 @@ func (Stapp *CoreManager) RevertUnitFromChanged(eTableType ETableType, strUnitNa
 		return false
 	}
 	strOperType := operationAttr.Value
+	before := Stapp.CopyShowSchema()
 
 	if strOperType == "delete" || strOperType == "update" {
-		show_catagory.AddChild(changed_unit)
+		// 用变化前的单元替换展示中的单元
+		if eTableType == TableType_Enum {
+			Stapp.ShowSchema.PutEnum(model.EnumFromElement(changed_unit))
+		} else if eTableType == TableType_RPC {
+			rpc, err := model.RpcFromElement(changed_unit)
+			if err != nil {
+				logrus.Error("RevertUnitFromChanged failed. err:", err, ", strUnitName:", strUnitName)
+				return false
+			}
+			Stapp.ShowSchema.PutRpc(rpc)
+		} else {
+			Stapp.ShowSchema.PutMessage(strRoot, model.MessageFromElement(changed_unit))
+		}
 	} else if strOperType == "add" {
-
+		Stapp.ShowSchema.RemoveUnit(strRoot, strUnitName)
 	} else {
 		logrus.Error("RevertUnitFromChanged failed. opertype is invalid. eTableType:", eTableType, ",strUnitName:", strUnitName, ",strOperType:", strOperType)
 		return false
 	}
 
-	Stapp.SyncListWithETree()
+	Stapp.ApplyShowSchema()
+	Stapp.RecordHistory("revert "+strUnitName, before)
 
 	logrus.Info("RevertUnitFromChanged done. eTableType:", eTableType, ",strUnitName:", strUnitName)
 	return true
 }
 
-func (Stapp *CoreManager) GetEtreeRootName(tableType ETableType) string {
+// 根据页签类型获取分类名
+func GetEtreeRootName(tableType ETableType) string {
 	var strUnitType string
 	if tableType == TableType_Enum {
-		strUnitType = "enum"
+		strUnitType = model.CategoryEnum
 	} else if tableType == TableType_Data {
-		strUnitType = "data"
+		strUnitType = model.CategoryData
 	} else if tableType == TableType_Protocol {
-		strUnitType = "protocol"
+		strUnitType = model.CategoryProtocol
 	} else if tableType == TableType_RPC {
-		strUnitType = "rpc"
+		strUnitType = model.CategoryRpc
 	}
 	return strUnitType
 }
 
-// 删除 enum/message 列表元素
-func (Stapp *CoreManager) DeleteCurrUnit(tableType ETableType, rowName string) bool {
+// 根据分类名获取页签类型
+func GetTableTypeByRootName(strRoot string) ETableType {
+	if strRoot == model.CategoryEnum {
+		return TableType_Enum
+	} else if strRoot == model.CategoryData {
+		return TableType_Data
+	} else if strRoot == model.CategoryProtocol {
+		return TableType_Protocol
+	} else if strRoot == model.CategoryRpc {
+		return TableType_RPC
+	}
+	return TableType_None
+}
 
-	strUnitName := Stapp.GetEtreeRootName(tableType)
+// 删除 enum/message 列表元素, 仍被其他字段引用时不删除
+func (Stapp *CoreManager) DeleteCurrUnit(tableType ETableType, rowName string) bool {
+	isOk, _, _ := Stapp.DeleteUnit(tableType, rowName, DeleteMode_Refuse, "")
+	return isOk
+}
 
-	// 先查找是否有枚举的分类
-	catagory := Stapp.ChangedShowEtree.FindElement(strUnitName)
-	if catagory == nil {
-		logrus.Error("DeleteCurrUnit failed. TableType:", tableType, ", strUnitName:", strUnitName, ", rowName:", rowName)
-		return false
+// 获取删除单元后仍引用它的字段. path 含 . 时为嵌套类型的全名
+func (Stapp *CoreManager) GetDeleteDependents(tableType ETableType, path string) []StDeleteDependent {
+	strCategory := ""
+	if !strings.Contains(path, ".") {
+		strCategory = GetEtreeRootName(tableType)
 	}
-	// 在查找Unit中是否有对应的key
-	unit := catagory.FindElement(rowName)
-	if unit == nil {
-		logrus.Error("DeleteCurrUnit failed. Can not find target.  TableType:", tableType, ", strUnitName:", strUnitName, ", rowName:", rowName)
-		return false
-	}
-	catagory.RemoveChild(catagory.SelectElement(rowName))
-
-	Stapp.SyncListWithETree()
+	return GetDeleteDependents(Stapp.ShowSchema, strCategory, path)
+}
 
-	logrus.Info("DeleteCurrUnit done. TableType:", tableType, ", strUnitName:", strUnitName, ", rowName:", rowName)
-	return false
+// 删除顶层单元或嵌套类型(path 含 .), 仍被引用时按 mode 处理引用的字段, 成功后写回 ShowSchema
+func (Stapp *CoreManager) DeleteUnit(tableType ETableType, path string, mode EDeleteMode, replaceType string) (bool, StDeleteResult, string) {
+	strCategory := ""
+	if !strings.Contains(path, ".") {
+		strCategory = GetEtreeRootName(tableType)
+	}
+	isOk, result, strError := DeleteType(Stapp.ShowSchema, strCategory, path, mode, replaceType)
+	if !isOk {
+		logrus.Error("DeleteUnit failed. TableType:", tableType, ", path:", path, ", mode:", mode, ", err:", strError)
+		return false, result, strError
+	}
+	before := Stapp.ShowSchema
+	Stapp.ShowSchema = result.Schema
+	Stapp.ApplyShowSchema()
+	strName := "delete " + path
+	if mode == DeleteMode_Cascade && len(result.Changes) > 0 {
+		strName += " and " + strconv.Itoa(len(result.Changes)) + " field(s)"
+	} else if mode == DeleteMode_Replace && len(result.Changes) > 0 {
+		strName += ", replace with " + replaceType
+	}
+	Stapp.RecordHistory(strName, before)
+
+	logrus.Info("DeleteUnit done. TableType:", tableType, ", path:", path, ", mode:", mode, ", changes:", len(result.Changes))
+	return true, result, ""
 }
 
 func (Stapp *CoreManager) GetTableListByType(tabletype ETableType) *binding.StringList {
@@ -750,42 +1288,50 @@ func (Stapp *CoreManager) GetEditTableTitle(tabletype ETableType, name string) s
 	}
 }
 
-func (Stapp *CoreManager) GetEtreeElem(tabletype ETableType, subtabletype ESubTableType, rowName string) *etree.Element {
-	strUnitName := Stapp.GetEtreeRootName(tabletype)
-	// 先查找是否有具体的分类
-	catagory := Stapp.ChangedShowEtree.FindElement(strUnitName)
-	if catagory == nil {
-		logrus.Error("GetEtreeElem failed. tabletype:", tabletype, ", strUnitName:", strUnitName, ", rowName:", rowName)
-		return nil
-	}
-	// 再查找是否有对应的key
-	unit := catagory.FindElement(rowName)
-	if unit == nil {
-		logrus.Error("GetEtreeElem failed. Can not find target. tabletype:", tabletype, ", strUnitName:", strUnitName, ", rowName:", rowName)
-		return nil
-	}
-	// 定义结果变量
-	result := unit
-
-	if tabletype == TableType_RPC {
-		for _, rpcUnit := range unit.ChildElements() {
-			rpcType := rpcUnit.SelectAttr("RpcType")
-			if rpcType == nil {
-				logrus.Error("GetEtreeElem failed. TableType_RPC does not have RpcType. tabletype:", tabletype, ", strUnitName:", strUnitName, ", rowName:", rowName, ", rpcUnit.Tag:", rpcUnit.Tag)
-				return nil
-			}
-			if rpcType.Value == "Req" && subtabletype == SubTableType_RpcReq {
-				result = rpcUnit
-				break
+// 获取展示中的单元数据, rpc 通过 subtabletype 区分 Req/Ack
+func (Stapp *CoreManager) GetStUnit(tabletype ETableType, subtabletype ESubTableType, rowName string) (StUnit, bool) {
+	if nil == Stapp.ShowSchema || rowName == "" {
+		return StUnit{}, false
+	}
+	strUnitName := GetEtreeRootName(tabletype)
+	if index := strings.LastIndex(rowName, "."); index >= 0 {
+		// 嵌套类型, 枚举按枚举编辑, 消息按 data 编辑
+		if enum := Stapp.ShowSchema.FindEnumByPath(rowName); enum != nil {
+			stUnit := StUnitFromEnum(enum)
+			stUnit.ParentPath = rowName[:index]
+			return stUnit, true
+		}
+		if msg := Stapp.ShowSchema.FindMessageByPath(rowName); msg != nil {
+			stUnit := StUnitFromMessage(TableType_Data, SubTableType_None, msg)
+			stUnit.ParentPath = rowName[:index]
+			return stUnit, true
+		}
+	} else if tabletype == TableType_Enum {
+		enum := Stapp.ShowSchema.FindEnum(rowName)
+		if enum != nil {
+			return StUnitFromEnum(enum), true
+		}
+	} else if tabletype == TableType_RPC {
+		rpc := Stapp.ShowSchema.FindRpc(rowName)
+		if rpc != nil {
+			var msg *model.Message
+			if subtabletype == SubTableType_RpcAck {
+				msg = rpc.Ack
+			} else {
+				msg = rpc.Req
 			}
-			if rpcType.Value == "Ack" && subtabletype == SubTableType_RpcAck {
-				result = rpcUnit
-				break
+			if msg != nil {
+				return StUnitFromMessage(tabletype, subtabletype, msg), true
 			}
 		}
+	} else {
+		msg := Stapp.ShowSchema.FindMessage(strUnitName, rowName)
+		if msg != nil {
+			return StUnitFromMessage(tabletype, subtabletype, msg), true
+		}
 	}
-
-	return result
+	logrus.Error("GetStUnit failed. Can not find target. tabletype:", tabletype, ", strUnitName:", strUnitName, ", rowName:", rowName)
+	return StUnit{}, false
 }
 
 func (Stapp *CoreManager) SyncListWithETree() bool {
@@ -793,8 +1339,23 @@ func (Stapp *CoreManager) SyncListWithETree() bool {
 		logrus.Error("SyncListWithETree failed. Stapp.ChangedShowEtree is nil, open the xml")
 		return false
 	}
+	// 确保各个分类都存在
+	for _, strRoot := range model.GetCategoryList() {
+		if Stapp.ChangedShowEtree.FindElement(strRoot) == nil {
+			Stapp.ChangedShowEtree.CreateElement(strRoot)
+		}
+	}
+	schema, err := model.SchemaFromDocument(Stapp.ChangedShowEtree)
+	if err != nil {
+		logrus.Error("SyncListWithETree failed. err:", err)
+		return false
+	}
+	Stapp.ShowSchema = schema
+
 	Stapp.SearchMap = map[string]string{}
-	Stapp.References = map[string][]string{}
+	Stapp.References = Stapp.ShowSchema.GetReferences()
+	Stapp.FieldReferences = Stapp.ShowSchema.GetFieldReferences()
+	Stapp.DepGraph = Stapp.ShowSchema.GetDepGraph()
 	Stapp.SyncListWithETreeCatagoryEnum()
 	Stapp.SyncListWithETreeCatagoryData()
 	Stapp.SyncListWithETreeCatagoryProtocol()
@@ -814,215 +1375,74 @@ func (Stapp *CoreManager) SyncListWithETree() bool {
 	return true
 }
 
-func (Stapp *CoreManager) SyncListWithETreeCatagoryEnum() {
-	// 先查找是否有 enum 的分类
-	enum_catagory := Stapp.ChangedShowEtree.FindElement("enum")
-	if enum_catagory == nil {
-		enum_catagory = Stapp.ChangedShowEtree.CreateElement("enum")
-	}
-	newEnumListString := []string{}
-
-	// 遍历子元素
-	for _, EnumClass := range enum_catagory.ChildElements() {
-		newEnumListString = append(newEnumListString, EnumClass.Tag)
-		// 枚举类名字映射
-		Stapp.SearchMap[EnumClass.Tag] = EnumClass.Tag
-		Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToLower(EnumClass.Tag)] = EnumClass.Tag
-		Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToUpper(EnumClass.Tag)] = EnumClass.Tag
-		// 将注释映射
-		for _, child := range EnumClass.Child {
-			// 检查该子元素是否为注释
-			if comment, ok := child.(*etree.Comment); ok {
-				if comment.Data != "" {
-					Stapp.SearchMap["["+EnumClass.Tag+"]"+comment.Data] = EnumClass.Tag
-					Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToLower(comment.Data)] = EnumClass.Tag
-					Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToUpper(comment.Data)] = EnumClass.Tag
-				}
-
-				break
-			}
-		}
-		for _, EnumClassConent := range EnumClass.ChildElements() {
-			entryName := EnumClassConent.SelectAttr("EntryName")
-			if entryName != nil && entryName.Value != "" {
-				Stapp.SearchMap["["+EnumClass.Tag+"]"+entryName.Value] = EnumClass.Tag
-				Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToLower(entryName.Value)] = EnumClass.Tag
-				Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToUpper(entryName.Value)] = EnumClass.Tag
-				// logrus.Debug("SyncListWithETree entryName.Value:", entryName.Value)
-			}
-			entryComment := EnumClassConent.SelectAttr("EntryComment")
-			if entryComment != nil && entryComment.Value != "" {
-				Stapp.SearchMap["["+EnumClass.Tag+"]"+entryComment.Value] = EnumClass.Tag
-				Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToLower(entryComment.Value)] = EnumClass.Tag
-				Stapp.SearchMap["["+EnumClass.Tag+"]"+strings.ToUpper(entryComment.Value)] = EnumClass.Tag
-				// logrus.Debug("SyncListWithETree entryComment.Value:", entryComment.Value)
-			}
+// 将单元的名字/注释/字段加入搜索映射
+func (Stapp *CoreManager) AddUnitToSearchMap(unitName string, comment string, rowList []model.Field) {
+	// 类名字映射
+	Stapp.SearchMap[unitName] = unitName
+	Stapp.SearchMap["["+unitName+"]"+strings.ToLower(unitName)] = unitName
+	Stapp.SearchMap["["+unitName+"]"+strings.ToUpper(unitName)] = unitName
+	// 将注释和字段映射
+	strList := []string{comment}
+	for _, row := range rowList {
+		strList = append(strList, row.EntryName, row.EntryComment)
+	}
+	for _, str := range strList {
+		if str == "" {
+			continue
 		}
-
+		Stapp.SearchMap["["+unitName+"]"+str] = unitName
+		Stapp.SearchMap["["+unitName+"]"+strings.ToLower(str)] = unitName
+		Stapp.SearchMap["["+unitName+"]"+strings.ToUpper(str)] = unitName
 	}
-	Stapp.EnumTableList.Set(newEnumListString)
 }
 
-func (Stapp *CoreManager) SyncListWithETreeCatagoryData() {
-	// 先查找是否有 protocol 的分类
-	data_catagory := Stapp.ChangedShowEtree.FindElement("data")
-	if data_catagory == nil {
-		data_catagory = Stapp.ChangedShowEtree.CreateElement("data")
+// 将消息的嵌套类型加入搜索映射, 映射到所在的顶层单元
+func (Stapp *CoreManager) AddNestedToSearchMap(unitName string, msg *model.Message) {
+	for _, enum := range msg.Enums {
+		Stapp.AddUnitToSearchMap(unitName, enum.Name, enum.Values)
+		Stapp.AddUnitToSearchMap(unitName, enum.Comment, nil)
 	}
-	newDataListString := []string{}
-
-	// 遍历子元素
-	for _, DataClass := range data_catagory.ChildElements() {
-		newDataListString = append(newDataListString, DataClass.Tag)
-		// 枚举类名字映射
-		Stapp.SearchMap[DataClass.Tag] = DataClass.Tag
-		Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToLower(DataClass.Tag)] = DataClass.Tag
-		Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToUpper(DataClass.Tag)] = DataClass.Tag
-		// 将注释映射
-		for _, child := range DataClass.Child {
-			// 检查该子元素是否为注释
-			if comment, ok := child.(*etree.Comment); ok {
-				if comment.Data != "" {
-					Stapp.SearchMap["["+DataClass.Tag+"]"+comment.Data] = DataClass.Tag
-					Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToLower(comment.Data)] = DataClass.Tag
-					Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToUpper(comment.Data)] = DataClass.Tag
-				}
-				break
-			}
-		}
-		for _, PtcClassConent := range DataClass.ChildElements() {
-			entryName := PtcClassConent.SelectAttr("EntryName")
-			if entryName != nil && entryName.Value != "" {
-				Stapp.SearchMap["["+DataClass.Tag+"]"+entryName.Value] = DataClass.Tag
-				Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToLower(entryName.Value)] = DataClass.Tag
-				Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToUpper(entryName.Value)] = DataClass.Tag
-				// logrus.Debug("SyncListWithETree entryName.Value:", entryName.Value)
-			}
-			entryComment := PtcClassConent.SelectAttr("EntryComment")
-			if entryComment != nil && entryComment.Value != "" {
-				Stapp.SearchMap["["+DataClass.Tag+"]"+entryComment.Value] = DataClass.Tag
-				Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToLower(entryComment.Value)] = DataClass.Tag
-				Stapp.SearchMap["["+DataClass.Tag+"]"+strings.ToUpper(entryComment.Value)] = DataClass.Tag
-				// logrus.Debug("SyncListWithETree entryComment.Value:", entryComment.Value)
-			}
-			// 记录依赖
-			entryType := PtcClassConent.SelectAttr("EntryType")
-			if entryType != nil && entryType.Value != "" && !Stapp.CheckProtoType(entryType.Value) {
-				Stapp.References[entryType.Value] = append(Stapp.References[entryType.Value], DataClass.Tag)
-				logrus.Debug("SyncListWithETree init References. Value:", entryType.Value, ", MsgClass.Tag:", DataClass.Tag, ",--->Stapp.References:", Stapp.References)
-			}
-		}
+	for _, nested := range msg.Messages {
+		Stapp.AddUnitToSearchMap(unitName, nested.Name, nested.Fields)
+		Stapp.AddUnitToSearchMap(unitName, nested.Comment, nil)
+		Stapp.AddNestedToSearchMap(unitName, nested)
 	}
-	Stapp.DataTableList.Set(newDataListString)
 }
-func (Stapp *CoreManager) SyncListWithETreeCatagoryProtocol() {
-	// 先查找是否有 protocol 的分类
-	ptc_catagory := Stapp.ChangedShowEtree.FindElement("protocol")
-	if ptc_catagory == nil {
-		ptc_catagory = Stapp.ChangedShowEtree.CreateElement("protocol")
+
+func (Stapp *CoreManager) SyncListWithETreeCatagoryEnum() {
+	for _, enum := range Stapp.ShowSchema.Enums {
+		Stapp.AddUnitToSearchMap(enum.Name, enum.Comment, enum.Values)
 	}
-	newPtcListString := []string{}
+	Stapp.EnumTableList.Set(Stapp.ShowSchema.GetUnitNames(model.CategoryEnum))
+}
 
-	// 遍历子元素
-	for _, PtcClass := range ptc_catagory.ChildElements() {
-		newPtcListString = append(newPtcListString, PtcClass.Tag)
-		// 枚举类名字映射
-		Stapp.SearchMap[PtcClass.Tag] = PtcClass.Tag
-		Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToLower(PtcClass.Tag)] = PtcClass.Tag
-		Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToUpper(PtcClass.Tag)] = PtcClass.Tag
-		// 将注释映射
-		for _, child := range PtcClass.Child {
-			// 检查该子元素是否为注释
-			if comment, ok := child.(*etree.Comment); ok {
-				if comment.Data != "" {
-					Stapp.SearchMap["["+PtcClass.Tag+"]"+comment.Data] = PtcClass.Tag
-					Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToLower(comment.Data)] = PtcClass.Tag
-					Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToUpper(comment.Data)] = PtcClass.Tag
-				}
-				break
-			}
-		}
-		for _, PtcClassConent := range PtcClass.ChildElements() {
-			entryName := PtcClassConent.SelectAttr("EntryName")
-			if entryName != nil && entryName.Value != "" {
-				Stapp.SearchMap["["+PtcClass.Tag+"]"+entryName.Value] = PtcClass.Tag
-				Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToLower(entryName.Value)] = PtcClass.Tag
-				Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToUpper(entryName.Value)] = PtcClass.Tag
-				// logrus.Debug("SyncListWithETree entryName.Value:", entryName.Value)
-			}
-			entryComment := PtcClassConent.SelectAttr("EntryComment")
-			if entryComment != nil && entryComment.Value != "" {
-				Stapp.SearchMap["["+PtcClass.Tag+"]"+entryComment.Value] = PtcClass.Tag
-				Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToLower(entryComment.Value)] = PtcClass.Tag
-				Stapp.SearchMap["["+PtcClass.Tag+"]"+strings.ToUpper(entryComment.Value)] = PtcClass.Tag
-				// logrus.Debug("SyncListWithETree entryComment.Value:", entryComment.Value)
-			}
-			// 记录依赖
-			entryType := PtcClassConent.SelectAttr("EntryType")
-			if entryType != nil && entryType.Value != "" && !Stapp.CheckProtoType(entryType.Value) {
-				Stapp.References[entryType.Value] = append(Stapp.References[entryType.Value], PtcClass.Tag)
-				// logrus.Debug("SyncListWithETree init References. Value:", entryType.Value, ", MsgClass.Tag:", MsgClass.Tag, ",--->Stapp.References:", Stapp.References)
-			}
-		}
+func (Stapp *CoreManager) SyncListWithETreeCatagoryData() {
+	for _, msg := range Stapp.ShowSchema.Datas {
+		Stapp.AddUnitToSearchMap(msg.Name, msg.Comment, msg.Fields)
+		Stapp.AddNestedToSearchMap(msg.Name, msg)
 	}
-	Stapp.PtcTableList.Set(newPtcListString)
+	Stapp.DataTableList.Set(Stapp.ShowSchema.GetUnitNames(model.CategoryData))
 }
-func (Stapp *CoreManager) SyncListWithETreeCatagoryRpc() {
-	// 先查找是否有 rpc 的分类
-	rpc_catagory := Stapp.ChangedShowEtree.FindElement("rpc")
-	if rpc_catagory == nil {
-		rpc_catagory = Stapp.ChangedShowEtree.CreateElement("rpc")
+
+func (Stapp *CoreManager) SyncListWithETreeCatagoryProtocol() {
+	for _, msg := range Stapp.ShowSchema.Protocols {
+		Stapp.AddUnitToSearchMap(msg.Name, msg.Comment, msg.Fields)
+		Stapp.AddNestedToSearchMap(msg.Name, msg)
 	}
-	newRpcListString := []string{}
+	Stapp.PtcTableList.Set(Stapp.ShowSchema.GetUnitNames(model.CategoryProtocol))
+}
 
-	// 遍历子元素
-	for _, RpcClass := range rpc_catagory.ChildElements() {
-		newRpcListString = append(newRpcListString, RpcClass.Tag)
-		for _, RpcClassReqAck := range RpcClass.ChildElements() {
-			// 枚举类名字映射
-			Stapp.SearchMap[RpcClass.Tag] = RpcClass.Tag
-			Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToLower(RpcClassReqAck.Tag)] = RpcClass.Tag
-			Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToUpper(RpcClassReqAck.Tag)] = RpcClass.Tag
-			// 将注释映射
-			for _, child := range RpcClass.Child {
-				// 检查该子元素是否为注释
-				if comment, ok := child.(*etree.Comment); ok {
-					if comment.Data != "" {
-						Stapp.SearchMap["["+RpcClass.Tag+"]"+comment.Data] = RpcClass.Tag
-						Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToLower(comment.Data)] = RpcClass.Tag
-						Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToUpper(comment.Data)] = RpcClass.Tag
-					}
-					break
-				}
-			}
-			for _, RpcClassConent := range RpcClassReqAck.ChildElements() {
-				entryName := RpcClassConent.SelectAttr("EntryName")
-				if entryName != nil && entryName.Value != "" {
-					Stapp.SearchMap["["+RpcClass.Tag+"]"+entryName.Value] = RpcClass.Tag
-					Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToLower(entryName.Value)] = RpcClass.Tag
-					Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToUpper(entryName.Value)] = RpcClass.Tag
-					// logrus.Debug("SyncListWithETree entryName.Value:", entryName.Value)
-				}
-				entryComment := RpcClassConent.SelectAttr("EntryComment")
-				if entryComment != nil && entryComment.Value != "" {
-					Stapp.SearchMap["["+RpcClass.Tag+"]"+entryComment.Value] = RpcClass.Tag
-					Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToLower(entryComment.Value)] = RpcClass.Tag
-					Stapp.SearchMap["["+RpcClass.Tag+"]"+strings.ToUpper(entryComment.Value)] = RpcClass.Tag
-					// logrus.Debug("SyncListWithETree entryComment.Value:", entryComment.Value)
-				}
-				// 记录依赖
-				entryType := RpcClassConent.SelectAttr("EntryType")
-				if entryType != nil && entryType.Value != "" && !Stapp.CheckProtoType(entryType.Value) {
-					Stapp.References[entryType.Value] = append(Stapp.References[entryType.Value], RpcClassReqAck.Tag)
-					// logrus.Debug("SyncListWithETree init References. Value:", entryType.Value, ", RpcClassReqAck.Tag:", RpcClassReqAck.Tag, ",--->Stapp.References:", Stapp.References)
-				}
-			}
+func (Stapp *CoreManager) SyncListWithETreeCatagoryRpc() {
+	for _, rpc := range Stapp.ShowSchema.Rpcs {
+		for _, msg := range rpc.GetMessages() {
+			Stapp.AddUnitToSearchMap(rpc.Name, rpc.Comment, msg.Fields)
+			Stapp.AddNestedToSearchMap(rpc.Name, msg)
 		}
-
 	}
 	// logrus.Debug("SyncListWithETree RpcTableList:", newRpcListString)
-	Stapp.RpcTableList.Set(newRpcListString)
+	Stapp.RpcTableList.Set(Stapp.ShowSchema.GetUnitNames(model.CategoryRpc))
 }
+
 func (Stapp *CoreManager) SyncMainListWithChangedEtree() {
 
 	if Stapp.ChangedEtree == nil {
@@ -1030,41 +1450,29 @@ func (Stapp *CoreManager) SyncMainListWithChangedEtree() {
 		return
 	}
 
-	newChangedListString := []string{}
+	newChangedListString := GetChangedUnitList(Stapp.ChangedEtree)
+	logrus.Debug("SyncMainListWithChangedEtree done. newChangedListString:", newChangedListString)
+	Stapp.MainTableList.Set(newChangedListString)
+}
 
+// 获取差异文档中的变化单元列表, 如 [update]Bag, 与 Main 页签的展示相同
+func GetChangedUnitList(diffDoc *etree.Document) []string {
+	result := []string{}
 	// 遍历子元素
-	for _, cataClass := range Stapp.ChangedEtree.ChildElements() {
+	for _, cataClass := range diffDoc.ChildElements() {
 		for _, diffClass := range cataClass.ChildElements() {
-			newChangedListString = append(newChangedListString, "["+diffClass.SelectAttr("opertype").Value+"]"+diffClass.Tag)
-			logrus.Debug("SyncMainListWithChangedEtree. newChangedListString:", newChangedListString)
+			result = append(result, "["+diffClass.SelectAttr("opertype").Value+"]"+diffClass.Tag)
 		}
 	}
-	logrus.Debug("SyncMainListWithChangedEtree done. newChangedListString:", newChangedListString)
-	Stapp.MainTableList.Set(newChangedListString)
+	return result
 }
 
 // 检查name 是否重复
 func (Stapp *CoreManager) CheckExistSameName(name string) bool {
-	// 先查找是否有 enum 的分类
-	enum_catagory := Stapp.ChangedShowEtree.FindElement("enum")
-	if enum_catagory == nil {
-		enum_catagory = Stapp.ChangedShowEtree.CreateElement("enum")
-	}
-
-	// 查找 enum 名字
-	enum_uint := enum_catagory.FindElement(name)
-	if enum_uint != nil {
-		return true
-	}
-
-	// 先查找是否有message的分类
-	msg_catagory := Stapp.ChangedShowEtree.FindElement("message")
-	if msg_catagory == nil {
-		msg_catagory = Stapp.ChangedShowEtree.CreateElement("message")
+	if Stapp.ShowSchema == nil {
+		return false
 	}
-	// 查找 message 名字
-	msg_uint := msg_catagory.FindElement(name)
-	return msg_uint != nil
+	return Stapp.ShowSchema.FindCategory(name) != ""
 }
 
 func (Stapp *CoreManager) GetAllSearchName() []string {
@@ -1078,55 +1486,21 @@ func (Stapp *CoreManager) GetListNameBySearchName(searchname string) string {
 	return Stapp.SearchMap[searchname]
 }
 
+// 获取可用作字段类型的名字, 嵌套类型为 Outer.Inner 形式的全名
 func (Stapp *CoreManager) GetAllUseableEntryType() []string {
 	result := []string{}
-	// 先查找是否有 enum 的分类
-	enum_catagory := Stapp.ChangedShowEtree.FindElement("enum")
-	if enum_catagory == nil {
-		enum_catagory = Stapp.ChangedShowEtree.CreateElement("enum")
-	}
-
-	// 遍历子元素
-	for _, child := range enum_catagory.ChildElements() {
-		result = append(result, child.Tag)
+	if Stapp.ShowSchema == nil {
+		return result
 	}
-
-	// 先查找是否有 data 的分类
-	msg_catagory := Stapp.ChangedShowEtree.FindElement("data")
-	if msg_catagory == nil {
-		msg_catagory = Stapp.ChangedShowEtree.CreateElement("data")
-	}
-
-	// 遍历子元素
-	for _, child := range msg_catagory.ChildElements() {
-		result = append(result, child.Tag)
-	}
-
-	// 先查找是否有 data 的分类
-	ptc_catagory := Stapp.ChangedShowEtree.FindElement("protocol")
-	if ptc_catagory == nil {
-		ptc_catagory = Stapp.ChangedShowEtree.CreateElement("protocol")
-	}
-
-	// 遍历子元素
-	for _, child := range ptc_catagory.ChildElements() {
-		result = append(result, child.Tag)
-	}
-
-	return result
+	return Stapp.ShowSchema.GetTypeNames()
 }
 
 func (coremgr *CoreManager) GetProtoType() []string {
-	return []string{"int32", "int64", "uint32", "uint64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64", "float", "double", "bool", "string", "bytes"}
+	return model.GetScalarTypes()
 }
 
 func (coremgr *CoreManager) CheckProtoType(str string) bool {
-	for _, v := range coremgr.GetProtoType() {
-		if v == str {
-			return true
-		}
-	}
-	return false
+	return model.IsScalarType(str)
 }
 
 func (coremgr *CoreManager) GetAllUseableEntryTypeWithProtoType() []string {
@@ -1140,89 +1514,155 @@ func (coremgr *CoreManager) GetReferences(str string) []string {
 	return coremgr.References[str]
 }
 
-func (Stapp *CoreManager) SearchTableListWithName(name string) ETableType {
-	if Stapp.ChangedShowEtree == nil {
-		return TableType_None
+// 获取引用该类型的字段, path 为类型全名
+func (coremgr *CoreManager) GetFieldReferences(path string) []model.FieldRef {
+	return coremgr.FieldReferences[path]
+}
+
+// 预览或执行重命名. valueName 不为空时重命名 path 枚举中的值, 否则重命名 path 类型.
+// 引用的字段类型和默认值同步修改, isApply 为 true 时写回 ShowSchema
+func (coremgr *CoreManager) Rename(path string, valueName string, newName string, isApply bool) (bool, StRenameResult, string) {
+	var isOk bool
+	var result StRenameResult
+	var strError string
+	if valueName == "" {
+		isOk, result, strError = RenameType(coremgr.ShowSchema, path, newName)
+	} else {
+		isOk, result, strError = RenameEnumValue(coremgr.ShowSchema, path, valueName, newName)
 	}
-	if name == "" {
-		return TableType_None
+	if !isOk {
+		logrus.Warn("Rename failed. path:", path, ", valueName:", valueName, ", newName:", newName, ", err:", strError)
+		return false, result, strError
 	}
-	// Stapp.SyncListWithETree()
-
-	enum_catagory := Stapp.ChangedShowEtree.FindElement("enum")
-	if enum_catagory == nil {
-		enum_catagory = Stapp.ChangedShowEtree.CreateElement("enum")
+	bIsProtocol := valueName == "" && coremgr.ShowSchema.FindCategory(path) == model.CategoryProtocol
+	if bIsProtocol {
+		if strNote := coremgr.GetMsgIdRenameNote(path, newName); strNote != "" {
+			result.Notes = append(result.Notes, strNote)
+		}
 	}
-	// 查找 enum 名字
-	enum_uint := enum_catagory.FindElement(name)
-	if enum_uint != nil {
-		return TableType_Enum
+	if isApply {
+		if bIsProtocol {
+			coremgr.MsgIdRenames = append(coremgr.MsgIdRenames, [2]string{path, newName})
+		}
+		before := coremgr.ShowSchema
+		coremgr.ShowSchema = result.Schema
+		coremgr.ApplyShowSchema()
+		if valueName == "" {
+			coremgr.RecordHistory("rename "+path+" to "+newName, before)
+		} else {
+			coremgr.RecordHistory("rename "+path+"."+valueName+" to "+newName, before)
+		}
+		logrus.Info("Rename done. path:", path, ", valueName:", valueName, ", newName:", newName, ", changes:", len(result.Changes))
 	}
+	return true, result, ""
+}
 
-	changed_enum_catagory := Stapp.ChangedEtree.FindElement("enum")
-	if changed_enum_catagory != nil {
-		// 查找 enum 名字
-		changed_enum_uint := changed_enum_catagory.FindElement(name)
-		if changed_enum_uint != nil {
-			return TableType_Enum
-		}
+// 获取直接或间接引用该顶层单元的所有单元
+func (coremgr *CoreManager) GetAllDependents(unitName string) []string {
+	if coremgr.DepGraph == nil {
+		return []string{}
 	}
+	return coremgr.DepGraph.GetAllDependents(unitName)
+}
 
-	data_catagory := Stapp.ChangedShowEtree.FindElement("data")
-	if data_catagory == nil {
-		data_catagory = Stapp.ChangedShowEtree.CreateElement("data")
+// 复制当前的 ShowSchema, 失败时记录错误并返回 nil
+func (coremgr *CoreManager) CopyShowSchema() *model.Schema {
+	if coremgr.ShowSchema == nil {
+		return nil
 	}
-	// 查找 data 名字
-	data_uint := data_catagory.FindElement(name)
-	if data_uint != nil {
-		return TableType_Data
+	schema, err := coremgr.ShowSchema.Clone()
+	if err != nil {
+		logrus.Error("CopyShowSchema failed. err:", err)
+		return nil
 	}
-	changed_data_catagory := Stapp.ChangedEtree.FindElement("data")
-	if changed_data_catagory != nil {
-		// 查找 enum 名字
-		changed_data_uint := changed_data_catagory.FindElement(name)
-		if changed_data_uint != nil {
-			return TableType_Data
-		}
+	return schema
+}
+
+// 记录一次修改到撤销历史, before 为修改前的数据模型, 修改后的为当前的 ShowSchema
+func (coremgr *CoreManager) RecordHistory(strName string, before *model.Schema) {
+	after := coremgr.CopyShowSchema()
+	if before == nil || after == nil {
+		logrus.Warn("RecordHistory failed. copy schema failed. name:", strName)
+		return
 	}
+	coremgr.History.Push(StCommand{Name: strName, Before: before, After: after})
+	logrus.Info("RecordHistory done. name:", strName, ", count:", len(coremgr.History.Commands))
+}
 
-	msg_catagory := Stapp.ChangedShowEtree.FindElement("protocol")
-	if msg_catagory == nil {
-		msg_catagory = Stapp.ChangedShowEtree.CreateElement("protocol")
+// 撤销或重做到执行了前 cursor 个修改后的状态, 之后的修改仍可以重做
+func (coremgr *CoreManager) GoToHistory(cursor int) bool {
+	schema := coremgr.History.GetSchemaAt(cursor)
+	if schema == nil {
+		logrus.Warn("GoToHistory failed. invalid cursor:", cursor, ", count:", len(coremgr.History.Commands))
+		return false
 	}
+	// 保存的数据模型在写回时会被修改, 使用副本
+	schema, err := schema.Clone()
+	if err != nil {
+		logrus.Error("GoToHistory failed. copy schema failed. cursor:", cursor, ", err:", err)
+		return false
+	}
+	coremgr.ShowSchema = schema
+	coremgr.History.Cursor = cursor
+	coremgr.ApplyShowSchema()
+	logrus.Info("GoToHistory done. cursor:", cursor)
+	return true
+}
 
-	// 查找 message 名字
-	msg_uint := msg_catagory.FindElement(name)
-	if msg_uint != nil {
-		return TableType_Protocol
+// 撤销最近一次修改, 返回是否成功和撤销的修改
+func (coremgr *CoreManager) Undo() (bool, string) {
+	if !coremgr.History.CanUndo() {
+		return false, "nothing to undo"
 	}
+	strName := coremgr.History.Commands[coremgr.History.Cursor-1].Name
+	if !coremgr.GoToHistory(coremgr.History.Cursor - 1) {
+		return false, "undo " + strName + " failed"
+	}
+	return true, strName
+}
 
-	changed_msg_catagory := Stapp.ChangedEtree.FindElement("protocol")
-	if changed_msg_catagory != nil {
-		// 查找 enum 名字
-		changed_enum_uint := changed_msg_catagory.FindElement(name)
-		if changed_enum_uint != nil {
-			return TableType_Protocol
-		}
+// 重做最近一次撤销的修改, 返回是否成功和重做的修改
+func (coremgr *CoreManager) Redo() (bool, string) {
+	if !coremgr.History.CanRedo() {
+		return false, "nothing to redo"
+	}
+	strName := coremgr.History.Commands[coremgr.History.Cursor].Name
+	if !coremgr.GoToHistory(coremgr.History.Cursor + 1) {
+		return false, "redo " + strName + " failed"
 	}
+	return true, strName
+}
 
-	rpc_catagory := Stapp.ChangedShowEtree.FindElement("rpc")
-	if rpc_catagory == nil {
-		rpc_catagory = Stapp.ChangedShowEtree.CreateElement("rpc")
+func (Stapp *CoreManager) SearchTableListWithName(name string) ETableType {
+	if Stapp.ShowSchema == nil {
+		return TableType_None
 	}
-	// 查找 message 名字
-	rpc_uint := rpc_catagory.FindElement(name)
-	if rpc_uint != nil {
-		return TableType_RPC
+	if name == "" {
+		return TableType_None
 	}
+	// Stapp.SyncListWithETree()
 
-	changed_rpc_catagory := Stapp.ChangedEtree.FindElement("rpc")
-	if changed_rpc_catagory != nil {
-		// 查找 enum 名字
-		changed_rpc_uint := changed_rpc_catagory.FindElement(name)
-		if changed_rpc_uint != nil {
-			return TableType_RPC
+	// 嵌套类型的全名, 枚举按枚举处理, 消息按 data 处理
+	if strings.Contains(name, ".") {
+		if Stapp.ShowSchema.FindEnumByPath(name) != nil {
+			return TableType_Enum
 		}
+		if Stapp.ShowSchema.FindMessageByPath(name) != nil {
+			return TableType_Data
+		}
+		return TableType_None
+	}
+
+	// 先在展示数据中查找
+	strRoot := Stapp.ShowSchema.FindCategory(name)
+	if strRoot != "" {
+		return GetTableTypeByRootName(strRoot)
+	}
+
+	// 再在变化数据(已删除的)中查找
+	changedSchema, err := model.SchemaFromDocument(Stapp.ChangedEtree)
+	if err == nil {
+		return GetTableTypeByRootName(changedSchema.FindCategory(name))
 	}
 
 	return TableType_None
@@ -1230,19 +1670,15 @@ func (Stapp *CoreManager) SearchTableListWithName(name string) ETableType {
 
 // 根据枚举类型获取枚举名字
 func (coremgr *CoreManager) GetVarListOfEnum(strEnumName string) []string {
-	enumElement := coremgr.GetEtreeElem(TableType_Enum, SubTableType_None, strEnumName)
-	if enumElement == nil {
-		logrus.Error("[CoreManager] failed for GetEtreeElem, strEnumName:", strEnumName)
+	if coremgr.ShowSchema == nil {
 		return []string{}
 	}
-	result := []string{}
-	for _, cfgEnum := range enumElement.ChildElements() {
-		enumVar := cfgEnum.SelectAttr("EntryName")
-		if enumVar != nil && enumVar.Value != "" {
-			result = append(result, enumVar.Value)
-		}
+	enum := coremgr.ShowSchema.FindEnumByPath(strEnumName)
+	if enum == nil {
+		logrus.Error("[CoreManager] failed for FindEnumByPath, strEnumName:", strEnumName)
+		return []string{}
 	}
-	return result
+	return enum.GetValueNames()
 }
 
 // 根据 FileEtree 和 ChangedShowEtree 算出差异和差异类型
@@ -1250,24 +1686,23 @@ func (coremgr *CoreManager) GetChangedEtree() {
 	if coremgr.FileEtree == nil || coremgr.ChangedEtree == nil || coremgr.ChangedShowEtree == nil {
 		logrus.Error("[CoreManager] GetChangedEtree failed. invalid etree.")
 	}
-	coremgr.ChangedEtree = etree.NewDocument()
-	coremgr.GetEtreeDiff("enum", "delete", coremgr.FileEtree, coremgr.ChangedShowEtree, coremgr.ChangedEtree)
-	coremgr.GetEtreeDiff("enum", "add", coremgr.ChangedShowEtree, coremgr.FileEtree, coremgr.ChangedEtree)
-
-	coremgr.GetEtreeDiff("data", "delete", coremgr.FileEtree, coremgr.ChangedShowEtree, coremgr.ChangedEtree)
-	coremgr.GetEtreeDiff("data", "add", coremgr.ChangedShowEtree, coremgr.FileEtree, coremgr.ChangedEtree)
-
-	coremgr.GetEtreeDiff("protocol", "delete", coremgr.FileEtree, coremgr.ChangedShowEtree, coremgr.ChangedEtree)
-	coremgr.GetEtreeDiff("protocol", "add", coremgr.ChangedShowEtree, coremgr.FileEtree, coremgr.ChangedEtree)
-
-	coremgr.GetEtreeDiff("rpc", "delete", coremgr.FileEtree, coremgr.ChangedShowEtree, coremgr.ChangedEtree)
-	coremgr.GetEtreeDiff("rpc", "add", coremgr.ChangedShowEtree, coremgr.FileEtree, coremgr.ChangedEtree)
+	coremgr.ChangedEtree = coremgr.GetDocumentDiff(coremgr.FileEtree, coremgr.ChangedShowEtree)
 
 	changedBuffer := new(bytes.Buffer)
 	coremgr.ChangedEtree.WriteTo(changedBuffer)
 	logrus.Info("[CoreManager] GetChangedEtree done. coremgr.ChangedEtree:", changedBuffer.String())
 }
 
+// 算出从 oldDoc 到 newDoc 的差异, 差异单元带有 opertype 属性(add/delete/update)
+func (coremgr *CoreManager) GetDocumentDiff(oldDoc *etree.Document, newDoc *etree.Document) *etree.Document {
+	diffDoc := etree.NewDocument()
+	for _, strRoot := range model.GetCategoryList() {
+		coremgr.GetEtreeDiff(strRoot, "delete", oldDoc, newDoc, diffDoc)
+		coremgr.GetEtreeDiff(strRoot, "add", newDoc, oldDoc, diffDoc)
+	}
+	return diffDoc
+}
+
 // 寻找两个 etree 之间的 差集 eTreeA - eTreeB
 func (coremgr *CoreManager) GetEtreeDiff(strTagName string, strOperType string, eTreeA *etree.Document, eTreeB *etree.Document, eTreeDiff *etree.Document) bool {
 	if eTreeA == nil || eTreeB == nil || eTreeDiff == nil {
@@ -1460,3 +1895,113 @@ func (coremgr *CoreManager) CheckConnection(client *ssh.Client) error {
 	_, err = session.CombinedOutput("ls")
 	return err
 }
+
+// 记录未保存的修改到自动保存日志, 由后台定时写入. 没有未保存的修改时删除日志
+func (coremgr *CoreManager) UpdateJournal() {
+	if coremgr.ChangedShowEtree == nil || coremgr.ChangedEtree == nil || len(coremgr.ChangedEtree.ChildElements()) == 0 {
+		coremgr.Journal.Update(nil)
+		return
+	}
+	doc := coremgr.ChangedShowEtree.Copy()
+	doc.Indent(4)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		logrus.Error("UpdateJournal failed. err:", err)
+		return
+	}
+	coremgr.Journal.Update(data)
+}
+
+// 获取打开的 xml 的自动保存日志中未保存的修改, 与 Main 页签的展示相同. 没有可恢复的修改时返回 false
+func (coremgr *CoreManager) GetJournalChanges() (bool, []string) {
+	if coremgr.FileEtree == nil || coremgr.ProtoXmlFilePath == "" {
+		return false, nil
+	}
+	strJournalPath := GetJournalPath(coremgr.ProtoXmlFilePath)
+	if !PathExists(strJournalPath) {
+		return false, nil
+	}
+	doc, err := ReadProtoXmlDocument(strJournalPath)
+	if err != nil {
+		logrus.Error("GetJournalChanges failed. err:", err, ", path:", strJournalPath)
+		return false, nil
+	}
+	changeList := GetChangedUnitList(coremgr.GetDocumentDiff(coremgr.FileEtree, doc))
+	return len(changeList) > 0, changeList
+}
+
+// 从自动保存日志恢复未保存的修改, 恢复后可以撤销
+func (coremgr *CoreManager) RestoreJournal() bool {
+	if coremgr.ShowSchema == nil || coremgr.ProtoXmlFilePath == "" {
+		logrus.Error("RestoreJournal failed. open the xml first")
+		return false
+	}
+	strJournalPath := GetJournalPath(coremgr.ProtoXmlFilePath)
+	doc, err := ReadProtoXmlDocument(strJournalPath)
+	if err != nil {
+		logrus.Error("RestoreJournal failed. err:", err, ", path:", strJournalPath)
+		return false
+	}
+	schema, err := model.SchemaFromDocument(doc)
+	if err != nil {
+		logrus.Error("RestoreJournal failed. err:", err, ", path:", strJournalPath)
+		return false
+	}
+	before := coremgr.ShowSchema
+	coremgr.ShowSchema = schema
+	coremgr.ApplyShowSchema()
+	coremgr.RecordHistory("restore autosave", before)
+	logrus.Info("RestoreJournal done. path:", strJournalPath)
+	return true
+}
+
+// 放弃自动保存日志中的修改, 删除日志
+func (coremgr *CoreManager) DiscardJournal() {
+	if coremgr.ProtoXmlFilePath == "" {
+		return
+	}
+	coremgr.Journal.Update(nil)
+	coremgr.Journal.Flush()
+	logrus.Info("DiscardJournal done. path:", GetJournalPath(coremgr.ProtoXmlFilePath))
+}
+
+// 获取打开的 xml 的所有备份, 最新的在前
+func (coremgr *CoreManager) GetBackupList() []StBackupFile {
+	if coremgr.ProtoXmlFilePath == "" {
+		return []StBackupFile{}
+	}
+	_, backupConfig := coremgr.GetBackupConfig()
+	return GetBackupList(backupConfig, coremgr.ProtoXmlFilePath)
+}
+
+// 获取恢复备份后相对已保存文件的变化单元列表, 与 Main 页签的展示相同
+func (coremgr *CoreManager) GetBackupChanges(backupPath string) (bool, []string) {
+	if coremgr.FileEtree == nil {
+		return false, nil
+	}
+	doc, err := ReadProtoXmlDocument(backupPath)
+	if err != nil {
+		logrus.Error("GetBackupChanges failed. err:", err, ", path:", backupPath)
+		return false, nil
+	}
+	return true, GetChangedUnitList(coremgr.GetDocumentDiff(coremgr.FileEtree, doc))
+}
+
+// 将备份的内容恢复为未保存的修改, 保存后才写入文件, 恢复后可以撤销
+func (coremgr *CoreManager) RestoreBackup(backupPath string) bool {
+	if coremgr.ShowSchema == nil {
+		logrus.Error("RestoreBackup failed. open the xml first")
+		return false
+	}
+	schema, err := model.LoadSchemaFromFile(backupPath)
+	if err != nil {
+		logrus.Error("RestoreBackup failed. err:", err, ", path:", backupPath)
+		return false
+	}
+	before := coremgr.ShowSchema
+	coremgr.ShowSchema = schema
+	coremgr.ApplyShowSchema()
+	coremgr.RecordHistory("restore backup "+filepath.Base(backupPath), before)
+	logrus.Info("RestoreBackup done. path:", backupPath)
+	return true
+}
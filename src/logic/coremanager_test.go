@@ -0,0 +1,85 @@
+package logic
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+func TestCreateNewXml(t *testing.T) {
+	strDir := t.TempDir()
+	coremgr := newTestCoreManager()
+	if !coremgr.ReadXmlFromReader(strings.NewReader(testSchemaXml), filepath.Join(strDir, "old.xml")) {
+		t.Fatal("ReadXmlFromReader failed")
+	}
+	coremgr.SetCurrXmlFilePath(filepath.Join(strDir, "new.xml"))
+	coremgr.CreateNewXml()
+
+	// 新的 xml 中不能带有之前打开的 xml 的内容, 包括编辑后写回的
+	if !coremgr.ApplyShowSchema() {
+		t.Fatal("ApplyShowSchema failed")
+	}
+	tests := []struct {
+		name string
+		got  int
+	}{
+		{"enums", len(coremgr.ShowSchema.Enums)},
+		{"datas", len(coremgr.ShowSchema.Datas)},
+		{"protocols", len(coremgr.ShowSchema.Protocols)},
+		{"rpcs", len(coremgr.ShowSchema.Rpcs)},
+		{"data list", coremgr.DataTableList.Length()},
+		{"changed elements", len(coremgr.ChangedShowEtree.FindElements("//data/*"))},
+	}
+	for _, test := range tests {
+		if test.got != 0 {
+			t.Errorf("%s: got %d, want 0", test.name, test.got)
+		}
+	}
+}
+
+func TestReserveRemovedOnSave(t *testing.T) {
+	strPath := filepath.Join(t.TempDir(), "protocolgo.xml")
+	coremgr := newTestCoreManager()
+	if !coremgr.ReadXmlFromReader(strings.NewReader(testSchemaXml), strPath) {
+		t.Fatal("ReadXmlFromReader failed")
+	}
+	role := coremgr.ShowSchema.FindMessageByPath("Role")
+	bag := role.Fields[1]
+
+	// 保存前删除后又加回, 不产生保留项
+	role.Fields = role.Fields[:1]
+	coremgr.ApplyShowSchema()
+	if reserved := coremgr.ShowSchema.FindMessageByPath("Role").Reserved; len(reserved) != 0 {
+		t.Fatalf("reserved before save: %v", reserved)
+	}
+	role = coremgr.ShowSchema.FindMessageByPath("Role")
+	role.Fields = append(role.Fields, bag)
+	coremgr.ApplyShowSchema()
+	if report := coremgr.GetCompatReport(); len(report) != 0 {
+		t.Fatalf("compat report after re-adding: %v", report)
+	}
+
+	// 保存时记录保留项
+	role = coremgr.ShowSchema.FindMessageByPath("Role")
+	role.Fields = role.Fields[:1]
+	coremgr.ApplyShowSchema()
+	if !coremgr.SaveProtoXmlToFile() {
+		t.Fatal("SaveProtoXmlToFile failed")
+	}
+	want := []model.Reserved{{EntryIndex: "2", EntryName: "bag"}}
+	tests := []struct {
+		name   string
+		schema *model.Schema
+	}{
+		{"file", coremgr.GetFileSchema()},
+		{"show", coremgr.ShowSchema},
+	}
+	for _, test := range tests {
+		if got := test.schema.FindMessageByPath("Role").Reserved; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+	}
+}
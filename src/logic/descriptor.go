@@ -0,0 +1,742 @@
+package logic
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 不调用 protoc, 直接根据协议生成 FileDescriptorProto. 字段号见 google/protobuf/descriptor.proto
+
+// FieldDescriptorProto 的类型
+var pbScalarTypes = map[string]int32{
+	"double": 1, "float": 2, "int64": 3, "uint64": 4, "int32": 5, "fixed64": 6, "fixed32": 7, "bool": 8,
+	"string": 9, "bytes": 12, "uint32": 13, "sfixed32": 15, "sfixed64": 16, "sint32": 17, "sint64": 18,
+}
+
+const (
+	pbTypeMessage = 11
+	pbTypeEnum    = 14
+)
+
+// FieldDescriptorProto 的 label
+const (
+	pbLabelOptional = 1
+	pbLabelRequired = 2
+	pbLabelRepeated = 3
+)
+
+// editions 的版本号对应的 Edition 枚举值
+var pbEditions = map[string]int64{"2023": 1000, "2024": 1001}
+
+// FeatureSet.field_presence 的 LEGACY_REQUIRED
+const pbFieldPresenceLegacyRequired = 3
+
+// reserved 中 max 对应的序号
+const (
+	pbMessageMaxIndex = 536870911
+	pbEnumMaxIndex    = 2147483647
+)
+
+// 文件选项的值类型
+const (
+	pbOptionString = iota
+	pbOptionBool
+	pbOptionEnum
+)
+
+type stPbOptionInfo struct {
+	Num  int
+	Kind int
+}
+
+// 内置生成支持的文件选项, 自定义选项需要 protoc 解析
+var pbFileOptionTable = map[string]stPbOptionInfo{
+	"java_package":                  {1, pbOptionString},
+	"java_outer_classname":          {8, pbOptionString},
+	"optimize_for":                  {9, pbOptionEnum},
+	"java_multiple_files":           {10, pbOptionBool},
+	"go_package":                    {11, pbOptionString},
+	"cc_generic_services":           {16, pbOptionBool},
+	"java_generic_services":         {17, pbOptionBool},
+	"py_generic_services":           {18, pbOptionBool},
+	"java_generate_equals_and_hash": {20, pbOptionBool},
+	"deprecated":                    {23, pbOptionBool},
+	"java_string_check_utf8":        {27, pbOptionBool},
+	"cc_enable_arenas":              {31, pbOptionBool},
+	"objc_class_prefix":             {36, pbOptionString},
+	"csharp_namespace":              {37, pbOptionString},
+	"swift_prefix":                  {39, pbOptionString},
+	"php_class_prefix":              {40, pbOptionString},
+	"php_namespace":                 {41, pbOptionString},
+	"php_metadata_namespace":        {44, pbOptionString},
+	"ruby_package":                  {45, pbOptionString},
+}
+
+var pbOptimizeModes = map[string]int64{"SPEED": 1, "CODE_SIZE": 2, "LITE_RUNTIME": 3}
+
+// 字段, 对应 FieldDescriptorProto
+type StFieldDescriptor struct {
+	Name           string
+	Number         int32
+	Label          int32
+	Type           int32
+	TypeName       string // 消息和枚举的全名, 如 .package.Type
+	DefaultValue   string
+	JsonName       string
+	OneofIndex     int32 // 所属 oneof 在消息中的序号, -1 表示不在 oneof 中
+	Proto3Optional bool
+	LegacyRequired bool // editions 中的 required
+	Comment        string
+}
+
+// 枚举值, 对应 EnumValueDescriptorProto
+type StEnumValueDescriptor struct {
+	Name    string
+	Number  int32
+	Comment string
+}
+
+// 枚举, 对应 EnumDescriptorProto. 保留范围包含结束值
+type StEnumDescriptor struct {
+	Name           string
+	Comment        string
+	Values         []StEnumValueDescriptor
+	ReservedRanges [][2]int32
+	ReservedNames  []string
+}
+
+// 消息, 对应 DescriptorProto. 保留范围不包含结束值
+type StMessageDescriptor struct {
+	Name           string
+	Comment        string
+	Fields         []StFieldDescriptor
+	Nested         []*StMessageDescriptor
+	Enums          []*StEnumDescriptor
+	Oneofs         []string
+	ReservedRanges [][2]int32
+	ReservedNames  []string
+	MapEntry       bool // map 字段生成的 XxxEntry
+}
+
+// rpc, 对应 MethodDescriptorProto
+type StMethodDescriptor struct {
+	Name       string
+	InputType  string
+	OutputType string
+	Comment    string
+}
+
+// 对应 ServiceDescriptorProto
+type StServiceDescriptor struct {
+	Name    string
+	Methods []StMethodDescriptor
+}
+
+// 一个输出文件, 对应 FileDescriptorProto
+type StFileDescriptor struct {
+	Name         string // 含 .proto 的文件名
+	Package      string
+	Dependencies []string
+	Messages     []*StMessageDescriptor
+	Enums        []*StEnumDescriptor
+	Services     []*StServiceDescriptor
+	Options      [][2]string // 文件选项, name/value, 值已去掉引号
+	Syntax       string
+	Edition      string // Syntax 为 editions 时的版本
+}
+
+// 生成过程中的类型信息和错误
+type stDescriptorBuilder struct {
+	genConfig  StGenConfig
+	typeMap    map[string]bool
+	enumMap    map[string]bool
+	fileMap    map[string]string // 类型全名 -> 文件名
+	packageMap map[string]string // 文件名 -> package
+	errors     []string
+}
+
+// 根据协议生成每个输出文件的 descriptor, 拆分方式, package 和文件选项与 GenProto 一致.
+// 返回的文件按依赖排序, 被引用的文件在前. 有错误时返回错误列表
+func BuildFileDescriptors(schema *model.Schema, genConfig StGenConfig) ([]*StFileDescriptor, []string) {
+	if nil == schema {
+		return nil, []string{"invalid schema"}
+	}
+	if !IsValidSyntax(genConfig.Syntax) {
+		return nil, []string{"invalid syntax: " + genConfig.Syntax}
+	}
+	fileList := SplitSchemaToFiles(schema, genConfig)
+	if genConfig.MsgIdEnum != nil {
+		msgIdSchema := model.NewSchema()
+		msgIdSchema.Enums = append(msgIdSchema.Enums, genConfig.MsgIdEnum)
+		fileList = append(fileList, StGenFile{FileName: MsgIdFileName, Schema: msgIdSchema})
+	}
+
+	builder := &stDescriptorBuilder{
+		genConfig:  genConfig,
+		typeMap:    map[string]bool{},
+		enumMap:    map[string]bool{},
+		fileMap:    GetGenFileMap(fileList),
+		packageMap: map[string]string{},
+	}
+	for _, genFile := range fileList {
+		builder.packageMap[genFile.FileName] = genConfig.GetFileOption(genFile.FileName).Package
+		genFile.Schema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+			builder.typeMap[path] = true
+			if enum != nil {
+				builder.enumMap[path] = true
+			}
+		})
+	}
+
+	result := []*StFileDescriptor{}
+	for _, genFile := range fileList {
+		result = append(result, builder.buildFile(genFile))
+	}
+	result = builder.sortFiles(result)
+	if len(builder.errors) > 0 {
+		return nil, builder.errors
+	}
+	return result, nil
+}
+
+func (builder *stDescriptorBuilder) addError(strError string) {
+	builder.errors = append(builder.errors, strError)
+}
+
+// 类型全名加上所在文件的 package, 如 .package.Outer.Inner
+func (builder *stDescriptorBuilder) getFullName(path string) string {
+	if strPackage := builder.packageMap[builder.fileMap[path]]; strPackage != "" {
+		return "." + strPackage + "." + path
+	}
+	return "." + path
+}
+
+// 解析字段类型, 返回类型和消息/枚举的全名
+func (builder *stDescriptorBuilder) resolveType(scope string, typeName string) (int32, string, bool) {
+	if nType, ok := pbScalarTypes[typeName]; ok {
+		return nType, "", true
+	}
+	path := model.ResolveTypeName(builder.typeMap, scope, typeName)
+	if path == "" {
+		builder.addError(scope + ": unknown type " + typeName)
+		return 0, "", false
+	}
+	if builder.enumMap[path] {
+		return pbTypeEnum, builder.getFullName(path), true
+	}
+	return pbTypeMessage, builder.getFullName(path), true
+}
+
+func (builder *stDescriptorBuilder) buildFile(genFile StGenFile) *StFileDescriptor {
+	fileOption := builder.genConfig.GetFileOption(genFile.FileName)
+	fileDesc := &StFileDescriptor{
+		Name:    genFile.FileName + ".proto",
+		Package: fileOption.Package,
+		Syntax:  builder.genConfig.Syntax,
+	}
+	if fileDesc.Syntax == SyntaxEditions {
+		fileDesc.Edition = builder.genConfig.Edition
+		if fileDesc.Edition == "" {
+			fileDesc.Edition = DefaultEdition
+		}
+		if _, ok := pbEditions[fileDesc.Edition]; !ok {
+			builder.addError(fileDesc.Name + ": unsupported edition " + fileDesc.Edition)
+		}
+	}
+	for _, strImport := range genFile.Imports {
+		fileDesc.Dependencies = append(fileDesc.Dependencies, strImport+".proto")
+	}
+	fileDesc.Options = builder.buildFileOptions(fileDesc.Name, fileOption)
+
+	fileSchema := genFile.Schema
+	for _, enum := range fileSchema.Enums {
+		fileDesc.Enums = append(fileDesc.Enums, builder.buildEnum(enum.Name, enum))
+	}
+	for _, category := range []string{model.CategoryData, model.CategoryProtocol} {
+		for _, msg := range fileSchema.GetMessageList(category) {
+			fileDesc.Messages = append(fileDesc.Messages, builder.buildMessage(msg.Name, msg, msg.Comment))
+		}
+	}
+	for _, rpc := range fileSchema.Rpcs {
+		// rpc 的注释在 proto 中位于 Req 之前
+		strComment := rpc.Comment
+		for _, rpcType := range []string{model.RpcTypeReq, model.RpcTypeAck} {
+			if msg := rpc.GetMessage(rpcType); msg != nil {
+				fileDesc.Messages = append(fileDesc.Messages, builder.buildMessage(rpc.Name+rpcType, msg, strComment))
+				strComment = ""
+			}
+		}
+	}
+	fileDesc.Services = builder.buildServices(fileSchema.Rpcs)
+	return fileDesc
+}
+
+// 文件选项按 proto 文件头的顺序输出, 检查是否是内置生成支持的选项
+func (builder *stDescriptorBuilder) buildFileOptions(fileName string, fileOption StFileOption) [][2]string {
+	optionList := [][2]string{}
+	if fileOption.GoPackage != "" {
+		optionList = append(optionList, [2]string{"go_package", fileOption.GoPackage})
+	}
+	if fileOption.JavaPackage != "" {
+		optionList = append(optionList, [2]string{"java_package", fileOption.JavaPackage})
+	}
+	if fileOption.CsharpNamespace != "" {
+		optionList = append(optionList, [2]string{"csharp_namespace", fileOption.CsharpNamespace})
+	}
+	for _, option := range fileOption.Options {
+		strValue := option[1]
+		if strings.HasPrefix(strValue, `"`) {
+			if strUnquote, err := strconv.Unquote(strValue); err == nil {
+				strValue = strUnquote
+			}
+		}
+		optionList = append(optionList, [2]string{option[0], strValue})
+	}
+	for _, option := range optionList {
+		optionInfo, ok := pbFileOptionTable[option[0]]
+		if !ok {
+			builder.addError(fileName + ": option " + option[0] + " is not supported without protoc")
+			continue
+		}
+		if optionInfo.Kind == pbOptionBool && option[1] != "true" && option[1] != "false" {
+			builder.addError(fileName + ": option " + option[0] + " needs true or false, got " + option[1])
+		}
+		if _, ok := pbOptimizeModes[option[1]]; optionInfo.Kind == pbOptionEnum && !ok {
+			builder.addError(fileName + ": invalid value of option " + option[0] + ": " + option[1])
+		}
+	}
+	return optionList
+}
+
+func (builder *stDescriptorBuilder) parseNumber(scope string, strIndex string) int32 {
+	nIndex, err := strconv.ParseInt(strings.TrimSpace(strIndex), 0, 32)
+	if err != nil {
+		builder.addError(scope + ": invalid number " + strIndex)
+		return 0
+	}
+	return int32(nIndex)
+}
+
+// 解析保留项, 返回序号范围(包含结束值)和名字. maxIndex 为 max 对应的序号
+func (builder *stDescriptorBuilder) buildReserved(scope string, reservedList []model.Reserved, maxIndex int32) ([][2]int32, []string) {
+	rangeList := [][2]int32{}
+	indexList, nameList := model.GetReservedIndexAndName(reservedList)
+	for _, strIndex := range indexList {
+		strStart, strEnd, isRange := strings.Cut(strIndex, " to ")
+		nStart := builder.parseNumber(scope, strStart)
+		nEnd := nStart
+		if isRange && strings.TrimSpace(strEnd) == "max" {
+			nEnd = maxIndex
+		} else if isRange {
+			nEnd = builder.parseNumber(scope, strEnd)
+		}
+		rangeList = append(rangeList, [2]int32{nStart, nEnd})
+	}
+	return rangeList, nameList
+}
+
+func (builder *stDescriptorBuilder) buildEnum(path string, enum *model.Enum) *StEnumDescriptor {
+	enumDesc := &StEnumDescriptor{Name: enum.Name, Comment: enum.Comment}
+	for _, value := range enum.Values {
+		enumDesc.Values = append(enumDesc.Values, StEnumValueDescriptor{
+			Name:    value.EntryName,
+			Number:  builder.parseNumber(path+"."+value.EntryName, value.EntryIndex),
+			Comment: value.EntryComment,
+		})
+	}
+	enumDesc.ReservedRanges, enumDesc.ReservedNames = builder.buildReserved(path, enum.Reserved, pbEnumMaxIndex)
+	return enumDesc
+}
+
+// 嵌套类型在字段之前, map 字段的 XxxEntry 追加在嵌套消息之后.
+// proto3 中 optional 字段放到名为 _字段名 的 oneof 中, 这些 oneof 在所有 oneof 之后
+func (builder *stDescriptorBuilder) buildMessage(path string, msg *model.Message, comment string) *StMessageDescriptor {
+	msgDesc := &StMessageDescriptor{Name: path[strings.LastIndex(path, ".")+1:], Comment: comment}
+	for _, enum := range msg.Enums {
+		msgDesc.Enums = append(msgDesc.Enums, builder.buildEnum(path+"."+enum.Name, enum))
+	}
+	for _, nested := range msg.Messages {
+		msgDesc.Nested = append(msgDesc.Nested, builder.buildMessage(path+"."+nested.Name, nested, nested.Comment))
+	}
+
+	oneofIndexMap := map[string]int32{}
+	for _, strOneof := range msg.GetOneofNames() {
+		oneofIndexMap[strOneof] = int32(len(msgDesc.Oneofs))
+		msgDesc.Oneofs = append(msgDesc.Oneofs, strOneof)
+	}
+	syntheticFields := []int{}
+	for _, field := range msg.Fields {
+		strScope := path + "." + field.EntryName
+		fieldDesc := StFieldDescriptor{
+			Name:       field.EntryName,
+			Number:     builder.parseNumber(strScope, field.EntryIndex),
+			Label:      pbLabelOptional,
+			JsonName:   getPbJsonName(field.EntryName),
+			OneofIndex: -1,
+			Comment:    field.EntryComment,
+		}
+		if field.IsMap() {
+			entryDesc := builder.buildMapEntry(path, field)
+			msgDesc.Nested = append(msgDesc.Nested, entryDesc)
+			fieldDesc.Label = pbLabelRepeated
+			fieldDesc.Type = pbTypeMessage
+			fieldDesc.TypeName = builder.getFullName(path) + "." + entryDesc.Name
+			msgDesc.Fields = append(msgDesc.Fields, fieldDesc)
+			continue
+		}
+		fieldDesc.Type, fieldDesc.TypeName, _ = builder.resolveType(path, field.EntryType)
+		switch {
+		case field.IsOneof():
+			fieldDesc.OneofIndex = oneofIndexMap[field.EntryOneof]
+		case field.EntryOption == model.OptionRepeated:
+			fieldDesc.Label = pbLabelRepeated
+		case field.EntryOption == model.OptionRequired && builder.genConfig.Syntax == SyntaxProto2:
+			fieldDesc.Label = pbLabelRequired
+		case field.EntryOption == model.OptionRequired && builder.genConfig.Syntax == SyntaxEditions:
+			fieldDesc.LegacyRequired = true
+		case field.EntryOption == model.OptionRequired:
+			builder.addError(strScope + ": required is not allowed in " + builder.genConfig.Syntax)
+		case field.EntryOption == model.OptionOptional && builder.genConfig.Syntax == SyntaxProto3:
+			fieldDesc.Proto3Optional = true
+			syntheticFields = append(syntheticFields, len(msgDesc.Fields))
+		}
+		if builder.genConfig.HasDefaultValue() && field.EntryDefault != "" && fieldDesc.Label != pbLabelRepeated {
+			fieldDesc.DefaultValue = field.EntryDefault
+		}
+		msgDesc.Fields = append(msgDesc.Fields, fieldDesc)
+	}
+	for _, fieldIndex := range syntheticFields {
+		msgDesc.Fields[fieldIndex].OneofIndex = int32(len(msgDesc.Oneofs))
+		msgDesc.Oneofs = append(msgDesc.Oneofs, "_"+msgDesc.Fields[fieldIndex].Name)
+	}
+
+	rangeList, nameList := builder.buildReserved(path, msg.Reserved, pbMessageMaxIndex)
+	for _, indexRange := range rangeList {
+		// 消息的保留范围不包含结束值
+		msgDesc.ReservedRanges = append(msgDesc.ReservedRanges, [2]int32{indexRange[0], indexRange[1] + 1})
+	}
+	msgDesc.ReservedNames = nameList
+	return msgDesc
+}
+
+// map 字段对应的嵌套消息, 名字为字段名的驼峰形式加 Entry
+func (builder *stDescriptorBuilder) buildMapEntry(path string, field model.Field) *StMessageDescriptor {
+	strScope := path + "." + field.EntryName
+	keyType, ok := pbScalarTypes[field.EntryKeyType]
+	if !ok || !model.IsMapKeyType(field.EntryKeyType) {
+		builder.addError(strScope + ": invalid map key type " + field.EntryKeyType)
+	}
+	valueType, valueTypeName, _ := builder.resolveType(path, field.EntryType)
+	return &StMessageDescriptor{
+		Name: getPbMapEntryName(field.EntryName),
+		Fields: []StFieldDescriptor{
+			{Name: "key", Number: 1, Label: pbLabelOptional, Type: keyType, JsonName: "key", OneofIndex: -1},
+			{Name: "value", Number: 2, Label: pbLabelOptional, Type: valueType, TypeName: valueTypeName, JsonName: "value", OneofIndex: -1},
+		},
+		MapEntry: true,
+	}
+}
+
+// 按 service 分组, 与 GenServiceStruct 一致
+func (builder *stDescriptorBuilder) buildServices(rpcList []*model.Rpc) []*StServiceDescriptor {
+	result := []*StServiceDescriptor{}
+	serviceMap := map[string]*StServiceDescriptor{}
+	for _, rpc := range rpcList {
+		if rpc.Req == nil || rpc.Ack == nil {
+			continue
+		}
+		strService := builder.genConfig.GetServiceName(rpc.Name)
+		serviceDesc := serviceMap[strService]
+		if serviceDesc == nil {
+			serviceDesc = &StServiceDescriptor{Name: strService}
+			serviceMap[strService] = serviceDesc
+			result = append(result, serviceDesc)
+		}
+		serviceDesc.Methods = append(serviceDesc.Methods, StMethodDescriptor{
+			Name:       rpc.Name,
+			InputType:  builder.getFullName(rpc.Name + model.RpcTypeReq),
+			OutputType: builder.getFullName(rpc.Name + model.RpcTypeAck),
+			Comment:    rpc.Comment,
+		})
+	}
+	return result
+}
+
+// 按依赖排序, 被引用的文件在前, 其他保持原顺序. 有循环引用时报错
+func (builder *stDescriptorBuilder) sortFiles(fileList []*StFileDescriptor) []*StFileDescriptor {
+	fileDescMap := map[string]*StFileDescriptor{}
+	for _, fileDesc := range fileList {
+		fileDescMap[fileDesc.Name] = fileDesc
+	}
+	result := []*StFileDescriptor{}
+	state := map[string]int{} // 1: 访问中, 2: 已输出
+	var visit func(fileDesc *StFileDescriptor)
+	visit = func(fileDesc *StFileDescriptor) {
+		switch state[fileDesc.Name] {
+		case 1:
+			builder.addError(fileDesc.Name + ": import cycle")
+			return
+		case 2:
+			return
+		}
+		state[fileDesc.Name] = 1
+		for _, strDependency := range fileDesc.Dependencies {
+			if dependency := fileDescMap[strDependency]; dependency != nil {
+				visit(dependency)
+			}
+		}
+		state[fileDesc.Name] = 2
+		result = append(result, fileDesc)
+	}
+	for _, fileDesc := range fileList {
+		visit(fileDesc)
+	}
+	return result
+}
+
+// 字段的 json 名, 与 protoc 一致: 去掉下划线并将其后的字母大写
+func getPbJsonName(name string) string {
+	var builder strings.Builder
+	isUpperNext := false
+	for _, c := range name {
+		if c == '_' {
+			isUpperNext = true
+			continue
+		}
+		if isUpperNext && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		isUpperNext = false
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// map 字段的 entry 名, 与 protoc 一致: 首字母大写, 去掉下划线并将其后的字母大写, 再加 Entry
+func getPbMapEntryName(name string) string {
+	strJsonName := getPbJsonName(name)
+	if strJsonName != "" && strJsonName[0] >= 'a' && strJsonName[0] <= 'z' {
+		strJsonName = string(strJsonName[0]-('a'-'A')) + strJsonName[1:]
+	}
+	return strJsonName + "Entry"
+}
+
+// 编码为 FileDescriptorProto, 注释放在 source_code_info 中
+func (fileDesc *StFileDescriptor) Marshal() []byte {
+	var encoder pbEncoder
+	sourceInfo := &stPbSourceInfo{}
+	encoder.String(1, fileDesc.Name)
+	if fileDesc.Package != "" {
+		encoder.String(2, fileDesc.Package)
+	}
+	for _, strDependency := range fileDesc.Dependencies {
+		encoder.String(3, strDependency)
+	}
+	for i, msgDesc := range fileDesc.Messages {
+		encoder.Message(4, msgDesc.marshal(sourceInfo, []int32{4, int32(i)}))
+	}
+	for i, enumDesc := range fileDesc.Enums {
+		encoder.Message(5, enumDesc.marshal(sourceInfo, []int32{5, int32(i)}))
+	}
+	for i, serviceDesc := range fileDesc.Services {
+		encoder.Message(6, serviceDesc.marshal(sourceInfo, []int32{6, int32(i)}))
+	}
+	if len(fileDesc.Options) > 0 {
+		encoder.Message(8, marshalPbFileOptions(fileDesc.Options))
+	}
+	if len(sourceInfo.locations) > 0 {
+		encoder.Message(9, sourceInfo.marshal())
+	}
+	// 与 protoc 一致, proto2 不设置 syntax
+	if fileDesc.Syntax != SyntaxProto2 {
+		encoder.String(12, fileDesc.Syntax)
+	}
+	if fileDesc.Syntax == SyntaxEditions {
+		encoder.Int(14, pbEditions[fileDesc.Edition])
+	}
+	return encoder.Bytes()
+}
+
+func marshalPbFileOptions(optionList [][2]string) *pbEncoder {
+	var encoder pbEncoder
+	for _, option := range optionList {
+		optionInfo, ok := pbFileOptionTable[option[0]]
+		if !ok {
+			continue
+		}
+		switch optionInfo.Kind {
+		case pbOptionString:
+			encoder.String(optionInfo.Num, option[1])
+		case pbOptionBool:
+			encoder.Bool(optionInfo.Num, option[1] == "true")
+		case pbOptionEnum:
+			encoder.Int(optionInfo.Num, pbOptimizeModes[option[1]])
+		}
+	}
+	return &encoder
+}
+
+func (msgDesc *StMessageDescriptor) marshal(sourceInfo *stPbSourceInfo, path []int32) *pbEncoder {
+	var encoder pbEncoder
+	sourceInfo.add(path, msgDesc.Comment, false)
+	encoder.String(1, msgDesc.Name)
+	for i, fieldDesc := range msgDesc.Fields {
+		encoder.Message(2, fieldDesc.marshal(sourceInfo, appendPbPath(path, 2, i)))
+	}
+	for i, nested := range msgDesc.Nested {
+		encoder.Message(3, nested.marshal(sourceInfo, appendPbPath(path, 3, i)))
+	}
+	for i, enumDesc := range msgDesc.Enums {
+		encoder.Message(4, enumDesc.marshal(sourceInfo, appendPbPath(path, 4, i)))
+	}
+	if msgDesc.MapEntry {
+		var options pbEncoder
+		options.Bool(7, true)
+		encoder.Message(7, &options)
+	}
+	for _, strOneof := range msgDesc.Oneofs {
+		var oneof pbEncoder
+		oneof.String(1, strOneof)
+		encoder.Message(8, &oneof)
+	}
+	for _, indexRange := range msgDesc.ReservedRanges {
+		encoder.Message(9, marshalPbRange(indexRange))
+	}
+	for _, strName := range msgDesc.ReservedNames {
+		encoder.String(10, strName)
+	}
+	return &encoder
+}
+
+func (fieldDesc *StFieldDescriptor) marshal(sourceInfo *stPbSourceInfo, path []int32) *pbEncoder {
+	var encoder pbEncoder
+	sourceInfo.add(path, fieldDesc.Comment, true)
+	encoder.String(1, fieldDesc.Name)
+	encoder.Int(3, int64(fieldDesc.Number))
+	encoder.Int(4, int64(fieldDesc.Label))
+	encoder.Int(5, int64(fieldDesc.Type))
+	if fieldDesc.TypeName != "" {
+		encoder.String(6, fieldDesc.TypeName)
+	}
+	if fieldDesc.DefaultValue != "" {
+		encoder.String(7, fieldDesc.DefaultValue)
+	}
+	if fieldDesc.LegacyRequired {
+		var features, options pbEncoder
+		features.Int(1, pbFieldPresenceLegacyRequired)
+		options.Message(21, &features)
+		encoder.Message(8, &options)
+	}
+	if fieldDesc.OneofIndex >= 0 {
+		encoder.Int(9, int64(fieldDesc.OneofIndex))
+	}
+	encoder.String(10, fieldDesc.JsonName)
+	if fieldDesc.Proto3Optional {
+		encoder.Bool(17, true)
+	}
+	return &encoder
+}
+
+func (enumDesc *StEnumDescriptor) marshal(sourceInfo *stPbSourceInfo, path []int32) *pbEncoder {
+	var encoder pbEncoder
+	sourceInfo.add(path, enumDesc.Comment, false)
+	encoder.String(1, enumDesc.Name)
+	for i, valueDesc := range enumDesc.Values {
+		sourceInfo.add(appendPbPath(path, 2, i), valueDesc.Comment, true)
+		var value pbEncoder
+		value.String(1, valueDesc.Name)
+		value.Int(2, int64(valueDesc.Number))
+		encoder.Message(2, &value)
+	}
+	for _, indexRange := range enumDesc.ReservedRanges {
+		encoder.Message(4, marshalPbRange(indexRange))
+	}
+	for _, strName := range enumDesc.ReservedNames {
+		encoder.String(5, strName)
+	}
+	return &encoder
+}
+
+func (serviceDesc *StServiceDescriptor) marshal(sourceInfo *stPbSourceInfo, path []int32) *pbEncoder {
+	var encoder pbEncoder
+	encoder.String(1, serviceDesc.Name)
+	for i, methodDesc := range serviceDesc.Methods {
+		sourceInfo.add(appendPbPath(path, 2, i), methodDesc.Comment, false)
+		var method pbEncoder
+		method.String(1, methodDesc.Name)
+		method.String(2, methodDesc.InputType)
+		method.String(3, methodDesc.OutputType)
+		encoder.Message(2, &method)
+	}
+	return &encoder
+}
+
+func marshalPbRange(indexRange [2]int32) *pbEncoder {
+	var encoder pbEncoder
+	encoder.Int(1, int64(indexRange[0]))
+	encoder.Int(2, int64(indexRange[1]))
+	return &encoder
+}
+
+func appendPbPath(path []int32, num int32, index int) []int32 {
+	result := make([]int32, 0, len(path)+2)
+	return append(append(result, path...), num, int32(index))
+}
+
+// source_code_info 中的注释, 结构和字段用前置注释, 字段和枚举值用行尾注释
+type stPbSourceInfo struct {
+	locations []*pbEncoder
+}
+
+func (sourceInfo *stPbSourceInfo) add(path []int32, comment string, isTrailing bool) {
+	if comment == "" {
+		return
+	}
+	var builder strings.Builder
+	for _, strLine := range strings.Split(comment, "\n") {
+		builder.WriteString(" " + strings.TrimRight(strLine, "\r") + "\n")
+	}
+	var location pbEncoder
+	location.PackedInt(1, path)
+	// 没有源文件, 位置填 0
+	location.PackedInt(2, []int32{0, 0, 0})
+	if isTrailing {
+		location.String(4, builder.String())
+	} else {
+		location.String(3, builder.String())
+	}
+	sourceInfo.locations = append(sourceInfo.locations, &location)
+}
+
+func (sourceInfo *stPbSourceInfo) marshal() *pbEncoder {
+	var encoder pbEncoder
+	for _, location := range sourceInfo.locations {
+		encoder.Message(1, location)
+	}
+	return &encoder
+}
+
+// 编码为 FileDescriptorSet
+func MarshalDescriptorSet(fileList []*StFileDescriptor) []byte {
+	var encoder pbEncoder
+	for _, fileDesc := range fileList {
+		encoder.Message(1, &pbEncoder{buf: fileDesc.Marshal()})
+	}
+	return encoder.Bytes()
+}
+
+// 将 FileDescriptorSet 写入文件, 与 protoc --descriptor_set_out --include_source_info 的输出格式相同
+func WriteDescriptorSet(fileList []*StFileDescriptor, filename string) bool {
+	err := os.WriteFile(filename, MarshalDescriptorSet(fileList), 0644)
+	if err != nil {
+		logrus.Error("[WriteDescriptorSet] write file failed. filename:", filename, ", err:", err)
+		return false
+	}
+	logrus.Info("[WriteDescriptorSet] done. filename:", filename, ", files:", len(fileList))
+	return true
+}
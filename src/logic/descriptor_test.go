@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// protoc --descriptor_set_out 对以下文件的输出, 不含 source_code_info:
+//
+//	syntax = "proto2";
+//	package pb;
+//	message Role { optional int32 id = 1; repeated Color colors = 2; reserved 5; reserved "old"; }
+//	enum Color { Color_None = 0; Color_Red = 1; }
+const testRoleDescriptorHex = "0a0a726f6c652e70726f746f" + // name: role.proto
+	"12027062" + // package: pb
+	"2244" + // message_type: Role
+	"0a04526f6c65" +
+	"120e" + "0a026964" + "1801" + "2001" + "2805" + "52026964" + // id: number 1, optional, int32, json_name
+	"1221" + "0a06636f6c6f7273" + "1802" + "2003" + "280e" + "32092e70622e436f6c6f72" + "5206636f6c6f7273" + // colors: .pb.Color
+	"4a0408051006" + // reserved_range: [5, 6)
+	"52036f6c64" + // reserved_name: old
+	"2a26" + // enum_type: Color
+	"0a05436f6c6f72" +
+	"120e0a0a436f6c6f725f4e6f6e651000" +
+	"120d0a09436f6c6f725f5265641001"
+
+func getTestRoleDescriptor(syntax string) *StFileDescriptor {
+	return &StFileDescriptor{
+		Name:    "role.proto",
+		Package: "pb",
+		Messages: []*StMessageDescriptor{{
+			Name: "Role",
+			Fields: []StFieldDescriptor{
+				{Name: "id", Number: 1, Label: pbLabelOptional, Type: pbScalarTypes["int32"], JsonName: "id", OneofIndex: -1},
+				{Name: "colors", Number: 2, Label: pbLabelRepeated, Type: pbTypeEnum, TypeName: ".pb.Color", JsonName: "colors", OneofIndex: -1},
+			},
+			ReservedRanges: [][2]int32{{5, 6}},
+			ReservedNames:  []string{"old"},
+		}},
+		Enums: []*StEnumDescriptor{{
+			Name:   "Color",
+			Values: []StEnumValueDescriptor{{Name: "Color_None", Number: 0}, {Name: "Color_Red", Number: 1}},
+		}},
+		Syntax: syntax,
+	}
+}
+
+func TestFileDescriptorMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"proto2", getTestRoleDescriptor(SyntaxProto2).Marshal(), testRoleDescriptorHex},
+		{"proto3", getTestRoleDescriptor(SyntaxProto3).Marshal(), testRoleDescriptorHex + "620670726f746f33"},
+		{"descriptor set", MarshalDescriptorSet([]*StFileDescriptor{getTestRoleDescriptor(SyntaxProto2)}), "0a7e" + testRoleDescriptorHex},
+	}
+	for _, test := range tests {
+		if got := hex.EncodeToString(test.got); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// 与 protoc 对生成的 proto 文件的输出逐字节比较, 没有 protoc 时跳过
+func TestBuildFileDescriptorsWithProtoc(t *testing.T) {
+	schema := loadTestSchema(t, testSchemaXml)
+	genConfig := NewGenConfig()
+	fileList, errorList := BuildFileDescriptors(schema, genConfig)
+	if len(errorList) > 0 {
+		t.Fatalf("BuildFileDescriptors failed. errors: %q", errorList)
+	}
+	strDir := t.TempDir()
+	if !GenProto(schema, strDir, genConfig) {
+		t.Fatal("GenProto failed")
+	}
+	nameList := []string{}
+	for _, fileDesc := range fileList {
+		if _, err := os.Stat(filepath.Join(strDir, fileDesc.Name)); err != nil {
+			t.Fatalf("%s is not generated by GenProto", fileDesc.Name)
+		}
+		nameList = append(nameList, fileDesc.Name)
+	}
+
+	strProtoc, err := exec.LookPath("protoc")
+	if err != nil {
+		t.Skip("protoc is not found in PATH")
+	}
+	strOut := filepath.Join(strDir, "descriptor.pb")
+	args := append([]string{"--descriptor_set_out=" + strOut, "-I", strDir}, nameList...)
+	if output, err := exec.Command(strProtoc, args...).CombinedOutput(); err != nil {
+		t.Fatalf("protoc failed. err: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	want, err := os.ReadFile(strOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := MarshalDescriptorSet(fileList); !bytes.Equal(got, want) {
+		t.Errorf("descriptor set is different from protoc.\ngot  %x\nwant %x", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+package logic
+
+import (
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 自动修复可修复的诊断, 直接修改 schema. 返回已修复的描述和无法修复的描述.
+// 前面的修复可能已解决后面的诊断, 此时跳过
+func FixDiagnostics(schema *model.Schema, diagList []StDiagnostic) ([]string, []string) {
+	fixed := []string{}
+	failed := []string{}
+	if schema == nil {
+		return fixed, failed
+	}
+	for _, diag := range diagList {
+		if !diag.IsFixable() {
+			continue
+		}
+		var isOk bool
+		var strReport string
+		if diag.RuleId == DiagRule_EnumZero {
+			isOk, strReport = fixEnumZero(schema, diag.UnitPath)
+		} else {
+			isOk, strReport = fixEnumValueScope(schema, diag.UnitPath, diag.Field)
+		}
+		if !isOk {
+			logrus.Warn("[FixDiagnostics] can not fix ", diag.String(), ": ", strReport)
+			failed = append(failed, diag.String()+": "+strReport)
+		} else if strReport != "" {
+			logrus.Info("[FixDiagnostics] ", strReport)
+			fixed = append(fixed, strReport)
+		}
+	}
+	return fixed, failed
+}
+
+// 获取枚举值所在作用域中已使用的名字及其描述.
+// 顶层枚举为 package 内的顶层类型名和其他枚举的值, 嵌套枚举为所在消息的嵌套类型名, 字段名, oneof 名和其他嵌套枚举的值.
+// exclude 为不计入其值的枚举名, 一般为正在检查的枚举
+func GetEnumScopeNames(schema *model.Schema, parentPath string, exclude string) map[string]string {
+	result := map[string]string{}
+	addEnumValues := func(enums []*model.Enum) {
+		for _, enum := range enums {
+			if enum.Name == exclude {
+				continue
+			}
+			for _, value := range enum.Values {
+				result[value.EntryName] = "enum value of " + enum.Name
+			}
+		}
+	}
+	if parentPath == "" {
+		for strPath := range schema.GetTypeMap() {
+			if !strings.Contains(strPath, ".") {
+				result[strPath] = "type " + strPath
+			}
+		}
+		addEnumValues(schema.Enums)
+		return result
+	}
+	parent := schema.FindMessageByPath(parentPath)
+	if parent == nil {
+		return result
+	}
+	for _, enum := range parent.Enums {
+		result[enum.Name] = "nested enum " + enum.Name
+	}
+	for _, nested := range parent.Messages {
+		result[nested.Name] = "nested message " + nested.Name
+	}
+	for _, field := range parent.Fields {
+		result[field.EntryName] = "field " + field.EntryName
+	}
+	for _, strOneof := range parent.GetOneofNames() {
+		result[strOneof] = "oneof " + strOneof
+	}
+	addEnumValues(parent.Enums)
+	return result
+}
+
+// 拆分枚举全名为所在消息的全名和枚举名
+func splitEnumPath(path string) (string, string) {
+	if index := strings.LastIndex(path, "."); index >= 0 {
+		return path[:index], path[index+1:]
+	}
+	return "", path
+}
+
+// 检查新的枚举值名是否可用
+func checkNewEnumValueName(schema *model.Schema, path string, enum *model.Enum, strName string) (bool, string) {
+	if enum.FindValue(strName) != nil {
+		return false, strName + " is already defined in " + enum.Name
+	}
+	if model.FindReservedName(enum.Reserved, strName) != nil {
+		return false, strName + " is reserved"
+	}
+	parentPath, _ := splitEnumPath(path)
+	if strUsed, ok := GetEnumScopeNames(schema, parentPath, enum.Name)[strName]; ok {
+		return false, strName + " is already used by " + strUsed
+	}
+	return true, ""
+}
+
+// 修复第一个枚举值不是 0: 已有值为 0 时移到最前, 否则在最前加入 <Enum>_None = 0
+func fixEnumZero(schema *model.Schema, path string) (bool, string) {
+	enum := schema.FindEnumByPath(path)
+	if enum == nil {
+		return false, "enum " + path + " is not found"
+	}
+	if isFirstEnumValueZero(enum) {
+		return true, ""
+	}
+	for i, value := range enum.Values {
+		if isZeroIndex(value.EntryIndex) {
+			copy(enum.Values[1:i+1], enum.Values[:i])
+			enum.Values[0] = value
+			return true, path + ": move " + value.EntryName + " = 0 to the first"
+		}
+	}
+	if reserved := model.FindReservedIndex(enum.Reserved, "0"); reserved != nil {
+		return false, "0 is reserved by " + reserved.EntryIndex
+	}
+	strName := enum.Name + "_None"
+	if isOk, strReport := checkNewEnumValueName(schema, path, enum, strName); !isOk {
+		return false, strReport
+	}
+	enum.Values = append([]model.Field{{EntryName: strName, EntryIndex: "0"}}, enum.Values...)
+	return true, path + ": add " + strName + " = 0"
+}
+
+// 修复枚举值名与作用域中的名字重复: 加上枚举名前缀, 并修改使用该值作为默认值的字段
+func fixEnumValueScope(schema *model.Schema, path string, strValue string) (bool, string) {
+	enum := schema.FindEnumByPath(path)
+	if enum == nil {
+		return false, "enum " + path + " is not found"
+	}
+	value := enum.FindValue(strValue)
+	if value == nil {
+		return false, "enum value " + strValue + " is not found"
+	}
+	parentPath, _ := splitEnumPath(path)
+	if _, ok := GetEnumScopeNames(schema, parentPath, enum.Name)[strValue]; !ok {
+		return true, ""
+	}
+	strName := enum.Name + "_" + strValue
+	if isOk, strReport := checkNewEnumValueName(schema, path, enum, strName); !isOk {
+		return false, strReport
+	}
+	value.EntryName = strName
+	changes := renameEnumDefault(schema, path, strValue, strName)
+	return true, path + ": rename " + strValue + " to " + strName + ", " + strconv.Itoa(len(changes)) + " default value(s) updated"
+}
+
+// 修改类型为该枚举的字段的默认值, 返回每处修改
+func renameEnumDefault(schema *model.Schema, enumPath string, oldName string, newName string) []StRenameChange {
+	result := []StRenameChange{}
+	typeMap := schema.GetTypeMap()
+	schema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		if msg == nil {
+			return
+		}
+		for i := range msg.Fields {
+			field := &msg.Fields[i]
+			if field.EntryDefault == oldName && model.ResolveTypeName(typeMap, path, field.EntryType) == enumPath {
+				field.EntryDefault = newName
+				result = append(result, StRenameChange{UnitName: unitName, TypePath: path, Field: field.EntryName, OldValue: oldName, NewValue: newName})
+			}
+		}
+	})
+	return result
+}
@@ -0,0 +1,143 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFixDiagnostics(t *testing.T) {
+	tests := []struct {
+		name       string
+		xml        string
+		wantFixed  []string
+		wantFailed []string
+		wantSchema string // 修复后的 xml, 为空时不检查
+	}{
+		{
+			name: "move zero to the first",
+			xml: `<enum><Color>
+    <Color EntryName="Color_Red" EntryIndex="1" EntryComment=""/>
+    <Color EntryName="Color_None" EntryIndex="0" EntryComment=""/>
+</Color></enum>`,
+			wantFixed: []string{"Color: move Color_None = 0 to the first"},
+			wantSchema: `<enum><Color>
+    <Color EntryName="Color_None" EntryIndex="0" EntryComment=""/>
+    <Color EntryName="Color_Red" EntryIndex="1" EntryComment=""/>
+</Color></enum>`,
+		},
+		{
+			name: "add none value",
+			xml: `<data><Bag>
+    <Kind NestedType="enum">
+        <Kind EntryName="Kind_Big" EntryIndex="1" EntryComment=""/>
+    </Kind>
+    <Bag EntryOption="optional" EntryType="Kind" EntryName="kind" EntryIndex="1" EntryDefault="" EntryComment=""/>
+</Bag></data>`,
+			wantFixed: []string{"Bag.Kind: add Kind_None = 0"},
+		},
+		{
+			name: "zero is reserved",
+			xml: `<enum><Color>
+    <Color EntryName="Color_Red" EntryIndex="1" EntryComment=""/>
+    <Color Reserved="true" EntryIndex="0"/>
+</Color></enum>`,
+			wantFailed: []string{"[error] Color.Color_Red: the first enum value must be 0 in proto3 (enum-zero): 0 is reserved by 0"},
+		},
+		{
+			// 改名后同时修改使用该值作为默认值的字段
+			name: "rename value used in scope",
+			xml: `<enum><Color>
+    <Color EntryName="None" EntryIndex="0" EntryComment=""/>
+</Color>
+<Kind>
+    <Kind EntryName="None" EntryIndex="0" EntryComment=""/>
+</Kind></enum>
+<data><Item>
+    <Item EntryOption="optional" EntryType="Kind" EntryName="kind" EntryIndex="1" EntryDefault="None" EntryComment=""/>
+</Item></data>`,
+			wantFixed: []string{"Kind: rename None to Kind_None, 1 default value(s) updated"},
+			wantSchema: `<enum><Color>
+    <Color EntryName="None" EntryIndex="0" EntryComment=""/>
+</Color>
+<Kind>
+    <Kind EntryName="Kind_None" EntryIndex="0" EntryComment=""/>
+</Kind></enum>
+<data><Item>
+    <Item EntryOption="optional" EntryType="Kind" EntryName="kind" EntryIndex="1" EntryDefault="Kind_None" EntryComment=""/>
+</Item></data>`,
+		},
+		{
+			name: "new name is used",
+			xml: `<enum><Color>
+    <Color EntryName="None" EntryIndex="0" EntryComment=""/>
+</Color>
+<Kind>
+    <Kind EntryName="None" EntryIndex="0" EntryComment=""/>
+    <Kind EntryName="Kind_None" EntryIndex="1" EntryComment=""/>
+</Kind></enum>`,
+			wantFailed: []string{"[error] Kind.None: enum value None is already used by enum value of Color, enum values are scoped to the package (enum-value-scope): Kind_None is already defined in Kind"},
+		},
+	}
+	for _, test := range tests {
+		schema := loadTestSchema(t, test.xml)
+		genConfig := NewGenConfig()
+		genConfig.Syntax = SyntaxProto3
+		fixed, failed := FixDiagnostics(schema, DiagnoseSchema(schema, genConfig))
+		if test.wantFixed == nil {
+			test.wantFixed = []string{}
+		}
+		if test.wantFailed == nil {
+			test.wantFailed = []string{}
+		}
+		if !reflect.DeepEqual(fixed, test.wantFixed) || !reflect.DeepEqual(failed, test.wantFailed) {
+			t.Errorf("%s:\ngot  %q %q\nwant %q %q", test.name, fixed, failed, test.wantFixed, test.wantFailed)
+			continue
+		}
+		if test.wantSchema != "" {
+			if got, want := getSchemaText(t, schema), getSchemaText(t, loadTestSchema(t, test.wantSchema)); got != want {
+				t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+			}
+		}
+		// 修复后不再有可修复的诊断
+		if len(test.wantFailed) == 0 {
+			for _, diag := range DiagnoseSchema(schema, genConfig) {
+				if diag.IsFixable() {
+					t.Errorf("%s: not fixed: %s", test.name, diag.String())
+				}
+			}
+		}
+	}
+}
+
+func TestGetEnumScopeNames(t *testing.T) {
+	schema := loadTestSchema(t, testSchemaXml)
+	tests := []struct {
+		parentPath string
+		exclude    string
+		want       map[string]string
+	}{
+		{
+			parentPath: "",
+			exclude:    "ItemType",
+			want: map[string]string{
+				"ItemType": "type ItemType", "Bag": "type Bag", "Role": "type Role",
+				"CS_Login": "type CS_Login", "CS_GetRoleReq": "type CS_GetRoleReq", "CS_GetRoleAck": "type CS_GetRoleAck",
+			},
+		},
+		{
+			parentPath: "Bag",
+			exclude:    "",
+			want: map[string]string{
+				"Kind": "nested enum Kind", "Slot": "nested message Slot",
+				"slot_map": "field slot_map", "slots": "field slots", "kind": "field kind", "role_id": "field role_id",
+				"guild_name": "field guild_name", "item_types": "field item_types", "owner": "oneof owner",
+				"Kind_None": "enum value of Kind",
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := GetEnumScopeNames(schema, test.parentPath, test.exclude); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.parentPath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package logic
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+)
+
+// 诊断的级别
+type EDiagSeverity int
+
+const (
+	DiagSeverity_Warn  EDiagSeverity = iota + 1 // 可以生成, 但可能不是预期的结果
+	DiagSeverity_Error                          // protoc 会报错或生成的协议无法使用
+)
+
+// 诊断规则 id
+const (
+	DiagRule_InvalidName     = "invalid-name"     // 名字不是合法的标识符
+	DiagRule_DuplicateName   = "duplicate-name"   // 同一作用域内名字重复
+	DiagRule_UnknownType     = "unknown-type"     // 字段类型找不到定义
+	DiagRule_MapKeyType      = "map-key-type"     // map 的 key 类型不合法
+	DiagRule_NumberRange     = "number-range"     // 序号不是整数或超出范围
+	DiagRule_NumberImpl      = "number-impl"      // 字段序号在 19000-19999, 为 protobuf 实现保留
+	DiagRule_DuplicateNumber = "duplicate-number" // 序号重复
+	DiagRule_Reserved        = "reserved"         // 使用了保留的序号或名字
+	DiagRule_FieldOption     = "field-option"     // 字段选项不被语法支持, 或 oneof 中有 repeated/map
+	DiagRule_DefaultValue    = "default-value"    // 默认值不合法或不被语法支持
+	DiagRule_RpcIncomplete   = "rpc-incomplete"   // rpc 缺少 Req 或 Ack, 不会输出到 service
+	DiagRule_EnumZero        = "enum-zero"        // proto3/editions 的枚举第一个值不是 0, 可自动修复
+	DiagRule_EnumValueScope  = "enum-value-scope" // 枚举值名与所在 package/消息中的其他名字重复, 可自动修复
+	DiagRule_RefCycle        = "ref-cycle"        // 单元之间循环引用, 跨文件时 import 也循环
+)
+
+// 字段序号的范围, 19000-19999 为 protobuf 实现保留
+const (
+	FieldNumberMin       = 1
+	FieldNumberMax       = 536870911
+	FieldNumberImplStart = 19000
+	FieldNumberImplEnd   = 19999
+)
+
+// 一条诊断
+type StDiagnostic struct {
+	Severity  EDiagSeverity
+	TableType ETableType // 打开单元时使用的页签类型, 嵌套类型为 Enum 或 Data
+	Unit      string     // 打开单元时使用的名字: 顶层单元名, rpc 名或嵌套类型的全名
+	UnitPath  string     // 类型全名, rpc 为 XxxReq/XxxAck
+	Field     string     // 字段或枚举值名, 单元本身的问题为空
+	RuleId    string
+	Message   string
+}
+
+func (diag *StDiagnostic) GetSeverityText() string {
+	if diag.Severity == DiagSeverity_Error {
+		return "error"
+	}
+	return "warn"
+}
+
+// 展示文本, 如 [error] Item.id: field number 0 is out of range [1, 536870911] (number-range)
+func (diag *StDiagnostic) String() string {
+	strName := diag.UnitPath
+	if diag.Field != "" {
+		strName += "." + diag.Field
+	}
+	return "[" + diag.GetSeverityText() + "] " + strName + ": " + diag.Message + " (" + diag.RuleId + ")"
+}
+
+// 是否可以由 FixDiagnostics 自动修复
+func (diag *StDiagnostic) IsFixable() bool {
+	return diag.RuleId == DiagRule_EnumZero || diag.RuleId == DiagRule_EnumValueScope
+}
+
+// 是否包含错误级别的诊断
+func HasErrorDiagnostic(diagList []StDiagnostic) bool {
+	for _, diag := range diagList {
+		if diag.Severity == DiagSeverity_Error {
+			return true
+		}
+	}
+	return false
+}
+
+// 统计错误和警告的数量
+func CountDiagnostics(diagList []StDiagnostic) (int, int) {
+	nError, nWarn := 0, 0
+	for _, diag := range diagList {
+		if diag.Severity == DiagSeverity_Error {
+			nError++
+		} else {
+			nWarn++
+		}
+	}
+	return nError, nWarn
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 检查是否是 proto 的标识符: 字母或下划线开头, 只包含字母, 数字和下划线
+func CheckIdentifier(name string) bool {
+	return identifierRegexp.MatchString(name)
+}
+
+// 诊断所在的单元
+type stDiagUnit struct {
+	TableType ETableType
+	Unit      string
+	UnitPath  string
+}
+
+type stDiagnoser struct {
+	schema    *model.Schema
+	genConfig StGenConfig
+	typeMap   map[string]bool
+	result    []StDiagnostic
+}
+
+func (diagnoser *stDiagnoser) add(severity EDiagSeverity, unit stDiagUnit, field string, ruleId string, message string) {
+	diagnoser.result = append(diagnoser.result, StDiagnostic{
+		Severity:  severity,
+		TableType: unit.TableType,
+		Unit:      unit.Unit,
+		UnitPath:  unit.UnitPath,
+		Field:     field,
+		RuleId:    ruleId,
+		Message:   message,
+	})
+}
+
+// 检查整个协议, 返回所有诊断, 按单元的顺序. genConfig 决定允许的字段选项和默认值
+func DiagnoseSchema(schema *model.Schema, genConfig StGenConfig) []StDiagnostic {
+	diagnoser := &stDiagnoser{schema: schema, genConfig: genConfig, result: []StDiagnostic{}}
+	if schema == nil {
+		return diagnoser.result
+	}
+	diagnoser.typeMap = schema.GetTypeMap()
+	diagnoser.checkTopNames()
+	// 顶层枚举都在 enum.proto, 枚举值与类型名共用 package 的名字空间
+	enumScope := map[string]string{}
+	for _, enum := range schema.Enums {
+		enumScope[enum.Name] = "enum " + enum.Name
+	}
+	for _, enum := range schema.Enums {
+		unit := stDiagUnit{TableType_Enum, enum.Name, enum.Name}
+		diagnoser.checkEnum(unit, enum)
+		diagnoser.checkEnumValueScope(unit, enum, enumScope, "package")
+	}
+	for _, msg := range schema.Datas {
+		diagnoser.checkMessage(stDiagUnit{TableType_Data, msg.Name, msg.Name}, msg)
+	}
+	for _, msg := range schema.Protocols {
+		diagnoser.checkMessage(stDiagUnit{TableType_Protocol, msg.Name, msg.Name}, msg)
+	}
+	for _, rpc := range schema.Rpcs {
+		unit := stDiagUnit{TableType_RPC, rpc.Name, rpc.Name}
+		if rpc.Req == nil || rpc.Ack == nil {
+			diagnoser.add(DiagSeverity_Warn, unit, "", DiagRule_RpcIncomplete, "rpc without Req or Ack is not in service")
+		}
+		for _, rpcType := range []string{model.RpcTypeReq, model.RpcTypeAck} {
+			if msg := rpc.GetMessage(rpcType); msg != nil {
+				diagnoser.checkMessage(stDiagUnit{TableType_RPC, rpc.Name, rpc.Name + rpcType}, msg)
+			}
+		}
+	}
+	diagnoser.checkCycles()
+	return diagnoser.result
+}
+
+// 检查顶层单元之间的循环引用. 同一文件内只是警告, 跨文件时 proto 的 import 循环, 无法生成
+func (diagnoser *stDiagnoser) checkCycles() {
+	graph := diagnoser.schema.GetDepGraph()
+	for _, cycle := range graph.FindCycles() {
+		fileSet := map[string]bool{}
+		fileNames := []string{}
+		for _, strName := range cycle {
+			strFileName := diagnoser.genConfig.GetUnitFileName(graph.Category[strName], strName) + ".proto"
+			if !fileSet[strFileName] {
+				fileSet[strFileName] = true
+				fileNames = append(fileNames, strFileName)
+			}
+		}
+		strStart := cycle[0]
+		unit := stDiagUnit{GetTableTypeByRootName(graph.Category[strStart]), strStart, strStart}
+		strCycle := strings.Join(cycle, " -> ")
+		if len(fileNames) > 1 {
+			diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_RefCycle, "import cycle between "+strings.Join(fileNames, " and ")+": "+strCycle)
+		} else {
+			diagnoser.add(DiagSeverity_Warn, unit, "", DiagRule_RefCycle, "reference cycle: "+strCycle)
+		}
+	}
+}
+
+// 顶层的类型名在同一个名字空间, rpc 的消息名为 XxxReq/XxxAck, rpc 名在 rpc 之间唯一
+func (diagnoser *stDiagnoser) checkTopNames() {
+	usedNames := map[string]string{}
+	checkName := func(unit stDiagUnit, strName string, strKind string) {
+		if !CheckIdentifier(strName) {
+			diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_InvalidName, "invalid "+strKind+" name \""+strName+"\"")
+			return
+		}
+		if strUsed, ok := usedNames[strName]; ok {
+			diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_DuplicateName, "name "+strName+" is already used by "+strUsed)
+			return
+		}
+		usedNames[strName] = strKind + " " + unit.Unit
+	}
+	for _, enum := range diagnoser.schema.Enums {
+		checkName(stDiagUnit{TableType_Enum, enum.Name, enum.Name}, enum.Name, model.CategoryEnum)
+	}
+	for _, msg := range diagnoser.schema.Datas {
+		checkName(stDiagUnit{TableType_Data, msg.Name, msg.Name}, msg.Name, model.CategoryData)
+	}
+	for _, msg := range diagnoser.schema.Protocols {
+		checkName(stDiagUnit{TableType_Protocol, msg.Name, msg.Name}, msg.Name, model.CategoryProtocol)
+	}
+	rpcNames := map[string]bool{}
+	for _, rpc := range diagnoser.schema.Rpcs {
+		unit := stDiagUnit{TableType_RPC, rpc.Name, rpc.Name}
+		if !CheckIdentifier(rpc.Name) {
+			diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_InvalidName, "invalid rpc name \""+rpc.Name+"\"")
+			continue
+		}
+		if rpcNames[rpc.Name] {
+			diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_DuplicateName, "rpc "+rpc.Name+" is already defined")
+			continue
+		}
+		rpcNames[rpc.Name] = true
+		for _, rpcType := range []string{model.RpcTypeReq, model.RpcTypeAck} {
+			if rpc.GetMessage(rpcType) != nil {
+				checkName(stDiagUnit{TableType_RPC, rpc.Name, rpc.Name + rpcType}, rpc.Name+rpcType, model.CategoryRpc)
+			}
+		}
+	}
+}
+
+// 嵌套类型的单元, 枚举在 Enum 页签打开, 消息在 Data 页签打开
+func getNestedDiagUnit(parentPath string, name string, isEnum bool) stDiagUnit {
+	tableType := TableType_Data
+	if isEnum {
+		tableType = TableType_Enum
+	}
+	return stDiagUnit{tableType, parentPath + "." + name, parentPath + "." + name}
+}
+
+// 检查序号, 返回解析结果
+func (diagnoser *stDiagnoser) parseNumber(unit stDiagUnit, field string, strIndex string) (int64, bool) {
+	nIndex, err := strconv.ParseInt(strings.TrimSpace(strIndex), 0, 32)
+	if err != nil {
+		diagnoser.add(DiagSeverity_Error, unit, field, DiagRule_NumberRange, "number \""+strIndex+"\" is not a 32-bit integer")
+		return 0, false
+	}
+	return nIndex, true
+}
+
+func (diagnoser *stDiagnoser) checkEnum(unit stDiagUnit, enum *model.Enum) {
+	if diagnoser.genConfig.RequiresEnumZero() && !isFirstEnumValueZero(enum) {
+		strFirst := ""
+		if len(enum.Values) > 0 {
+			strFirst = enum.Values[0].EntryName
+		}
+		diagnoser.add(DiagSeverity_Error, unit, strFirst, DiagRule_EnumZero, "the first enum value must be 0 in "+diagnoser.genConfig.Syntax)
+	}
+	valueNames := map[string]bool{}
+	valueNumbers := map[int64]string{}
+	for _, value := range enum.Values {
+		if !CheckIdentifier(value.EntryName) {
+			diagnoser.add(DiagSeverity_Error, unit, value.EntryName, DiagRule_InvalidName, "invalid enum value name \""+value.EntryName+"\"")
+		} else if valueNames[value.EntryName] {
+			diagnoser.add(DiagSeverity_Error, unit, value.EntryName, DiagRule_DuplicateName, "enum value "+value.EntryName+" is already defined")
+		}
+		valueNames[value.EntryName] = true
+		if model.FindReservedName(enum.Reserved, value.EntryName) != nil {
+			diagnoser.add(DiagSeverity_Error, unit, value.EntryName, DiagRule_Reserved, "name "+value.EntryName+" is reserved")
+		}
+
+		nIndex, ok := diagnoser.parseNumber(unit, value.EntryName, value.EntryIndex)
+		if !ok {
+			continue
+		}
+		if nIndex < 0 {
+			diagnoser.add(DiagSeverity_Warn, unit, value.EntryName, DiagRule_NumberRange, "negative enum value "+value.EntryIndex+" is encoded as 10 bytes")
+		}
+		if strName, ok := valueNumbers[nIndex]; ok {
+			diagnoser.add(DiagSeverity_Error, unit, value.EntryName, DiagRule_DuplicateNumber, "value "+value.EntryIndex+" is already used by "+strName)
+		} else {
+			valueNumbers[nIndex] = value.EntryName
+		}
+		if reserved := model.FindReservedIndex(enum.Reserved, value.EntryIndex); reserved != nil {
+			diagnoser.add(DiagSeverity_Error, unit, value.EntryName, DiagRule_Reserved, "value "+value.EntryIndex+" is reserved by "+reserved.EntryIndex)
+		}
+	}
+}
+
+// 第一个枚举值是否为 0
+func isFirstEnumValueZero(enum *model.Enum) bool {
+	return len(enum.Values) > 0 && isZeroIndex(enum.Values[0].EntryIndex)
+}
+
+func isZeroIndex(strIndex string) bool {
+	nIndex, err := strconv.ParseInt(strings.TrimSpace(strIndex), 0, 32)
+	return err == nil && nIndex == 0
+}
+
+// 枚举值的作用域是枚举所在的 package 或消息, 不同枚举的值不能重名, 也不能与该作用域的其他名字重复.
+// usedNames 为作用域中已使用的名字, 检查后加入本枚举的值
+func (diagnoser *stDiagnoser) checkEnumValueScope(unit stDiagUnit, enum *model.Enum, usedNames map[string]string, strScope string) {
+	strOwner := "enum value of " + enum.Name
+	for _, value := range enum.Values {
+		if !CheckIdentifier(value.EntryName) {
+			continue
+		}
+		strUsed, ok := usedNames[value.EntryName]
+		if !ok {
+			usedNames[value.EntryName] = strOwner
+			continue
+		}
+		// 同一枚举内的重名由 checkEnum 报告
+		if strUsed != strOwner {
+			diagnoser.add(DiagSeverity_Error, unit, value.EntryName, DiagRule_EnumValueScope,
+				"enum value "+value.EntryName+" is already used by "+strUsed+", enum values are scoped to the "+strScope)
+		}
+	}
+}
+
+func (diagnoser *stDiagnoser) checkMessage(unit stDiagUnit, msg *model.Message) {
+	// 嵌套类型, 字段和 oneof 共用消息内的名字空间
+	usedNames := map[string]string{}
+	for _, enum := range msg.Enums {
+		nestedUnit := getNestedDiagUnit(unit.UnitPath, enum.Name, true)
+		diagnoser.checkNestedName(unit, usedNames, enum.Name, "enum")
+		diagnoser.checkEnum(nestedUnit, enum)
+	}
+	for _, nested := range msg.Messages {
+		nestedUnit := getNestedDiagUnit(unit.UnitPath, nested.Name, false)
+		diagnoser.checkNestedName(unit, usedNames, nested.Name, "message")
+		diagnoser.checkMessage(nestedUnit, nested)
+	}
+	for _, enum := range msg.Enums {
+		diagnoser.checkEnumValueScope(getNestedDiagUnit(unit.UnitPath, enum.Name, true), enum, usedNames, "message "+msg.Name)
+	}
+
+	fieldNumbers := map[int64]string{}
+	for _, field := range msg.Fields {
+		if !CheckIdentifier(field.EntryName) {
+			diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_InvalidName, "invalid field name \""+field.EntryName+"\"")
+		} else if strUsed, ok := usedNames[field.EntryName]; ok {
+			diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DuplicateName, "name "+field.EntryName+" is already used by "+strUsed)
+		} else {
+			usedNames[field.EntryName] = "field"
+		}
+		if model.FindReservedName(msg.Reserved, field.EntryName) != nil {
+			diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_Reserved, "name "+field.EntryName+" is reserved")
+		}
+		diagnoser.checkFieldNumber(unit, msg, field, fieldNumbers)
+		diagnoser.checkFieldType(unit, field)
+		diagnoser.checkFieldOption(unit, field)
+	}
+	for _, strOneof := range msg.GetOneofNames() {
+		if !CheckIdentifier(strOneof) {
+			diagnoser.add(DiagSeverity_Error, unit, strOneof, DiagRule_InvalidName, "invalid oneof name \""+strOneof+"\"")
+		} else if strUsed, ok := usedNames[strOneof]; ok {
+			diagnoser.add(DiagSeverity_Error, unit, strOneof, DiagRule_DuplicateName, "oneof name "+strOneof+" is already used by "+strUsed)
+		}
+	}
+}
+
+func (diagnoser *stDiagnoser) checkNestedName(unit stDiagUnit, usedNames map[string]string, strName string, strKind string) {
+	if !CheckIdentifier(strName) {
+		diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_InvalidName, "invalid nested "+strKind+" name \""+strName+"\"")
+		return
+	}
+	if strUsed, ok := usedNames[strName]; ok {
+		diagnoser.add(DiagSeverity_Error, unit, "", DiagRule_DuplicateName, "nested name "+strName+" is already used by "+strUsed)
+		return
+	}
+	usedNames[strName] = "nested " + strKind
+}
+
+func (diagnoser *stDiagnoser) checkFieldNumber(unit stDiagUnit, msg *model.Message, field model.Field, fieldNumbers map[int64]string) {
+	nIndex, ok := diagnoser.parseNumber(unit, field.EntryName, field.EntryIndex)
+	if !ok {
+		return
+	}
+	if nIndex < FieldNumberMin || nIndex > FieldNumberMax {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_NumberRange,
+			"field number "+field.EntryIndex+" is out of range ["+strconv.Itoa(FieldNumberMin)+", "+strconv.Itoa(FieldNumberMax)+"]")
+		return
+	}
+	if nIndex >= FieldNumberImplStart && nIndex <= FieldNumberImplEnd {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_NumberImpl,
+			"field number "+field.EntryIndex+" is reserved for the protobuf implementation ("+strconv.Itoa(FieldNumberImplStart)+"-"+strconv.Itoa(FieldNumberImplEnd)+")")
+	}
+	if strName, ok := fieldNumbers[nIndex]; ok {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DuplicateNumber, "field number "+field.EntryIndex+" is already used by "+strName)
+	} else {
+		fieldNumbers[nIndex] = field.EntryName
+	}
+	if reserved := model.FindReservedIndex(msg.Reserved, field.EntryIndex); reserved != nil {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_Reserved, "field number "+field.EntryIndex+" is reserved by "+reserved.EntryIndex)
+	}
+}
+
+// 检查字段类型和默认值, 类型按作用域规则解析
+func (diagnoser *stDiagnoser) checkFieldType(unit stDiagUnit, field model.Field) {
+	if field.IsMap() && !model.IsMapKeyType(field.EntryKeyType) {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_MapKeyType, "invalid map key type \""+field.EntryKeyType+"\"")
+	}
+	var enum *model.Enum
+	if !model.IsScalarType(field.EntryType) {
+		strTypePath := model.ResolveTypeName(diagnoser.typeMap, unit.UnitPath, field.EntryType)
+		if strTypePath == "" {
+			diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_UnknownType, "type \""+field.EntryType+"\" is not defined")
+			return
+		}
+		enum = diagnoser.schema.FindEnumByPath(strTypePath)
+	}
+
+	// proto3 不输出默认值, 枚举字段的默认值只用于展示
+	if field.EntryDefault == "" || (!diagnoser.genConfig.HasDefaultValue() && enum != nil) {
+		return
+	}
+	if !diagnoser.genConfig.HasDefaultValue() {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DefaultValue, "default value is not supported by "+diagnoser.genConfig.Syntax)
+		return
+	}
+	if field.EntryOption == model.OptionRepeated || field.IsMap() {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DefaultValue, "repeated or map field can not have default value")
+		return
+	}
+	if enum != nil {
+		if enum.FindValue(field.EntryDefault) == nil {
+			diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DefaultValue, "default value "+field.EntryDefault+" is not a value of enum "+enum.Name)
+		}
+		return
+	}
+	if !model.IsScalarType(field.EntryType) {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DefaultValue, "message field can not have default value")
+	} else if !CheckDefaultValue(field.EntryType, field.EntryDefault) {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_DefaultValue, "default value "+field.EntryDefault+" does not match type "+field.EntryType)
+	}
+}
+
+func (diagnoser *stDiagnoser) checkFieldOption(unit stDiagUnit, field model.Field) {
+	if field.EntryOption != "" && !diagnoser.genConfig.IsValidFieldOption(field.EntryOption) {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_FieldOption, field.EntryOption+" is not supported by "+diagnoser.genConfig.Syntax)
+	}
+	if field.IsOneof() && (field.EntryOption == model.OptionRepeated || field.EntryOption == model.OptionRequired || field.IsMap()) {
+		diagnoser.add(DiagSeverity_Error, unit, field.EntryName, DiagRule_FieldOption, "field in oneof can not be repeated, required or map")
+	}
+}
@@ -0,0 +1,156 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func getDiagnosticTexts(diagList []StDiagnostic) []string {
+	result := []string{}
+	for _, diag := range diagList {
+		result = append(result, diag.String())
+	}
+	return result
+}
+
+func TestDiagnoseSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		xml    string
+		syntax string
+		want   []string
+	}{
+		{
+			name: "test schema",
+			xml:  testSchemaXml,
+			want: []string{},
+		},
+		{
+			name: "field numbers",
+			xml: `<data><Item>
+    <Item EntryOption="optional" EntryType="int32" EntryName="a" EntryIndex="0"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="b" EntryIndex="19001"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="c" EntryIndex="2"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="d" EntryIndex="2"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="e" EntryIndex="x"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="f" EntryIndex="5"/>
+    <Item Reserved="true" EntryIndex="4 to 6"/>
+</Item></data>`,
+			want: []string{
+				"[error] Item.a: field number 0 is out of range [1, 536870911] (number-range)",
+				"[error] Item.b: field number 19001 is reserved for the protobuf implementation (19000-19999) (number-impl)",
+				"[error] Item.d: field number 2 is already used by c (duplicate-number)",
+				`[error] Item.e: number "x" is not a 32-bit integer (number-range)`,
+				"[error] Item.f: field number 5 is reserved by 4 to 6 (reserved)",
+			},
+		},
+		{
+			name: "names and types",
+			xml: `<enum><Color>
+    <Color EntryName="Color_None" EntryIndex="0"/>
+</Color></enum>
+<data><Item>
+    <Item EntryOption="optional" EntryType="Unknown" EntryName="a" EntryIndex="1"/>
+    <Item EntryOption="map" EntryKeyType="double" EntryType="int32" EntryName="b" EntryIndex="2"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="1c" EntryIndex="3"/>
+</Item>
+<Color>
+    <Color EntryOption="optional" EntryType="int32" EntryName="id" EntryIndex="1"/>
+</Color></data>`,
+			want: []string{
+				"[error] Color: name Color is already used by enum Color (duplicate-name)",
+				`[error] Item.a: type "Unknown" is not defined (unknown-type)`,
+				`[error] Item.b: invalid map key type "double" (map-key-type)`,
+				`[error] Item.1c: invalid field name "1c" (invalid-name)`,
+			},
+		},
+		{
+			name:   "proto3 enum and defaults",
+			syntax: SyntaxProto3,
+			xml: `<enum><Color>
+    <Color EntryName="Color_Red" EntryIndex="1"/>
+</Color>
+<Kind>
+    <Kind EntryName="Color_Red" EntryIndex="0"/>
+</Kind></enum>
+<data><Item>
+    <Item EntryOption="optional" EntryType="int32" EntryName="a" EntryIndex="1" EntryDefault="1"/>
+    <Item EntryOption="required" EntryType="int32" EntryName="b" EntryIndex="2"/>
+</Item></data>`,
+			want: []string{
+				"[error] Color.Color_Red: the first enum value must be 0 in proto3 (enum-zero)",
+				"[error] Kind.Color_Red: enum value Color_Red is already used by enum value of Color, enum values are scoped to the package (enum-value-scope)",
+				"[error] Item.a: default value is not supported by proto3 (default-value)",
+				"[error] Item.b: required is not supported by proto3 (field-option)",
+			},
+		},
+		{
+			name:   "proto2 defaults and oneof",
+			syntax: SyntaxProto2,
+			xml: `<data><Item>
+    <Item EntryOption="optional" EntryType="int32" EntryName="a" EntryIndex="1" EntryDefault="x"/>
+    <Item EntryOption="repeated" EntryType="int32" EntryName="b" EntryIndex="2" EntryDefault="1"/>
+    <Item EntryOption="repeated" EntryType="int32" EntryName="c" EntryIndex="3" EntryOneof="kind"/>
+    <Item EntryOption="optional" EntryType="int32" EntryName="d" EntryIndex="4" EntryOneof="a"/>
+</Item></data>`,
+			want: []string{
+				"[error] Item.a: default value x does not match type int32 (default-value)",
+				"[error] Item.b: repeated or map field can not have default value (default-value)",
+				"[error] Item.c: field in oneof can not be repeated, required or map (field-option)",
+				"[error] Item.a: oneof name a is already used by field (duplicate-name)",
+			},
+		},
+		{
+			name: "rpc without ack",
+			xml: `<rpc><CS_Get>
+    <CS_Get RpcType="Req">
+        <CS_Get EntryOption="optional" EntryType="int32" EntryName="id" EntryIndex="19000"/>
+    </CS_Get>
+</CS_Get></rpc>`,
+			want: []string{
+				"[warn] CS_Get: rpc without Req or Ack is not in service (rpc-incomplete)",
+				"[error] CS_GetReq.id: field number 19000 is reserved for the protobuf implementation (19000-19999) (number-impl)",
+			},
+		},
+		{
+			// 同一文件内的循环引用只是警告, 跨文件时 import 循环
+			name: "reference cycles",
+			xml: `<data><A>
+    <A EntryOption="optional" EntryType="B" EntryName="b" EntryIndex="1"/>
+</A>
+<B>
+    <B EntryOption="optional" EntryType="A" EntryName="a" EntryIndex="1"/>
+</B>
+<C>
+    <C EntryOption="optional" EntryType="CS_Login" EntryName="login" EntryIndex="1"/>
+</C></data>
+<protocol><CS_Login>
+    <CS_Login EntryOption="optional" EntryType="C" EntryName="c" EntryIndex="1"/>
+</CS_Login></protocol>`,
+			want: []string{
+				"[warn] A: reference cycle: A -> B -> A (ref-cycle)",
+				"[error] C: import cycle between data.proto and protocol.proto: C -> CS_Login -> C (ref-cycle)",
+			},
+		},
+	}
+	for _, test := range tests {
+		genConfig := NewGenConfig()
+		if test.syntax != "" {
+			genConfig.Syntax = test.syntax
+		}
+		got := getDiagnosticTexts(DiagnoseSchema(loadTestSchema(t, test.xml), genConfig))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCountDiagnostics(t *testing.T) {
+	diagList := []StDiagnostic{{Severity: DiagSeverity_Warn}, {Severity: DiagSeverity_Error}, {Severity: DiagSeverity_Warn}}
+	if nError, nWarn := CountDiagnostics(diagList); nError != 1 || nWarn != 2 {
+		t.Errorf("got %d errors %d warns, want 1 2", nError, nWarn)
+	}
+	if !HasErrorDiagnostic(diagList) || HasErrorDiagnostic(diagList[:1]) {
+		t.Error("HasErrorDiagnostic")
+	}
+}
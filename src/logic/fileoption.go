@@ -0,0 +1,177 @@
+package logic
+
+import (
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+)
+
+// 所有输出文件共用的文件选项名
+const FileOptionAll = "*"
+
+// 文件选项中替换为输出文件名(不含 .proto)的占位符
+const FileOptionFilePlaceholder = "{file}"
+
+// 未配置 go_package 时使用的值, 与之前的输出保持一致
+const DefaultGoPackage = "example/" + FileOptionFilePlaceholder
+
+// 一个输出文件的 package 和文件选项, 来自 config.xml 中 genproto 下的 fileoption
+type StFileOption struct {
+	Package         string          // proto 的 package, 为空时不输出
+	GoPackage       string          // option go_package
+	JavaPackage     string          // option java_package
+	CsharpNamespace string          // option csharp_namespace
+	Options         [][2]string     // 其他文件选项, name/value
+	EnumOptions     map[string]bool // 值为枚举值的自定义选项名, 内置选项按类型输出不需要配置
+}
+
+// 获取输出文件的选项: 先取 * 的配置, 再用该文件的配置覆盖, 其他选项按名字覆盖, 新的选项依次追加
+func (genConfig *StGenConfig) GetFileOption(fileName string) StFileOption {
+	result := StFileOption{GoPackage: DefaultGoPackage, EnumOptions: map[string]bool{}}
+	for _, key := range []string{FileOptionAll, fileName} {
+		fileOption, ok := genConfig.FileOptions[key]
+		if !ok {
+			continue
+		}
+		if fileOption.Package != "" {
+			result.Package = fileOption.Package
+		}
+		if fileOption.GoPackage != "" {
+			result.GoPackage = fileOption.GoPackage
+		}
+		if fileOption.JavaPackage != "" {
+			result.JavaPackage = fileOption.JavaPackage
+		}
+		if fileOption.CsharpNamespace != "" {
+			result.CsharpNamespace = fileOption.CsharpNamespace
+		}
+		for _, option := range fileOption.Options {
+			result.Options = setFileOption(result.Options, option)
+		}
+		for strName, isEnum := range fileOption.EnumOptions {
+			result.EnumOptions[strName] = isEnum
+		}
+	}
+	result.Package = strings.ReplaceAll(result.Package, FileOptionFilePlaceholder, fileName)
+	result.GoPackage = strings.ReplaceAll(result.GoPackage, FileOptionFilePlaceholder, fileName)
+	result.JavaPackage = strings.ReplaceAll(result.JavaPackage, FileOptionFilePlaceholder, fileName)
+	result.CsharpNamespace = strings.ReplaceAll(result.CsharpNamespace, FileOptionFilePlaceholder, fileName)
+	return result
+}
+
+// 获取文件头中 package 和 option 的文本, 未配置 package 时输出注释
+func (fileOption *StFileOption) GetHeadText(fileName string) string {
+	var builder strings.Builder
+	if fileOption.Package != "" {
+		builder.WriteString("package " + fileOption.Package + ";\n")
+	} else {
+		builder.WriteString("// package " + fileName + ";\n")
+	}
+	if fileOption.GoPackage != "" {
+		builder.WriteString("option go_package = " + strconv.Quote(fileOption.GoPackage) + ";\n")
+	}
+	if fileOption.JavaPackage != "" {
+		builder.WriteString("option java_package = " + strconv.Quote(fileOption.JavaPackage) + ";\n")
+	}
+	if fileOption.CsharpNamespace != "" {
+		builder.WriteString("option csharp_namespace = " + strconv.Quote(fileOption.CsharpNamespace) + ";\n")
+	}
+	for _, option := range fileOption.Options {
+		builder.WriteString("option " + option[0] + " = " + GetOptionValueText(option[0], option[1], fileOption.EnumOptions[option[0]]) + ";\n")
+	}
+	return builder.String()
+}
+
+// 设置选项, 已有同名选项时替换它的值
+func setFileOption(optionList [][2]string, option [2]string) [][2]string {
+	for i := range optionList {
+		if optionList[i][0] == option[0] {
+			optionList[i][1] = option[1]
+			return optionList
+		}
+	}
+	return append(optionList, option)
+}
+
+// 获取选项值在 proto 中的写法. 已带引号时原样输出; 内置选项按类型输出, 字符串加引号, bool 和枚举值原样输出;
+// 自定义选项的 bool 和数字原样输出, 配置为枚举的原样输出, 其他作为字符串输出
+func GetOptionValueText(name string, value string, isEnum bool) string {
+	if strings.HasPrefix(value, `"`) {
+		return value
+	}
+	if optionInfo, ok := pbFileOptionTable[name]; ok {
+		if optionInfo.Kind == pbOptionString {
+			return strconv.Quote(value)
+		}
+		return value
+	}
+	if isEnum || value == "true" || value == "false" {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// 检查文件选项, 返回错误描述列表
+func (genConfig *StGenConfig) CheckFileOptions() []string {
+	result := []string{}
+	for fileName, fileOption := range genConfig.FileOptions {
+		strPackage := strings.ReplaceAll(fileOption.Package, FileOptionFilePlaceholder, fileName)
+		if strPackage != "" && !CheckTypeName(strPackage) {
+			result = append(result, "fileoption "+fileName+": invalid package "+fileOption.Package)
+		}
+		for _, option := range fileOption.Options {
+			if !CheckTypeName(strings.Trim(option[0], "()")) {
+				result = append(result, "fileoption "+fileName+": invalid option name "+option[0])
+			}
+		}
+	}
+	return result
+}
+
+// 不同 package 的文件之间引用类型时, 需要使用 .package.Type 形式的全名.
+// 返回修改了类型名的文件列表, 每个文件的 Schema 为副本, 原 Schema 不变
+func QualifyGenFiles(fileList []StGenFile, genConfig StGenConfig) []StGenFile {
+	fileMap := GetGenFileMap(fileList)
+	typeMap := map[string]bool{}
+	for path := range fileMap {
+		typeMap[path] = true
+	}
+	packageMap := map[string]string{}
+	for _, genFile := range fileList {
+		packageMap[genFile.FileName] = genConfig.GetFileOption(genFile.FileName).Package
+	}
+	result := []StGenFile{}
+	for _, genFile := range fileList {
+		fileSchema, err := model.SchemaFromDocument(genFile.Schema.ToDocument())
+		if err != nil {
+			result = append(result, genFile)
+			continue
+		}
+		strPackage := packageMap[genFile.FileName]
+		fileSchema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+			if msg == nil {
+				return
+			}
+			for i := range msg.Fields {
+				strTypePath := model.ResolveTypeName(typeMap, path, msg.Fields[i].EntryType)
+				if strTypePath == "" {
+					continue
+				}
+				strTargetPackage := packageMap[fileMap[strTypePath]]
+				if strTargetPackage == strPackage {
+					continue
+				}
+				if strTargetPackage != "" {
+					strTypePath = strTargetPackage + "." + strTypePath
+				}
+				msg.Fields[i].EntryType = "." + strTypePath
+			}
+		})
+		result = append(result, StGenFile{FileName: genFile.FileName, Schema: fileSchema, Imports: genFile.Imports})
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetFileOption(t *testing.T) {
+	genConfig := NewGenConfig()
+	genConfig.FileOptions = map[string]StFileOption{
+		FileOptionAll: {
+			Package:   "pb",
+			GoPackage: "game/{file}",
+			Options:   [][2]string{{"optimize_for", "SPEED"}, {"cc_enable_arenas", "true"}},
+		},
+		"enum": {
+			Package:     "pb.enum",
+			Options:     [][2]string{{"optimize_for", "LITE_RUNTIME"}, {"(my.level)", "HIGH"}},
+			EnumOptions: map[string]bool{"(my.level)": true},
+		},
+	}
+	tests := []struct {
+		fileName string
+		want     StFileOption
+	}{
+		{
+			fileName: "data",
+			want: StFileOption{
+				Package:     "pb",
+				GoPackage:   "game/data",
+				Options:     [][2]string{{"optimize_for", "SPEED"}, {"cc_enable_arenas", "true"}},
+				EnumOptions: map[string]bool{},
+			},
+		},
+		{
+			fileName: "enum",
+			want: StFileOption{
+				Package:     "pb.enum",
+				GoPackage:   "game/enum",
+				Options:     [][2]string{{"optimize_for", "LITE_RUNTIME"}, {"cc_enable_arenas", "true"}, {"(my.level)", "HIGH"}},
+				EnumOptions: map[string]bool{"(my.level)": true},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := genConfig.GetFileOption(test.fileName); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.fileName, got, test.want)
+		}
+	}
+	// 覆盖 * 的配置不影响其他文件
+	if got := genConfig.FileOptions[FileOptionAll].Options[0][1]; got != "SPEED" {
+		t.Errorf("the options of * are changed: %s", got)
+	}
+}
+
+func TestGetOptionValueText(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		isEnum bool
+		want   string
+	}{
+		{"objc_class_prefix", "GPB", false, `"GPB"`},
+		{"java_outer_classname", "Role", false, `"Role"`},
+		{"php_namespace", `"Game\\Pb"`, false, `"Game\\Pb"`},
+		{"optimize_for", "SPEED", false, "SPEED"},
+		{"java_multiple_files", "true", false, "true"},
+		{"(my.level)", "HIGH", true, "HIGH"},
+		{"(my.name)", "HIGH", false, `"HIGH"`},
+		{"(my.flag)", "false", false, "false"},
+		{"(my.count)", "10", false, "10"},
+		{"(my.path)", "a/b", false, `"a/b"`},
+	}
+	for _, test := range tests {
+		if got := GetOptionValueText(test.name, test.value, test.isEnum); got != test.want {
+			t.Errorf("%s = %s: got %s, want %s", test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestFileOptionGetHeadText(t *testing.T) {
+	fileOption := StFileOption{
+		GoPackage: "game/enum",
+		Options:   [][2]string{{"optimize_for", "LITE_RUNTIME"}, {"objc_class_prefix", "GPB"}},
+	}
+	want := "// package enum;\n" +
+		"option go_package = \"game/enum\";\n" +
+		"option optimize_for = LITE_RUNTIME;\n" +
+		"option objc_class_prefix = \"GPB\";\n"
+	if got := fileOption.GetHeadText("enum"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
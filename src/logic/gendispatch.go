@@ -0,0 +1,346 @@
+package logic
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 生成的公共文件名, 包含消息 ID 常量, Session 和 Dispatcher
+const DispatchCommonFileName = "dispatch.go"
+
+// 未配置包名时使用的包名
+const DefaultDispatchPackage = "dispatch"
+
+// 生成 go 分发代码的配置, 来自 config.xml 的 gendispatch
+type StDispatchConfig struct {
+	Package string // 生成代码的 go 包名
+}
+
+// 一条需要分发的消息
+type StDispatchMsg struct {
+	Name     string // 消息名, rpc 为 XxxReq/XxxAck
+	RpcName  string // 所属 rpc 名, 普通协议为空
+	RpcType  string // Req/Ack, 普通协议为空
+	Comment  string
+	MsgId    int
+	GoImport string // 消息所在 pb 的 go import 路径
+	Source   string // 发送方, 服务器全名中[]内的部分, 如 GameServer
+	Target   string // 接收方
+}
+
+// 是否为有 Req 和 Ack 的 rpc 请求, 接收方处理后回复 Ack
+func (dispatchMsg *StDispatchMsg) IsRpcReq() bool {
+	return dispatchMsg.RpcType == model.RpcTypeReq
+}
+
+// 收集需要分发的消息, 无法识别收发服务器的协议不分发. detect 返回协议名对应的源/目标服务器全名
+func GetDispatchList(schema *model.Schema, registry *StMsgIdRegistry, genConfig StGenConfig, detect func(protoName string) (bool, string, string)) ([]StDispatchMsg, []string) {
+	result := []StDispatchMsg{}
+	errorList := []string{}
+	addMsg := func(category string, strName string, strRpcName string, strRpcType string, strComment string) {
+		strProtoName := strName
+		if strRpcName != "" {
+			strProtoName = strRpcName
+		}
+		isSuccess, firstName, secondName := detect(strProtoName)
+		strSource := GetServiceIdentifier(firstName)
+		strTarget := GetServiceIdentifier(secondName)
+		if !isSuccess || strSource == "" || strTarget == "" {
+			logrus.Warn("[GetDispatchList] unknown source or target server, not dispatched. Name:", strName)
+			return
+		}
+		entry := registry.FindEntry(strName)
+		if entry == nil {
+			errorList = append(errorList, "msgid of "+strName+" is not assigned")
+			return
+		}
+		strFileName := genConfig.GetUnitFileName(category, strProtoName)
+		fileOption := genConfig.GetFileOption(strFileName)
+		strGoImport := strings.SplitN(fileOption.GoPackage, ";", 2)[0]
+		if strGoImport == "" {
+			errorList = append(errorList, "go_package of "+strFileName+".proto is empty, used by "+strName)
+			return
+		}
+		result = append(result, StDispatchMsg{
+			Name:     strName,
+			RpcName:  strRpcName,
+			RpcType:  strRpcType,
+			Comment:  strComment,
+			MsgId:    entry.Id,
+			GoImport: strGoImport,
+			Source:   strSource,
+			Target:   strTarget,
+		})
+	}
+	for _, msg := range schema.Protocols {
+		addMsg(model.CategoryProtocol, msg.Name, "", "", msg.Comment)
+	}
+	for _, rpc := range schema.Rpcs {
+		// 缺少 Ack 的 rpc 按普通协议处理
+		if rpc.Req != nil && rpc.Ack != nil {
+			addMsg(model.CategoryRpc, rpc.Name+model.RpcTypeReq, rpc.Name, model.RpcTypeReq, rpc.Comment)
+			addMsg(model.CategoryRpc, rpc.Name+model.RpcTypeAck, rpc.Name, model.RpcTypeAck, rpc.Comment)
+		} else {
+			for _, rpcMsg := range []string{model.RpcTypeReq, model.RpcTypeAck} {
+				if rpc.GetMessage(rpcMsg) != nil {
+					addMsg(model.CategoryRpc, rpc.Name+rpcMsg, rpc.Name, "", rpc.Comment)
+				}
+			}
+		}
+	}
+	return result, errorList
+}
+
+// 生成每个接收方服务器的分发代码和公共文件, 返回生成的文件名
+func GenDispatch(dispatchList []StDispatchMsg, outputPath string, dispatchConfig StDispatchConfig) (bool, []string) {
+	if outputPath == "" {
+		logrus.Error("[GenDispatch] failed for invalid param: outputPath.")
+		return false, nil
+	}
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		logrus.Error("[GenDispatch] failed to create outputPath:", err, ", outputPath:", outputPath)
+		return false, nil
+	}
+	strPackage := dispatchConfig.Package
+	if strPackage == "" {
+		strPackage = DefaultDispatchPackage
+	}
+	importMap := GetDispatchImportMap(dispatchList)
+
+	fileMap := map[string]string{DispatchCommonFileName: GenDispatchCommon(dispatchList, strPackage)}
+	for _, strServer := range GetDispatchServerList(dispatchList) {
+		fileMap[strings.ToLower(strServer)+"_dispatch.go"] = GenDispatchServer(dispatchList, strServer, strPackage, importMap)
+	}
+	fileNames := []string{}
+	for strFileName, strSource := range fileMap {
+		// 格式化失败说明生成的代码有误, 仍写入未格式化的代码便于排查
+		formatted, err := format.Source([]byte(strSource))
+		if err != nil {
+			logrus.Error("[GenDispatch] format failed:", err, ", filename:", strFileName)
+			formatted = []byte(strSource)
+		}
+		strFilePath := filepath.Join(outputPath, strFileName)
+		if err := os.WriteFile(strFilePath, formatted, 0644); err != nil {
+			logrus.Error("[GenDispatch] write file failed:", err, ", filename:", strFilePath)
+			return false, fileNames
+		}
+		fileNames = append(fileNames, strFileName)
+	}
+	sort.Strings(fileNames)
+	logrus.Info("[GenDispatch] done. outputPath:", outputPath, ", files:", fileNames)
+	return true, fileNames
+}
+
+// 收发消息的服务器列表, 按名字排序
+func GetDispatchServerList(dispatchList []StDispatchMsg) []string {
+	serverMap := map[string]bool{}
+	for _, dispatchMsg := range dispatchList {
+		serverMap[dispatchMsg.Source] = true
+		serverMap[dispatchMsg.Target] = true
+	}
+	result := []string{}
+	for strServer := range serverMap {
+		result = append(result, strServer)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// pb 的 go import 路径到包别名的映射, 别名为路径最后一段加 pb, 重复时加序号
+func GetDispatchImportMap(dispatchList []StDispatchMsg) map[string]string {
+	importList := []string{}
+	result := map[string]string{}
+	for _, dispatchMsg := range dispatchList {
+		if _, ok := result[dispatchMsg.GoImport]; !ok {
+			result[dispatchMsg.GoImport] = ""
+			importList = append(importList, dispatchMsg.GoImport)
+		}
+	}
+	sort.Strings(importList)
+	aliasMap := map[string]bool{}
+	for _, strImport := range importList {
+		strBase := GetServiceIdentifier(strings.ToLower(filepath.Base(strImport))) + "pb"
+		strAlias := strBase
+		for i := 2; aliasMap[strAlias]; i++ {
+			strAlias = strBase + strconv.Itoa(i)
+		}
+		aliasMap[strAlias] = true
+		result[strImport] = strAlias
+	}
+	return result
+}
+
+// 生成公共文件: 消息 ID 常量, Session 接口, Dispatcher
+func GenDispatchCommon(dispatchList []StDispatchMsg, strPackage string) string {
+	var builder strings.Builder
+	builder.WriteString("// Code generated by protocolgo. DO NOT EDIT.\n\n")
+	builder.WriteString("package " + strPackage + "\n\n")
+	builder.WriteString("import (\n\t\"fmt\"\n\n\t\"google.golang.org/protobuf/proto\"\n)\n\n")
+
+	msgList := append([]StDispatchMsg{}, dispatchList...)
+	sort.SliceStable(msgList, func(i, j int) bool { return msgList[i].MsgId < msgList[j].MsgId })
+	builder.WriteString("// 消息 ID, 与消息 ID 锁文件一致\n")
+	builder.WriteString("const (\n")
+	for _, dispatchMsg := range msgList {
+		builder.WriteString("\tMsgId_" + dispatchMsg.Name + " uint32 = " + strconv.Itoa(dispatchMsg.MsgId) + "\n")
+	}
+	builder.WriteString(")\n\n")
+
+	builder.WriteString(`// 连接, 由使用方实现, 负责按消息 ID 封包发送
+type Session interface {
+	Send(msgId uint32, data []byte) error
+}
+
+// 解码消息并调用处理函数
+type DecodeFunc func(session Session, data []byte) error
+
+// 按消息 ID 分发消息
+type Dispatcher struct {
+	decoders map[uint32]DecodeFunc
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{decoders: map[uint32]DecodeFunc{}}
+}
+
+// 注册消息的解码函数, 重复注册时覆盖
+func (dispatcher *Dispatcher) Register(msgId uint32, decode DecodeFunc) {
+	dispatcher.decoders[msgId] = decode
+}
+
+// 分发收到的消息, 未注册的消息 ID 返回错误
+func (dispatcher *Dispatcher) Dispatch(session Session, msgId uint32, data []byte) error {
+	decode, ok := dispatcher.decoders[msgId]
+	if !ok {
+		return fmt.Errorf("dispatch: unknown msg id %d", msgId)
+	}
+	return decode(session, data)
+}
+
+// 编码并发送消息
+func send(session Session, msgId uint32, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return session.Send(msgId, data)
+}
+`)
+	return builder.String()
+}
+
+// 生成一个服务器的分发代码: 接收消息的处理接口和注册函数, 发送消息的函数
+func GenDispatchServer(dispatchList []StDispatchMsg, strServer string, strPackage string, importMap map[string]string) string {
+	recvList := []StDispatchMsg{}
+	sendList := []StDispatchMsg{}
+	usedImports := map[string]bool{}
+	for _, dispatchMsg := range dispatchList {
+		// rpc 的 Ack 由源服务器接收
+		strReceiver := dispatchMsg.Target
+		if dispatchMsg.RpcType == model.RpcTypeAck {
+			strReceiver = dispatchMsg.Source
+		}
+		if strReceiver == strServer {
+			recvList = append(recvList, dispatchMsg)
+			usedImports[dispatchMsg.GoImport] = true
+		} else if dispatchMsg.Source == strServer && dispatchMsg.RpcType != model.RpcTypeAck {
+			// rpc 的 Ack 由处理函数返回后自动回复, 不生成发送函数
+			sendList = append(sendList, dispatchMsg)
+			usedImports[dispatchMsg.GoImport] = true
+		}
+	}
+	// rpc 请求的处理函数会返回 Ack
+	typeName := func(dispatchMsg StDispatchMsg) string {
+		return "*" + importMap[dispatchMsg.GoImport] + "." + dispatchMsg.Name
+	}
+	ackOf := func(dispatchMsg StDispatchMsg) StDispatchMsg {
+		for _, ackMsg := range dispatchList {
+			if ackMsg.RpcName == dispatchMsg.RpcName && ackMsg.RpcType == model.RpcTypeAck {
+				return ackMsg
+			}
+		}
+		return dispatchMsg
+	}
+	for _, dispatchMsg := range recvList {
+		if dispatchMsg.IsRpcReq() {
+			usedImports[ackOf(dispatchMsg).GoImport] = true
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString("// Code generated by protocolgo. DO NOT EDIT.\n\n")
+	builder.WriteString("package " + strPackage + "\n\n")
+	builder.WriteString("import (\n")
+	if len(recvList) > 0 {
+		builder.WriteString("\t\"google.golang.org/protobuf/proto\"\n\n")
+	}
+	importList := []string{}
+	for strImport := range usedImports {
+		importList = append(importList, strImport)
+	}
+	sort.Strings(importList)
+	for _, strImport := range importList {
+		builder.WriteString("\t" + importMap[strImport] + " " + strconv.Quote(strImport) + "\n")
+	}
+	builder.WriteString(")\n\n")
+
+	if len(recvList) > 0 {
+		builder.WriteString("// " + strServer + " 接收的消息的处理接口\n")
+		builder.WriteString("type " + strServer + "Handler interface {\n")
+		for _, dispatchMsg := range recvList {
+			builder.WriteString(getDispatchComment(dispatchMsg.Comment, "\t"))
+			if dispatchMsg.IsRpcReq() {
+				builder.WriteString("\tOn" + dispatchMsg.RpcName + "(session Session, req " + typeName(dispatchMsg) + ") (" + typeName(ackOf(dispatchMsg)) + ", error)\n")
+			} else {
+				builder.WriteString("\tOn" + dispatchMsg.Name + "(session Session, msg " + typeName(dispatchMsg) + ") error\n")
+			}
+		}
+		builder.WriteString("}\n\n")
+
+		builder.WriteString("// 注册 " + strServer + " 接收的消息\n")
+		builder.WriteString("func Register" + strServer + "Handler(dispatcher *Dispatcher, handler " + strServer + "Handler) {\n")
+		for _, dispatchMsg := range recvList {
+			strType := strings.TrimPrefix(typeName(dispatchMsg), "*")
+			builder.WriteString("\tdispatcher.Register(MsgId_" + dispatchMsg.Name + ", func(session Session, data []byte) error {\n")
+			builder.WriteString("\t\tmsg := &" + strType + "{}\n")
+			builder.WriteString("\t\tif err := proto.Unmarshal(data, msg); err != nil {\n\t\t\treturn err\n\t\t}\n")
+			if dispatchMsg.IsRpcReq() {
+				builder.WriteString("\t\tack, err := handler.On" + dispatchMsg.RpcName + "(session, msg)\n")
+				builder.WriteString("\t\tif err != nil || ack == nil {\n\t\t\treturn err\n\t\t}\n")
+				builder.WriteString("\t\treturn send(session, MsgId_" + ackOf(dispatchMsg).Name + ", ack)\n")
+			} else {
+				builder.WriteString("\t\treturn handler.On" + dispatchMsg.Name + "(session, msg)\n")
+			}
+			builder.WriteString("\t})\n")
+		}
+		builder.WriteString("}\n\n")
+	}
+
+	for _, dispatchMsg := range sendList {
+		builder.WriteString("// " + strServer + " 发送到 " + dispatchMsg.Target + "\n")
+		builder.WriteString("func Send" + dispatchMsg.Name + "(session Session, msg " + typeName(dispatchMsg) + ") error {\n")
+		builder.WriteString("\treturn send(session, MsgId_" + dispatchMsg.Name + ", msg)\n")
+		builder.WriteString("}\n\n")
+	}
+	return builder.String()
+}
+
+// 多行注释转为 go 注释
+func getDispatchComment(comment string, strIndent string) string {
+	if comment == "" {
+		return ""
+	}
+	var builder strings.Builder
+	for _, line := range strings.Split(comment, "\n") {
+		builder.WriteString(strIndent + "// " + strings.TrimSpace(line) + "\n")
+	}
+	return builder.String()
+}
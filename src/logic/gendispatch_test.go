@@ -0,0 +1,134 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 测试用的收发服务器: CS_Xxx 为客户端发送到场景服, 其他无法识别
+func detectTestServer(protoName string) (bool, string, string) {
+	if strings.HasPrefix(protoName, "CS_") {
+		return true, "客户端[Client]", "场景服[GameServer]"
+	}
+	return false, "", ""
+}
+
+func getTestDispatchRegistry() *StMsgIdRegistry {
+	return &StMsgIdRegistry{
+		Ranges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+		Entries: []StMsgIdEntry{
+			{Name: "CS_Login", Pair: "CS", Id: 1000},
+			{Name: "CS_GetRoleReq", Pair: "CS", Id: 1001},
+			{Name: "CS_GetRoleAck", Pair: "CS", Id: 1002},
+		},
+	}
+}
+
+func TestGetDispatchList(t *testing.T) {
+	schema := loadTestSchema(t, testSchemaXml)
+	genConfig := NewGenConfig()
+	genConfig.FileOptions = map[string]StFileOption{FileOptionAll: {GoPackage: "game/pb/{file}"}}
+
+	dispatchList, errorList := GetDispatchList(schema, getTestDispatchRegistry(), genConfig, detectTestServer)
+	if len(errorList) > 0 {
+		t.Fatalf("errors: %q", errorList)
+	}
+	want := []StDispatchMsg{
+		{Name: "CS_Login", MsgId: 1000, GoImport: "game/pb/protocol", Source: "Client", Target: "GameServer"},
+		{Name: "CS_GetRoleReq", RpcName: "CS_GetRole", RpcType: "Req", MsgId: 1001, GoImport: "game/pb/rpc", Source: "Client", Target: "GameServer"},
+		{Name: "CS_GetRoleAck", RpcName: "CS_GetRole", RpcType: "Ack", MsgId: 1002, GoImport: "game/pb/rpc", Source: "Client", Target: "GameServer"},
+	}
+	if !reflect.DeepEqual(dispatchList, want) {
+		t.Errorf("got %+v, want %+v", dispatchList, want)
+	}
+
+	// 未分配消息 ID 的协议报错
+	registry := getTestDispatchRegistry()
+	registry.Entries = registry.Entries[1:]
+	if _, errorList = GetDispatchList(schema, registry, genConfig, detectTestServer); !reflect.DeepEqual(errorList, []string{"msgid of CS_Login is not assigned"}) {
+		t.Errorf("missing msgid: got %q", errorList)
+	}
+
+	// 无法识别收发服务器的协议不分发
+	dispatchList, errorList = GetDispatchList(schema, getTestDispatchRegistry(), genConfig, func(string) (bool, string, string) { return false, "", "" })
+	if len(dispatchList) != 0 || len(errorList) != 0 {
+		t.Errorf("unknown server: got %+v, %q", dispatchList, errorList)
+	}
+}
+
+func TestGetDispatchImportMap(t *testing.T) {
+	dispatchList := []StDispatchMsg{
+		{Name: "A", GoImport: "game/rpc"},
+		{Name: "B", GoImport: "game/protocol"},
+		{Name: "C", GoImport: "other/rpc"},
+		{Name: "D", GoImport: "game/rpc"},
+	}
+	want := map[string]string{
+		"game/protocol": "protocolpb",
+		"game/rpc":      "rpcpb",
+		"other/rpc":     "rpcpb2",
+	}
+	if got := GetDispatchImportMap(dispatchList); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGenDispatch(t *testing.T) {
+	schema := loadTestSchema(t, testSchemaXml)
+	genConfig := NewGenConfig()
+	genConfig.FileOptions = map[string]StFileOption{FileOptionAll: {GoPackage: "game/pb/{file}"}}
+	dispatchList, errorList := GetDispatchList(schema, getTestDispatchRegistry(), genConfig, detectTestServer)
+	if len(errorList) > 0 {
+		t.Fatalf("GetDispatchList: %q", errorList)
+	}
+
+	strDir := t.TempDir()
+	isSuccess, fileNames := GenDispatch(dispatchList, strDir, StDispatchConfig{Package: "msg"})
+	if !isSuccess {
+		t.Fatal("GenDispatch failed")
+	}
+	if want := []string{"client_dispatch.go", "dispatch.go", "gameserver_dispatch.go"}; !reflect.DeepEqual(fileNames, want) {
+		t.Fatalf("files: got %v, want %v", fileNames, want)
+	}
+	tests := []struct {
+		fileName string
+		want     []string
+	}{
+		{
+			fileName: "dispatch.go",
+			want:     []string{"package msg", "MsgId_CS_Login      uint32 = 1000", "MsgId_CS_GetRoleAck uint32 = 1002"},
+		},
+		{
+			// 场景服接收 CS_Login 和 rpc 请求, 处理 rpc 请求后自动回复 Ack
+			fileName: "gameserver_dispatch.go",
+			want: []string{
+				"OnCS_Login(session Session, msg *protocolpb.CS_Login) error",
+				"OnCS_GetRole(session Session, req *rpcpb.CS_GetRoleReq) (*rpcpb.CS_GetRoleAck, error)",
+				"return send(session, MsgId_CS_GetRoleAck, ack)",
+			},
+		},
+		{
+			// 客户端发送请求, 接收 Ack
+			fileName: "client_dispatch.go",
+			want: []string{
+				"OnCS_GetRoleAck(session Session, msg *rpcpb.CS_GetRoleAck) error",
+				"func SendCS_Login(session Session, msg *protocolpb.CS_Login) error",
+				"func SendCS_GetRoleReq(session Session, msg *rpcpb.CS_GetRoleReq) error",
+			},
+		},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join(strDir, test.fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, strWant := range test.want {
+			if !strings.Contains(string(data), strWant) {
+				t.Errorf("%s: missing %q in\n%s", test.fileName, strWant, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package logic
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 内置的 go 插件名, 未配置 opt 时按 gomodule 设置输出路径
+const (
+	PbPluginGo     = "go"
+	PbPluginGoGrpc = "go-grpc"
+)
+
+// 生成方式
+const (
+	PbModeAuto    = "auto"    // 找到 protoc 时调用 protoc, 否则使用内置生成
+	PbModeProtoc  = "protoc"  // 调用 protoc
+	PbModeBuiltin = "builtin" // 不调用 protoc, 由协议直接生成 descriptor 并调用插件
+)
+
+func GetPbModeList() []string {
+	return []string{PbModeAuto, PbModeProtoc, PbModeBuiltin}
+}
+
+func IsValidPbMode(mode string) bool {
+	for _, v := range GetPbModeList() {
+		if v == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// 内置生成使用的协议和生成 proto 的配置, 输出的文件与 GenProto 一致
+type StPbSource struct {
+	Schema    *model.Schema
+	GenConfig StGenConfig
+}
+
+// 一个生成目标, 对应 protoc 的 --<plugin>_out 和 --<plugin>_opt
+type StPbTarget struct {
+	Name   string // 目标名, 用于展示结果
+	Plugin string // 插件名, 如 go, csharp, cpp, python 或 protoc-gen-xxx 的 xxx
+	Out    string // 输出目录, 相对路径相对 genpb 的输出目录, 为空时为 genpb 的输出目录
+	Opt    string // 插件选项
+	// 插件 protoc-gen-<Plugin> 的路径, 为空时在 data 目录和 PATH 中查找. 内置生成器不需要
+	Path       string
+	MinVersion string // 插件的最低版本, 为空时不检查
+}
+
+// 生成 pb 的配置
+type StPbConfig struct {
+	Grpc     bool   // 是否调用 protoc-gen-go-grpc 生成 service 代码
+	GoModule string // go 代码的模块前缀, 输出时去掉该前缀; 为空时按 go_package 的完整路径输出
+	// 配置的生成目标, 为空时按 Grpc 生成 go 和 go-grpc, 与之前保持一致
+	Targets []StPbTarget
+	// protoc 的路径, 为空时在 data 目录和 PATH 中查找
+	Protoc           string
+	ProtocMinVersion string // protoc 的最低版本, 为空时不检查
+	Mode             string // 生成方式, 为空时为 auto
+	// 输出的 FileDescriptorSet 文件名, 相对输出目录, 为空时不输出
+	DescriptorSet string
+	// 内置生成使用的协议, 为 nil 时只能调用 protoc
+	Source *StPbSource
+}
+
+// 一个生成目标的结果
+type StPbTargetResult struct {
+	Target  StPbTarget
+	Success bool
+	Files   []string // 本次生成或更新的文件, 相对输出目录
+	Output  string   // protoc 的输出或错误
+}
+
+// 是否使用内置生成: 配置为 builtin, 或 auto 时找不到 protoc
+func (pbConfig *StPbConfig) IsBuiltin(report StToolchainReport) bool {
+	switch pbConfig.Mode {
+	case PbModeBuiltin:
+		return true
+	case PbModeProtoc:
+		return false
+	}
+	return report.Protoc.Source == ToolSourceNotFound
+}
+
+// 获取 go 插件的输出路径选项, 输出文件按 go_package 放到对应的目录
+func (pbConfig *StPbConfig) GetGoOpt() string {
+	if pbConfig.GoModule != "" {
+		return "module=" + pbConfig.GoModule
+	}
+	return "paths=import"
+}
+
+// 获取要执行的生成目标, 输出目录按 outputPath 补全
+func (pbConfig *StPbConfig) GetTargets(outputPath string) []StPbTarget {
+	targetList := pbConfig.Targets
+	if len(targetList) == 0 {
+		targetList = []StPbTarget{{Name: PbPluginGo, Plugin: PbPluginGo, Out: outputPath}}
+		if pbConfig.Grpc {
+			targetList = append(targetList, StPbTarget{Name: PbPluginGoGrpc, Plugin: PbPluginGoGrpc, Out: outputPath})
+		}
+	}
+	result := []StPbTarget{}
+	for _, target := range targetList {
+		if !filepath.IsAbs(target.Out) {
+			target.Out = filepath.Join(outputPath, target.Out)
+		}
+		if target.Opt == "" && (target.Plugin == PbPluginGo || target.Plugin == PbPluginGoGrpc) {
+			target.Opt = pbConfig.GetGoOpt()
+		}
+		result = append(result, target)
+	}
+	return result
+}
+
+// 获取目录中的 proto 文件, 按文件名排序
+func GetProtoFileList(protopath string) ([]string, error) {
+	fileList, err := filepath.Glob(filepath.Join(protopath, "*.proto"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fileList)
+	return fileList, nil
+}
+
+// 对目录中的 proto 文件依次执行每个生成目标, 返回是否全部成功和每个目标的结果.
+// 使用内置生成时不读取 proto 文件, 由 pbConfig.Source 生成 descriptor
+func GenPbFromProto(protopath string, outputPath string, pbConfig StPbConfig) (bool, []StPbTargetResult) {
+	if outputPath == "" || !PathExists(outputPath) {
+		logrus.Error("[GenPbFromProto] failed for invalid param: outputPath:", outputPath)
+		return false, []StPbTargetResult{{Output: "invalid output path: " + outputPath}}
+	}
+	report := CheckToolchain(pbConfig)
+	if pbConfig.IsBuiltin(report) {
+		return GenPbBuiltin(outputPath, pbConfig, report)
+	}
+	if protopath == "" || !PathExists(protopath) {
+		logrus.Error("[GenPbFromProto] failed for invalid param: protopath:", protopath)
+		return false, []StPbTargetResult{{Output: "invalid proto path: " + protopath}}
+	}
+	protoFileList, err := GetProtoFileList(protopath)
+	if err != nil || len(protoFileList) == 0 {
+		logrus.Error("[GenPbFromProto] no proto file in protopath:", protopath, ", err:", err)
+		return false, []StPbTargetResult{{Output: "no proto file in " + protopath}}
+	}
+	logrus.Debug("[GenPbFromProto] param:protopath:", protopath, ",outputPath:", outputPath, ",files:", protoFileList)
+
+	if report.Protoc.Source == ToolSourceNotFound {
+		logrus.Error("[GenPbFromProto] ", report.Protoc.String())
+		return false, []StPbTargetResult{{Target: StPbTarget{Name: "protoc"}, Output: report.Protoc.String()}}
+	}
+
+	isAllSuccess := true
+	result := []StPbTargetResult{}
+	if pbConfig.DescriptorSet != "" {
+		targetResult := GenDescriptorSetByProtoc(report, protopath, protoFileList, filepath.Join(outputPath, pbConfig.DescriptorSet))
+		isAllSuccess = targetResult.Success
+		result = append(result, targetResult)
+	}
+	for _, target := range pbConfig.GetTargets(outputPath) {
+		targetResult := GenPbTarget(report, protopath, protoFileList, target)
+		if !targetResult.Success {
+			isAllSuccess = false
+		}
+		result = append(result, targetResult)
+	}
+	return isAllSuccess, result
+}
+
+// 不调用 protoc, 由协议生成 descriptor 后直接调用每个目标的插件
+func GenPbBuiltin(outputPath string, pbConfig StPbConfig, report StToolchainReport) (bool, []StPbTargetResult) {
+	if pbConfig.Source == nil || pbConfig.Source.Schema == nil {
+		logrus.Error("[GenPbBuiltin] no schema for builtin generation.")
+		return false, []StPbTargetResult{{Target: StPbTarget{Name: "protoc"}, Output: report.Protoc.String() + "\nno protocol xml for builtin generation"}}
+	}
+	fileList, errorList := BuildFileDescriptors(pbConfig.Source.Schema, pbConfig.Source.GenConfig)
+	if len(errorList) > 0 {
+		logrus.Error("[GenPbBuiltin] BuildFileDescriptors failed:", errorList)
+		return false, []StPbTargetResult{{Target: StPbTarget{Name: "descriptor"}, Output: strings.Join(errorList, "\n")}}
+	}
+
+	isAllSuccess := true
+	result := []StPbTargetResult{}
+	if pbConfig.DescriptorSet != "" {
+		targetResult := StPbTargetResult{Target: StPbTarget{Name: "descriptor_set", Out: outputPath}}
+		if WriteDescriptorSet(fileList, filepath.Join(outputPath, pbConfig.DescriptorSet)) {
+			targetResult.Success = true
+			targetResult.Files = []string{pbConfig.DescriptorSet}
+		} else {
+			targetResult.Output = "write descriptor set failed"
+			isAllSuccess = false
+		}
+		result = append(result, targetResult)
+	}
+	for _, target := range pbConfig.GetTargets(outputPath) {
+		targetResult := StPbTargetResult{Target: target}
+		if err := os.MkdirAll(target.Out, 0755); err != nil {
+			targetResult.Output = err.Error()
+		} else {
+			targetResult = GenPbTargetBuiltin(report, fileList, target)
+		}
+		if !targetResult.Success {
+			isAllSuccess = false
+		}
+		result = append(result, targetResult)
+	}
+	return isAllSuccess, result
+}
+
+// 调用 protoc 输出包含注释的 FileDescriptorSet
+func GenDescriptorSetByProtoc(report StToolchainReport, protopath string, protoFileList []string, filename string) StPbTargetResult {
+	result := StPbTargetResult{Target: StPbTarget{Name: "descriptor_set", Out: filepath.Dir(filename)}}
+	args := []string{"--proto_path=" + protopath, "--descriptor_set_out=" + filename, "--include_imports", "--include_source_info"}
+	output, err := exec.Command(report.Protoc.Path, append(args, protoFileList...)...).CombinedOutput()
+	result.Output = string(output)
+	if err != nil {
+		logrus.Error("[GenDescriptorSetByProtoc] Error executing protoc command:", err, ",output:", string(output))
+		result.Output = err.Error() + "\n" + result.Output
+		return result
+	}
+	result.Success = true
+	result.Files = []string{filepath.Base(filename)}
+	return result
+}
+
+// 执行一个生成目标, 使用 report 中查找到的 protoc 和插件
+func GenPbTarget(report StToolchainReport, protopath string, protoFileList []string, target StPbTarget) StPbTargetResult {
+	result := StPbTargetResult{Target: target}
+	if target.Plugin == "" {
+		result.Output = "plugin of target " + target.Name + " is not configed"
+		return result
+	}
+	pluginInfo := report.FindPlugin(target.Plugin)
+	if pluginInfo != nil && pluginInfo.Level == ToolLevel_Error {
+		result.Output = pluginInfo.String()
+		return result
+	}
+	if err := os.MkdirAll(target.Out, 0755); err != nil {
+		result.Output = err.Error()
+		return result
+	}
+	command := report.Protoc.Path
+	args := []string{"--proto_path=" + protopath}
+	if pluginInfo != nil && pluginInfo.Source != ToolSourceBuiltin {
+		args = append(args, "--plugin="+pluginInfo.Name+"="+pluginInfo.Path)
+	}
+	args = append(args, "--"+target.Plugin+"_out="+target.Out)
+	if target.Opt != "" {
+		args = append(args, "--"+target.Plugin+"_opt="+target.Opt)
+	}
+	args = append(args, protoFileList...)
+
+	snapshot := getFileModTimes(target.Out)
+	cmd := exec.Command(command, args...)
+	output, err := cmd.CombinedOutput()
+	result.Output = string(output)
+	if err != nil {
+		logrus.Error("[GenPbTarget] Error executing protoc command:", err, ",output:", string(output), ",target:", target.Name)
+		result.Output = err.Error() + "\n" + result.Output
+		return result
+	}
+	result.Success = true
+	result.Files = getChangedFiles(target.Out, snapshot)
+	logrus.Info("[GenPbTarget] done. target:", target.Name, ", files:", len(result.Files), ", output:", string(output))
+	return result
+}
+
+// 获取目录中所有文件的修改时间, key 为相对 dir 的路径
+func getFileModTimes(dir string) map[string]time.Time {
+	result := map[string]time.Time{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if strRel, err := filepath.Rel(dir, path); err == nil {
+			result[strRel] = info.ModTime()
+		}
+		return nil
+	})
+	return result
+}
+
+// 对比执行前的快照, 获取新增或修改的文件. 多个目标输出到同一目录时只包含本目标的文件
+func getChangedFiles(dir string, snapshot map[string]time.Time) []string {
+	result := []string{}
+	for strPath, modTime := range getFileModTimes(dir) {
+		if oldTime, ok := snapshot[strPath]; !ok || !oldTime.Equal(modTime) {
+			result = append(result, strPath)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 结果的展示文本, 每个目标一段
+func GetPbResultText(resultList []StPbTargetResult) string {
+	var builder strings.Builder
+	for _, result := range resultList {
+		strState := "failed"
+		if result.Success {
+			strState = "ok, " + strconv.Itoa(len(result.Files)) + " file(s)"
+		}
+		builder.WriteString("[" + result.Target.Name + "] " + strState)
+		if result.Target.Out != "" {
+			builder.WriteString(" -> " + result.Target.Out)
+		}
+		builder.WriteString("\n")
+		for _, strFile := range result.Files {
+			builder.WriteString("    " + strFile + "\n")
+		}
+		if strOutput := strings.TrimSpace(result.Output); strOutput != "" {
+			builder.WriteString("    " + strings.ReplaceAll(strOutput, "\n", "\n    ") + "\n")
+		}
+	}
+	return builder.String()
+}
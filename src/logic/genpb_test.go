@@ -0,0 +1,134 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// 在 dir 中写入可执行的 shell 脚本, 用于模拟 protoc 和插件
+func writeTestTool(t *testing.T, dir string, name string, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script tools are not supported on windows")
+	}
+	strPath := filepath.Join(dir, name)
+	if err := os.WriteFile(strPath, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return strPath
+}
+
+// 模拟的 protoc: 输出版本号, 在每个 --xxx_out 目录中写入 xxx.out, 内容为全部参数
+const testProtocScript = `if [ "$1" = "--version" ]; then echo "libprotoc 25.1"; exit 0; fi
+for arg in "$@"; do
+    case "$arg" in
+    --*_out=*) plugin=${arg%%_out=*}; plugin=${plugin#--}; echo "$@" > "${arg#*_out=}/$plugin.out";;
+    esac
+done
+`
+
+func TestPbConfigGetTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		pbConfig StPbConfig
+		want     []StPbTarget
+	}{
+		{
+			name:     "default go",
+			pbConfig: StPbConfig{},
+			want:     []StPbTarget{{Name: "go", Plugin: "go", Out: "/out", Opt: "paths=import"}},
+		},
+		{
+			name:     "default go and grpc with module",
+			pbConfig: StPbConfig{Grpc: true, GoModule: "game"},
+			want: []StPbTarget{
+				{Name: "go", Plugin: "go", Out: "/out", Opt: "module=game"},
+				{Name: "go-grpc", Plugin: "go-grpc", Out: "/out", Opt: "module=game"},
+			},
+		},
+		{
+			name: "configed targets",
+			pbConfig: StPbConfig{Targets: []StPbTarget{
+				{Name: "go", Plugin: "go", Opt: "paths=source_relative"},
+				{Name: "csharp", Plugin: "csharp", Out: "cs"},
+				{Name: "cpp", Plugin: "cpp", Out: "/cpp"},
+			}},
+			want: []StPbTarget{
+				{Name: "go", Plugin: "go", Out: "/out", Opt: "paths=source_relative"},
+				{Name: "csharp", Plugin: "csharp", Out: "/out/cs"},
+				{Name: "cpp", Plugin: "cpp", Out: "/cpp"},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := test.pbConfig.GetTargets("/out"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGenPbFromProto(t *testing.T) {
+	strDir := t.TempDir()
+	strProtoPath := filepath.Join(strDir, "proto")
+	strOutputPath := filepath.Join(strDir, "out")
+	for _, strPath := range []string{strProtoPath, strOutputPath} {
+		if err := os.Mkdir(strPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, strName := range []string{"rpc.proto", "enum.proto", "readme.txt"} {
+		if err := os.WriteFile(filepath.Join(strProtoPath, strName), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pbConfig := StPbConfig{
+		Protoc: writeTestTool(t, strDir, "protoc", testProtocScript),
+		Mode:   PbModeProtoc,
+		Targets: []StPbTarget{
+			{Name: "cpp", Plugin: "cpp", Out: "cpp"},
+			{Name: "python", Plugin: "python", Opt: "pyi_out"},
+			{Name: "missing", Plugin: "missing", Path: filepath.Join(strDir, "protoc-gen-missing")},
+		},
+	}
+
+	isSuccess, resultList := GenPbFromProto(strProtoPath, strOutputPath, pbConfig)
+	if isSuccess {
+		t.Error("want failed for the missing plugin")
+	}
+	if len(resultList) != 3 {
+		t.Fatalf("got %d results, want 3", len(resultList))
+	}
+	tests := []struct {
+		result      StPbTargetResult
+		wantSuccess bool
+		wantFiles   []string
+	}{
+		{resultList[0], true, []string{"cpp.out"}},
+		// 输出到 cpp 目录的文件不属于这个目标
+		{resultList[1], true, []string{"python.out"}},
+		{resultList[2], false, nil},
+	}
+	for _, test := range tests {
+		if test.result.Success != test.wantSuccess || !reflect.DeepEqual(test.result.Files, test.wantFiles) {
+			t.Errorf("%s: got %v %v, want %v %v", test.result.Target.Name, test.result.Success, test.result.Files, test.wantSuccess, test.wantFiles)
+		}
+	}
+
+	// 参数: proto 路径, 插件选项, 按文件名排序的 proto 文件
+	data, err := os.ReadFile(filepath.Join(strOutputPath, "python.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--proto_path=" + strProtoPath + " --python_out=" + strOutputPath + " --python_opt=pyi_out " +
+		filepath.Join(strProtoPath, "enum.proto") + " " + filepath.Join(strProtoPath, "rpc.proto")
+	if got := strings.TrimSpace(string(data)); got != want {
+		t.Errorf("args: got %q, want %q", got, want)
+	}
+	if strText := GetPbResultText(resultList); !strings.Contains(strText, "[cpp] ok, 1 file(s)") || !strings.Contains(strText, "[missing] failed") {
+		t.Errorf("result text: %s", strText)
+	}
+}
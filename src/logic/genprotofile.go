@@ -3,11 +3,10 @@ package logic
 import (
 	"bufio"
 	"os"
-	"os/exec"
-	"protocolgo/src/utils"
+	"protocolgo/src/model"
+	"strconv"
 	"strings"
 
-	"github.com/beevik/etree"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,29 +14,121 @@ import (
 // 	protopath string
 // }
 
-func GenProto(filetree *etree.Document, protopath string) {
-	if nil == filetree {
-		logrus.Error("[GenProtoFile] failed for invalid param: filetree.")
-		return
+// proto 语法
+const (
+	SyntaxProto2   = "proto2"
+	SyntaxProto3   = "proto3"
+	SyntaxEditions = "editions"
+)
+
+// 未配置 edition 时使用的版本
+const DefaultEdition = "2023"
+
+// 生成 proto 的配置, 来自 config.xml 的 genproto
+type StGenConfig struct {
+	Syntax  string // proto2/proto3/editions
+	Edition string // Syntax 为 editions 时的版本
+	// rpc 所属的 service 名, 为 nil 或返回空时归入 DefaultServiceName
+	ServiceName func(rpcName string) string
+	// 输出文件名(不含 .proto)到文件选项的映射, * 为所有文件共用
+	FileOptions map[string]StFileOption
+	Split       string // 输出文件的拆分方式: category/pair
+	Order       string // 文件中单元的输出顺序: xml/name/topo
+	// 协议名对应的服务器对前缀, 如 CS_Xxx 为 CS. 识别失败返回空, 此时按分类输出
+	PairName func(protoName string) string
+	// 消息 ID 枚举, 不为 nil 时输出到 msgid.proto
+	MsgIdEnum *model.Enum
+}
+
+// 无法按服务器对分组的 rpc 所在的 service
+const DefaultServiceName = "RpcService"
+
+// 获取 rpc 所属的 service 名
+func (genConfig *StGenConfig) GetServiceName(rpcName string) string {
+	if genConfig.ServiceName != nil {
+		if strName := genConfig.ServiceName(rpcName); strName != "" {
+			return strName
+		}
+	}
+	return DefaultServiceName
+}
+
+// 默认配置, 与之前的输出保持一致
+func NewGenConfig() StGenConfig {
+	return StGenConfig{Syntax: SyntaxProto3, Split: SplitCategory, Order: OrderXml}
+}
+
+func GetSyntaxList() []string {
+	return []string{SyntaxProto2, SyntaxProto3, SyntaxEditions}
+}
+
+func IsValidSyntax(syntax string) bool {
+	for _, v := range GetSyntaxList() {
+		if v == syntax {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取该语法允许的字段选项, proto3 没有 required
+func (genConfig *StGenConfig) GetFieldOptions() []string {
+	if genConfig.Syntax == SyntaxProto3 {
+		return []string{model.OptionOptional, model.OptionRepeated, model.OptionMap}
+	}
+	return []string{model.OptionOptional, model.OptionRequired, model.OptionRepeated, model.OptionMap}
+}
+
+// 检查字段选项是否被该语法允许
+func (genConfig *StGenConfig) IsValidFieldOption(option string) bool {
+	for _, v := range genConfig.GetFieldOptions() {
+		if v == option {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否支持 [default = X], proto3 不支持
+func (genConfig *StGenConfig) HasDefaultValue() bool {
+	return genConfig.Syntax != SyntaxProto3
+}
+
+// 枚举的第一个值是否必须为 0. proto3 和 editions 默认的 open 枚举要求, proto2 不要求
+func (genConfig *StGenConfig) RequiresEnumZero() bool {
+	return genConfig.Syntax != SyntaxProto2
+}
+
+func GenProto(schema *model.Schema, protopath string, genConfig StGenConfig) bool {
+	if nil == schema {
+		logrus.Error("[GenProtoFile] failed for invalid param: schema.")
+		return false
 	}
 	if protopath == "" || !PathExists(protopath) {
 		logrus.Error("[GenProtoFile] failed for invalid param: protopath:", protopath)
-		return
+		return false
 	}
-	// 遍历各个类型去生成文件
-	for _, cataElem := range filetree.ChildElements() {
-		strProtoFilePath := protopath + "/" + cataElem.Tag + ".proto"
-		if !GenStructProto(cataElem, strProtoFilePath) {
+	// 按配置拆分文件, 不同 package 之间的引用使用全名
+	fileList := QualifyGenFiles(SplitSchemaToFiles(schema, genConfig), genConfig)
+	if genConfig.MsgIdEnum != nil {
+		msgIdSchema := model.NewSchema()
+		msgIdSchema.Enums = append(msgIdSchema.Enums, genConfig.MsgIdEnum)
+		fileList = append(fileList, StGenFile{FileName: MsgIdFileName, Schema: msgIdSchema})
+	}
+	// 遍历各个文件去生成
+	for _, genFile := range fileList {
+		strProtoFilePath := protopath + "/" + genFile.FileName + ".proto"
+		if !GenStructProto(genFile, strProtoFilePath, genConfig) {
 			logrus.Error("[GenProtoFile] failed for GenStructProto. strProtoFilePath:", strProtoFilePath)
-			return
+			return false
 		}
 	}
-
+	return true
 }
 
-func GenStructProto(catatree *etree.Element, protopath string) bool {
-	if nil == catatree {
-		logrus.Error("[GenEnumProto] failed for invalid param: catatree.")
+func GenStructProto(genFile StGenFile, protopath string, genConfig StGenConfig) bool {
+	if nil == genFile.Schema {
+		logrus.Error("[GenEnumProto] failed for invalid param: schema.")
 		return false
 	}
 
@@ -49,11 +140,11 @@ func GenStructProto(catatree *etree.Element, protopath string) bool {
 	}
 	defer fileHandler.Close()
 
-	if !GenProtoHead(fileHandler, catatree.Tag) {
+	if !GenProtoHead(fileHandler, genFile.FileName, genFile.Imports, genConfig) {
 		logrus.Error("[GenStructProto] GenProtoHead failed. filename:", protopath)
 		return false
 	}
-	if !GenProtoBody(fileHandler, catatree) {
+	if !GenProtoBody(fileHandler, genFile.Schema, genConfig) {
 		logrus.Error("[GenStructProto] GenProtoBody failed. filename:", protopath)
 		return false
 	}
@@ -64,36 +155,33 @@ func GenStructProto(catatree *etree.Element, protopath string) bool {
 	return true
 }
 
-// 生成 proto 文件的 head
-func GenProtoHead(fileHandler *os.File, packageName string) bool {
+// 生成 proto 文件的 head, importList 为引用的文件名, 不含 .proto
+func GenProtoHead(fileHandler *os.File, packageName string, importList []string, genConfig StGenConfig) bool {
 	if nil == fileHandler {
 		logrus.Error("[GenProtoHead] Failed to GenProtoHead for invalid param: fileHandler.")
 		return false
 	}
 
 	strImport := ""
-	if packageName == "data" {
-		strImport = `
-import "enum.proto";
-
-
-`
-	} else if packageName == "protocol" || packageName == "rpc" {
-		strImport = `
-import "enum.proto";
-import "data.proto";
-
-
-`
+	if len(importList) > 0 {
+		strImport = "\n"
+		for _, strFileName := range importList {
+			strImport += `import "` + strFileName + `.proto";` + "\n"
+		}
+		strImport += "\n\n"
 	}
 
-	// 写入 Proto3 文件头
-	_, err := fileHandler.WriteString(`syntax = "proto3";
-
-// package ` + packageName + `;
-option go_package = "example/` + packageName + `";
-
-` + strImport)
+	// 写入文件头, editions 使用 edition 声明
+	strSyntax := `syntax = "` + genConfig.Syntax + `";`
+	if genConfig.Syntax == SyntaxEditions {
+		strEdition := genConfig.Edition
+		if strEdition == "" {
+			strEdition = DefaultEdition
+		}
+		strSyntax = `edition = "` + strEdition + `";`
+	}
+	fileOption := genConfig.GetFileOption(packageName)
+	_, err := fileHandler.WriteString(strSyntax + "\n\n" + fileOption.GetHeadText(packageName) + "\n" + strImport)
 	if err != nil {
 		logrus.Error("[GenProtoHead] Failed toWriteString:", err)
 		return false
@@ -102,118 +190,99 @@ option go_package = "example/` + packageName + `";
 	return true
 }
 
-func GenProtoBody(fileHandler *os.File, catatree *etree.Element) bool {
+// 按 enum/data/protocol/rpc 的顺序输出 Schema 中的单元
+func GenProtoBody(fileHandler *os.File, schema *model.Schema, genConfig StGenConfig) bool {
 	if nil == fileHandler {
-		logrus.Error("[GenProtoHead] Failed to GenProtoHead for invalid param: fileHandler.")
+		logrus.Error("[GenProtoBody] Failed to GenProtoBody for invalid param: fileHandler.")
 		return false
 	}
-	if nil == catatree {
-		logrus.Error("[GenProtoHead] Failed to GenProtoHead for invalid param: catatree.")
+	if nil == schema {
+		logrus.Error("[GenProtoBody] Failed to GenProtoBody for invalid param: schema.")
 		return false
 	}
 
-	for _, elem := range catatree.ChildElements() {
-		if !GenStruct(fileHandler, catatree.Tag, elem) {
-			logrus.Error("[GenProtoHead] GenStruct failed. catatree.Tag:", catatree.Tag)
+	for _, enum := range schema.Enums {
+		if !GenStructComment(fileHandler, enum.Comment, "") || !GenEnumStruct(fileHandler, enum, "", genConfig) {
+			logrus.Error("[GenProtoBody] Failed to GenEnumStruct. Name:", enum.Name)
 			return false
 		}
 	}
+	for _, category := range []string{model.CategoryData, model.CategoryProtocol} {
+		for _, msg := range schema.GetMessageList(category) {
+			if !GenStructComment(fileHandler, msg.Comment, "") || !GenMessageStruct(fileHandler, msg.Name, msg, "", genConfig) {
+				logrus.Error("[GenProtoBody] Failed to GenMessageStruct. Name:", msg.Name)
+				return false
+			}
+		}
+	}
+	for _, rpc := range schema.Rpcs {
+		if !GenStructComment(fileHandler, rpc.Comment, "") || !GenRpcStruct(fileHandler, rpc, genConfig) {
+			logrus.Error("[GenProtoBody] Failed to GenRpcStruct. Name:", rpc.Name)
+			return false
+		}
+	}
+	if !GenServiceStruct(fileHandler, schema.Rpcs, genConfig) {
+		logrus.Error("[GenProtoBody] Failed to GenServiceStruct.")
+		return false
+	}
 	return true
 }
 
-func GenStruct(fileHandler *os.File, structType string, structTree *etree.Element) bool {
+// 处理结构注释, strIndent 为嵌套类型的缩进
+func GenStructComment(fileHandler *os.File, comment string, strIndent string) bool {
 	if nil == fileHandler {
-		logrus.Error("[GenStruct] Failed to GenStruct for invalid param: fileHandler.")
-		return false
-	}
-	if nil == structTree {
-		logrus.Error("[GenStruct] Failed to GenStruct for invalid param: structTree.")
+		logrus.Error("[GenStructComment] Failed to GenStructComment for invalid param: fileHandler.")
 		return false
 	}
-	// 处理结构注释
-	for _, child := range structTree.Child {
-		// 检查该子元素是否为注释
-		if comment, ok := child.(*etree.Comment); ok {
-			if comment.Data != "" {
-				scanner := bufio.NewScanner(strings.NewReader(comment.Data))
-
-				for scanner.Scan() {
-					line := scanner.Text()
-					_, err := fileHandler.WriteString("// " + line + "\n")
-					if err != nil {
-						logrus.Error("[GenStruct] Failed toWriteString:", err)
-						return false
-					}
-				}
-			}
-			break
-		}
+	if comment == "" {
+		return true
 	}
-
-	if structType == "enum" {
-		if !GenEnumStruct(fileHandler, structType, structTree) {
-			logrus.Error("[GenStruct] Failed to GenEnumStruct:")
-			return false
-		}
-	} else if structType == "rpc" {
-		GenRpcStruct(fileHandler, structTree)
-	} else {
-		if !GenMessageStruct(fileHandler, structType, structTree) {
-			logrus.Error("[GenStruct] Failed to GenMessageStruct:")
+	scanner := bufio.NewScanner(strings.NewReader(comment))
+	for scanner.Scan() {
+		line := scanner.Text()
+		_, err := fileHandler.WriteString(strIndent + "// " + line + "\n")
+		if err != nil {
+			logrus.Error("[GenStructComment] Failed toWriteString:", err)
 			return false
 		}
 	}
-
 	return true
 }
 
-func GenEnumStruct(fileHandler *os.File, structType string, structTree *etree.Element) bool {
+func GenEnumStruct(fileHandler *os.File, enum *model.Enum, strIndent string, genConfig StGenConfig) bool {
 	if nil == fileHandler {
 		logrus.Error("[GenEnumStruct] Failed to GenStruct for invalid param: fileHandler.")
 		return false
 	}
-	if nil == structTree {
-		logrus.Error("[GenEnumStruct] Failed to GenStruct for invalid param: structTree.")
+	if nil == enum {
+		logrus.Error("[GenEnumStruct] Failed to GenStruct for invalid param: enum.")
 		return false
 	}
-	strStructHeadType := "message"
-	if structType == "enum" {
-		strStructHeadType = "enum"
-	}
 
-	_, err := fileHandler.WriteString(strStructHeadType + " " + structTree.Tag + " { \n")
+	_, err := fileHandler.WriteString(strIndent + "enum " + enum.Name + " { \n")
 	if err != nil {
 		logrus.Error("[GenEnumStruct] Failed toWriteString:", err)
 		return false
 	}
 
-	for _, elem := range structTree.ChildElements() {
-		etreeElemName := elem.SelectAttr("EntryName")
-		etreeElemIndex := elem.SelectAttr("EntryIndex")
-		etreeElemComment := elem.SelectAttr("EntryComment")
-		if etreeElemName == nil || etreeElemIndex == nil || etreeElemComment == nil {
-			logrus.Error("[GenEnumStruct] Failed to get entry attr, invalid format. elem:", elem)
-			return false
-		}
+	for _, value := range enum.Values {
 		// 元素数据
-		_, err := fileHandler.WriteString("	" + etreeElemName.Value + "		=	" + etreeElemIndex.Value + ";")
+		_, err := fileHandler.WriteString(strIndent + "	" + value.EntryName + "		=	" + value.EntryIndex + ";")
 		if err != nil {
 			logrus.Error("[GenEnumStruct] Failed toWriteString:", err)
 			return false
 		}
 		// 注释
-		strComment := "\n"
-		if etreeElemComment.Value != "" {
-			strComment = "	//" + etreeElemComment.Value + "	\n"
-		}
-		_, err = fileHandler.WriteString(strComment)
-		if err != nil {
-			logrus.Error("[GenEnumStruct] Failed toWriteString:", err)
+		if !GenFieldComment(fileHandler, value.EntryComment) {
+			logrus.Error("[GenEnumStruct] Failed to GenFieldComment.")
 			return false
 		}
 	}
+	if !GenReservedStruct(fileHandler, enum.Reserved, strIndent+"	", genConfig) {
+		return false
+	}
 
-	_, err = fileHandler.WriteString("} \n\n")
+	_, err = fileHandler.WriteString(strIndent + "} \n\n")
 	if err != nil {
 		logrus.Error("[GenEnumStruct] Failed toWriteString:", err)
 		return false
@@ -222,116 +291,163 @@ func GenEnumStruct(fileHandler *os.File, structType string, structTree *etree.El
 	return true
 }
 
-func GenRpcStruct(fileHandler *os.File, structTree *etree.Element) bool {
+func GenRpcStruct(fileHandler *os.File, rpc *model.Rpc, genConfig StGenConfig) bool {
 	if nil == fileHandler {
 		logrus.Error("[GenRpcStruct] Failed to GenStruct for invalid param: fileHandler.")
 		return false
 	}
-	if nil == structTree {
-		logrus.Error("[GenRpcStruct] Failed to GenStruct for invalid param: structTree.")
+	if nil == rpc {
+		logrus.Error("[GenRpcStruct] Failed to GenStruct for invalid param: rpc.")
+		return false
+	}
+	if rpc.Req != nil && !GenMessageStruct(fileHandler, rpc.Name+model.RpcTypeReq, rpc.Req, "", genConfig) {
+		logrus.Error("[GenRpcStruct] Failed to GenMessageStruct Req. Name:", rpc.Name)
+		return false
+	}
+	if rpc.Ack != nil && !GenMessageStruct(fileHandler, rpc.Name+model.RpcTypeAck, rpc.Ack, "", genConfig) {
+		logrus.Error("[GenRpcStruct] Failed to GenMessageStruct Ack. Name:", rpc.Name)
 		return false
 	}
-	for _, elemRPC := range structTree.ChildElements() {
-		strStructHeadType := "message"
-		etreeRpcType := elemRPC.SelectAttr("RpcType")
-		if etreeRpcType == nil {
-			logrus.Error("[GenRpcStruct] Failed to get RpcType, invalid format. elem:", elemRPC)
+
+	return true
+}
+
+// 输出保留的序号和名字, editions 中保留的名字不加引号
+func GenReservedStruct(fileHandler *os.File, reservedList []model.Reserved, strIndent string, genConfig StGenConfig) bool {
+	indexList, nameList := model.GetReservedIndexAndName(reservedList)
+	if len(indexList) > 0 {
+		_, err := fileHandler.WriteString(strIndent + "reserved " + strings.Join(indexList, ", ") + ";\n")
+		if err != nil {
+			logrus.Error("[GenReservedStruct] Failed toWriteString:", err)
 			return false
 		}
-		_, err := fileHandler.WriteString(strStructHeadType + " " + elemRPC.Tag + etreeRpcType.Value + " { \n")
+	}
+	if len(nameList) > 0 {
+		for i, name := range nameList {
+			if genConfig.Syntax != SyntaxEditions {
+				nameList[i] = "\"" + name + "\""
+			}
+		}
+		_, err := fileHandler.WriteString(strIndent + "reserved " + strings.Join(nameList, ", ") + ";\n")
 		if err != nil {
-			logrus.Error("[GenRpcStruct] Failed toWriteString:", err)
+			logrus.Error("[GenReservedStruct] Failed toWriteString:", err)
 			return false
 		}
+	}
+	return true
+}
 
-		for _, elem := range elemRPC.ChildElements() {
-			etreeElemOption := elem.SelectAttr("EntryOption")
-			etreeElemType := elem.SelectAttr("EntryType")
-			etreeElemName := elem.SelectAttr("EntryName")
-			etreeElemIndex := elem.SelectAttr("EntryIndex")
-			etreeElemComment := elem.SelectAttr("EntryComment")
-			if etreeElemOption == nil || etreeElemType == nil || etreeElemName == nil || etreeElemIndex == nil || etreeElemComment == nil {
-				logrus.Error("[GenRpcStruct] Failed to get entry attr, invalid format. elem:", elem)
-				return false
-			}
-			// 元素数据
-			_, err := fileHandler.WriteString("	" + etreeElemOption.Value + "	" + etreeElemType.Value + "			" + etreeElemName.Value + "	=	" + etreeElemIndex.Value + ";")
-			if err != nil {
-				logrus.Error("[GenRpcStruct] Failed toWriteString:", err)
+// 按 service 分组输出 rpc, service 按第一个 rpc 出现的顺序输出. 缺少 Req 或 Ack 的 rpc 不输出
+func GenServiceStruct(fileHandler *os.File, rpcList []*model.Rpc, genConfig StGenConfig) bool {
+	if nil == fileHandler {
+		logrus.Error("[GenServiceStruct] Failed to GenStruct for invalid param: fileHandler.")
+		return false
+	}
+	serviceNames := []string{}
+	serviceMap := map[string][]*model.Rpc{}
+	for _, rpc := range rpcList {
+		if rpc.Req == nil || rpc.Ack == nil {
+			logrus.Warn("[GenServiceStruct] rpc without Req or Ack is not in service. Name:", rpc.Name)
+			continue
+		}
+		strService := genConfig.GetServiceName(rpc.Name)
+		if _, ok := serviceMap[strService]; !ok {
+			serviceNames = append(serviceNames, strService)
+		}
+		serviceMap[strService] = append(serviceMap[strService], rpc)
+	}
+
+	for _, strService := range serviceNames {
+		_, err := fileHandler.WriteString("service " + strService + " { \n")
+		if err != nil {
+			logrus.Error("[GenServiceStruct] Failed toWriteString:", err)
+			return false
+		}
+		for _, rpc := range serviceMap[strService] {
+			if !GenStructComment(fileHandler, rpc.Comment, "	") {
 				return false
 			}
-			// 注释
-			strComment := "\n"
-			if etreeElemComment.Value != "" {
-				strComment = "	//" + etreeElemComment.Value + "	\n"
-			}
-			_, err = fileHandler.WriteString(strComment)
+			_, err := fileHandler.WriteString("	rpc " + rpc.Name + "(" + rpc.Name + model.RpcTypeReq + ") returns (" + rpc.Name + model.RpcTypeAck + ");\n")
 			if err != nil {
-				logrus.Error("[GenRpcStruct] Failed toWriteString:", err)
+				logrus.Error("[GenServiceStruct] Failed toWriteString:", err)
 				return false
 			}
 		}
-
 		_, err = fileHandler.WriteString("} \n\n")
 		if err != nil {
-			logrus.Error("[GenRpcStruct] Failed toWriteString:", err)
+			logrus.Error("[GenServiceStruct] Failed toWriteString:", err)
 			return false
 		}
 	}
-
 	return true
 }
 
-func GenMessageStruct(fileHandler *os.File, structType string, structTree *etree.Element) bool {
+// 生成 message, 嵌套的枚举和消息在字段之前输出
+func GenMessageStruct(fileHandler *os.File, structName string, msg *model.Message, strIndent string, genConfig StGenConfig) bool {
 	if nil == fileHandler {
 		logrus.Error("[GenMessageStruct] Failed to GenStruct for invalid param: fileHandler.")
 		return false
 	}
-	if nil == structTree {
-		logrus.Error("[GenMessageStruct] Failed to GenStruct for invalid param: structTree.")
+	if nil == msg {
+		logrus.Error("[GenMessageStruct] Failed to GenStruct for invalid param: msg.")
 		return false
 	}
 
-	strStructHeadType := "message"
-	if structType == "enum" {
-		strStructHeadType = "enum"
-	}
-
-	_, err := fileHandler.WriteString(strStructHeadType + " " + structTree.Tag + " { \n")
+	_, err := fileHandler.WriteString(strIndent + "message " + structName + " { \n")
 	if err != nil {
 		logrus.Error("[GenMessageStruct] Failed toWriteString:", err)
 		return false
 	}
 
-	for _, elem := range structTree.ChildElements() {
-		etreeElemOption := elem.SelectAttr("EntryOption")
-		etreeElemType := elem.SelectAttr("EntryType")
-		etreeElemName := elem.SelectAttr("EntryName")
-		etreeElemIndex := elem.SelectAttr("EntryIndex")
-		etreeElemComment := elem.SelectAttr("EntryComment")
-		if etreeElemOption == nil || etreeElemType == nil || etreeElemName == nil || etreeElemIndex == nil || etreeElemComment == nil {
-			logrus.Error("[GenMessageStruct] Failed to get entry attr, invalid format. elem:", elem)
+	// 嵌套类型多缩进一级
+	strNestedIndent := strIndent + "	"
+	for _, enum := range msg.Enums {
+		if !GenStructComment(fileHandler, enum.Comment, strNestedIndent) || !GenEnumStruct(fileHandler, enum, strNestedIndent, genConfig) {
+			logrus.Error("[GenMessageStruct] Failed to GenEnumStruct. Name:", enum.Name)
 			return false
 		}
-		// 元素数据
-		_, err := fileHandler.WriteString("	" + etreeElemOption.Value + "	" + etreeElemType.Value + "			" + etreeElemName.Value + "	=	" + etreeElemIndex.Value + ";")
+	}
+	for _, nested := range msg.Messages {
+		if !GenStructComment(fileHandler, nested.Comment, strNestedIndent) || !GenMessageStruct(fileHandler, nested.Name, nested, strNestedIndent, genConfig) {
+			logrus.Error("[GenMessageStruct] Failed to GenMessageStruct. Name:", nested.Name)
+			return false
+		}
+	}
+
+	// oneof 的字段在第一个字段处整体输出
+	writtenOneof := map[string]bool{}
+	for _, field := range msg.Fields {
+		if !field.IsOneof() {
+			if !GenFieldStruct(fileHandler, field, strIndent+"	", genConfig) {
+				return false
+			}
+			continue
+		}
+		if writtenOneof[field.EntryOneof] {
+			continue
+		}
+		writtenOneof[field.EntryOneof] = true
+		_, err := fileHandler.WriteString(strIndent + "	oneof " + field.EntryOneof + " { \n")
 		if err != nil {
 			logrus.Error("[GenMessageStruct] Failed toWriteString:", err)
 			return false
 		}
-		// 注释
-		strComment := "\n"
-		if etreeElemComment.Value != "" {
-			strComment = "	//" + etreeElemComment.Value + "	\n"
+		for _, oneofField := range msg.Fields {
+			if oneofField.EntryOneof == field.EntryOneof && !GenFieldStruct(fileHandler, oneofField, strIndent+"		", genConfig) {
+				return false
+			}
 		}
-		_, err = fileHandler.WriteString(strComment)
+		_, err = fileHandler.WriteString(strIndent + "	} \n")
 		if err != nil {
 			logrus.Error("[GenMessageStruct] Failed toWriteString:", err)
 			return false
 		}
 	}
+	if !GenReservedStruct(fileHandler, msg.Reserved, strIndent+"	", genConfig) {
+		return false
+	}
 
-	_, err = fileHandler.WriteString("} \n\n")
+	_, err = fileHandler.WriteString(strIndent + "} \n\n")
 	if err != nil {
 		logrus.Error("[GenMessageStruct] Failed toWriteString:", err)
 		return false
@@ -340,34 +456,57 @@ func GenMessageStruct(fileHandler *os.File, structType string, structTree *etree
 	return true
 }
 
-func GenPbFromProto(protopath string, outputPath string) (bool, string) {
-	if protopath == "" || !PathExists(protopath) {
-		logrus.Error("[GenPbFromProto] failed for invalid param: protopath:", protopath)
-		return false, "[GenPbFromProto] failed for invalid param"
-	}
-	if outputPath == "" || !PathExists(outputPath) {
-		logrus.Error("[GenPbFromProto] failed for invalid param: outputPath:", outputPath)
-		return false, "[GenPbFromProto] failed for invalid param"
-	}
-	logrus.Debug("[GenPbFromProto] param:protopath:", protopath, ",outputPath:", outputPath)
-	// 定义要执行的 protoc 命令，包括所有需要的参数
-	// 这里以生成 Go 相关代码为例，确保您已定义好 .proto 文件
-	command := utils.GetWorkRootPath() + "/data/protoc"
-	// args := []string{"./output_protofiles/*.proto"}
-	args := []string{"--proto_path=" + protopath, "--go_out=" + outputPath, "--go_opt=paths=source_relative", utils.GetWorkRootPath() + "/data/output_protofiles/*.proto"}
-
-	// ./protoc --proto_path=./output_protofiles --go_out=./output_pbfiles --go_opt=paths=source_relative ./output_protofiles/*.proto
-	// 使用 exec.Command 创建命令
-	cmd := exec.Command(command, args...)
-
-	// 执行命令
-	output, err := cmd.CombinedOutput()
+// 生成一行字段, map 和 oneof 中的字段没有 optional/repeated.
+// editions 中只保留 repeated, required 转为 field_presence 特性
+func GenFieldStruct(fileHandler *os.File, field model.Field, strIndent string, genConfig StGenConfig) bool {
+	strOption := field.EntryOption + "	"
+	if field.IsMap() || field.IsOneof() {
+		strOption = ""
+	} else if genConfig.Syntax == SyntaxEditions && field.EntryOption != model.OptionRepeated {
+		strOption = ""
+	}
+	fieldOptions := []string{}
+	if genConfig.Syntax == SyntaxEditions && field.EntryOption == model.OptionRequired && !field.IsOneof() {
+		fieldOptions = append(fieldOptions, "features.field_presence = LEGACY_REQUIRED")
+	}
+	if genConfig.HasDefaultValue() && field.EntryDefault != "" && field.EntryOption != model.OptionRepeated && !field.IsMap() {
+		fieldOptions = append(fieldOptions, "default = "+GetDefaultValueText(field.EntryType, field.EntryDefault))
+	}
+	strFieldOptions := ""
+	if len(fieldOptions) > 0 {
+		strFieldOptions = " [" + strings.Join(fieldOptions, ", ") + "]"
+	}
+	_, err := fileHandler.WriteString(strIndent + strOption + field.GetProtoType() + "			" + field.EntryName + "	=	" + field.EntryIndex + strFieldOptions + ";")
 	if err != nil {
-		logrus.Error("Error executing protoc command:", err, ",output:", string(output), ",command:", command)
-		return false, err.Error() + string(output)
+		logrus.Error("[GenFieldStruct] Failed toWriteString:", err)
+		return false
+	}
+	// 注释
+	if !GenFieldComment(fileHandler, field.EntryComment) {
+		logrus.Error("[GenFieldStruct] Failed to GenFieldComment.")
+		return false
+	}
+	return true
+}
+
+// 默认值在 proto 中的写法, string/bytes 需要加引号
+func GetDefaultValueText(strType string, strDefault string) string {
+	if strType == "string" || strType == "bytes" {
+		return strconv.Quote(strDefault)
 	}
+	return strDefault
+}
 
-	// 打印命令输出
-	logrus.Info("protoc command output:", string(output))
-	return true, ""
+// 字段行尾注释
+func GenFieldComment(fileHandler *os.File, comment string) bool {
+	strComment := "\n"
+	if comment != "" {
+		strComment = "	//" + comment + "	\n"
+	}
+	_, err := fileHandler.WriteString(strComment)
+	if err != nil {
+		logrus.Error("[GenFieldComment] Failed toWriteString:", err)
+		return false
+	}
+	return true
 }
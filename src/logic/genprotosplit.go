@@ -0,0 +1,199 @@
+package logic
+
+import (
+	"sort"
+
+	"protocolgo/src/model"
+)
+
+// 输出文件的拆分方式
+const (
+	SplitCategory = "category" // 每个分类一个文件: enum.proto, data.proto, protocol.proto, rpc.proto
+	SplitPair     = "pair"     // protocol/rpc 按源/目标服务器对拆分, 如 CS.proto, GSMS.proto. enum/data 仍按分类输出
+)
+
+// 文件中单元的输出顺序, 嵌套类型保持 xml 中的顺序
+const (
+	OrderXml  = "xml"  // 与 xml 中的顺序相同
+	OrderName = "name" // 按名字排序
+	OrderTopo = "topo" // 按依赖排序, 被引用的在前, 其余按名字排序
+)
+
+func GetOrderList() []string {
+	return []string{OrderXml, OrderName, OrderTopo}
+}
+
+func IsValidOrder(order string) bool {
+	for _, v := range GetOrderList() {
+		if v == order {
+			return true
+		}
+	}
+	return false
+}
+
+func GetSplitList() []string {
+	return []string{SplitCategory, SplitPair}
+}
+
+func IsValidSplit(split string) bool {
+	for _, v := range GetSplitList() {
+		if v == split {
+			return true
+		}
+	}
+	return false
+}
+
+// 一个输出文件, Schema 中只包含该文件的单元
+type StGenFile struct {
+	FileName string // 不含 .proto 的文件名
+	Schema   *model.Schema
+	Imports  []string // import 的文件名, 不含 .proto
+}
+
+// 获取协议所在的输出文件名. 按服务器对拆分时, 能识别前缀的 protocol/rpc 放到前缀对应的文件
+func (genConfig *StGenConfig) GetUnitFileName(category string, unitName string) string {
+	if genConfig.Split != SplitPair || genConfig.PairName == nil {
+		return category
+	}
+	if category != model.CategoryProtocol && category != model.CategoryRpc {
+		return category
+	}
+	if strPair := genConfig.PairName(unitName); strPair != "" {
+		return strPair
+	}
+	return category
+}
+
+// 按配置拆分输出文件, 并根据字段引用的类型计算每个文件的 import.
+// 分类文件按 enum/data/protocol/rpc 的顺序, 服务器对文件按文件名排序
+func SplitSchemaToFiles(schema *model.Schema, genConfig StGenConfig) []StGenFile {
+	fileSchemaMap := map[string]*model.Schema{}
+	getFileSchema := func(category string, unitName string) *model.Schema {
+		strFileName := genConfig.GetUnitFileName(category, unitName)
+		if fileSchemaMap[strFileName] == nil {
+			fileSchemaMap[strFileName] = model.NewSchema()
+		}
+		return fileSchemaMap[strFileName]
+	}
+	for _, enum := range schema.Enums {
+		fileSchema := getFileSchema(model.CategoryEnum, enum.Name)
+		fileSchema.Enums = append(fileSchema.Enums, enum)
+	}
+	for _, msg := range schema.Datas {
+		fileSchema := getFileSchema(model.CategoryData, msg.Name)
+		fileSchema.Datas = append(fileSchema.Datas, msg)
+	}
+	for _, msg := range schema.Protocols {
+		fileSchema := getFileSchema(model.CategoryProtocol, msg.Name)
+		fileSchema.Protocols = append(fileSchema.Protocols, msg)
+	}
+	for _, rpc := range schema.Rpcs {
+		fileSchema := getFileSchema(model.CategoryRpc, rpc.Name)
+		fileSchema.Rpcs = append(fileSchema.Rpcs, rpc)
+	}
+	if genConfig.Order == OrderName || genConfig.Order == OrderTopo {
+		graph := schema.GetDepGraph()
+		for _, fileSchema := range fileSchemaMap {
+			sortFileUnits(fileSchema, graph, genConfig.Order)
+		}
+	}
+
+	// 分类文件即使为空也输出, 与之前保持一致
+	fileNames := model.GetCategoryList()
+	pairNames := []string{}
+	for strFileName := range fileSchemaMap {
+		if !isCategoryFileName(strFileName) {
+			pairNames = append(pairNames, strFileName)
+		}
+	}
+	sort.Strings(pairNames)
+	fileNames = append(fileNames, pairNames...)
+
+	result := []StGenFile{}
+	for _, strFileName := range fileNames {
+		fileSchema := fileSchemaMap[strFileName]
+		if fileSchema == nil {
+			fileSchema = model.NewSchema()
+		}
+		result = append(result, StGenFile{FileName: strFileName, Schema: fileSchema})
+	}
+	fileMap := GetGenFileMap(result)
+	typeMap := schema.GetTypeMap()
+	for i := range result {
+		result[i].Imports = getFileImports(result[i], fileNames, fileMap, typeMap)
+	}
+	return result
+}
+
+// 类型全名到输出文件名的映射
+func GetGenFileMap(fileList []StGenFile) map[string]string {
+	result := map[string]string{}
+	for _, genFile := range fileList {
+		strFileName := genFile.FileName
+		genFile.Schema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+			result[path] = strFileName
+		})
+	}
+	return result
+}
+
+// 计算文件引用的其他文件, 按 fileNames 的顺序
+func getFileImports(genFile StGenFile, fileNames []string, fileMap map[string]string, typeMap map[string]bool) []string {
+	importMap := map[string]bool{}
+	genFile.Schema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		if msg == nil {
+			return
+		}
+		for _, field := range msg.Fields {
+			strTypePath := model.ResolveTypeName(typeMap, path, field.EntryType)
+			if strFileName, ok := fileMap[strTypePath]; ok && strFileName != genFile.FileName {
+				importMap[strFileName] = true
+			}
+		}
+	})
+	result := []string{}
+	for _, strFileName := range fileNames {
+		if importMap[strFileName] {
+			result = append(result, strFileName)
+		}
+	}
+	return result
+}
+
+// 按配置的顺序排列文件中每个分类的单元. 只改变文件 schema 中的列表, 不影响原 schema
+func sortFileUnits(fileSchema *model.Schema, graph *model.DepGraph, order string) {
+	getRank := func(names []string) map[string]int {
+		if order == OrderTopo {
+			names = graph.TopoSort(names)
+		} else {
+			names = append([]string{}, names...)
+			sort.Strings(names)
+		}
+		result := map[string]int{}
+		for i, strName := range names {
+			result[strName] = i
+		}
+		return result
+	}
+	rank := getRank(fileSchema.GetUnitNames(model.CategoryEnum))
+	sort.SliceStable(fileSchema.Enums, func(i, j int) bool { return rank[fileSchema.Enums[i].Name] < rank[fileSchema.Enums[j].Name] })
+	rank = getRank(fileSchema.GetUnitNames(model.CategoryData))
+	sort.SliceStable(fileSchema.Datas, func(i, j int) bool { return rank[fileSchema.Datas[i].Name] < rank[fileSchema.Datas[j].Name] })
+	rank = getRank(fileSchema.GetUnitNames(model.CategoryProtocol))
+	sort.SliceStable(fileSchema.Protocols, func(i, j int) bool {
+		return rank[fileSchema.Protocols[i].Name] < rank[fileSchema.Protocols[j].Name]
+	})
+	rank = getRank(fileSchema.GetUnitNames(model.CategoryRpc))
+	sort.SliceStable(fileSchema.Rpcs, func(i, j int) bool { return rank[fileSchema.Rpcs[i].Name] < rank[fileSchema.Rpcs[j].Name] })
+}
+
+func isCategoryFileName(strFileName string) bool {
+	for _, category := range model.GetCategoryList() {
+		if category == strFileName {
+			return true
+		}
+	}
+	return false
+}
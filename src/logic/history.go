@@ -0,0 +1,55 @@
+package logic
+
+import (
+	"protocolgo/src/model"
+)
+
+// 撤销历史最多保留的修改数
+const HistoryMaxCount = 100
+
+// 一次修改, 保存修改前后的数据模型, 撤销和重做时整体替换 ShowSchema
+type StCommand struct {
+	Name   string // 展示文本, 如 delete Bag
+	Before *model.Schema
+	After  *model.Schema
+}
+
+// 撤销/重做历史. Cursor 为已执行的修改数, 之前的可以撤销, 之后的可以重做
+type StHistory struct {
+	Commands []StCommand
+	Cursor   int
+}
+
+// 记录一次新的修改, 丢弃可以重做的修改, 超过上限时丢弃最早的修改
+func (history *StHistory) Push(command StCommand) {
+	history.Commands = append(history.Commands[:history.Cursor], command)
+	if len(history.Commands) > HistoryMaxCount {
+		history.Commands = history.Commands[len(history.Commands)-HistoryMaxCount:]
+	}
+	history.Cursor = len(history.Commands)
+}
+
+// 清空历史, 打开新的 xml 时调用
+func (history *StHistory) Clear() {
+	history.Commands = nil
+	history.Cursor = 0
+}
+
+func (history *StHistory) CanUndo() bool {
+	return history.Cursor > 0
+}
+
+func (history *StHistory) CanRedo() bool {
+	return history.Cursor < len(history.Commands)
+}
+
+// 获取执行了前 cursor 个修改后的数据模型, cursor 超出范围时返回 nil
+func (history *StHistory) GetSchemaAt(cursor int) *model.Schema {
+	if cursor < 0 || cursor > len(history.Commands) || len(history.Commands) == 0 {
+		return nil
+	}
+	if cursor == 0 {
+		return history.Commands[0].Before
+	}
+	return history.Commands[cursor-1].After
+}
@@ -0,0 +1,93 @@
+package logic
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+func TestHistoryPush(t *testing.T) {
+	var history StHistory
+	if history.CanUndo() || history.CanRedo() || history.GetSchemaAt(0) != nil {
+		t.Fatal("empty history")
+	}
+	schemaList := []*model.Schema{}
+	for i := 0; i <= HistoryMaxCount+1; i++ {
+		schemaList = append(schemaList, &model.Schema{})
+	}
+	for i := 0; i < 3; i++ {
+		history.Push(StCommand{Name: strconv.Itoa(i), Before: schemaList[i], After: schemaList[i+1]})
+	}
+	if history.GetSchemaAt(0) != schemaList[0] || history.GetSchemaAt(3) != schemaList[3] || history.GetSchemaAt(4) != nil {
+		t.Error("GetSchemaAt")
+	}
+
+	// 撤销后的新修改丢弃可以重做的修改
+	history.Cursor = 1
+	history.Push(StCommand{Name: "new", Before: schemaList[1], After: schemaList[5]})
+	if len(history.Commands) != 2 || history.Cursor != 2 || history.CanRedo() || history.GetSchemaAt(2) != schemaList[5] {
+		t.Errorf("push after undo: got %d commands, cursor %d", len(history.Commands), history.Cursor)
+	}
+
+	// 超过上限时丢弃最早的修改
+	history.Clear()
+	for i := 0; i <= HistoryMaxCount; i++ {
+		history.Push(StCommand{Name: strconv.Itoa(i), Before: schemaList[i], After: schemaList[i+1]})
+	}
+	if len(history.Commands) != HistoryMaxCount || history.Cursor != HistoryMaxCount || history.Commands[0].Name != "1" {
+		t.Errorf("max count: got %d commands, cursor %d, first %s", len(history.Commands), history.Cursor, history.Commands[0].Name)
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	coremgr := newTestCoreManager()
+	if !coremgr.ReadXmlFromReader(strings.NewReader(testSchemaXml), filepath.Join(t.TempDir(), "protocolgo.xml")) {
+		t.Fatal("ReadXmlFromReader failed")
+	}
+	if isOk, strName := coremgr.Undo(); isOk || strName != "nothing to undo" {
+		t.Fatalf("undo without history: got %v %s", isOk, strName)
+	}
+	for _, names := range [][2]string{{"Role", "Hero"}, {"Hero", "Player"}} {
+		if isOk, _, strError := coremgr.Rename(names[0], "", names[1], true); !isOk {
+			t.Fatalf("rename %s failed: %s", names[0], strError)
+		}
+	}
+
+	// 每一步检查当前的类型名和列表
+	steps := []struct {
+		name     string
+		action   func() (bool, string)
+		wantName string
+		want     string
+	}{
+		{"undo", coremgr.Undo, "rename Hero to Player", "Hero"},
+		{"undo", coremgr.Undo, "rename Role to Hero", "Role"},
+		{"undo", coremgr.Undo, "nothing to undo", "Role"},
+		{"redo", coremgr.Redo, "rename Role to Hero", "Hero"},
+		{"redo", coremgr.Redo, "rename Hero to Player", "Player"},
+		{"redo", coremgr.Redo, "nothing to redo", "Player"},
+	}
+	for i, step := range steps {
+		_, strName := step.action()
+		if strName != step.wantName {
+			t.Errorf("step %d %s: got %q, want %q", i, step.name, strName, step.wantName)
+		}
+		if coremgr.ShowSchema.FindMessage(model.CategoryData, step.want) == nil {
+			t.Errorf("step %d %s: data %s is not found", i, step.name, step.want)
+		}
+		if dataList, _ := coremgr.DataTableList.Get(); !reflect.DeepEqual(dataList, []string{"Bag", step.want}) {
+			t.Errorf("step %d %s: data list got %v", i, step.name, dataList)
+		}
+	}
+
+	// 撤销后的新修改丢弃可以重做的修改
+	coremgr.Undo()
+	coremgr.Rename("Hero", "", "Avatar", true)
+	if coremgr.History.CanRedo() || len(coremgr.History.Commands) != 2 {
+		t.Errorf("edit after undo: got %d commands, can redo %v", len(coremgr.History.Commands), coremgr.History.CanRedo())
+	}
+}
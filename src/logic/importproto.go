@@ -0,0 +1,478 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// proto 导入器, 记录已解析的文件和无法表示的内容
+type stProtoImporter struct {
+	rootPath       string
+	isProtocolName func(string) bool
+	genConfig      StGenConfig     // 目标语法, 决定 required 和默认值能否保留
+	fileList       []*StProtoFile  // 按依赖顺序排列, 被导入的文件在前
+	loaded         map[string]bool // 已解析的文件绝对路径
+	reports        []string
+
+	enumMap    map[string]*model.Enum // 本次导入的枚举, key 为全名
+	typeMap    map[string]bool        // 本次导入和 xml 中已有的类型全名
+	packageMap map[string]bool        // 出现过的 package
+}
+
+// 导入 proto 文件或目录(递归)到 schema, 会跟随 import 导入依赖的文件.
+// isProtocolName 用于判断消息是否为协议, 为 nil 时全部导入为 data.
+// genConfig 为项目的 proto 语法, 该语法无法表示的内容会被记录.
+// 返回是否成功以及所有无法表示的内容, 失败时 schema 不会被修改.
+func ImportProto(schema *model.Schema, protoPath string, isProtocolName func(string) bool, genConfig StGenConfig) (bool, []string) {
+	if nil == schema {
+		logrus.Error("[ImportProto] failed for invalid param: schema.")
+		return false, []string{"invalid schema"}
+	}
+	info, err := os.Stat(protoPath)
+	if err != nil {
+		logrus.Error("[ImportProto] failed for invalid param: protoPath:", protoPath, ", err:", err)
+		return false, []string{err.Error()}
+	}
+	importer := &stProtoImporter{
+		isProtocolName: isProtocolName,
+		genConfig:      genConfig,
+		loaded:         map[string]bool{},
+		enumMap:        map[string]*model.Enum{},
+		typeMap:        schema.GetTypeMap(),
+		packageMap:     map[string]bool{},
+	}
+	if importer.isProtocolName == nil {
+		importer.isProtocolName = func(string) bool { return false }
+	}
+
+	// 收集要导入的文件
+	pathList := []string{}
+	if info.IsDir() {
+		importer.rootPath = protoPath
+		err = filepath.Walk(protoPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".proto") {
+				pathList = append(pathList, path)
+			}
+			return nil
+		})
+		if err != nil {
+			logrus.Error("[ImportProto] walk dir failed. protoPath:", protoPath, ", err:", err)
+			return false, []string{err.Error()}
+		}
+		sort.Strings(pathList)
+	} else {
+		importer.rootPath = filepath.Dir(protoPath)
+		pathList = append(pathList, protoPath)
+	}
+	for _, path := range pathList {
+		if err := importer.loadFile(path); err != nil {
+			logrus.Error("[ImportProto] parse failed. err:", err)
+			return false, append(importer.reports, err.Error())
+		}
+	}
+
+	// 先建立类型索引, 再转换
+	for _, file := range importer.fileList {
+		importer.indexFile(file)
+	}
+	result := model.NewSchema()
+	// 枚举需要先转换, 字段的默认值会用到
+	for _, file := range importer.fileList {
+		importer.convertFileEnums(schema, file, result)
+	}
+	for _, file := range importer.fileList {
+		importer.convertFileMessages(schema, file, result)
+	}
+
+	// 全部转换完再写入, 保证失败时不修改 schema
+	for _, enum := range result.Enums {
+		schema.PutEnum(enum)
+	}
+	for _, category := range []string{model.CategoryData, model.CategoryProtocol} {
+		for _, msg := range result.GetMessageList(category) {
+			schema.PutMessage(category, msg)
+		}
+	}
+	for _, rpc := range result.Rpcs {
+		schema.PutRpc(rpc)
+	}
+	logrus.Info("[ImportProto] done. protoPath:", protoPath, ", files:", len(importer.fileList), ", enum:", len(result.Enums), ", data:", len(result.Datas), ", protocol:", len(result.Protocols), ", rpc:", len(result.Rpcs), ", reports:", len(importer.reports))
+	return true, importer.reports
+}
+
+// 记录无法表示的内容
+func (importer *stProtoImporter) report(file *StProtoFile, line int, format string, args ...interface{}) {
+	strPath := file.Path
+	if relPath, err := filepath.Rel(importer.rootPath, file.Path); err == nil && !strings.HasPrefix(relPath, "..") {
+		strPath = relPath
+	}
+	strReport := fmt.Sprintf("%s:%d: %s", strPath, line, fmt.Sprintf(format, args...))
+	logrus.Warn("[ImportProto] ", strReport)
+	importer.reports = append(importer.reports, strReport)
+}
+
+// 解析文件, 依赖的文件排在前面
+func (importer *stProtoImporter) loadFile(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if importer.loaded[absPath] {
+		return nil
+	}
+	importer.loaded[absPath] = true
+	file, err := ParseProtoFile(path)
+	if err != nil {
+		return err
+	}
+	for _, strImport := range file.Imports {
+		strImportPath := ""
+		for _, dir := range []string{filepath.Dir(path), importer.rootPath} {
+			if candidate := filepath.Join(dir, strImport); PathExists(candidate) {
+				strImportPath = candidate
+				break
+			}
+		}
+		if strImportPath == "" {
+			importer.report(file, file.ImportLines[strImport], "import %q not found, types from it can not be resolved", strImport)
+			continue
+		}
+		if err := importer.loadFile(strImportPath); err != nil {
+			return err
+		}
+	}
+	importer.fileList = append(importer.fileList, file)
+	return nil
+}
+
+// 建立类型索引, 嵌套类型以 Outer.Inner 的全名记录
+func (importer *stProtoImporter) indexFile(file *StProtoFile) {
+	if file.Package != "" {
+		importer.packageMap[file.Package] = true
+	}
+	for _, enum := range file.Enums {
+		importer.indexEnum(enum.Name)
+	}
+	for _, msg := range file.Messages {
+		importer.indexMessage(msg, msg.Name)
+	}
+}
+
+func (importer *stProtoImporter) indexEnum(strPath string) {
+	importer.typeMap[strPath] = true
+	if _, ok := importer.enumMap[strPath]; !ok {
+		importer.enumMap[strPath] = nil
+	}
+}
+
+func (importer *stProtoImporter) indexMessage(msg *StProtoMessage, strPath string) {
+	importer.typeMap[strPath] = true
+	for _, enum := range msg.Enums {
+		importer.indexEnum(strPath + "." + enum.Name)
+	}
+	for _, nested := range msg.Messages {
+		importer.indexMessage(nested, strPath+"."+nested.Name)
+	}
+}
+
+// 把引用的类型名转为 xml 中的类型全名, 去掉 package 前缀. strScope 为字段所在消息的全名
+func (importer *stProtoImporter) resolveType(file *StProtoFile, strScope string, strType string) (string, bool) {
+	if model.IsScalarType(strType) {
+		return strType, true
+	}
+	strName := strType
+	strAbsName := strings.TrimPrefix(strType, ".")
+	if file.Package != "" && strings.HasPrefix(strAbsName, file.Package+".") {
+		strName = "." + strings.TrimPrefix(strAbsName, file.Package+".")
+	} else {
+		for strPackage := range importer.packageMap {
+			if strings.HasPrefix(strAbsName, strPackage+".") {
+				strName = "." + strings.TrimPrefix(strAbsName, strPackage+".")
+				break
+			}
+		}
+	}
+	if strPath := model.ResolveTypeName(importer.typeMap, strScope, strName); strPath != "" {
+		return strPath, true
+	}
+	return strings.TrimPrefix(strName, "."), false
+}
+
+// 获取枚举, 先找本次导入的, 再找 xml 中已有的
+func (importer *stProtoImporter) findEnum(schema *model.Schema, strPath string) (*model.Enum, bool) {
+	if enum := importer.enumMap[strPath]; enum != nil {
+		return enum, true
+	}
+	if enum := schema.FindEnumByPath(strPath); enum != nil {
+		return enum, true
+	}
+	_, ok := importer.enumMap[strPath]
+	return nil, ok
+}
+
+// 检查名字是否可用, 不可用时记录原因
+func (importer *stProtoImporter) checkUnitName(schema *model.Schema, result *model.Schema, file *StProtoFile, line int, strName string) bool {
+	if category := schema.FindCategory(strName); category != "" {
+		importer.report(file, line, "%s already exists in %s, skipped", strName, category)
+		return false
+	}
+	if category := result.FindCategory(strName); category != "" {
+		importer.report(file, line, "%s is declared more than once, skipped", strName)
+		return false
+	}
+	return true
+}
+
+// 转换文件中的枚举, 同时记录文件级别无法表示的内容
+func (importer *stProtoImporter) convertFileEnums(schema *model.Schema, file *StProtoFile, result *model.Schema) {
+	if file.Syntax != importer.genConfig.Syntax {
+		importer.report(file, max(file.SyntaxLine, 1), "syntax %s is different from the configured %s", file.Syntax, importer.genConfig.Syntax)
+	}
+	if file.Package != "" {
+		importer.report(file, file.PackageLine, "package %s is ignored", file.Package)
+	}
+	for _, strImport := range file.PublicImports {
+		importer.report(file, file.ImportLines[strImport], "import public %q is imported as a normal import", strImport)
+	}
+	for _, option := range file.Options {
+		importer.report(file, option.Line, "file option %s is ignored", option.Name)
+	}
+	for _, extend := range file.Extends {
+		importer.report(file, extend.Line, "extend %s is not supported, skipped", extend.Extendee)
+	}
+	for _, service := range file.Services {
+		importer.report(file, service.Line, "service %s with %d rpc(s) is not supported, skipped", service.Name, len(service.Methods))
+	}
+
+	for _, protoEnum := range file.Enums {
+		if !importer.checkUnitName(schema, result, file, protoEnum.Line, protoEnum.Name) {
+			continue
+		}
+		enum := importer.convertEnum(file, protoEnum)
+		importer.enumMap[enum.Name] = enum
+		result.PutEnum(enum)
+	}
+	// 嵌套枚举也先转换, 所在消息转换时再挂上去
+	for _, protoMsg := range file.Messages {
+		importer.convertNestedEnums(file, protoMsg, protoMsg.Name)
+	}
+}
+
+func (importer *stProtoImporter) convertNestedEnums(file *StProtoFile, protoMsg *StProtoMessage, strPath string) {
+	for _, protoEnum := range protoMsg.Enums {
+		importer.enumMap[strPath+"."+protoEnum.Name] = importer.convertEnum(file, protoEnum)
+	}
+	for _, nested := range protoMsg.Messages {
+		importer.convertNestedEnums(file, nested, strPath+"."+nested.Name)
+	}
+}
+
+// 转换文件中的消息, 成对的 Req/Ack 协议转为 rpc
+func (importer *stProtoImporter) convertFileMessages(schema *model.Schema, file *StProtoFile, result *model.Schema) {
+	messageMap := map[string]*StProtoMessage{}
+	for _, protoMsg := range file.Messages {
+		messageMap[protoMsg.Name] = protoMsg
+	}
+	for _, protoMsg := range file.Messages {
+		// XxxReq/XxxAck 成对出现且 Xxx 是协议名时, 合并为 rpc Xxx
+		if strings.HasSuffix(protoMsg.Name, model.RpcTypeReq) || strings.HasSuffix(protoMsg.Name, model.RpcTypeAck) {
+			strRpcName := protoMsg.Name[:len(protoMsg.Name)-len(model.RpcTypeReq)]
+			protoReq, hasReq := messageMap[strRpcName+model.RpcTypeReq]
+			protoAck, hasAck := messageMap[strRpcName+model.RpcTypeAck]
+			if hasReq && hasAck && importer.isProtocolName(strRpcName) {
+				if protoMsg != protoReq {
+					// 在 Req 处统一处理
+					continue
+				}
+				if !importer.checkUnitName(schema, result, file, protoReq.Line, strRpcName) {
+					continue
+				}
+				rpc := &model.Rpc{Name: strRpcName}
+				rpc.SetComment(protoReq.Comment)
+				rpc.Req = importer.convertMessage(schema, file, protoReq, protoReq.Name)
+				rpc.Ack = importer.convertMessage(schema, file, protoAck, protoAck.Name)
+				rpc.Req.Name = strRpcName
+				rpc.Ack.Name = strRpcName
+				rpc.Req.SetComment("")
+				rpc.Ack.SetComment("")
+				if protoAck.Comment != "" && protoAck.Comment != protoReq.Comment {
+					importer.report(file, protoAck.Line, "comment of %s is dropped, rpc %s keeps the comment of %s", protoAck.Name, strRpcName, protoReq.Name)
+				}
+				result.PutRpc(rpc)
+				continue
+			}
+		}
+		if !importer.checkUnitName(schema, result, file, protoMsg.Line, protoMsg.Name) {
+			continue
+		}
+		category := model.CategoryData
+		if importer.isProtocolName(protoMsg.Name) {
+			category = model.CategoryProtocol
+		}
+		result.PutMessage(category, importer.convertMessage(schema, file, protoMsg, protoMsg.Name))
+	}
+}
+
+func (importer *stProtoImporter) convertEnum(file *StProtoFile, protoEnum *StProtoEnum) *model.Enum {
+	enum := &model.Enum{Name: protoEnum.Name, Values: []model.Field{}}
+	enum.SetComment(protoEnum.Comment)
+	for _, option := range protoEnum.Options {
+		importer.report(file, option.Line, "enum %s option %s is ignored", protoEnum.Name, option.Name)
+	}
+	enum.Reserved = convertReserved(protoEnum.Reserved)
+	for _, value := range protoEnum.Values {
+		if strings.HasPrefix(value.Number, "-") {
+			importer.report(file, value.Line, "enum value %s.%s = %s is negative, skipped", protoEnum.Name, value.Name, value.Number)
+			continue
+		}
+		for _, option := range value.Options {
+			importer.report(file, value.Line, "enum value %s.%s option %s is ignored", protoEnum.Name, value.Name, option.Name)
+		}
+		enum.Values = append(enum.Values, model.Field{EntryName: value.Name, EntryIndex: value.Number, EntryComment: value.Comment})
+	}
+	return enum
+}
+
+// 转换保留的序号范围和名字, 以数字或负号开头的是序号
+func convertReserved(reservedList []string) []model.Reserved {
+	var result []model.Reserved
+	for _, strReserved := range reservedList {
+		if strReserved == "" {
+			continue
+		}
+		if strReserved[0] == '-' || (strReserved[0] >= '0' && strReserved[0] <= '9') {
+			result = append(result, model.Reserved{EntryIndex: strReserved})
+		} else {
+			result = append(result, model.Reserved{EntryName: strReserved})
+		}
+	}
+	return result
+}
+
+// 转换消息及其嵌套类型, strPath 为消息的全名
+func (importer *stProtoImporter) convertMessage(schema *model.Schema, file *StProtoFile, protoMsg *StProtoMessage, strPath string) *model.Message {
+	msg := &model.Message{Name: protoMsg.Name, Fields: []model.Field{}}
+	msg.SetComment(protoMsg.Comment)
+	for _, option := range protoMsg.Options {
+		importer.report(file, option.Line, "message %s option %s is ignored", protoMsg.Name, option.Name)
+	}
+	msg.Reserved = convertReserved(protoMsg.Reserved)
+	if len(protoMsg.Extensions) > 0 {
+		importer.report(file, protoMsg.Line, "message %s extensions %s is ignored", protoMsg.Name, strings.Join(protoMsg.Extensions, ", "))
+	}
+	for _, nested := range protoMsg.Enums {
+		if enum := importer.enumMap[strPath+"."+nested.Name]; enum != nil {
+			msg.PutNestedEnum(enum)
+		}
+	}
+	for _, nested := range protoMsg.Messages {
+		msg.PutNestedMessage(importer.convertMessage(schema, file, nested, strPath+"."+nested.Name))
+	}
+	for _, extend := range protoMsg.Extends {
+		importer.report(file, extend.Line, "extend %s in message %s is not supported, skipped", extend.Extendee, protoMsg.Name)
+	}
+	for _, oneof := range protoMsg.Oneofs {
+		for _, option := range oneof.Options {
+			importer.report(file, option.Line, "oneof %s.%s option %s is ignored", protoMsg.Name, oneof.Name, option.Name)
+		}
+	}
+
+	// 跳过的字段记录为保留, 防止之后重用它的序号和名字
+	reserveSkipped := func(strName string, strIndex string) {
+		msg.Reserved = model.AddReserved(msg.Reserved, model.Reserved{EntryIndex: strIndex, EntryName: strName})
+	}
+	for _, protoField := range protoMsg.Fields {
+		if protoField.Group != nil {
+			importer.report(file, protoField.Line, "group %s.%s is not supported, skipped and reserved", protoMsg.Name, protoField.Name)
+			// group 的字段名为类型名的小写
+			reserveSkipped(strings.ToLower(protoField.Name), protoField.Number)
+			continue
+		}
+		// map 字段的类型为 value 类型
+		strProtoType := protoField.Type
+		if protoField.Type == "map" {
+			strProtoType = protoField.ValueType
+			if !model.IsMapKeyType(protoField.KeyType) {
+				importer.report(file, protoField.Line, "map field %s.%s has invalid key type %s, skipped and reserved", protoMsg.Name, protoField.Name, protoField.KeyType)
+				reserveSkipped(protoField.Name, protoField.Number)
+				continue
+			}
+		}
+		strType, isOk := importer.resolveType(file, strPath, strProtoType)
+		if !isOk {
+			importer.report(file, protoField.Line, "field %s.%s uses unknown type %s, skipped and reserved", protoMsg.Name, protoField.Name, strProtoType)
+			reserveSkipped(protoField.Name, protoField.Number)
+			continue
+		}
+
+		field := model.Field{EntryOption: model.OptionOptional, EntryType: strType, EntryName: protoField.Name, EntryIndex: protoField.Number, EntryComment: protoField.Comment}
+		if protoField.Type == "map" {
+			field.EntryOption = model.OptionMap
+			field.EntryKeyType = protoField.KeyType
+		} else if protoField.Label == "repeated" {
+			field.EntryOption = model.OptionRepeated
+		} else if protoField.OneofName != "" {
+			field.EntryOneof = protoField.OneofName
+		} else if protoField.Label == "required" {
+			if importer.genConfig.IsValidFieldOption(model.OptionRequired) {
+				field.EntryOption = model.OptionRequired
+			} else {
+				importer.report(file, protoField.Line, "field %s.%s is required, imported as optional", protoMsg.Name, protoField.Name)
+			}
+		}
+		enum, isEnum := importer.findEnum(schema, strType)
+		for _, option := range protoField.Options {
+			if option.Name == "default" && isEnum && enum != nil && enum.FindValue(option.Value) != nil {
+				field.EntryDefault = option.Value
+				continue
+			}
+			// 标量默认值, 字符串已经去掉了引号
+			if option.Name == "default" && importer.genConfig.HasDefaultValue() && model.IsScalarType(strType) && CheckDefaultValue(strType, option.Value) {
+				field.EntryDefault = option.Value
+				continue
+			}
+			importer.report(file, option.Line, "field %s.%s option %s = %s is ignored", protoMsg.Name, protoField.Name, option.Name, option.Value)
+		}
+		// 枚举字段需要默认值, 与 proto3 一致取第一个枚举值
+		if isEnum && enum != nil && field.EntryDefault == "" && len(enum.Values) > 0 {
+			field.EntryDefault = enum.Values[0].EntryName
+		}
+		msg.Fields = append(msg.Fields, field)
+	}
+	return msg
+}
+
+// 判断名字是否为协议名, 即前缀能对应到配置中的两个服务器
+func (Stapp *CoreManager) IsProtocolName(name string) bool {
+	if nil == Stapp.Config {
+		return false
+	}
+	result, firstName, secondName := Stapp.DetectFullNameByProtoName(name)
+	return result && firstName != "" && secondName != ""
+}
+
+// 导入 proto 文件或目录到展示数据中, 保存后才写入文件
+func (Stapp *CoreManager) ImportProto(protoPath string) (bool, []string) {
+	if nil == Stapp.ShowSchema {
+		logrus.Error("ImportProto failed. Stapp.ShowSchema is nil, open the xml")
+		return false, []string{"no opened xml"}
+	}
+	before := Stapp.CopyShowSchema()
+	isSuccess, reports := ImportProto(Stapp.ShowSchema, protoPath, Stapp.IsProtocolName, Stapp.GetGenConfig())
+	if !isSuccess {
+		return false, reports
+	}
+	Stapp.ApplyShowSchema()
+	Stapp.RecordHistory("import "+filepath.Base(protoPath), before)
+	return true, reports
+}
@@ -0,0 +1,147 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 测试用的导入文件, role.proto 包含不支持的 group, 非法的 map key 和未知类型
+var testImportFiles = map[string]string{
+	"item.proto": `syntax = "proto3";
+package pb;
+
+// 道具类型
+enum ItemType {
+    ItemType_None = 0;
+    ItemType_Weapon = 1; // 武器
+    reserved 5;
+}
+`,
+	"role.proto": `syntax = "proto2";
+package pb;
+import "item.proto";
+
+message Role {
+    optional int32 id = 1 [default = 5];
+    repeated ItemType items = 2;
+    map<string, int64> scores = 3;
+    oneof owner {
+        uint64 role_id = 4;
+        string guild = 5;
+    }
+    optional group Result = 6 {
+        optional string url = 7;
+    }
+    map<float, int32> bad = 8;
+    optional Unknown unknown = 10;
+    message Inner { optional bytes data = 1; }
+    reserved 9;
+}
+
+message CS_Login {
+    optional string account = 1;
+}
+`,
+}
+
+const testImportXml = `<enum>
+    <ItemType>
+        <!--道具类型-->
+        <ItemType EntryName="ItemType_None" EntryIndex="0" EntryComment=""/>
+        <ItemType EntryName="ItemType_Weapon" EntryIndex="1" EntryComment="武器"/>
+        <ItemType Reserved="true" EntryIndex="5"/>
+    </ItemType>
+</enum>
+<data>
+    <Role>
+        <Inner NestedType="message">
+            <Inner EntryOption="optional" EntryType="bytes" EntryName="data" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        </Inner>
+        <Role EntryOption="optional" EntryType="int32" EntryName="id" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        <Role EntryOption="repeated" EntryType="ItemType" EntryName="items" EntryIndex="2" EntryDefault="ItemType_None" EntryComment=""/>
+        <Role EntryOption="map" EntryKeyType="string" EntryType="int64" EntryName="scores" EntryIndex="3" EntryDefault="" EntryComment=""/>
+        <Role EntryOption="optional" EntryType="uint64" EntryName="role_id" EntryIndex="4" EntryDefault="" EntryComment="" EntryOneof="owner"/>
+        <Role EntryOption="optional" EntryType="string" EntryName="guild" EntryIndex="5" EntryDefault="" EntryComment="" EntryOneof="owner"/>
+        <Role Reserved="true" EntryIndex="9"/>
+        <Role Reserved="true" EntryIndex="6" EntryName="result"/>
+        <Role Reserved="true" EntryIndex="8" EntryName="bad"/>
+        <Role Reserved="true" EntryIndex="10" EntryName="unknown"/>
+    </Role>
+</data>
+<protocol>
+    <CS_Login>
+        <CS_Login EntryOption="optional" EntryType="string" EntryName="account" EntryIndex="1" EntryDefault="" EntryComment=""/>
+    </CS_Login>
+</protocol>
+`
+
+func TestImportProto(t *testing.T) {
+	strDir := t.TempDir()
+	for strName, strContent := range testImportFiles {
+		if err := os.WriteFile(filepath.Join(strDir, strName), []byte(strContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isProtocolName := func(name string) bool { return strings.HasPrefix(name, "CS_") }
+	tests := []struct {
+		name        string
+		schemaXml   string
+		path        string
+		wantOk      bool
+		wantReports []string
+		wantXml     string
+	}{
+		{
+			name:   "dir",
+			path:   strDir,
+			wantOk: true,
+			wantReports: []string{
+				"item.proto:2: package pb is ignored",
+				"role.proto:1: syntax proto2 is different from the configured proto3",
+				"role.proto:2: package pb is ignored",
+				"role.proto:6: field Role.id option default = 5 is ignored",
+				"role.proto:13: group Role.Result is not supported, skipped and reserved",
+				"role.proto:16: map field Role.bad has invalid key type float, skipped and reserved",
+				"role.proto:17: field Role.unknown uses unknown type Unknown, skipped and reserved",
+			},
+			wantXml: testImportXml,
+		},
+		{
+			name:      "name conflict",
+			schemaXml: testSchemaXml,
+			path:      strDir,
+			wantOk:    true,
+			wantReports: []string{
+				"item.proto:2: package pb is ignored",
+				"item.proto:5: ItemType already exists in enum, skipped",
+				"role.proto:1: syntax proto2 is different from the configured proto3",
+				"role.proto:2: package pb is ignored",
+				"role.proto:5: Role already exists in data, skipped",
+				"role.proto:22: CS_Login already exists in protocol, skipped",
+			},
+			wantXml: testSchemaXml,
+		},
+		{
+			name:        "path not exist",
+			path:        filepath.Join(strDir, "none.proto"),
+			wantReports: []string{"stat " + filepath.Join(strDir, "none.proto") + ": no such file or directory"},
+			wantXml:     "",
+		},
+	}
+	for _, test := range tests {
+		schema := loadTestSchema(t, test.schemaXml)
+		isOk, reports := ImportProto(schema, test.path, isProtocolName, NewGenConfig())
+		if isOk != test.wantOk {
+			t.Errorf("%s: got %v, want %v", test.name, isOk, test.wantOk)
+		}
+		if !reflect.DeepEqual(reports, test.wantReports) {
+			t.Errorf("%s: reports got %q, want %q", test.name, reports, test.wantReports)
+		}
+		if strXml := getSchemaText(t, schema); strXml != test.wantXml {
+			t.Errorf("%s: xml got\n%s\nwant\n%s", test.name, strXml, test.wantXml)
+		}
+	}
+}
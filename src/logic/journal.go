@@ -0,0 +1,96 @@
+package logic
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"protocolgo/src/model"
+
+	"github.com/beevik/etree"
+	"github.com/sirupsen/logrus"
+)
+
+// 自动保存日志的文件后缀, 日志位于打开的 xml 旁边, 如 protocolgo.xml.autosave
+const JournalSuffix = ".autosave"
+
+// 自动保存日志的写入间隔
+const JournalInterval = 10 * time.Second
+
+// 获取 xml 文件对应的自动保存日志路径
+func GetJournalPath(xmlPath string) string {
+	return xmlPath + JournalSuffix
+}
+
+// 自动保存日志. 修改时在界面线程中记录未保存的 ChangedShowEtree, 由后台定时写入文件, 没有未保存的修改时删除文件
+type StJournal struct {
+	mutex   sync.Mutex
+	path    string // 日志文件路径, 为空时不写入
+	data    []byte // 待写入的内容, 为 nil 时删除日志文件
+	isDirty bool   // 是否有待写入的内容
+}
+
+// 切换到新打开的 xml, 不删除已有的日志, 用于下次启动时恢复
+func (journal *StJournal) Reset(xmlPath string) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.path = ""
+	if xmlPath != "" {
+		journal.path = GetJournalPath(xmlPath)
+	}
+	journal.data = nil
+	journal.isDirty = false
+}
+
+// 记录待写入的内容, data 为 nil 时表示没有未保存的修改
+func (journal *StJournal) Update(data []byte) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.data = data
+	journal.isDirty = true
+}
+
+// 将待写入的内容写入文件, 没有未保存的修改时删除文件
+func (journal *StJournal) Flush() bool {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	if !journal.isDirty || journal.path == "" {
+		return true
+	}
+	if journal.data == nil {
+		if err := os.Remove(journal.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Error("[Journal] remove failed. err:", err, ", path:", journal.path)
+			return false
+		}
+	} else if err := WriteFileAtomic(journal.path, journal.data); err != nil {
+		logrus.Error("[Journal] write failed. err:", err, ", path:", journal.path)
+		return false
+	}
+	journal.isDirty = false
+	logrus.Debug("[Journal] flush done. path:", journal.path)
+	return true
+}
+
+// 后台定时写入日志, 进程退出时结束
+func (journal *StJournal) StartAutosave(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			journal.Flush()
+		}
+	}()
+}
+
+// 读取自动保存日志或备份等协议 xml 文档, 内容无法解析为协议时返回错误
+func ReadProtoXmlDocument(filename string) (*etree.Document, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(filename); err != nil {
+		return nil, err
+	}
+	if _, err := model.SchemaFromDocument(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
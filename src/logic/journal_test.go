@@ -0,0 +1,112 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+func TestJournalFlush(t *testing.T) {
+	strXmlPath := filepath.Join(t.TempDir(), "protocolgo.xml")
+	strJournalPath := GetJournalPath(strXmlPath)
+	var journal StJournal
+	journal.Reset(strXmlPath)
+
+	steps := []struct {
+		name string
+		data []byte // 为 nil 时表示没有未保存的修改
+		want string // 为空时日志文件不存在
+	}{
+		{"write", []byte("<enum/>"), "<enum/>"},
+		{"overwrite", []byte("<data/>"), "<data/>"},
+		{"remove", nil, ""},
+		{"remove again", nil, ""},
+	}
+	for _, step := range steps {
+		journal.Update(step.data)
+		if !journal.Flush() {
+			t.Fatalf("%s: Flush failed", step.name)
+		}
+		data, err := os.ReadFile(strJournalPath)
+		if step.want == "" {
+			if err == nil {
+				t.Errorf("%s: journal exists: %s", step.name, data)
+			}
+		} else if string(data) != step.want {
+			t.Errorf("%s: got %q, err %v, want %q", step.name, data, err, step.want)
+		}
+	}
+
+	// 没有打开的 xml 时不写入
+	journal.Reset("")
+	journal.Update([]byte("<enum/>"))
+	if !journal.Flush() || PathExists(strJournalPath) {
+		t.Error("journal is written without xml")
+	}
+}
+
+func TestRestoreJournal(t *testing.T) {
+	strXmlPath := filepath.Join(t.TempDir(), "protocolgo.xml")
+	strJournalPath := GetJournalPath(strXmlPath)
+	openXml := func() *CoreManager {
+		coremgr := newTestCoreManager()
+		if !coremgr.ReadXmlFromReader(strings.NewReader(testSchemaXml), strXmlPath) {
+			t.Fatal("ReadXmlFromReader failed")
+		}
+		return coremgr
+	}
+
+	// 未保存的修改写入日志, 没有修改时不写入
+	coremgr := openXml()
+	coremgr.Journal.Flush()
+	if PathExists(strJournalPath) {
+		t.Fatal("journal is written without changes")
+	}
+	if isOk, _, strError := coremgr.Rename("Role", "", "Hero", true); !isOk {
+		t.Fatalf("Rename failed: %s", strError)
+	}
+	coremgr.Journal.Flush()
+	if !PathExists(strJournalPath) {
+		t.Fatal("journal is not written")
+	}
+
+	// 重新打开时可以恢复, 恢复后可以撤销
+	coremgr = openXml()
+	if isOk, changeList := coremgr.GetJournalChanges(); !isOk || len(changeList) == 0 {
+		t.Fatalf("GetJournalChanges: got %v %q", isOk, changeList)
+	}
+	if !coremgr.RestoreJournal() {
+		t.Fatal("RestoreJournal failed")
+	}
+	if coremgr.ShowSchema.FindMessage(model.CategoryData, "Hero") == nil {
+		t.Error("Hero is not restored")
+	}
+	if isOk, strName := coremgr.Undo(); !isOk || strName != "restore autosave" || coremgr.ShowSchema.FindMessage(model.CategoryData, "Role") == nil {
+		t.Errorf("undo restore: got %v %s", isOk, strName)
+	}
+
+	// 放弃后删除日志
+	coremgr.DiscardJournal()
+	if PathExists(strJournalPath) {
+		t.Error("journal is not removed after discard")
+	}
+	if isOk, _ := coremgr.GetJournalChanges(); isOk {
+		t.Error("GetJournalChanges after discard")
+	}
+
+	// 保存后删除日志
+	coremgr.Redo()
+	coremgr.Journal.Flush()
+	if !PathExists(strJournalPath) {
+		t.Fatal("journal is not written after redo")
+	}
+	if !coremgr.SaveProtoXmlToFile() {
+		t.Fatal("SaveProtoXmlToFile failed")
+	}
+	if PathExists(strJournalPath) {
+		t.Error("journal is not removed after save")
+	}
+}
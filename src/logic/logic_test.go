@@ -0,0 +1,86 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"protocolgo/src/model"
+
+	"fyne.io/fyne/v2/data/binding"
+)
+
+// 测试用的协议: 枚举, 带嵌套类型/map/oneof/保留项的 data, protocol 和 rpc
+const testSchemaXml = `<enum>
+    <ItemType>
+        <ItemType EntryName="ItemType_None" EntryIndex="0" EntryComment=""/>
+        <ItemType EntryName="ItemType_Weapon" EntryIndex="1" EntryComment=""/>
+        <ItemType Reserved="true" EntryIndex="5 to 8"/>
+    </ItemType>
+</enum>
+<data>
+    <Bag>
+        <Kind NestedType="enum">
+            <Kind EntryName="Kind_None" EntryIndex="0" EntryComment=""/>
+        </Kind>
+        <Slot NestedType="message">
+            <Slot EntryOption="optional" EntryType="int32" EntryName="pos" EntryIndex="1" EntryDefault="" EntryComment=""/>
+            <Slot EntryOption="optional" EntryType="ItemType" EntryName="type" EntryIndex="2" EntryDefault="ItemType_None" EntryComment=""/>
+        </Slot>
+        <Bag EntryOption="map" EntryKeyType="string" EntryType="Bag.Slot" EntryName="slot_map" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        <Bag EntryOption="repeated" EntryType="Slot" EntryName="slots" EntryIndex="2" EntryDefault="" EntryComment=""/>
+        <Bag EntryOption="optional" EntryType="Kind" EntryName="kind" EntryIndex="3" EntryDefault="Kind_None" EntryComment=""/>
+        <Bag EntryOption="optional" EntryType="uint64" EntryName="role_id" EntryIndex="4" EntryDefault="" EntryComment="" EntryOneof="owner"/>
+        <Bag EntryOption="optional" EntryType="string" EntryName="guild_name" EntryIndex="5" EntryDefault="" EntryComment="" EntryOneof="owner"/>
+        <Bag EntryOption="repeated" EntryType="ItemType" EntryName="item_types" EntryIndex="6" EntryDefault="" EntryComment=""/>
+        <Bag Reserved="true" EntryIndex="9"/>
+    </Bag>
+    <Role>
+        <Role EntryOption="optional" EntryType="int32" EntryName="id" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        <Role EntryOption="optional" EntryType="Bag" EntryName="bag" EntryIndex="2" EntryDefault="" EntryComment=""/>
+    </Role>
+</data>
+<protocol>
+    <CS_Login>
+        <CS_Login EntryOption="optional" EntryType="string" EntryName="account" EntryIndex="1" EntryDefault="" EntryComment=""/>
+    </CS_Login>
+</protocol>
+<rpc>
+    <CS_GetRole>
+        <CS_GetRole RpcType="Req">
+            <CS_GetRole EntryOption="optional" EntryType="int32" EntryName="id" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        </CS_GetRole>
+        <CS_GetRole RpcType="Ack">
+            <CS_GetRole EntryOption="optional" EntryType="Role" EntryName="role" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        </CS_GetRole>
+    </CS_GetRole>
+</rpc>
+`
+
+func loadTestSchema(t *testing.T, strXml string) *model.Schema {
+	t.Helper()
+	schema, err := model.LoadSchemaFromReader(strings.NewReader(strXml))
+	if err != nil {
+		t.Fatalf("LoadSchemaFromReader failed. err: %v", err)
+	}
+	return schema
+}
+
+func getSchemaText(t *testing.T, schema *model.Schema) string {
+	t.Helper()
+	data, err := schema.ToBytes()
+	if err != nil {
+		t.Fatalf("ToBytes failed. err: %v", err)
+	}
+	return string(data)
+}
+
+// 不依赖界面的 CoreManager, 不读取配置和打开 xml
+func newTestCoreManager() *CoreManager {
+	return &CoreManager{
+		MainTableList: binding.NewStringList(),
+		EnumTableList: binding.NewStringList(),
+		DataTableList: binding.NewStringList(),
+		PtcTableList:  binding.NewStringList(),
+		RpcTableList:  binding.NewStringList(),
+	}
+}
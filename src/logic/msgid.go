@@ -0,0 +1,356 @@
+package logic
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/beevik/etree"
+	"github.com/sirupsen/logrus"
+)
+
+// 无法识别服务器对前缀的协议使用的号段名
+const MsgIdPairOther = "*"
+
+// 消息 ID 枚举输出的文件名, 不含 .proto
+const MsgIdFileName = "msgid"
+
+// 消息 ID 的默认配置
+const (
+	DefaultMsgIdStart     = 1000
+	DefaultMsgIdRangeSize = 1000
+	DefaultMsgIdEnumName  = "EMsgId"
+)
+
+// 消息 ID 枚举值的前缀, 避免与同名的消息冲突
+const MsgIdValuePrefix = "MsgId_"
+
+// 一个服务器对的消息 ID 号段, [Start, End]
+type StMsgIdRange struct {
+	Pair  string
+	Start int
+	End   int
+}
+
+// 一条消息 ID 记录, 删除的协议只标记 Removed, ID 不再分配
+type StMsgIdEntry struct {
+	Name    string // 协议名, rpc 为 XxxReq/XxxAck
+	Pair    string
+	Id      int
+	Removed bool
+}
+
+// 消息 ID 的配置, 来自 config.xml 的 msgid
+type StMsgIdConfig struct {
+	LockFile  string // 锁文件路径, 为空时使用协议 xml 同目录的 xxx_msgid.xml
+	Start     int    // 自动分配的第一个号段的起始 ID
+	RangeSize int    // 自动分配的号段大小
+	EnumName  string // 生成的枚举名
+	Ranges    []StMsgIdRange
+}
+
+// 消息 ID 注册表, 保存在锁文件中, 保证 ID 在多次编辑之间不变
+type StMsgIdRegistry struct {
+	Ranges  []StMsgIdRange
+	Entries []StMsgIdEntry
+}
+
+// 锁文件中的节点和属性名
+const (
+	msgIdTagRoot  = "msgid"
+	msgIdTagRange = "range"
+	msgIdTagEntry = "entry"
+)
+
+func NewMsgIdConfig() StMsgIdConfig {
+	return StMsgIdConfig{Start: DefaultMsgIdStart, RangeSize: DefaultMsgIdRangeSize, EnumName: DefaultMsgIdEnumName}
+}
+
+// 获取协议 xml 对应的锁文件路径
+func (msgIdConfig *StMsgIdConfig) GetLockPath(xmlPath string) string {
+	if msgIdConfig.LockFile != "" {
+		return msgIdConfig.LockFile
+	}
+	return strings.TrimSuffix(xmlPath, ".xml") + "_msgid.xml"
+}
+
+// 从锁文件读取注册表, 文件不存在时返回空的注册表
+func LoadMsgIdRegistry(filename string) (*StMsgIdRegistry, bool) {
+	registry := &StMsgIdRegistry{}
+	if !PathExists(filename) {
+		return registry, true
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(filename); err != nil {
+		logrus.Error("[LoadMsgIdRegistry] read lock file failed. err:", err, ", filename:", filename)
+		return nil, false
+	}
+	root := doc.SelectElement(msgIdTagRoot)
+	if root == nil {
+		logrus.Error("[LoadMsgIdRegistry] invalid lock file, no msgid. filename:", filename)
+		return nil, false
+	}
+	for _, elem := range root.SelectElements(msgIdTagRange) {
+		nStart, errStart := strconv.Atoi(elem.SelectAttrValue("Start", ""))
+		nEnd, errEnd := strconv.Atoi(elem.SelectAttrValue("End", ""))
+		if errStart != nil || errEnd != nil {
+			logrus.Error("[LoadMsgIdRegistry] invalid range. Pair:", elem.SelectAttrValue("Pair", ""), ", filename:", filename)
+			return nil, false
+		}
+		registry.Ranges = append(registry.Ranges, StMsgIdRange{Pair: elem.SelectAttrValue("Pair", ""), Start: nStart, End: nEnd})
+	}
+	for _, elem := range root.SelectElements(msgIdTagEntry) {
+		nId, err := strconv.Atoi(elem.SelectAttrValue("Id", ""))
+		if err != nil {
+			logrus.Error("[LoadMsgIdRegistry] invalid id. Name:", elem.SelectAttrValue("Name", ""), ", filename:", filename)
+			return nil, false
+		}
+		registry.Entries = append(registry.Entries, StMsgIdEntry{
+			Name:    elem.SelectAttrValue("Name", ""),
+			Pair:    elem.SelectAttrValue("Pair", ""),
+			Id:      nId,
+			Removed: elem.SelectAttrValue("Removed", "") == "true",
+		})
+	}
+	return registry, true
+}
+
+// 保存注册表到锁文件, 号段和记录都按 ID 排序
+func (registry *StMsgIdRegistry) SaveToFile(filename string) bool {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	root := doc.CreateElement(msgIdTagRoot)
+	root.CreateComment("消息 ID 锁文件, 由工具维护, 请提交到版本库. 删除的协议标记 Removed, 其 ID 不会再分配")
+	sort.SliceStable(registry.Ranges, func(i, j int) bool { return registry.Ranges[i].Start < registry.Ranges[j].Start })
+	sort.SliceStable(registry.Entries, func(i, j int) bool { return registry.Entries[i].Id < registry.Entries[j].Id })
+	for _, idRange := range registry.Ranges {
+		elem := root.CreateElement(msgIdTagRange)
+		elem.CreateAttr("Pair", idRange.Pair)
+		elem.CreateAttr("Start", strconv.Itoa(idRange.Start))
+		elem.CreateAttr("End", strconv.Itoa(idRange.End))
+	}
+	for _, entry := range registry.Entries {
+		elem := root.CreateElement(msgIdTagEntry)
+		elem.CreateAttr("Name", entry.Name)
+		elem.CreateAttr("Pair", entry.Pair)
+		elem.CreateAttr("Id", strconv.Itoa(entry.Id))
+		if entry.Removed {
+			elem.CreateAttr("Removed", "true")
+		}
+	}
+	doc.Indent(4)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		logrus.Error("[SaveMsgIdRegistry] write lock file failed. err:", err, ", filename:", filename)
+		return false
+	}
+	// 先写入临时文件再替换, 写入失败时不会留下不完整的锁文件
+	if err := WriteFileAtomic(filename, data); err != nil {
+		logrus.Error("[SaveMsgIdRegistry] write lock file failed. err:", err, ", filename:", filename)
+		return false
+	}
+	return true
+}
+
+// 获取需要消息 ID 的协议名及其服务器对, 按 protocol, rpc 的 Req/Ack 的顺序
+func GetMsgIdNames(schema *model.Schema, pairName func(protoName string) string) ([]string, map[string]string) {
+	nameList := []string{}
+	pairMap := map[string]string{}
+	addName := func(strName string, strProtoName string) {
+		strPair := ""
+		if pairName != nil {
+			strPair = pairName(strProtoName)
+		}
+		if strPair == "" {
+			strPair = MsgIdPairOther
+		}
+		nameList = append(nameList, strName)
+		pairMap[strName] = strPair
+	}
+	for _, msg := range schema.Protocols {
+		addName(msg.Name, msg.Name)
+	}
+	for _, rpc := range schema.Rpcs {
+		if rpc.Req != nil {
+			addName(rpc.Name+model.RpcTypeReq, rpc.Name)
+		}
+		if rpc.Ack != nil {
+			addName(rpc.Name+model.RpcTypeAck, rpc.Name)
+		}
+	}
+	return nameList, pairMap
+}
+
+// 为 schema 中的协议分配消息 ID: 已有的 ID 保持不变, 删除的协议标记为 Removed, 新协议在所属服务器对的号段中分配.
+// 返回注册表是否有变化, 号段用完时返回错误
+func (registry *StMsgIdRegistry) Assign(schema *model.Schema, pairName func(protoName string) string, msgIdConfig StMsgIdConfig) (bool, []string) {
+	bChanged := false
+	errorList := []string{}
+	nameList, pairMap := GetMsgIdNames(schema, pairName)
+
+	// 配置中的号段优先
+	for _, idRange := range msgIdConfig.Ranges {
+		if oldRange := registry.FindRange(idRange.Pair); oldRange == nil {
+			registry.Ranges = append(registry.Ranges, idRange)
+			bChanged = true
+		} else if *oldRange != idRange {
+			*oldRange = idRange
+			bChanged = true
+		}
+	}
+	// 已删除的协议
+	for i := range registry.Entries {
+		entry := &registry.Entries[i]
+		if _, ok := pairMap[entry.Name]; !ok && !entry.Removed {
+			entry.Removed = true
+			bChanged = true
+		}
+	}
+	// 新协议
+	for _, strName := range nameList {
+		if registry.FindEntry(strName) != nil {
+			continue
+		}
+		strPair := pairMap[strName]
+		idRange := registry.FindRange(strPair)
+		if idRange == nil {
+			idRange = registry.AddRange(strPair, msgIdConfig)
+		}
+		nId := registry.GetNextId(*idRange)
+		if nId > idRange.End {
+			errorList = append(errorList, "msgid range of "+strPair+" is full, can not assign id for "+strName)
+			continue
+		}
+		registry.Entries = append(registry.Entries, StMsgIdEntry{Name: strName, Pair: strPair, Id: nId})
+		bChanged = true
+	}
+	return bChanged, errorList
+}
+
+// 查找服务器对的号段
+func (registry *StMsgIdRegistry) FindRange(pair string) *StMsgIdRange {
+	for i := range registry.Ranges {
+		if registry.Ranges[i].Pair == pair {
+			return &registry.Ranges[i]
+		}
+	}
+	return nil
+}
+
+// 查找协议当前使用的记录, 不包括已删除的
+func (registry *StMsgIdRegistry) FindEntry(name string) *StMsgIdEntry {
+	for i := range registry.Entries {
+		if registry.Entries[i].Name == name && !registry.Entries[i].Removed {
+			return &registry.Entries[i]
+		}
+	}
+	return nil
+}
+
+// 协议改名时新名字沿用旧名字的 ID. 旧名字没有 ID, 新名字已有 ID 或服务器对改变时不修改, 返回是否修改
+func (registry *StMsgIdRegistry) RenameEntry(oldName string, newName string, pair string) bool {
+	entry := registry.FindEntry(oldName)
+	if entry == nil || entry.Pair != pair || registry.FindEntry(newName) != nil {
+		return false
+	}
+	entry.Name = newName
+	return true
+}
+
+// 在所有号段之后为服务器对分配新的号段
+func (registry *StMsgIdRegistry) AddRange(pair string, msgIdConfig StMsgIdConfig) *StMsgIdRange {
+	nStart := msgIdConfig.Start
+	for _, idRange := range registry.Ranges {
+		if idRange.End >= nStart {
+			nStart = idRange.End + 1
+		}
+	}
+	registry.Ranges = append(registry.Ranges, StMsgIdRange{Pair: pair, Start: nStart, End: nStart + msgIdConfig.RangeSize - 1})
+	return &registry.Ranges[len(registry.Ranges)-1]
+}
+
+// 号段中下一个可用的 ID, 比号段中已使用过的 ID(包括已删除的)都大, 号段用完时大于 End
+func (registry *StMsgIdRegistry) GetNextId(idRange StMsgIdRange) int {
+	nId := idRange.Start
+	for _, entry := range registry.Entries {
+		if entry.Id >= idRange.Start && entry.Id <= idRange.End && entry.Id >= nId {
+			nId = entry.Id + 1
+		}
+	}
+	return nId
+}
+
+// 检查注册表: ID 冲突, 协议名重复, ID 不在所属号段内, 号段重叠
+func (registry *StMsgIdRegistry) Check() []string {
+	result := []string{}
+	idMap := map[int]string{}
+	nameMap := map[string]bool{}
+	for _, entry := range registry.Entries {
+		strId := strconv.Itoa(entry.Id)
+		if entry.Id <= 0 {
+			result = append(result, "msgid "+strId+" of "+entry.Name+" should be positive")
+		}
+		if strOther, ok := idMap[entry.Id]; ok {
+			result = append(result, "msgid "+strId+" collision: "+strOther+" and "+entry.Name)
+		} else {
+			idMap[entry.Id] = entry.Name
+		}
+		if !entry.Removed {
+			if nameMap[entry.Name] {
+				result = append(result, "msgid name duplicate: "+entry.Name)
+			}
+			nameMap[entry.Name] = true
+		}
+		if idRange := registry.FindRange(entry.Pair); idRange == nil {
+			result = append(result, "msgid range of "+entry.Pair+" is not exist, used by "+entry.Name)
+		} else if entry.Id < idRange.Start || entry.Id > idRange.End {
+			result = append(result, "msgid "+strId+" of "+entry.Name+" is out of range "+entry.Pair+"["+strconv.Itoa(idRange.Start)+", "+strconv.Itoa(idRange.End)+"]")
+		}
+	}
+	for i, rangeA := range registry.Ranges {
+		if rangeA.Start > rangeA.End {
+			result = append(result, "msgid range of "+rangeA.Pair+" is invalid")
+		}
+		for _, rangeB := range registry.Ranges[i+1:] {
+			if rangeA.Pair == rangeB.Pair {
+				result = append(result, "msgid range of "+rangeA.Pair+" is duplicate")
+			} else if rangeA.Start <= rangeB.End && rangeB.Start <= rangeA.End {
+				result = append(result, "msgid range of "+rangeA.Pair+" overlaps "+rangeB.Pair)
+			}
+		}
+	}
+	return result
+}
+
+// 生成消息 ID 枚举, 第一个值为 0. 已删除的 ID 和名字作为保留项, 防止被重新使用
+func (registry *StMsgIdRegistry) ToEnum(enumName string) *model.Enum {
+	if enumName == "" {
+		enumName = DefaultMsgIdEnumName
+	}
+	enum := &model.Enum{Name: enumName, Comment: "消息 ID, 由工具根据锁文件生成, 请勿手动修改"}
+	enum.Values = append(enum.Values, model.Field{EntryName: MsgIdValuePrefix + "None", EntryIndex: "0"})
+	entryList := append([]StMsgIdEntry{}, registry.Entries...)
+	sort.SliceStable(entryList, func(i, j int) bool { return entryList[i].Id < entryList[j].Id })
+	for _, entry := range entryList {
+		if entry.Removed {
+			continue
+		}
+		strComment := entry.Pair
+		if strComment == MsgIdPairOther {
+			strComment = ""
+		}
+		enum.Values = append(enum.Values, model.Field{EntryName: MsgIdValuePrefix + entry.Name, EntryIndex: strconv.Itoa(entry.Id), EntryComment: strComment})
+	}
+	for _, entry := range entryList {
+		if !entry.Removed {
+			continue
+		}
+		enum.Reserved = model.AddReserved(enum.Reserved, model.Reserved{EntryIndex: strconv.Itoa(entry.Id)})
+		if registry.FindEntry(entry.Name) == nil {
+			enum.Reserved = model.AddReserved(enum.Reserved, model.Reserved{EntryName: MsgIdValuePrefix + entry.Name})
+		}
+	}
+	return enum
+}
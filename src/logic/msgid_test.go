@@ -0,0 +1,259 @@
+package logic
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"protocolgo/src/model"
+
+	"github.com/beevik/etree"
+)
+
+// 测试用的服务器对前缀, CS_Xxx 为 CS, 其他无法识别
+func getTestPairName(protoName string) string {
+	if strings.HasPrefix(protoName, "CS_") {
+		return "CS"
+	}
+	return ""
+}
+
+func TestMsgIdAssign(t *testing.T) {
+	tests := []struct {
+		name        string
+		registry    StMsgIdRegistry
+		modify      func(schema *model.Schema)
+		config      func(config *StMsgIdConfig)
+		wantChanged bool
+		wantEntries []StMsgIdEntry
+		wantRanges  []StMsgIdRange
+		wantErrors  []string
+	}{
+		{
+			name:        "new registry",
+			wantChanged: true,
+			wantEntries: []StMsgIdEntry{
+				{Name: "CS_Login", Pair: "CS", Id: 1000},
+				{Name: "CS_GetRoleReq", Pair: "CS", Id: 1001},
+				{Name: "CS_GetRoleAck", Pair: "CS", Id: 1002},
+			},
+			wantRanges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+		},
+		{
+			name: "keep existing ids",
+			registry: StMsgIdRegistry{
+				Ranges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+				Entries: []StMsgIdEntry{
+					{Name: "CS_GetRoleAck", Pair: "CS", Id: 1005},
+					{Name: "CS_GetRoleReq", Pair: "CS", Id: 1004},
+					{Name: "CS_Login", Pair: "CS", Id: 1003},
+				},
+			},
+			wantChanged: false,
+			wantEntries: []StMsgIdEntry{
+				{Name: "CS_GetRoleAck", Pair: "CS", Id: 1005},
+				{Name: "CS_GetRoleReq", Pair: "CS", Id: 1004},
+				{Name: "CS_Login", Pair: "CS", Id: 1003},
+			},
+			wantRanges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+		},
+		{
+			name: "removed id is not reused",
+			registry: StMsgIdRegistry{
+				Ranges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+				Entries: []StMsgIdEntry{
+					{Name: "CS_Login", Pair: "CS", Id: 1000},
+					{Name: "CS_GetRoleReq", Pair: "CS", Id: 1001},
+					{Name: "CS_GetRoleAck", Pair: "CS", Id: 1002},
+				},
+			},
+			modify: func(schema *model.Schema) {
+				schema.Protocols[0].Name = "CS_Logout"
+			},
+			wantChanged: true,
+			wantEntries: []StMsgIdEntry{
+				{Name: "CS_Login", Pair: "CS", Id: 1000, Removed: true},
+				{Name: "CS_GetRoleReq", Pair: "CS", Id: 1001},
+				{Name: "CS_GetRoleAck", Pair: "CS", Id: 1002},
+				{Name: "CS_Logout", Pair: "CS", Id: 1003},
+			},
+			wantRanges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+		},
+		{
+			name: "new range after existing ranges",
+			registry: StMsgIdRegistry{
+				Ranges: []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}},
+			},
+			modify: func(schema *model.Schema) {
+				schema.Protocols[0].Name = "Ping"
+				schema.Rpcs = nil
+			},
+			wantChanged: true,
+			wantEntries: []StMsgIdEntry{{Name: "Ping", Pair: MsgIdPairOther, Id: 2000}},
+			wantRanges:  []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}, {Pair: MsgIdPairOther, Start: 2000, End: 2999}},
+		},
+		{
+			name: "range in config",
+			config: func(config *StMsgIdConfig) {
+				config.Ranges = []StMsgIdRange{{Pair: "CS", Start: 100, End: 101}}
+			},
+			wantChanged: true,
+			wantEntries: []StMsgIdEntry{
+				{Name: "CS_Login", Pair: "CS", Id: 100},
+				{Name: "CS_GetRoleReq", Pair: "CS", Id: 101},
+			},
+			wantRanges: []StMsgIdRange{{Pair: "CS", Start: 100, End: 101}},
+			wantErrors: []string{"msgid range of CS is full, can not assign id for CS_GetRoleAck"},
+		},
+	}
+	for _, test := range tests {
+		schema := loadTestSchema(t, testSchemaXml)
+		if test.modify != nil {
+			test.modify(schema)
+		}
+		msgIdConfig := NewMsgIdConfig()
+		if test.config != nil {
+			test.config(&msgIdConfig)
+		}
+		registry := test.registry
+		bChanged, errorList := registry.Assign(schema, getTestPairName, msgIdConfig)
+		if bChanged != test.wantChanged {
+			t.Errorf("%s: changed got %v, want %v", test.name, bChanged, test.wantChanged)
+		}
+		if !reflect.DeepEqual(registry.Entries, test.wantEntries) {
+			t.Errorf("%s: entries got %v, want %v", test.name, registry.Entries, test.wantEntries)
+		}
+		if !reflect.DeepEqual(registry.Ranges, test.wantRanges) {
+			t.Errorf("%s: ranges got %v, want %v", test.name, registry.Ranges, test.wantRanges)
+		}
+		if len(errorList) > 0 || len(test.wantErrors) > 0 {
+			if !reflect.DeepEqual(errorList, test.wantErrors) {
+				t.Errorf("%s: errors got %q, want %q", test.name, errorList, test.wantErrors)
+			}
+		}
+	}
+}
+
+func TestMsgIdCheck(t *testing.T) {
+	csRange := StMsgIdRange{Pair: "CS", Start: 1000, End: 1999}
+	tests := []struct {
+		name     string
+		registry StMsgIdRegistry
+		want     []string
+	}{
+		{
+			name: "valid",
+			registry: StMsgIdRegistry{
+				Ranges:  []StMsgIdRange{csRange},
+				Entries: []StMsgIdEntry{{Name: "CS_Login", Pair: "CS", Id: 1000}, {Name: "CS_Login", Pair: "CS", Id: 1001, Removed: true}},
+			},
+			want: []string{},
+		},
+		{
+			name: "collision",
+			registry: StMsgIdRegistry{
+				Ranges:  []StMsgIdRange{csRange},
+				Entries: []StMsgIdEntry{{Name: "CS_Login", Pair: "CS", Id: 1000}, {Name: "CS_Logout", Pair: "CS", Id: 1000}},
+			},
+			want: []string{"msgid 1000 collision: CS_Login and CS_Logout"},
+		},
+		{
+			name: "name duplicate",
+			registry: StMsgIdRegistry{
+				Ranges:  []StMsgIdRange{csRange},
+				Entries: []StMsgIdEntry{{Name: "CS_Login", Pair: "CS", Id: 1000}, {Name: "CS_Login", Pair: "CS", Id: 1001}},
+			},
+			want: []string{"msgid name duplicate: CS_Login"},
+		},
+		{
+			name: "out of range",
+			registry: StMsgIdRegistry{
+				Ranges:  []StMsgIdRange{csRange},
+				Entries: []StMsgIdEntry{{Name: "CS_Login", Pair: "CS", Id: 2000}, {Name: "Ping", Pair: "*", Id: 0}},
+			},
+			want: []string{
+				"msgid 2000 of CS_Login is out of range CS[1000, 1999]",
+				"msgid 0 of Ping should be positive",
+				"msgid range of * is not exist, used by Ping",
+			},
+		},
+		{
+			name: "ranges",
+			registry: StMsgIdRegistry{
+				Ranges: []StMsgIdRange{csRange, {Pair: "GS", Start: 1500, End: 2500}, {Pair: "CS", Start: 3000, End: 3999}, {Pair: "MS", Start: 10, End: 1}},
+			},
+			want: []string{
+				"msgid range of CS overlaps GS",
+				"msgid range of CS is duplicate",
+				"msgid range of MS is invalid",
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := test.registry.Check(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMsgIdRegistryFile(t *testing.T) {
+	registry := &StMsgIdRegistry{
+		Ranges:  []StMsgIdRange{{Pair: "GS", Start: 2000, End: 2999}, {Pair: "CS", Start: 1000, End: 1999}},
+		Entries: []StMsgIdEntry{{Name: "GS_Sync", Pair: "GS", Id: 2000}, {Name: "CS_Login", Pair: "CS", Id: 1000, Removed: true}},
+	}
+	strPath := filepath.Join(t.TempDir(), "protocolgo_msgid.xml")
+	if !registry.SaveToFile(strPath) {
+		t.Fatal("SaveToFile failed")
+	}
+	loaded, isOk := LoadMsgIdRegistry(strPath)
+	if !isOk {
+		t.Fatal("LoadMsgIdRegistry failed")
+	}
+	// 保存时按 ID 排序
+	want := &StMsgIdRegistry{
+		Ranges:  []StMsgIdRange{{Pair: "CS", Start: 1000, End: 1999}, {Pair: "GS", Start: 2000, End: 2999}},
+		Entries: []StMsgIdEntry{{Name: "CS_Login", Pair: "CS", Id: 1000, Removed: true}, {Name: "GS_Sync", Pair: "GS", Id: 2000}},
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("got %v, want %v", loaded, want)
+	}
+}
+
+// 生成时只读取锁文件, 锁文件需要更新时返回错误且不写入
+func TestLoadMsgIdsReadOnly(t *testing.T) {
+	strLockPath := filepath.Join(t.TempDir(), "protocolgo_msgid.xml")
+	config := etree.NewDocument()
+	if err := config.ReadFromString(`<config><msgid lockfile="` + strLockPath + `" start="1000" rangesize="1000"/></config>`); err != nil {
+		t.Fatal(err)
+	}
+	coremgr := newTestCoreManager()
+	coremgr.Config = config
+	schema := loadTestSchema(t, testSchemaXml)
+
+	registry, errorList := coremgr.LoadMsgIds(schema, "")
+	if registry == nil || len(errorList) != 1 || !strings.Contains(errorList[0], "out of date") {
+		t.Fatalf("stale lock file: got %q", errorList)
+	}
+	if PathExists(strLockPath) {
+		t.Fatal("LoadMsgIds wrote the lock file")
+	}
+	if _, errorList = coremgr.GetGenConfigWithMsgId(schema, ""); len(errorList) == 0 {
+		t.Fatal("GetGenConfigWithMsgId: want out of date error")
+	}
+	if PathExists(strLockPath) {
+		t.Fatal("GetGenConfigWithMsgId wrote the lock file")
+	}
+
+	// msgid 或保存写入锁文件后可以生成
+	if _, errorList = coremgr.UpdateMsgIds(schema, ""); len(errorList) > 0 {
+		t.Fatalf("UpdateMsgIds: %q", errorList)
+	}
+	genConfig, errorList := coremgr.GetGenConfigWithMsgId(schema, "")
+	if len(errorList) > 0 {
+		t.Fatalf("GetGenConfigWithMsgId: %q", errorList)
+	}
+	if genConfig.MsgIdEnum == nil || len(genConfig.MsgIdEnum.Values) != 4 {
+		t.Errorf("MsgIdEnum: got %v, want None and 3 ids", genConfig.MsgIdEnum)
+	}
+}
@@ -0,0 +1,198 @@
+package logic
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CodeGeneratorResponse.Feature
+const (
+	pbFeatureProto3Optional   = 1
+	pbFeatureSupportsEditions = 2
+)
+
+// 插件返回的一个文件
+type stPluginFile struct {
+	Name           string
+	InsertionPoint string
+	Content        string
+}
+
+// 插件的返回, 对应 CodeGeneratorResponse
+type stPluginResponse struct {
+	Error             string
+	SupportedFeatures uint64
+	MinimumEdition    int64
+	MaximumEdition    int64
+	Files             []stPluginFile
+}
+
+// 编码 CodeGeneratorRequest, 所有文件都生成. fileList 需要按依赖排序
+func marshalPluginRequest(fileList []*StFileDescriptor, parameter string) []byte {
+	var encoder pbEncoder
+	for _, fileDesc := range fileList {
+		encoder.String(1, fileDesc.Name)
+	}
+	if parameter != "" {
+		encoder.String(2, parameter)
+	}
+	for _, fileDesc := range fileList {
+		encoder.Message(15, &pbEncoder{buf: fileDesc.Marshal()})
+	}
+	return encoder.Bytes()
+}
+
+// 解码 CodeGeneratorResponse
+func unmarshalPluginResponse(data []byte) (stPluginResponse, error) {
+	var response stPluginResponse
+	err := walkPbFields(data, func(field stPbField) error {
+		switch field.Num {
+		case 1:
+			response.Error = string(field.Data)
+		case 2:
+			response.SupportedFeatures = field.Varint
+		case 3:
+			response.MinimumEdition = int64(field.Varint)
+		case 4:
+			response.MaximumEdition = int64(field.Varint)
+		case 15:
+			var pluginFile stPluginFile
+			err := walkPbFields(field.Data, func(fileField stPbField) error {
+				switch fileField.Num {
+				case 1:
+					pluginFile.Name = string(fileField.Data)
+				case 2:
+					pluginFile.InsertionPoint = string(fileField.Data)
+				case 15:
+					pluginFile.Content = string(fileField.Data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			response.Files = append(response.Files, pluginFile)
+		}
+		return nil
+	})
+	return response, err
+}
+
+// 检查插件是否支持文件用到的特性, protoc 对不支持的插件同样报错
+func checkPluginFeatures(fileList []*StFileDescriptor, response stPluginResponse) error {
+	for _, fileDesc := range fileList {
+		if fileDesc.Syntax == SyntaxEditions {
+			nEdition := pbEditions[fileDesc.Edition]
+			if response.SupportedFeatures&pbFeatureSupportsEditions == 0 {
+				return errors.New("plugin does not support editions, file: " + fileDesc.Name)
+			}
+			if nEdition < response.MinimumEdition || nEdition > response.MaximumEdition {
+				return errors.New("plugin does not support edition " + fileDesc.Edition + ", file: " + fileDesc.Name)
+			}
+		}
+		if response.SupportedFeatures&pbFeatureProto3Optional != 0 {
+			continue
+		}
+		for _, msgDesc := range fileDesc.Messages {
+			if msgDesc.hasProto3Optional() {
+				return errors.New("plugin does not support proto3 optional, file: " + fileDesc.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (msgDesc *StMessageDescriptor) hasProto3Optional() bool {
+	for _, fieldDesc := range msgDesc.Fields {
+		if fieldDesc.Proto3Optional {
+			return true
+		}
+	}
+	for _, nested := range msgDesc.Nested {
+		if nested.hasProto3Optional() {
+			return true
+		}
+	}
+	return false
+}
+
+// 不经过 protoc, 直接按插件协议调用 protoc-gen-<plugin>: 请求从 stdin 写入, 从 stdout 读取生成的文件.
+// protoc 内置的生成器(cpp, csharp 等)没有插件, 需要 protoc
+func GenPbTargetBuiltin(report StToolchainReport, fileList []*StFileDescriptor, target StPbTarget) StPbTargetResult {
+	result := StPbTargetResult{Target: target}
+	if target.Plugin == "" {
+		result.Output = "plugin of target " + target.Name + " is not configed"
+		return result
+	}
+	pluginInfo := report.FindPlugin(target.Plugin)
+	if pluginInfo == nil || pluginInfo.Source == ToolSourceBuiltin {
+		result.Output = target.Plugin + " is a protoc builtin generator, protoc is required"
+		return result
+	}
+	if pluginInfo.Level == ToolLevel_Error {
+		result.Output = pluginInfo.String()
+		return result
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(pluginInfo.Path)
+	cmd.Stdin = bytes.NewReader(marshalPluginRequest(fileList, target.Opt))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	result.Output = stderr.String()
+	if err != nil {
+		logrus.Error("[GenPbTargetBuiltin] Error executing plugin:", err, ",stderr:", stderr.String(), ",target:", target.Name)
+		result.Output = err.Error() + "\n" + result.Output
+		return result
+	}
+	response, err := unmarshalPluginResponse(stdout.Bytes())
+	if err == nil && response.Error != "" {
+		err = errors.New(response.Error)
+	}
+	if err == nil {
+		err = checkPluginFeatures(fileList, response)
+	}
+	if err == nil {
+		result.Files, err = writePluginFiles(target.Out, response.Files)
+	}
+	if err != nil {
+		logrus.Error("[GenPbTargetBuiltin] plugin failed:", err, ",target:", target.Name)
+		result.Output = err.Error() + "\n" + result.Output
+		return result
+	}
+	result.Success = true
+	logrus.Info("[GenPbTargetBuiltin] done. target:", target.Name, ", files:", len(result.Files))
+	return result
+}
+
+// 写入插件生成的文件, 文件名相对输出目录. 不支持插入点
+func writePluginFiles(outputPath string, pluginFiles []stPluginFile) ([]string, error) {
+	result := []string{}
+	for _, pluginFile := range pluginFiles {
+		if pluginFile.InsertionPoint != "" {
+			return result, errors.New("insertion point is not supported without protoc, file: " + pluginFile.Name)
+		}
+		strName := filepath.Clean(filepath.FromSlash(pluginFile.Name))
+		if pluginFile.Name == "" || filepath.IsAbs(strName) || strName == ".." || strings.HasPrefix(strName, ".."+string(filepath.Separator)) {
+			return result, errors.New("invalid file name from plugin: " + pluginFile.Name)
+		}
+		strPath := filepath.Join(outputPath, strName)
+		if err := os.MkdirAll(filepath.Dir(strPath), 0755); err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(strPath, []byte(pluginFile.Content), 0644); err != nil {
+			return result, err
+		}
+		result = append(result, strName)
+	}
+	sort.Strings(result)
+	return result, nil
+}
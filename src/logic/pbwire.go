@@ -0,0 +1,122 @@
+package logic
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// protobuf 编码的 wire type, 只用到 varint 和 length-delimited, 其他类型在解码时跳过
+const (
+	pbWireVarint  = 0
+	pbWireFixed64 = 1
+	pbWireBytes   = 2
+	pbWireFixed32 = 5
+)
+
+// protobuf 编码器, 只实现生成 descriptor 和插件请求需要的部分
+type pbEncoder struct {
+	buf []byte
+}
+
+func (encoder *pbEncoder) Bytes() []byte {
+	return encoder.buf
+}
+
+func (encoder *pbEncoder) appendVarint(value uint64) {
+	encoder.buf = binary.AppendUvarint(encoder.buf, value)
+}
+
+func (encoder *pbEncoder) appendTag(num int, wireType int) {
+	encoder.appendVarint(uint64(num)<<3 | uint64(wireType))
+}
+
+// int32/int64/enum 字段, 负数按 64 位补码编码
+func (encoder *pbEncoder) Int(num int, value int64) {
+	encoder.appendTag(num, pbWireVarint)
+	encoder.appendVarint(uint64(value))
+}
+
+func (encoder *pbEncoder) Bool(num int, value bool) {
+	var nValue int64
+	if value {
+		nValue = 1
+	}
+	encoder.Int(num, nValue)
+}
+
+func (encoder *pbEncoder) String(num int, value string) {
+	encoder.appendTag(num, pbWireBytes)
+	encoder.appendVarint(uint64(len(value)))
+	encoder.buf = append(encoder.buf, value...)
+}
+
+// 嵌套消息字段, 内容为已编码的消息
+func (encoder *pbEncoder) Message(num int, msg *pbEncoder) {
+	encoder.appendTag(num, pbWireBytes)
+	encoder.appendVarint(uint64(len(msg.buf)))
+	encoder.buf = append(encoder.buf, msg.buf...)
+}
+
+// packed 编码的 repeated int32
+func (encoder *pbEncoder) PackedInt(num int, values []int32) {
+	var packed pbEncoder
+	for _, value := range values {
+		packed.appendVarint(uint64(int64(value)))
+	}
+	encoder.appendTag(num, pbWireBytes)
+	encoder.appendVarint(uint64(len(packed.buf)))
+	encoder.buf = append(encoder.buf, packed.buf...)
+}
+
+var errPbTruncated = errors.New("protobuf: truncated message")
+
+// 解码出的一个字段, varint 字段的值在 Varint 中, length-delimited 字段的值在 Data 中
+type stPbField struct {
+	Num      int
+	WireType int
+	Varint   uint64
+	Data     []byte
+}
+
+// 依次解码消息中的字段, fn 返回 error 时停止
+func walkPbFields(data []byte, fn func(field stPbField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errPbTruncated
+		}
+		data = data[n:]
+		field := stPbField{Num: int(tag >> 3), WireType: int(tag & 7)}
+		switch field.WireType {
+		case pbWireVarint:
+			field.Varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errPbTruncated
+			}
+			data = data[n:]
+		case pbWireFixed64:
+			if len(data) < 8 {
+				return errPbTruncated
+			}
+			data = data[8:]
+		case pbWireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errPbTruncated
+			}
+			field.Data = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case pbWireFixed32:
+			if len(data) < 4 {
+				return errPbTruncated
+			}
+			data = data[4:]
+		default:
+			return errors.New("protobuf: unsupported wire type")
+		}
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
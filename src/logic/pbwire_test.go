@@ -0,0 +1,79 @@
+package logic
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestPbEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(encoder *pbEncoder)
+		want   string
+	}{
+		{"int", func(encoder *pbEncoder) { encoder.Int(1, 150) }, "089601"},
+		{"negative int", func(encoder *pbEncoder) { encoder.Int(1, -1) }, "08ffffffffffffffffff01"},
+		{"two bytes tag", func(encoder *pbEncoder) { encoder.Int(16, 1) }, "800101"},
+		{"bool true", func(encoder *pbEncoder) { encoder.Bool(3, true) }, "1801"},
+		{"bool false", func(encoder *pbEncoder) { encoder.Bool(3, false) }, "1800"},
+		{"string", func(encoder *pbEncoder) { encoder.String(2, "testing") }, "120774657374696e67"},
+		{"empty string", func(encoder *pbEncoder) { encoder.String(2, "") }, "1200"},
+		{"message", func(encoder *pbEncoder) {
+			var msg pbEncoder
+			msg.Int(1, 150)
+			encoder.Message(3, &msg)
+		}, "1a03089601"},
+		{"packed", func(encoder *pbEncoder) { encoder.PackedInt(4, []int32{3, 270, 86942}) }, "2206038e029ea705"},
+		{"packed negative", func(encoder *pbEncoder) { encoder.PackedInt(1, []int32{-1}) }, "0a0affffffffffffffffff01"},
+	}
+	for _, test := range tests {
+		var encoder pbEncoder
+		test.encode(&encoder)
+		if got := hex.EncodeToString(encoder.Bytes()); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWalkPbFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []stPbField
+		wantErr string
+	}{
+		{
+			name: "fields",
+			data: "089601" + "120774657374696e67" + "1d01020304" + "210102030405060708" + "08ffffffffffffffffff01",
+			want: []stPbField{
+				{Num: 1, WireType: pbWireVarint, Varint: 150},
+				{Num: 2, WireType: pbWireBytes, Data: []byte("testing")},
+				{Num: 3, WireType: pbWireFixed32},
+				{Num: 4, WireType: pbWireFixed64},
+				{Num: 1, WireType: pbWireVarint, Varint: 1<<64 - 1},
+			},
+		},
+		{name: "truncated varint", data: "08", wantErr: "protobuf: truncated message"},
+		{name: "truncated bytes", data: "120561", wantErr: "protobuf: truncated message"},
+		{name: "truncated fixed32", data: "1d0102", wantErr: "protobuf: truncated message"},
+		{name: "group", data: "0b", wantErr: "protobuf: unsupported wire type"},
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		got := []stPbField{}
+		err := walkPbFields(data, func(field stPbField) error {
+			got = append(got, field)
+			return nil
+		})
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v %v, want %v", test.name, got, err, test.want)
+		}
+	}
+}
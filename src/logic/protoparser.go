@@ -0,0 +1,1054 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// proto 文件中的 option
+type StProtoOption struct {
+	Name  string
+	Value string
+	Line  int
+}
+
+// 枚举值
+type StProtoEnumValue struct {
+	Name    string
+	Number  string
+	Comment string
+	Line    int
+	Options []StProtoOption
+}
+
+// 枚举
+type StProtoEnum struct {
+	Name     string
+	Comment  string
+	Line     int
+	Values   []StProtoEnumValue
+	Options  []StProtoOption
+	Reserved []string
+}
+
+// 字段. map 字段的 Type 为 "map", 键值类型分别在 KeyType/ValueType 中
+type StProtoField struct {
+	Label     string
+	Type      string
+	KeyType   string
+	ValueType string
+	Name      string
+	Number    string
+	Comment   string
+	Line      int
+	Options   []StProtoOption
+	OneofName string          // 所属 oneof, 为空表示不在 oneof 中
+	Group     *StProtoMessage // proto2 group 的消息体
+}
+
+// oneof
+type StProtoOneof struct {
+	Name    string
+	Comment string
+	Line    int
+	Options []StProtoOption
+}
+
+// 消息
+type StProtoMessage struct {
+	Name       string
+	Comment    string
+	Line       int
+	Fields     []*StProtoField
+	Oneofs     []*StProtoOneof
+	Enums      []*StProtoEnum
+	Messages   []*StProtoMessage
+	Extends    []*StProtoExtend
+	Options    []StProtoOption
+	Reserved   []string
+	Extensions []string
+}
+
+// extend 块
+type StProtoExtend struct {
+	Extendee string
+	Line     int
+	Fields   []*StProtoField
+}
+
+// service 中的 rpc 方法
+type StProtoMethod struct {
+	Name         string
+	InputType    string
+	OutputType   string
+	InputStream  bool
+	OutputStream bool
+	Comment      string
+	Line         int
+	Options      []StProtoOption
+}
+
+// service
+type StProtoService struct {
+	Name    string
+	Comment string
+	Line    int
+	Methods []*StProtoMethod
+	Options []StProtoOption
+}
+
+// 一个 proto 文件的语法树
+type StProtoFile struct {
+	Path          string
+	Syntax        string // proto2/proto3/editions, 未声明时为 proto2
+	SyntaxLine    int    // syntax/edition 语句所在的行, 未声明时为 0
+	Edition       string
+	Package       string
+	PackageLine   int
+	Imports       []string
+	ImportLines   map[string]int // import 语句所在的行
+	PublicImports []string
+	Options       []StProtoOption
+	Enums         []*StProtoEnum
+	Messages      []*StProtoMessage
+	Services      []*StProtoService
+	Extends       []*StProtoExtend
+}
+
+// 词法单元类型
+const (
+	protoTokenIdent = iota + 1
+	protoTokenNumber
+	protoTokenString
+	protoTokenSymbol
+)
+
+// 词法单元
+type stProtoToken struct {
+	Kind     int
+	Text     string
+	Line     int
+	Leading  []string // 紧贴在前面的注释
+	Trailing string   // 同一行后面的注释
+}
+
+type stProtoComment struct {
+	Text      string
+	StartLine int
+	EndLine   int
+}
+
+// 解析 proto 文件
+func ParseProtoFile(filename string) (*StProtoFile, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProto(filename, string(content))
+}
+
+// 解析 proto 内容, filename 只用于错误信息
+func ParseProto(filename string, content string) (*StProtoFile, error) {
+	tokens, err := tokenizeProto(filename, content)
+	if err != nil {
+		return nil, err
+	}
+	parser := &stProtoParser{filename: filename, tokens: tokens}
+	return parser.parseFile()
+}
+
+// 词法分析
+func tokenizeProto(filename string, content string) ([]*stProtoToken, error) {
+	tokens := []*stProtoToken{}
+	pending := []stProtoComment{}
+	line := 1
+	var lastToken *stProtoToken
+
+	addComment := func(comment stProtoComment) {
+		// 与上一个词法单元同一行的注释作为其行尾注释
+		if lastToken != nil && comment.StartLine == lastToken.Line && lastToken.Trailing == "" && len(pending) == 0 {
+			lastToken.Trailing = strings.Join(strings.Fields(comment.Text), " ")
+			return
+		}
+		pending = append(pending, comment)
+	}
+	addToken := func(kind int, text string, tokenLine int) {
+		token := &stProtoToken{Kind: kind, Text: text, Line: tokenLine}
+		// 只保留与该单元之间没有空行的注释
+		nextLine := tokenLine
+		nStart := len(pending)
+		for i := len(pending) - 1; i >= 0; i-- {
+			if pending[i].EndLine+1 < nextLine {
+				break
+			}
+			nextLine = pending[i].StartLine
+			nStart = i
+		}
+		for _, comment := range pending[nStart:] {
+			token.Leading = append(token.Leading, comment.Text)
+		}
+		pending = pending[:0]
+		tokens = append(tokens, token)
+		lastToken = token
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(content[i:], "//"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				end = len(content) - i
+			}
+			addComment(stProtoComment{Text: strings.TrimSpace(content[i+2 : i+end]), StartLine: line, EndLine: line})
+			i += end
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: unterminated comment", filename, line)
+			}
+			text := content[i+2 : i+2+end]
+			startLine := line
+			line += strings.Count(text, "\n")
+			lines := []string{}
+			for _, strLine := range strings.Split(text, "\n") {
+				strLine = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strLine), "*"))
+				if strLine != "" {
+					lines = append(lines, strLine)
+				}
+			}
+			addComment(stProtoComment{Text: strings.Join(lines, "\n"), StartLine: startLine, EndLine: line})
+			i += end + 4
+		case c == '"' || c == '\'':
+			var builder strings.Builder
+			j := i + 1
+			for ; j < len(content) && content[j] != c; j++ {
+				if content[j] == '\n' {
+					return nil, fmt.Errorf("%s:%d: unterminated string", filename, line)
+				}
+				if content[j] == '\\' && j+1 < len(content) {
+					j++
+					switch content[j] {
+					case 'n':
+						builder.WriteByte('\n')
+					case 't':
+						builder.WriteByte('\t')
+					default:
+						builder.WriteByte(content[j])
+					}
+					continue
+				}
+				builder.WriteByte(content[j])
+			}
+			if j >= len(content) {
+				return nil, fmt.Errorf("%s:%d: unterminated string", filename, line)
+			}
+			addToken(protoTokenString, builder.String(), line)
+			i = j + 1
+		case isProtoLetter(c):
+			j := i
+			for j < len(content) && (isProtoLetter(content[j]) || isProtoDigit(content[j])) {
+				j++
+			}
+			addToken(protoTokenIdent, content[i:j], line)
+			i = j
+		case isProtoDigit(c) || (c == '.' && i+1 < len(content) && isProtoDigit(content[i+1])):
+			j := i
+			for j < len(content) && (isProtoLetter(content[j]) || isProtoDigit(content[j]) || content[j] == '.' ||
+				((content[j] == '+' || content[j] == '-') && (content[j-1] == 'e' || content[j-1] == 'E') && !strings.HasPrefix(strings.ToLower(content[i:j]), "0x"))) {
+				j++
+			}
+			addToken(protoTokenNumber, content[i:j], line)
+			i = j
+		default:
+			addToken(protoTokenSymbol, string(c), line)
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isProtoLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isProtoDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// 语法分析
+type stProtoParser struct {
+	filename string
+	tokens   []*stProtoToken
+	pos      int
+}
+
+// 结束时返回空的词法单元, 避免到处判断越界
+func (parser *stProtoParser) peekAt(offset int) *stProtoToken {
+	if parser.pos+offset >= len(parser.tokens) {
+		line := 0
+		if len(parser.tokens) > 0 {
+			line = parser.tokens[len(parser.tokens)-1].Line
+		}
+		return &stProtoToken{Line: line}
+	}
+	return parser.tokens[parser.pos+offset]
+}
+
+func (parser *stProtoParser) peek() *stProtoToken {
+	return parser.peekAt(0)
+}
+
+func (parser *stProtoParser) next() *stProtoToken {
+	token := parser.peek()
+	if parser.pos < len(parser.tokens) {
+		parser.pos++
+	}
+	return token
+}
+
+func (parser *stProtoParser) atEnd() bool {
+	return parser.pos >= len(parser.tokens)
+}
+
+func (parser *stProtoParser) errorf(token *stProtoToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", parser.filename, token.Line, fmt.Sprintf(format, args...))
+}
+
+func (parser *stProtoParser) expect(text string) (*stProtoToken, error) {
+	token := parser.next()
+	if token.Kind == protoTokenString || token.Text != text {
+		return token, parser.errorf(token, "expected %q, found %q", text, token.Text)
+	}
+	return token, nil
+}
+
+func (parser *stProtoParser) readIdent() (string, error) {
+	token := parser.next()
+	if token.Kind != protoTokenIdent {
+		return "", parser.errorf(token, "expected identifier, found %q", token.Text)
+	}
+	return token.Text, nil
+}
+
+// 读取 a.b.C 形式的名字, 允许以 . 开头
+func (parser *stProtoParser) readFullIdent() (string, error) {
+	strName := ""
+	if parser.peek().Kind == protoTokenSymbol && parser.peek().Text == "." {
+		parser.next()
+		strName = "."
+	}
+	for {
+		ident, err := parser.readIdent()
+		if err != nil {
+			return "", err
+		}
+		strName += ident
+		if parser.peek().Kind != protoTokenSymbol || parser.peek().Text != "." {
+			return strName, nil
+		}
+		parser.next()
+		strName += "."
+	}
+}
+
+func (parser *stProtoParser) readString() (string, error) {
+	token := parser.next()
+	if token.Kind != protoTokenString {
+		return "", parser.errorf(token, "expected string, found %q", token.Text)
+	}
+	result := token.Text
+	// 相邻字符串拼接
+	for parser.peek().Kind == protoTokenString {
+		result += parser.next().Text
+	}
+	return result, nil
+}
+
+// 读取整数, 统一转为十进制
+func (parser *stProtoParser) readInteger() (string, error) {
+	strSign := ""
+	if parser.peek().Kind == protoTokenSymbol && parser.peek().Text == "-" {
+		parser.next()
+		strSign = "-"
+	}
+	token := parser.next()
+	if token.Kind != protoTokenNumber {
+		return "", parser.errorf(token, "expected number, found %q", token.Text)
+	}
+	number, err := strconv.ParseInt(strSign+token.Text, 0, 64)
+	if err != nil {
+		return "", parser.errorf(token, "invalid number %q", token.Text)
+	}
+	return strconv.FormatInt(number, 10), nil
+}
+
+// 读取 option 的名字, 如 (my.ext).field
+func (parser *stProtoParser) readOptionName() (string, error) {
+	strName := ""
+	for {
+		token := parser.peek()
+		if token.Kind == protoTokenSymbol && token.Text == "(" {
+			parser.next()
+			ident, err := parser.readFullIdent()
+			if err != nil {
+				return "", err
+			}
+			if _, err := parser.expect(")"); err != nil {
+				return "", err
+			}
+			strName += "(" + ident + ")"
+		} else {
+			ident, err := parser.readIdent()
+			if err != nil {
+				return "", err
+			}
+			strName += ident
+		}
+		if parser.peek().Kind != protoTokenSymbol || parser.peek().Text != "." {
+			return strName, nil
+		}
+		parser.next()
+		strName += "."
+	}
+}
+
+// 读取常量, 聚合值 {...} 原样拼接
+func (parser *stProtoParser) readConstant() (string, error) {
+	token := parser.peek()
+	switch {
+	case token.Kind == protoTokenString:
+		return parser.readString()
+	case token.Kind == protoTokenSymbol && token.Text == "{":
+		parts := []string{}
+		depth := 0
+		for !parser.atEnd() {
+			token = parser.next()
+			parts = append(parts, token.Text)
+			if token.Kind == protoTokenSymbol && token.Text == "{" {
+				depth++
+			} else if token.Kind == protoTokenSymbol && token.Text == "}" {
+				depth--
+				if depth == 0 {
+					return strings.Join(parts, " "), nil
+				}
+			}
+		}
+		return "", parser.errorf(token, "unterminated aggregate value")
+	case token.Kind == protoTokenSymbol && (token.Text == "-" || token.Text == "+"):
+		parser.next()
+		value := parser.next()
+		if value.Kind != protoTokenNumber && value.Kind != protoTokenIdent {
+			return "", parser.errorf(value, "expected number, found %q", value.Text)
+		}
+		if token.Text == "-" {
+			return "-" + value.Text, nil
+		}
+		return value.Text, nil
+	case token.Kind == protoTokenNumber:
+		return parser.next().Text, nil
+	case token.Kind == protoTokenIdent:
+		return parser.readFullIdent()
+	}
+	return "", parser.errorf(token, "expected constant, found %q", token.Text)
+}
+
+// option name = value;
+func (parser *stProtoParser) parseOptionStatement() (StProtoOption, error) {
+	token := parser.next() // option
+	var option StProtoOption
+	option.Line = token.Line
+	var err error
+	if option.Name, err = parser.readOptionName(); err != nil {
+		return option, err
+	}
+	if _, err = parser.expect("="); err != nil {
+		return option, err
+	}
+	if option.Value, err = parser.readConstant(); err != nil {
+		return option, err
+	}
+	_, err = parser.expect(";")
+	return option, err
+}
+
+// [name = value, ...], 没有时返回空
+func (parser *stProtoParser) parseOptionList() ([]StProtoOption, error) {
+	result := []StProtoOption{}
+	if parser.peek().Kind != protoTokenSymbol || parser.peek().Text != "[" {
+		return result, nil
+	}
+	parser.next()
+	for {
+		var option StProtoOption
+		option.Line = parser.peek().Line
+		var err error
+		if option.Name, err = parser.readOptionName(); err != nil {
+			return nil, err
+		}
+		if _, err = parser.expect("="); err != nil {
+			return nil, err
+		}
+		if option.Value, err = parser.readConstant(); err != nil {
+			return nil, err
+		}
+		result = append(result, option)
+		token := parser.next()
+		if token.Kind == protoTokenSymbol && token.Text == "]" {
+			return result, nil
+		}
+		if token.Kind != protoTokenSymbol || token.Text != "," {
+			return nil, parser.errorf(token, "expected \",\" or \"]\", found %q", token.Text)
+		}
+	}
+}
+
+// reserved 1, 2 to 5, "foo"; 以及 extensions 100 to max;
+func (parser *stProtoParser) parseRanges() ([]string, error) {
+	parser.next() // reserved/extensions
+	result := []string{}
+	for {
+		token := parser.peek()
+		if token.Kind == protoTokenString || token.Kind == protoTokenIdent {
+			parser.next()
+			result = append(result, token.Text)
+		} else {
+			strStart, err := parser.readInteger()
+			if err != nil {
+				return nil, err
+			}
+			strRange := strStart
+			if parser.peek().Kind == protoTokenIdent && parser.peek().Text == "to" {
+				parser.next()
+				if parser.peek().Kind == protoTokenIdent && parser.peek().Text == "max" {
+					parser.next()
+					strRange += " to max"
+				} else {
+					strEnd, err := parser.readInteger()
+					if err != nil {
+						return nil, err
+					}
+					strRange += " to " + strEnd
+				}
+			}
+			result = append(result, strRange)
+		}
+		// extensions 的选项
+		if _, err := parser.parseOptionList(); err != nil {
+			return nil, err
+		}
+		token = parser.next()
+		if token.Kind == protoTokenSymbol && token.Text == ";" {
+			return result, nil
+		}
+		if token.Kind != protoTokenSymbol || token.Text != "," {
+			return nil, parser.errorf(token, "expected \",\" or \";\", found %q", token.Text)
+		}
+	}
+}
+
+// 跳过空语句
+func (parser *stProtoParser) skipEmptyStatement() bool {
+	if parser.peek().Kind == protoTokenSymbol && parser.peek().Text == ";" {
+		parser.next()
+		return true
+	}
+	return false
+}
+
+// 注释: 优先使用前置注释, 否则使用行尾注释
+func getProtoComment(leading []string, trailing string) string {
+	if len(leading) > 0 {
+		return strings.Join(leading, "\n")
+	}
+	return trailing
+}
+
+func (parser *stProtoParser) parseFile() (*StProtoFile, error) {
+	file := &StProtoFile{Path: parser.filename, Syntax: "proto2", ImportLines: map[string]int{}}
+	for !parser.atEnd() {
+		if parser.skipEmptyStatement() {
+			continue
+		}
+		token := parser.peek()
+		if token.Kind != protoTokenIdent {
+			return nil, parser.errorf(token, "unexpected %q", token.Text)
+		}
+		switch token.Text {
+		case "syntax", "edition":
+			parser.next()
+			if _, err := parser.expect("="); err != nil {
+				return nil, err
+			}
+			value, err := parser.readString()
+			if err != nil {
+				return nil, err
+			}
+			file.SyntaxLine = token.Line
+			if token.Text == "syntax" {
+				file.Syntax = value
+			} else {
+				file.Syntax = "editions"
+				file.Edition = value
+			}
+			if _, err := parser.expect(";"); err != nil {
+				return nil, err
+			}
+		case "package":
+			parser.next()
+			strPackage, err := parser.readFullIdent()
+			if err != nil {
+				return nil, err
+			}
+			file.Package = strPackage
+			file.PackageLine = token.Line
+			if _, err := parser.expect(";"); err != nil {
+				return nil, err
+			}
+		case "import":
+			parser.next()
+			bPublic := false
+			if parser.peek().Kind == protoTokenIdent && (parser.peek().Text == "public" || parser.peek().Text == "weak") {
+				bPublic = parser.next().Text == "public"
+			}
+			strImport, err := parser.readString()
+			if err != nil {
+				return nil, err
+			}
+			file.Imports = append(file.Imports, strImport)
+			file.ImportLines[strImport] = token.Line
+			if bPublic {
+				file.PublicImports = append(file.PublicImports, strImport)
+			}
+			if _, err := parser.expect(";"); err != nil {
+				return nil, err
+			}
+		case "option":
+			option, err := parser.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			file.Options = append(file.Options, option)
+		case "message":
+			msg, err := parser.parseMessage()
+			if err != nil {
+				return nil, err
+			}
+			file.Messages = append(file.Messages, msg)
+		case "enum":
+			enum, err := parser.parseEnum()
+			if err != nil {
+				return nil, err
+			}
+			file.Enums = append(file.Enums, enum)
+		case "service":
+			service, err := parser.parseService()
+			if err != nil {
+				return nil, err
+			}
+			file.Services = append(file.Services, service)
+		case "extend":
+			extend, err := parser.parseExtend()
+			if err != nil {
+				return nil, err
+			}
+			file.Extends = append(file.Extends, extend)
+		default:
+			return nil, parser.errorf(token, "unexpected %q", token.Text)
+		}
+	}
+	return file, nil
+}
+
+// message Name { ... }
+func (parser *stProtoParser) parseMessage() (*StProtoMessage, error) {
+	token := parser.next() // message
+	msg := &StProtoMessage{Line: token.Line}
+	var err error
+	if msg.Name, err = parser.readIdent(); err != nil {
+		return nil, err
+	}
+	braceToken, err := parser.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	msg.Comment = getProtoComment(token.Leading, braceToken.Trailing)
+	if err := parser.parseMessageBody(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// 解析消息体直到 }
+func (parser *stProtoParser) parseMessageBody(msg *StProtoMessage) error {
+	for {
+		if parser.atEnd() {
+			return parser.errorf(parser.peek(), "unexpected end of file in message %s", msg.Name)
+		}
+		if parser.skipEmptyStatement() {
+			continue
+		}
+		token := parser.peek()
+		if token.Kind == protoTokenSymbol && token.Text == "}" {
+			parser.next()
+			return nil
+		}
+		// 以 . 开头的是带全名类型的字段
+		if token.Kind == protoTokenSymbol && token.Text == "." {
+			field, err := parser.parseField()
+			if err != nil {
+				return err
+			}
+			msg.Fields = append(msg.Fields, field)
+			continue
+		}
+		if token.Kind != protoTokenIdent {
+			return parser.errorf(token, "unexpected %q", token.Text)
+		}
+		// 关键字后面跟着 "名字 {" 才是声明, 否则是以该关键字为类型名的字段
+		bIsBlock := parser.peekAt(1).Kind == protoTokenIdent && parser.peekAt(2).Text == "{"
+		switch {
+		case token.Text == "option":
+			option, err := parser.parseOptionStatement()
+			if err != nil {
+				return err
+			}
+			msg.Options = append(msg.Options, option)
+		case token.Text == "message" && bIsBlock:
+			nested, err := parser.parseMessage()
+			if err != nil {
+				return err
+			}
+			msg.Messages = append(msg.Messages, nested)
+		case token.Text == "enum" && bIsBlock:
+			enum, err := parser.parseEnum()
+			if err != nil {
+				return err
+			}
+			msg.Enums = append(msg.Enums, enum)
+		case token.Text == "oneof" && bIsBlock:
+			if err := parser.parseOneof(msg); err != nil {
+				return err
+			}
+		case token.Text == "extend" && parser.peekAt(1).Text != "=" && parser.peekAt(2).Text != "=":
+			extend, err := parser.parseExtend()
+			if err != nil {
+				return err
+			}
+			msg.Extends = append(msg.Extends, extend)
+		case (token.Text == "reserved" || token.Text == "extensions") && parser.peekAt(2).Text != "=":
+			ranges, err := parser.parseRanges()
+			if err != nil {
+				return err
+			}
+			if token.Text == "reserved" {
+				msg.Reserved = append(msg.Reserved, ranges...)
+			} else {
+				msg.Extensions = append(msg.Extensions, ranges...)
+			}
+		default:
+			field, err := parser.parseField()
+			if err != nil {
+				return err
+			}
+			msg.Fields = append(msg.Fields, field)
+		}
+	}
+}
+
+// [label] type name = number [options];
+func (parser *stProtoParser) parseField() (*StProtoField, error) {
+	token := parser.peek()
+	field := &StProtoField{Line: token.Line}
+	if token.Kind == protoTokenIdent && (token.Text == "optional" || token.Text == "required" || token.Text == "repeated") && parser.peekAt(1).Text != "=" {
+		field.Label = parser.next().Text
+	}
+	var err error
+	if parser.peek().Kind == protoTokenIdent && parser.peek().Text == "map" && parser.peekAt(1).Text == "<" {
+		parser.next()
+		parser.next()
+		if field.KeyType, err = parser.readFullIdent(); err != nil {
+			return nil, err
+		}
+		if _, err = parser.expect(","); err != nil {
+			return nil, err
+		}
+		if field.ValueType, err = parser.readFullIdent(); err != nil {
+			return nil, err
+		}
+		if _, err = parser.expect(">"); err != nil {
+			return nil, err
+		}
+		field.Type = "map"
+	} else if field.Type, err = parser.readFullIdent(); err != nil {
+		return nil, err
+	}
+	if field.Name, err = parser.readIdent(); err != nil {
+		return nil, err
+	}
+	if _, err = parser.expect("="); err != nil {
+		return nil, err
+	}
+	if field.Number, err = parser.readInteger(); err != nil {
+		return nil, err
+	}
+	if field.Options, err = parser.parseOptionList(); err != nil {
+		return nil, err
+	}
+	endToken := parser.next()
+	if field.Type == "group" && endToken.Kind == protoTokenSymbol && endToken.Text == "{" {
+		// proto2 group, 消息体与字段同名
+		field.Group = &StProtoMessage{Name: field.Name, Line: field.Line}
+		if err := parser.parseMessageBody(field.Group); err != nil {
+			return nil, err
+		}
+		field.Comment = getProtoComment(token.Leading, endToken.Trailing)
+		return field, nil
+	}
+	if endToken.Kind != protoTokenSymbol || endToken.Text != ";" {
+		return nil, parser.errorf(endToken, "expected \";\", found %q", endToken.Text)
+	}
+	field.Comment = getProtoComment(nil, endToken.Trailing)
+	if field.Comment == "" {
+		field.Comment = strings.Join(token.Leading, " ")
+	}
+	return field, nil
+}
+
+// oneof name { ... }, 字段追加到 msg.Fields 中
+func (parser *stProtoParser) parseOneof(msg *StProtoMessage) error {
+	token := parser.next() // oneof
+	oneof := &StProtoOneof{Line: token.Line}
+	var err error
+	if oneof.Name, err = parser.readIdent(); err != nil {
+		return err
+	}
+	braceToken, err := parser.expect("{")
+	if err != nil {
+		return err
+	}
+	oneof.Comment = getProtoComment(token.Leading, braceToken.Trailing)
+	msg.Oneofs = append(msg.Oneofs, oneof)
+	for {
+		if parser.atEnd() {
+			return parser.errorf(parser.peek(), "unexpected end of file in oneof %s", oneof.Name)
+		}
+		if parser.skipEmptyStatement() {
+			continue
+		}
+		token := parser.peek()
+		if token.Kind == protoTokenSymbol && token.Text == "}" {
+			parser.next()
+			return nil
+		}
+		if token.Kind == protoTokenIdent && token.Text == "option" {
+			option, err := parser.parseOptionStatement()
+			if err != nil {
+				return err
+			}
+			oneof.Options = append(oneof.Options, option)
+			continue
+		}
+		field, err := parser.parseField()
+		if err != nil {
+			return err
+		}
+		field.OneofName = oneof.Name
+		msg.Fields = append(msg.Fields, field)
+	}
+}
+
+// enum Name { ... }
+func (parser *stProtoParser) parseEnum() (*StProtoEnum, error) {
+	token := parser.next() // enum
+	enum := &StProtoEnum{Line: token.Line}
+	var err error
+	if enum.Name, err = parser.readIdent(); err != nil {
+		return nil, err
+	}
+	braceToken, err := parser.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	enum.Comment = getProtoComment(token.Leading, braceToken.Trailing)
+	for {
+		if parser.atEnd() {
+			return nil, parser.errorf(parser.peek(), "unexpected end of file in enum %s", enum.Name)
+		}
+		if parser.skipEmptyStatement() {
+			continue
+		}
+		token := parser.peek()
+		if token.Kind == protoTokenSymbol && token.Text == "}" {
+			parser.next()
+			return enum, nil
+		}
+		if token.Kind == protoTokenIdent && token.Text == "option" {
+			option, err := parser.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			enum.Options = append(enum.Options, option)
+			continue
+		}
+		if token.Kind == protoTokenIdent && token.Text == "reserved" && parser.peekAt(1).Text != "=" {
+			ranges, err := parser.parseRanges()
+			if err != nil {
+				return nil, err
+			}
+			enum.Reserved = append(enum.Reserved, ranges...)
+			continue
+		}
+		value := StProtoEnumValue{Line: token.Line}
+		if value.Name, err = parser.readIdent(); err != nil {
+			return nil, err
+		}
+		if _, err = parser.expect("="); err != nil {
+			return nil, err
+		}
+		if value.Number, err = parser.readInteger(); err != nil {
+			return nil, err
+		}
+		if value.Options, err = parser.parseOptionList(); err != nil {
+			return nil, err
+		}
+		endToken, err := parser.expect(";")
+		if err != nil {
+			return nil, err
+		}
+		value.Comment = getProtoComment(nil, endToken.Trailing)
+		if value.Comment == "" {
+			value.Comment = strings.Join(token.Leading, " ")
+		}
+		enum.Values = append(enum.Values, value)
+	}
+}
+
+// service Name { rpc ... }
+func (parser *stProtoParser) parseService() (*StProtoService, error) {
+	token := parser.next() // service
+	service := &StProtoService{Line: token.Line}
+	var err error
+	if service.Name, err = parser.readIdent(); err != nil {
+		return nil, err
+	}
+	braceToken, err := parser.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	service.Comment = getProtoComment(token.Leading, braceToken.Trailing)
+	for {
+		if parser.atEnd() {
+			return nil, parser.errorf(parser.peek(), "unexpected end of file in service %s", service.Name)
+		}
+		if parser.skipEmptyStatement() {
+			continue
+		}
+		token := parser.peek()
+		if token.Kind == protoTokenSymbol && token.Text == "}" {
+			parser.next()
+			return service, nil
+		}
+		if token.Kind == protoTokenIdent && token.Text == "option" {
+			option, err := parser.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			service.Options = append(service.Options, option)
+			continue
+		}
+		if token.Kind != protoTokenIdent || token.Text != "rpc" {
+			return nil, parser.errorf(token, "unexpected %q in service %s", token.Text, service.Name)
+		}
+		method, err := parser.parseMethod()
+		if err != nil {
+			return nil, err
+		}
+		service.Methods = append(service.Methods, method)
+	}
+}
+
+// rpc Name ([stream] Req) returns ([stream] Ack) (; | { options })
+func (parser *stProtoParser) parseMethod() (*StProtoMethod, error) {
+	token := parser.next() // rpc
+	method := &StProtoMethod{Line: token.Line}
+	var err error
+	if method.Name, err = parser.readIdent(); err != nil {
+		return nil, err
+	}
+	readType := func() (string, bool, error) {
+		if _, err := parser.expect("("); err != nil {
+			return "", false, err
+		}
+		bStream := false
+		if parser.peek().Kind == protoTokenIdent && parser.peek().Text == "stream" && parser.peekAt(1).Text != ")" {
+			parser.next()
+			bStream = true
+		}
+		strType, err := parser.readFullIdent()
+		if err != nil {
+			return "", false, err
+		}
+		_, err = parser.expect(")")
+		return strType, bStream, err
+	}
+	if method.InputType, method.InputStream, err = readType(); err != nil {
+		return nil, err
+	}
+	if _, err = parser.expect("returns"); err != nil {
+		return nil, err
+	}
+	if method.OutputType, method.OutputStream, err = readType(); err != nil {
+		return nil, err
+	}
+	endToken := parser.next()
+	if endToken.Kind == protoTokenSymbol && endToken.Text == "{" {
+		for {
+			if parser.atEnd() {
+				return nil, parser.errorf(parser.peek(), "unexpected end of file in rpc %s", method.Name)
+			}
+			if parser.skipEmptyStatement() {
+				continue
+			}
+			if parser.peek().Kind == protoTokenSymbol && parser.peek().Text == "}" {
+				endToken = parser.next()
+				break
+			}
+			option, err := parser.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			method.Options = append(method.Options, option)
+		}
+	} else if endToken.Kind != protoTokenSymbol || endToken.Text != ";" {
+		return nil, parser.errorf(endToken, "expected \";\", found %q", endToken.Text)
+	}
+	method.Comment = getProtoComment(token.Leading, endToken.Trailing)
+	return method, nil
+}
+
+// extend Type { fields }
+func (parser *stProtoParser) parseExtend() (*StProtoExtend, error) {
+	token := parser.next() // extend
+	extend := &StProtoExtend{Line: token.Line}
+	var err error
+	if extend.Extendee, err = parser.readFullIdent(); err != nil {
+		return nil, err
+	}
+	if _, err = parser.expect("{"); err != nil {
+		return nil, err
+	}
+	body := &StProtoMessage{Name: extend.Extendee, Line: token.Line}
+	if err := parser.parseMessageBody(body); err != nil {
+		return nil, err
+	}
+	extend.Fields = body.Fields
+	return extend, nil
+}
@@ -0,0 +1,112 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 测试用的 proto 文件, 覆盖枚举, map, oneof, group, 保留项, 选项, 注释和 service
+const testProtoContent = `// role file
+syntax = "proto2";
+
+package pb.game;
+import "common.proto";
+import public "base.proto";
+option go_package = "./pb";
+
+// 颜色
+enum Color {
+    option allow_alias = true;
+    Color_None = 0; // 无
+    Color_Red = 1 [deprecated = true];
+    reserved 5 to 8, 10;
+    reserved "Color_Blue";
+}
+
+message Role {
+    optional int32 id = 1 [default = 5];
+    repeated Color colors = 2 [packed = true];
+    map<string, int64> scores = 3;
+    oneof owner {
+        uint64 role_id = 4;
+        string guild = 5;
+    }
+    optional group Result = 6 {
+        optional string url = 7;
+    }
+    message Inner { optional bytes data = 1; }
+    reserved 9, 11 to max;
+    reserved "old";
+    extensions 100 to 199;
+}
+
+service RoleService {
+    rpc Get (Role) returns (stream Role);
+}
+`
+
+func TestParseProto(t *testing.T) {
+	file, err := ParseProto("role.proto", testProtoContent)
+	if err != nil {
+		t.Fatalf("ParseProto failed. err: %v", err)
+	}
+	role := file.Messages[0]
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"syntax", []interface{}{file.Syntax, file.SyntaxLine}, []interface{}{"proto2", 2}},
+		{"package", []interface{}{file.Package, file.PackageLine}, []interface{}{"pb.game", 4}},
+		{"imports", file.Imports, []string{"common.proto", "base.proto"}},
+		{"import lines", file.ImportLines, map[string]int{"common.proto": 5, "base.proto": 6}},
+		{"public imports", file.PublicImports, []string{"base.proto"}},
+		{"file options", file.Options, []StProtoOption{{Name: "go_package", Value: "./pb", Line: 7}}},
+		{"enum", *file.Enums[0], StProtoEnum{
+			Name:    "Color",
+			Comment: "颜色",
+			Line:    10,
+			Values: []StProtoEnumValue{
+				{Name: "Color_None", Number: "0", Comment: "无", Line: 12, Options: []StProtoOption{}},
+				{Name: "Color_Red", Number: "1", Line: 13, Options: []StProtoOption{{Name: "deprecated", Value: "true", Line: 13}}},
+			},
+			Options:  []StProtoOption{{Name: "allow_alias", Value: "true", Line: 11}},
+			Reserved: []string{"5 to 8", "10", "Color_Blue"},
+		}},
+		{"field with default", *role.Fields[0], StProtoField{Label: "optional", Type: "int32", Name: "id", Number: "1", Line: 19, Options: []StProtoOption{{Name: "default", Value: "5", Line: 19}}}},
+		{"repeated field", *role.Fields[1], StProtoField{Label: "repeated", Type: "Color", Name: "colors", Number: "2", Line: 20, Options: []StProtoOption{{Name: "packed", Value: "true", Line: 20}}}},
+		{"map field", *role.Fields[2], StProtoField{Type: "map", KeyType: "string", ValueType: "int64", Name: "scores", Number: "3", Line: 21, Options: []StProtoOption{}}},
+		{"oneof field", *role.Fields[4], StProtoField{Type: "string", Name: "guild", Number: "5", Line: 24, Options: []StProtoOption{}, OneofName: "owner"}},
+		{"oneof", *role.Oneofs[0], StProtoOneof{Name: "owner", Line: 22}},
+		{"group", []interface{}{role.Fields[5].Type, role.Fields[5].Name, role.Fields[5].Group.Fields[0].Name}, []interface{}{"group", "Result", "url"}},
+		{"nested message", []interface{}{role.Messages[0].Name, role.Messages[0].Fields[0].Type}, []interface{}{"Inner", "bytes"}},
+		{"message reserved", role.Reserved, []string{"9", "11 to max", "old"}},
+		{"extensions", role.Extensions, []string{"100 to 199"}},
+		{"method", *file.Services[0].Methods[0], StProtoMethod{Name: "Get", InputType: "Role", OutputType: "Role", OutputStream: true, Line: 36}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestParseProtoError(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing number", "syntax = \"proto3\";\nmessage A {\n  int32 a = ;\n}", "a.proto:3: expected number, found \";\""},
+		{"unexpected end", "message A {", "a.proto:1: unexpected end of file in message A"},
+		{"missing semicolon", "enum E { A = 0 }", "a.proto:1: expected \";\", found \"}\""},
+		{"unterminated comment", "/* x", "a.proto:1: unterminated comment"},
+		{"unterminated string", "x = \"abc", "a.proto:1: unterminated string"},
+	}
+	for _, test := range tests {
+		_, err := ParseProto("a.proto", test.content)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+}
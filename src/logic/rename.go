@@ -0,0 +1,233 @@
+package logic
+
+import (
+	"sort"
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 重命名时的一处修改
+type StRenameChange struct {
+	UnitName string // 所在的顶层单元名, 为重命名前的名字
+	TypePath string // 所在类型的全名, 为重命名前的名字
+	Field    string // 字段名, 重命名类型或枚举值本身时为空
+	OldValue string
+	NewValue string
+}
+
+// 展示文本, 如 Role.item: Item -> Goods
+func (change *StRenameChange) String() string {
+	strName := change.TypePath
+	if change.Field != "" {
+		strName += "." + change.Field
+	}
+	return strName + ": " + change.OldValue + " -> " + change.NewValue
+}
+
+// 重命名的结果, Schema 为重命名后的协议, 原协议不变
+type StRenameResult struct {
+	Schema  *model.Schema
+	Changes []StRenameChange
+	Notes   []string // 需要注意的影响, 如协议改名后的消息 ID
+}
+
+// 获取受影响的顶层单元名, 排序
+func (result *StRenameResult) GetAffectedUnits() []string {
+	unitSet := map[string]bool{}
+	units := []string{}
+	for _, change := range result.Changes {
+		if !unitSet[change.UnitName] {
+			unitSet[change.UnitName] = true
+			units = append(units, change.UnitName)
+		}
+	}
+	sort.Strings(units)
+	return units
+}
+
+// 重命名类型后的全名, 包括该类型下的嵌套类型
+func renamePath(path string, oldPath string, newPath string) string {
+	if path == oldPath {
+		return newPath
+	}
+	if strings.HasPrefix(path, oldPath+".") {
+		return newPath + path[len(oldPath):]
+	}
+	return path
+}
+
+// 重命名 enum/data/protocol 或嵌套类型, path 为类型全名, newName 为新的名字(不含外层).
+// 引用该类型及其嵌套类型的字段类型会同步修改, 修改后所有字段的类型解析结果必须不变, 否则失败
+func RenameType(schema *model.Schema, path string, newName string) (bool, StRenameResult, string) {
+	result := StRenameResult{}
+	if schema == nil {
+		return false, result, "the xml is not opened"
+	}
+	if !CheckIdentifier(newName) {
+		return false, result, "invalid name \"" + newName + "\""
+	}
+	parentPath, oldName := splitEnumPath(path)
+	newPath := newName
+	if parentPath != "" {
+		newPath = parentPath + "." + newName
+	}
+	if newName == oldName {
+		return false, result, "the name is not changed"
+	}
+
+	cloned, err := schema.Clone()
+	if err != nil {
+		return false, result, "copy schema failed: " + err.Error()
+	}
+	var strUnitName string
+	var renameTarget func()
+	if parentPath == "" {
+		category := cloned.FindCategory(oldName)
+		if category != model.CategoryEnum && category != model.CategoryData && category != model.CategoryProtocol {
+			return false, result, "enum, data or protocol " + path + " is not found"
+		}
+		if cloned.FindCategory(newName) != "" {
+			return false, result, "the name " + newName + " is already used"
+		}
+		strUnitName = oldName
+		renameTarget = func() {
+			if category == model.CategoryEnum {
+				cloned.FindEnum(oldName).Name = newName
+			} else {
+				cloned.FindMessage(category, oldName).Name = newName
+			}
+		}
+	} else {
+		parent := cloned.FindMessageByPath(parentPath)
+		if parent == nil || !parent.HasNested(oldName) {
+			return false, result, "nested type " + path + " is not found"
+		}
+		renameTarget = func() {
+			if enum := parent.FindNestedEnum(oldName); enum != nil {
+				enum.Name = newName
+			} else {
+				parent.FindNestedMessage(oldName).Name = newName
+			}
+		}
+	}
+
+	// 类型名与作用域中的类型, 字段和枚举值共用名字空间
+	if strUsed, ok := GetEnumScopeNames(cloned, parentPath, "")[newName]; ok {
+		return false, result, "the name " + newName + " is already used by " + strUsed
+	}
+
+	// 记录每个字段原来的解析结果
+	type stFieldRef struct {
+		field    *model.Field
+		unitName string
+		scope    string
+		resolved string
+	}
+	refList := []stFieldRef{}
+	typeMap := cloned.GetTypeMap()
+	cloned.WalkTypes(func(typePath string, unitName string, enum *model.Enum, msg *model.Message) {
+		if typePath == path && strUnitName == "" {
+			strUnitName = unitName
+		}
+		if msg == nil {
+			return
+		}
+		for i := range msg.Fields {
+			field := &msg.Fields[i]
+			if field.EntryType == "" || model.IsScalarType(field.EntryType) {
+				continue
+			}
+			refList = append(refList, stFieldRef{field, unitName, typePath, model.ResolveTypeName(typeMap, typePath, field.EntryType)})
+		}
+	})
+	result.Changes = append(result.Changes, StRenameChange{UnitName: strUnitName, TypePath: path, OldValue: oldName, NewValue: newName})
+
+	renameTarget()
+
+	// 修改引用: 类型名中对应被重命名类型的那一段改为新名字
+	newTypeMap := cloned.GetTypeMap()
+	for _, ref := range refList {
+		if ref.resolved == "" {
+			continue
+		}
+		strExpected := renamePath(ref.resolved, path, newPath)
+		if strExpected != ref.resolved {
+			strRest := strings.TrimPrefix(ref.resolved[len(path):], ".")
+			nRest := 0
+			if strRest != "" {
+				nRest = len(strings.Split(strRest, "."))
+			}
+			strPrefix := ""
+			strType := ref.field.EntryType
+			if strings.HasPrefix(strType, ".") {
+				strPrefix = "."
+				strType = strType[1:]
+			}
+			parts := strings.Split(strType, ".")
+			if index := len(parts) - 1 - nRest; index >= 0 {
+				parts[index] = newName
+				strNewType := strPrefix + strings.Join(parts, ".")
+				result.Changes = append(result.Changes, StRenameChange{
+					UnitName: ref.unitName,
+					TypePath: ref.scope,
+					Field:    ref.field.EntryName,
+					OldValue: ref.field.EntryType,
+					NewValue: strNewType,
+				})
+				ref.field.EntryType = strNewType
+			}
+		}
+		strResolved := model.ResolveTypeName(newTypeMap, renamePath(ref.scope, path, newPath), ref.field.EntryType)
+		if strResolved != strExpected {
+			logrus.Warn("[RenameType] type of field changes. field:", ref.scope, ".", ref.field.EntryName, ", expected:", strExpected, ", resolved:", strResolved)
+			return false, result, "type " + ref.field.EntryType + " of " + ref.scope + "." + ref.field.EntryName + " would resolve to " + strResolved + " instead of " + strExpected
+		}
+	}
+	result.Schema = cloned
+	logrus.Info("[RenameType] done. path:", path, ", newName:", newName, ", changes:", len(result.Changes))
+	return true, result, ""
+}
+
+// 重命名枚举值, path 为枚举全名. 类型为该枚举, 默认值为该值的字段同步修改
+func RenameEnumValue(schema *model.Schema, path string, valueName string, newName string) (bool, StRenameResult, string) {
+	result := StRenameResult{}
+	if schema == nil {
+		return false, result, "the xml is not opened"
+	}
+	if !CheckIdentifier(newName) {
+		return false, result, "invalid name \"" + newName + "\""
+	}
+	if newName == valueName {
+		return false, result, "the name is not changed"
+	}
+	cloned, err := schema.Clone()
+	if err != nil {
+		return false, result, "copy schema failed: " + err.Error()
+	}
+	enum := cloned.FindEnumByPath(path)
+	if enum == nil {
+		return false, result, "enum " + path + " is not found"
+	}
+	value := enum.FindValue(valueName)
+	if value == nil {
+		return false, result, "enum value " + valueName + " is not found in " + path
+	}
+	if isOk, strReport := checkNewEnumValueName(cloned, path, enum, newName); !isOk {
+		return false, result, strReport
+	}
+	strUnitName := path
+	cloned.WalkTypes(func(typePath string, unitName string, walkEnum *model.Enum, msg *model.Message) {
+		if walkEnum == enum {
+			strUnitName = unitName
+		}
+	})
+	value.EntryName = newName
+	result.Changes = append(result.Changes, StRenameChange{UnitName: strUnitName, TypePath: path, OldValue: valueName, NewValue: newName})
+	result.Changes = append(result.Changes, renameEnumDefault(cloned, path, valueName, newName)...)
+	result.Schema = cloned
+	logrus.Info("[RenameEnumValue] done. path:", path, ", value:", valueName, ", newName:", newName, ", changes:", len(result.Changes))
+	return true, result, ""
+}
@@ -0,0 +1,233 @@
+package logic
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+func TestRenameType(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		newName   string
+		wantOk    bool
+		wantError string
+		want      []string
+	}{
+		{
+			name:    "enum",
+			path:    "ItemType",
+			newName: "ItemKind",
+			wantOk:  true,
+			want:    []string{"ItemType: ItemType -> ItemKind", "Bag.item_types: ItemType -> ItemKind", "Bag.Slot.type: ItemType -> ItemKind"},
+		},
+		{
+			name:    "data with nested type references",
+			path:    "Bag",
+			newName: "Pack",
+			wantOk:  true,
+			want:    []string{"Bag: Bag -> Pack", "Bag.slot_map: Bag.Slot -> Pack.Slot", "Role.bag: Bag -> Pack"},
+		},
+		{
+			name:    "nested message",
+			path:    "Bag.Slot",
+			newName: "Cell",
+			wantOk:  true,
+			want:    []string{"Bag.Slot: Slot -> Cell", "Bag.slot_map: Bag.Slot -> Bag.Cell", "Bag.slots: Slot -> Cell"},
+		},
+		{
+			name:    "protocol",
+			path:    "CS_Login",
+			newName: "CS_SignIn",
+			wantOk:  true,
+			want:    []string{"CS_Login: CS_Login -> CS_SignIn"},
+		},
+		{
+			name:      "invalid name",
+			path:      "Role",
+			newName:   "1Role",
+			wantError: "invalid name \"1Role\"",
+		},
+		{
+			name:      "name used",
+			path:      "Role",
+			newName:   "Bag",
+			wantError: "the name Bag is already used",
+		},
+		{
+			name:      "nested name used",
+			path:      "Bag.Slot",
+			newName:   "Kind",
+			wantError: "the name Kind is already used by nested enum Kind",
+		},
+		{
+			name:      "rpc is not renamed",
+			path:      "CS_GetRole",
+			newName:   "CS_FindRole",
+			wantError: "enum, data or protocol CS_GetRole is not found",
+		},
+		{
+			name:      "not found",
+			path:      "Bag.Box",
+			newName:   "Case",
+			wantError: "nested type Bag.Box is not found",
+		},
+	}
+	schema := loadTestSchema(t, testSchemaXml)
+	strOriginal := getSchemaText(t, schema)
+	for _, test := range tests {
+		isOk, result, strError := RenameType(schema, test.path, test.newName)
+		if isOk != test.wantOk || strError != test.wantError {
+			t.Errorf("%s: got (%v, %q), want (%v, %q)", test.name, isOk, strError, test.wantOk, test.wantError)
+			continue
+		}
+		if !isOk {
+			continue
+		}
+		got := []string{}
+		for _, change := range result.Changes {
+			got = append(got, change.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: changes got %q, want %q", test.name, got, test.want)
+		}
+		// 消息 ID 的影响由 CoreManager 根据配置补充
+		if len(result.Notes) != 0 {
+			t.Errorf("%s: notes got %q, want none", test.name, result.Notes)
+		}
+		// 重命名后所有类型都能解析
+		if diagList := DiagnoseSchema(result.Schema, NewGenConfig()); HasErrorDiagnostic(diagList) {
+			t.Errorf("%s: renamed schema is invalid: %q", test.name, getDiagnosticTexts(diagList))
+		}
+		if getSchemaText(t, schema) != strOriginal {
+			t.Fatalf("%s: the original schema is changed", test.name)
+		}
+	}
+}
+
+func TestRenameEnumValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		value     string
+		newName   string
+		wantError string
+		want      []string
+	}{
+		{
+			name:    "default value",
+			path:    "ItemType",
+			value:   "ItemType_None",
+			newName: "ItemType_Empty",
+			want:    []string{"ItemType: ItemType_None -> ItemType_Empty", "Bag.Slot.type: ItemType_None -> ItemType_Empty"},
+		},
+		{
+			name:    "nested enum",
+			path:    "Bag.Kind",
+			value:   "Kind_None",
+			newName: "Kind_Unknown",
+			want:    []string{"Bag.Kind: Kind_None -> Kind_Unknown", "Bag.kind: Kind_None -> Kind_Unknown"},
+		},
+		{
+			name:      "value not found",
+			path:      "ItemType",
+			value:     "ItemType_Armor",
+			newName:   "ItemType_Shield",
+			wantError: "enum value ItemType_Armor is not found in ItemType",
+		},
+	}
+	schema := loadTestSchema(t, testSchemaXml)
+	for _, test := range tests {
+		isOk, result, strError := RenameEnumValue(schema, test.path, test.value, test.newName)
+		if isOk != (test.wantError == "") || strError != test.wantError {
+			t.Errorf("%s: got (%v, %q), want error %q", test.name, isOk, strError, test.wantError)
+			continue
+		}
+		if !isOk {
+			continue
+		}
+		got := []string{}
+		for _, change := range result.Changes {
+			got = append(got, change.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: changes got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// 协议改名后保存时沿用原来的消息 ID, 服务器对改变时分配新的 ID
+func TestRenameProtocolKeepsMsgId(t *testing.T) {
+	strDir := t.TempDir()
+	strLockPath := filepath.Join(strDir, "protocolgo_msgid.xml")
+	coremgr := newTestCoreManager()
+	coremgr.Config = etree.NewDocument()
+	if err := coremgr.Config.ReadFromString(`<config>
+    <servershort>
+        <servershort FullName="客户端[Client]" ClientShortName="C" ServerShortName="C" IsClient="true"/>
+        <servershort FullName="场景服[GameServer]" ClientShortName="S" ServerShortName="GS"/>
+        <servershort FullName="MsgServer" ClientShortName="M" ServerShortName="MS"/>
+    </servershort>
+    <msgid lockfile="` + strLockPath + `" start="1000" rangesize="1000"/>
+</config>`); err != nil {
+		t.Fatal(err)
+	}
+	strXmlPath := filepath.Join(strDir, "protocolgo.xml")
+	if !coremgr.ReadXmlFromReader(strings.NewReader(testSchemaXml), strXmlPath) {
+		t.Fatal("ReadXmlFromReader failed")
+	}
+	if _, errorList := coremgr.UpdateMsgIds(coremgr.GetFileSchema(), strXmlPath); len(errorList) > 0 {
+		t.Fatalf("UpdateMsgIds: %q", errorList)
+	}
+	registry, _ := LoadMsgIdRegistry(strLockPath)
+	nLoginId := registry.FindEntry("CS_Login").Id
+
+	tests := []struct {
+		name     string
+		oldName  string
+		newName  string
+		wantNote string
+		wantSame bool // 新名字是否沿用旧名字的 ID
+	}{
+		{"same pair", "CS_Login", "CS_SignIn", "protocol CS_SignIn keeps the message id of CS_Login when saved", true},
+		{"pair changed", "CS_SignIn", "MS_SignIn", "protocol MS_SignIn gets a new message id when saved, the server pair is changed", false},
+	}
+	for _, test := range tests {
+		isOk, result, strError := coremgr.Rename(test.oldName, "", test.newName, true)
+		if !isOk {
+			t.Fatalf("%s: Rename failed: %s", test.name, strError)
+		}
+		if !reflect.DeepEqual(result.Notes, []string{test.wantNote}) {
+			t.Errorf("%s: notes got %q, want %q", test.name, result.Notes, test.wantNote)
+		}
+		if !coremgr.SaveProtoXmlToFile() {
+			t.Fatalf("%s: SaveProtoXmlToFile failed", test.name)
+		}
+		registry, _ = LoadMsgIdRegistry(strLockPath)
+		oldEntry, newEntry := registry.FindEntry(test.oldName), registry.FindEntry(test.newName)
+		if oldEntry != nil || newEntry == nil {
+			t.Fatalf("%s: got entries %v %v", test.name, oldEntry, newEntry)
+		}
+		if (newEntry.Id == nLoginId) != test.wantSame {
+			t.Errorf("%s: got id %d, id of CS_Login is %d", test.name, newEntry.Id, nLoginId)
+		}
+		if len(registry.Check()) > 0 {
+			t.Errorf("%s: check: %q", test.name, registry.Check())
+		}
+	}
+
+	// 改名后撤销, 保存时不改锁文件中的名字
+	coremgr.Rename("MS_SignIn", "", "MS_Enter", true)
+	coremgr.Undo()
+	if !coremgr.SaveProtoXmlToFile() {
+		t.Fatal("SaveProtoXmlToFile failed")
+	}
+	registry, _ = LoadMsgIdRegistry(strLockPath)
+	if registry.FindEntry("MS_SignIn") == nil || registry.FindEntry("MS_Enter") != nil {
+		t.Errorf("undo: got entries %v", registry.Entries)
+	}
+}
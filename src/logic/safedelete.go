@@ -0,0 +1,190 @@
+package logic
+
+import (
+	"strings"
+
+	"protocolgo/src/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 删除被引用的类型时对引用字段的处理
+type EDeleteMode int
+
+const (
+	DeleteMode_Refuse  EDeleteMode = iota + 1 // 仍被引用时不删除
+	DeleteMode_Cascade                        // 同时删除引用它的字段
+	DeleteMode_Replace                        // 引用它的字段改为另一个类型
+)
+
+// 级联删除时字段修改后的展示值
+const deletedFieldValue = "(deleted)"
+
+// 删除后仍引用被删除类型的字段
+type StDeleteDependent struct {
+	model.FieldRef
+	FieldType string // 字段的类型, 为 xml 中的写法
+	TypeName  string // 引用的被删除类型的全名
+}
+
+// 展示文本, 如 Role.item uses Item
+func (dependent *StDeleteDependent) String() string {
+	return dependent.TypePath + "." + dependent.Field + " uses " + dependent.TypeName
+}
+
+// 删除的结果, Schema 为删除后的协议, 原协议不变
+type StDeleteResult struct {
+	Schema     *model.Schema
+	Dependents []StDeleteDependent
+	Changes    []StRenameChange // 级联删除或替换类型时对字段的修改
+}
+
+// 被删除的类型全名的前缀: 顶层单元为单元名, rpc 为 XxxReq 和 XxxAck, 嵌套类型为全名
+func getDeleteRoots(category string, path string) []string {
+	if category == model.CategoryRpc {
+		return []string{path + model.RpcTypeReq, path + model.RpcTypeAck}
+	}
+	return []string{path}
+}
+
+// 类型是否随删除一起被删除, 包括被删除类型下的嵌套类型
+func isDeletedPath(roots []string, typePath string) bool {
+	for _, strRoot := range roots {
+		if typePath == strRoot || strings.HasPrefix(typePath, strRoot+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取删除后仍引用被删除类型的字段, 按遍历顺序. category 为顶层单元的分类, 删除嵌套类型时为空.
+// 被删除类型内部的字段随之删除, 不计入
+func GetDeleteDependents(schema *model.Schema, category string, path string) []StDeleteDependent {
+	result := []StDeleteDependent{}
+	if schema == nil {
+		return result
+	}
+	roots := getDeleteRoots(category, path)
+	typeMap := schema.GetTypeMap()
+	schema.WalkTypes(func(typePath string, unitName string, enum *model.Enum, msg *model.Message) {
+		if msg == nil || isDeletedPath(roots, typePath) {
+			return
+		}
+		for _, field := range msg.Fields {
+			strResolved := model.ResolveTypeName(typeMap, typePath, field.EntryType)
+			if strResolved == "" || !isDeletedPath(roots, strResolved) {
+				continue
+			}
+			result = append(result, StDeleteDependent{
+				FieldRef:  model.FieldRef{UnitName: unitName, TypePath: typePath, Field: field.EntryName},
+				FieldType: field.EntryType,
+				TypeName:  strResolved,
+			})
+		}
+	})
+	return result
+}
+
+// 删除顶层单元或嵌套类型. category 为顶层单元的分类, 删除嵌套类型时为空, 此时 path 为类型全名.
+// 仍被引用时按 mode 处理, replaceType 为 DeleteMode_Replace 时引用字段的新类型
+func DeleteType(schema *model.Schema, category string, path string, mode EDeleteMode, replaceType string) (bool, StDeleteResult, string) {
+	result := StDeleteResult{}
+	if schema == nil {
+		return false, result, "the xml is not opened"
+	}
+	result.Dependents = GetDeleteDependents(schema, category, path)
+	if len(result.Dependents) > 0 && mode == DeleteMode_Refuse {
+		return false, result, path + " is still used by " + result.Dependents[0].String()
+	}
+
+	cloned, err := schema.Clone()
+	if err != nil {
+		return false, result, "copy schema failed: " + err.Error()
+	}
+	if category != "" {
+		if !cloned.RemoveUnit(category, path) {
+			return false, result, category + " " + path + " is not found"
+		}
+	} else {
+		parentPath, strName := splitEnumPath(path)
+		parent := cloned.FindMessageByPath(parentPath)
+		if parentPath == "" || parent == nil || !parent.RemoveNested(strName) {
+			return false, result, "nested type " + path + " is not found"
+		}
+	}
+	if len(result.Dependents) > 0 {
+		var isOk bool
+		var strError string
+		if mode == DeleteMode_Cascade {
+			isOk, strError = cascadeDeleteFields(cloned, &result)
+		} else if mode == DeleteMode_Replace {
+			isOk, strError = replaceFieldType(cloned, &result, replaceType)
+		} else {
+			strError = "unknown delete mode"
+		}
+		if !isOk {
+			return false, result, strError
+		}
+	}
+	result.Schema = cloned
+	logrus.Info("[DeleteType] done. category:", category, ", path:", path, ", mode:", mode, ", dependents:", len(result.Dependents))
+	return true, result, ""
+}
+
+// 删除引用被删除类型的字段, 序号和名字记录为保留
+func cascadeDeleteFields(schema *model.Schema, result *StDeleteResult) (bool, string) {
+	for _, dependent := range result.Dependents {
+		msg := schema.FindMessageByPath(dependent.TypePath)
+		if msg == nil {
+			return false, "message " + dependent.TypePath + " is not found"
+		}
+		for i, field := range msg.Fields {
+			if field.EntryName == dependent.Field {
+				msg.Fields = append(msg.Fields[:i], msg.Fields[i+1:]...)
+				for _, reserved := range GetRemovedFields([]model.Field{field}, msg.Fields, true) {
+					msg.Reserved = model.AddReserved(msg.Reserved, reserved)
+				}
+				break
+			}
+		}
+		result.Changes = append(result.Changes, StRenameChange{
+			UnitName: dependent.UnitName,
+			TypePath: dependent.TypePath,
+			Field:    dependent.Field,
+			OldValue: dependent.FieldType,
+			NewValue: deletedFieldValue,
+		})
+	}
+	return true, ""
+}
+
+// 引用被删除类型的字段改为 replaceType, 新类型在每个字段的作用域中都必须能解析, 原默认值清空
+func replaceFieldType(schema *model.Schema, result *StDeleteResult, replaceType string) (bool, string) {
+	if replaceType == "" {
+		return false, "the replace type is empty"
+	}
+	typeMap := schema.GetTypeMap()
+	for _, dependent := range result.Dependents {
+		if !model.IsScalarType(replaceType) && model.ResolveTypeName(typeMap, dependent.TypePath, replaceType) == "" {
+			return false, "type " + replaceType + " can not be resolved in " + dependent.TypePath
+		}
+		msg := schema.FindMessageByPath(dependent.TypePath)
+		if msg == nil {
+			return false, "message " + dependent.TypePath + " is not found"
+		}
+		field := msg.FindField(dependent.Field)
+		if field == nil {
+			return false, "field " + dependent.TypePath + "." + dependent.Field + " is not found"
+		}
+		field.EntryType = replaceType
+		field.EntryDefault = ""
+		result.Changes = append(result.Changes, StRenameChange{
+			UnitName: dependent.UnitName,
+			TypePath: dependent.TypePath,
+			Field:    dependent.Field,
+			OldValue: dependent.FieldType,
+			NewValue: replaceType,
+		})
+	}
+	return true, ""
+}
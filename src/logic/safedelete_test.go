@@ -0,0 +1,159 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+func TestDeleteType(t *testing.T) {
+	tests := []struct {
+		name           string
+		category       string
+		path           string
+		mode           EDeleteMode
+		replaceType    string
+		wantError      string
+		wantDependents []string
+		wantChanges    []string
+		check          func(schema *model.Schema) string
+	}{
+		{
+			name:           "refuse when used",
+			category:       model.CategoryData,
+			path:           "Role",
+			mode:           DeleteMode_Refuse,
+			wantError:      "Role is still used by CS_GetRoleAck.role uses Role",
+			wantDependents: []string{"CS_GetRoleAck.role uses Role"},
+		},
+		{
+			name:           "unused",
+			category:       model.CategoryProtocol,
+			path:           "CS_Login",
+			mode:           DeleteMode_Refuse,
+			wantDependents: []string{},
+			check: func(schema *model.Schema) string {
+				if schema.FindMessageByPath("CS_Login") != nil {
+					return "CS_Login is not deleted"
+				}
+				return ""
+			},
+		},
+		{
+			name:           "rpc",
+			category:       model.CategoryRpc,
+			path:           "CS_GetRole",
+			mode:           DeleteMode_Refuse,
+			wantDependents: []string{},
+			check: func(schema *model.Schema) string {
+				if len(schema.Rpcs) != 0 {
+					return "CS_GetRole is not deleted"
+				}
+				return ""
+			},
+		},
+		{
+			name:           "cascade",
+			category:       model.CategoryEnum,
+			path:           "ItemType",
+			mode:           DeleteMode_Cascade,
+			wantDependents: []string{"Bag.item_types uses ItemType", "Bag.Slot.type uses ItemType"},
+			wantChanges:    []string{"Bag.item_types: ItemType -> (deleted)", "Bag.Slot.type: ItemType -> (deleted)"},
+			check: func(schema *model.Schema) string {
+				if schema.FindMessageByPath("Bag").FindField("item_types") != nil {
+					return "Bag.item_types is not deleted"
+				}
+				if !reflect.DeepEqual(schema.FindMessageByPath("Bag.Slot").Reserved, []model.Reserved{{EntryIndex: "2", EntryName: "type"}}) {
+					return "Bag.Slot.type is not reserved"
+				}
+				return ""
+			},
+		},
+		{
+			name:           "replace",
+			category:       model.CategoryEnum,
+			path:           "ItemType",
+			mode:           DeleteMode_Replace,
+			replaceType:    "int32",
+			wantDependents: []string{"Bag.item_types uses ItemType", "Bag.Slot.type uses ItemType"},
+			wantChanges:    []string{"Bag.item_types: ItemType -> int32", "Bag.Slot.type: ItemType -> int32"},
+			check: func(schema *model.Schema) string {
+				field := schema.FindMessageByPath("Bag.Slot").FindField("type")
+				if field.EntryType != "int32" || field.EntryDefault != "" {
+					return "Bag.Slot.type is not replaced"
+				}
+				return ""
+			},
+		},
+		{
+			name:           "replace type not resolved",
+			category:       model.CategoryEnum,
+			path:           "ItemType",
+			mode:           DeleteMode_Replace,
+			replaceType:    "Item",
+			wantError:      "type Item can not be resolved in Bag",
+			wantDependents: []string{"Bag.item_types uses ItemType", "Bag.Slot.type uses ItemType"},
+		},
+		{
+			name:           "nested type",
+			path:           "Bag.Slot",
+			mode:           DeleteMode_Cascade,
+			wantDependents: []string{"Bag.slot_map uses Bag.Slot", "Bag.slots uses Bag.Slot"},
+			wantChanges:    []string{"Bag.slot_map: Bag.Slot -> (deleted)", "Bag.slots: Slot -> (deleted)"},
+			check: func(schema *model.Schema) string {
+				bag := schema.FindMessageByPath("Bag")
+				if schema.FindMessageByPath("Bag.Slot") != nil || len(bag.Fields) != 4 {
+					return "Bag.Slot is not deleted"
+				}
+				return ""
+			},
+		},
+		{
+			name:           "nested type not found",
+			path:           "Bag.Box",
+			mode:           DeleteMode_Refuse,
+			wantError:      "nested type Bag.Box is not found",
+			wantDependents: []string{},
+		},
+		{
+			name:           "unit not found",
+			category:       model.CategoryProtocol,
+			path:           "CS_Logout",
+			mode:           DeleteMode_Refuse,
+			wantError:      "protocol CS_Logout is not found",
+			wantDependents: []string{},
+		},
+	}
+	schema := loadTestSchema(t, testSchemaXml)
+	strOriginal := getSchemaText(t, schema)
+	for _, test := range tests {
+		isOk, result, strError := DeleteType(schema, test.category, test.path, test.mode, test.replaceType)
+		if isOk != (test.wantError == "") || strError != test.wantError {
+			t.Errorf("%s: got (%v, %q), want error %q", test.name, isOk, strError, test.wantError)
+			continue
+		}
+		gotDependents := []string{}
+		for _, dependent := range result.Dependents {
+			gotDependents = append(gotDependents, dependent.String())
+		}
+		if !reflect.DeepEqual(gotDependents, test.wantDependents) {
+			t.Errorf("%s: dependents got %q, want %q", test.name, gotDependents, test.wantDependents)
+		}
+		var gotChanges []string
+		for _, change := range result.Changes {
+			gotChanges = append(gotChanges, change.String())
+		}
+		if !reflect.DeepEqual(gotChanges, test.wantChanges) {
+			t.Errorf("%s: changes got %q, want %q", test.name, gotChanges, test.wantChanges)
+		}
+		if isOk && test.check != nil {
+			if strCheck := test.check(result.Schema); strCheck != "" {
+				t.Errorf("%s: %s", test.name, strCheck)
+			}
+		}
+		if getSchemaText(t, schema) != strOriginal {
+			t.Fatalf("%s: the original schema is changed", test.name)
+		}
+	}
+}
@@ -5,30 +5,11 @@ import (
 	"regexp"
 	"sort"
 
-	"fyne.io/fyne/v2/widget"
+	"protocolgo/src/model"
+
 	"github.com/sirupsen/logrus"
 )
 
-// Unit 的一行数据
-type StRowUnit struct {
-	EntryIndex  *widget.Entry
-	EntryOption *widget.Select
-	// EntryType   *widget.Entry
-	EntryType    *CompletionEntry
-	EntryName    *widget.Entry
-	EntryDefault *widget.Select
-	EntryComment *widget.Entry
-}
-
-type StStrRowUnit struct {
-	EntryIndex   string
-	EntryOption  string
-	EntryType    string
-	EntryName    string
-	EntryDefault string
-	EntryComment string
-}
-
 // Unit 集合
 type StUnits struct {
 	UnitListName string
@@ -41,38 +22,41 @@ type StUnit struct {
 	UnitComment  string
 	TableType    ETableType
 	SubTableType ESubTableType
-	RowList      []StRowUnit
+	RowList      []model.Field
 	IsCreatNew   bool
+	ParentPath   string           // 嵌套类型所在消息的全名, 顶层单元为空
+	Reserved     []model.Reserved // 保留的序号和名字
 }
 
-// Unit container
-type StUnitContainer struct {
-	UnitNameEntry    *widget.Entry
-	UnitCommentEntry *widget.Entry
-	TableType        ETableType
-	RowList          *[]StRowUnit
-	IsCreatNew       bool
-}
-
-func (editrow *StRowUnit) RemoveElementFromSlice(s []StRowUnit, elementToBeDeleted StRowUnit) []StRowUnit {
-	for i, element := range s {
-		// 使用适当的比较来确定哪一个元素应被删除
-		if element == elementToBeDeleted {
-			return append(s[:i], s[i+1:]...)
-		}
+// 获取单元的全名, 也是字段类型解析的作用域
+func (stUnit *StUnit) GetTypePath() string {
+	if stUnit.ParentPath != "" {
+		return stUnit.ParentPath + "." + stUnit.UnitName
 	}
-	return s
+	if stUnit.TableType != TableType_RPC {
+		return stUnit.UnitName
+	}
+	if stUnit.SubTableType == SubTableType_RpcReq {
+		return stUnit.UnitName + model.RpcTypeReq
+	} else if stUnit.SubTableType == SubTableType_RpcAck {
+		return stUnit.UnitName + model.RpcTypeAck
+	}
+	return stUnit.UnitName
 }
 
-// 检查字段名是否有相同的,或者有空的.
-func CheckFieldNameList(rowList []StRowUnit) bool {
+// 检查字段名是否有相同的,或者有空的,或者已被保留.
+func CheckFieldNameList(rowList []model.Field, reservedList []model.Reserved) bool {
 	fieldValueNames := make([]string, len(rowList))
 	for i, row := range rowList {
-		if row.EntryName.Text == "" {
-			logrus.Error("Found an empty field name. Index:", row.EntryIndex.Text)
+		if row.EntryName == "" {
+			logrus.Error("Found an empty field name. Index:", row.EntryIndex)
+			return false
+		}
+		if model.FindReservedName(reservedList, row.EntryName) != nil {
+			logrus.Error("Found a reserved field name. EntryName:", row.EntryName)
 			return false
 		}
-		fieldValueNames[i] = row.EntryName.Text
+		fieldValueNames[i] = row.EntryName
 	}
 
 	sort.Strings(fieldValueNames)
@@ -87,37 +71,94 @@ func CheckFieldNameList(rowList []StRowUnit) bool {
 	return true
 }
 
-// 检查字段序列号是否有相同的,或者有空的.
-func CheckFieldIndexList(rowList []StRowUnit) bool {
-	fieldIndexes := make([]string, len(rowList))
-	for i, row := range rowList {
-		if row.EntryIndex.Text == "" {
-			logrus.Error("Found an empty field index. EntryName:", row.EntryName.Text)
-			return false
+// 对比修改前后的字段, 返回被删除的字段序号和名字, 只返回没有被现在的字段使用的序号和名字.
+// 同一序号只改了名字(消息字段还要求类型不变)视为改名; 序号被其他字段重用时只保留名字, 由兼容性检查报告重用
+func GetRemovedFields(oldRowList []model.Field, newRowList []model.Field, bHasType bool) []model.Reserved {
+	newIndexMap := map[string]model.Field{}
+	newNameMap := map[string]bool{}
+	for _, row := range newRowList {
+		newIndexMap[row.EntryIndex] = row
+		newNameMap[row.EntryName] = true
+	}
+	result := []model.Reserved{}
+	for _, oldRow := range oldRowList {
+		if oldRow.EntryIndex == "" {
+			continue
+		}
+		newRow, ok := newIndexMap[oldRow.EntryIndex]
+		if ok && (newRow.EntryName == oldRow.EntryName || !bHasType || newRow.GetProtoType() == oldRow.GetProtoType()) {
+			continue
+		}
+		reserved := model.Reserved{}
+		if !ok {
+			reserved.EntryIndex = oldRow.EntryIndex
+		}
+		if !newNameMap[oldRow.EntryName] {
+			reserved.EntryName = oldRow.EntryName
+		}
+		if reserved.EntryIndex != "" || reserved.EntryName != "" {
+			result = append(result, reserved)
 		}
-		fieldIndexes[i] = row.EntryIndex.Text
 	}
+	return result
+}
 
-	sort.Strings(fieldIndexes)
-
-	for i := 0; i < len(fieldIndexes)-1; i++ {
-		if fieldIndexes[i] == fieldIndexes[i+1] {
-			logrus.Error("Found duplicate field index. fieldIndexes:", fieldIndexes[i])
-			return false
+// 对比已保存的 fileSchema, 将 schema 中被删除的字段和枚举值的序号和名字记录为保留, 防止重新使用
+func AddRemovedAsReserved(fileSchema *model.Schema, schema *model.Schema) {
+	schema.WalkTypes(func(path string, unitName string, enum *model.Enum, msg *model.Message) {
+		if enum != nil {
+			if fileEnum := fileSchema.FindEnumByPath(path); fileEnum != nil {
+				for _, reserved := range GetRemovedFields(fileEnum.Values, enum.Values, false) {
+					enum.Reserved = model.AddReserved(enum.Reserved, reserved)
+				}
+			}
+		} else if fileMsg := fileSchema.FindMessageByPath(path); fileMsg != nil {
+			for _, reserved := range GetRemovedFields(fileMsg.Fields, msg.Fields, true) {
+				msg.Reserved = model.AddReserved(msg.Reserved, reserved)
+			}
 		}
-	}
+	})
+}
 
-	return true
+// 转为枚举模型
+func (stUnit *StUnit) ToEnum() *model.Enum {
+	enum := &model.Enum{Name: stUnit.UnitName}
+	enum.SetComment(stUnit.UnitComment)
+	enum.Values = append([]model.Field{}, stUnit.RowList...)
+	enum.Reserved = append([]model.Reserved{}, stUnit.Reserved...)
+	return enum
+}
+
+// 转为消息模型
+func (stUnit *StUnit) ToMessage() *model.Message {
+	msg := &model.Message{Name: stUnit.UnitName}
+	msg.SetComment(stUnit.UnitComment)
+	msg.Fields = append([]model.Field{}, stUnit.RowList...)
+	msg.Reserved = append([]model.Reserved{}, stUnit.Reserved...)
+	return msg
 }
 
-func (stUnitContainer *StUnitContainer) GetStUnit() StUnit {
-	var stUnit StUnit
-	stUnit.UnitName = stUnitContainer.UnitNameEntry.Text
-	stUnit.UnitComment = stUnitContainer.UnitCommentEntry.Text
-	stUnit.TableType = stUnitContainer.TableType
-	stUnit.RowList = *stUnitContainer.RowList
-	stUnit.IsCreatNew = stUnitContainer.IsCreatNew
-	return stUnit
+// 从枚举模型创建
+func StUnitFromEnum(enum *model.Enum) StUnit {
+	return StUnit{
+		UnitName:    enum.Name,
+		UnitComment: enum.Comment,
+		TableType:   TableType_Enum,
+		RowList:     append([]model.Field{}, enum.Values...),
+		Reserved:    append([]model.Reserved{}, enum.Reserved...),
+	}
+}
+
+// 从消息模型创建
+func StUnitFromMessage(tableType ETableType, subTableType ESubTableType, msg *model.Message) StUnit {
+	return StUnit{
+		UnitName:     msg.Name,
+		UnitComment:  msg.Comment,
+		TableType:    tableType,
+		SubTableType: subTableType,
+		RowList:      append([]model.Field{}, msg.Fields...),
+		Reserved:     append([]model.Reserved{}, msg.Reserved...),
+	}
 }
 
 // 检查路径是否存在
@@ -0,0 +1,116 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	"protocolgo/src/model"
+)
+
+func TestGetRemovedFields(t *testing.T) {
+	id := model.Field{EntryOption: model.OptionOptional, EntryType: "int32", EntryName: "id", EntryIndex: "1"}
+	name := model.Field{EntryOption: model.OptionOptional, EntryType: "string", EntryName: "name", EntryIndex: "2"}
+	tests := []struct {
+		name     string
+		oldRows  []model.Field
+		newRows  []model.Field
+		bHasType bool
+		want     []model.Reserved
+	}{
+		{
+			name:     "unchanged",
+			oldRows:  []model.Field{id, name},
+			newRows:  []model.Field{id, name},
+			bHasType: true,
+			want:     []model.Reserved{},
+		},
+		{
+			name:     "removed",
+			oldRows:  []model.Field{id, name},
+			newRows:  []model.Field{id},
+			bHasType: true,
+			want:     []model.Reserved{{EntryIndex: "2", EntryName: "name"}},
+		},
+		{
+			name:     "renamed with the same type",
+			oldRows:  []model.Field{id},
+			newRows:  []model.Field{{EntryOption: model.OptionOptional, EntryType: "int32", EntryName: "uid", EntryIndex: "1"}},
+			bHasType: true,
+			want:     []model.Reserved{},
+		},
+		{
+			name:     "number reused by another field",
+			oldRows:  []model.Field{id},
+			newRows:  []model.Field{{EntryOption: model.OptionOptional, EntryType: "string", EntryName: "title", EntryIndex: "1"}},
+			bHasType: true,
+			want:     []model.Reserved{{EntryName: "id"}},
+		},
+		{
+			name:     "number reused and name moved",
+			oldRows:  []model.Field{id, name},
+			newRows:  []model.Field{{EntryOption: model.OptionOptional, EntryType: "string", EntryName: "title", EntryIndex: "1"}, {EntryOption: model.OptionOptional, EntryType: "int32", EntryName: "id", EntryIndex: "3"}},
+			bHasType: true,
+			want:     []model.Reserved{{EntryIndex: "2", EntryName: "name"}},
+		},
+		{
+			name:     "name used by another field",
+			oldRows:  []model.Field{id, name},
+			newRows:  []model.Field{{EntryOption: model.OptionOptional, EntryType: "string", EntryName: "name", EntryIndex: "3"}, id},
+			bHasType: true,
+			want:     []model.Reserved{{EntryIndex: "2"}},
+		},
+		{
+			name:     "enum value renamed",
+			oldRows:  []model.Field{{EntryName: "Color_Red", EntryIndex: "1"}},
+			newRows:  []model.Field{{EntryName: "Color_Crimson", EntryIndex: "1"}},
+			bHasType: false,
+			want:     []model.Reserved{},
+		},
+		{
+			name:     "enum value removed",
+			oldRows:  []model.Field{{EntryName: "Color_None", EntryIndex: "0"}, {EntryName: "Color_Red", EntryIndex: "1"}},
+			newRows:  []model.Field{{EntryName: "Color_None", EntryIndex: "0"}},
+			bHasType: false,
+			want:     []model.Reserved{{EntryIndex: "1", EntryName: "Color_Red"}},
+		},
+		{
+			name:     "row without index",
+			oldRows:  []model.Field{{EntryName: "draft"}},
+			newRows:  []model.Field{},
+			bHasType: true,
+			want:     []model.Reserved{},
+		},
+	}
+	for _, test := range tests {
+		if got := GetRemovedFields(test.oldRows, test.newRows, test.bHasType); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAddRemovedAsReserved(t *testing.T) {
+	fileSchema := loadTestSchema(t, testSchemaXml)
+	schema := loadTestSchema(t, testSchemaXml)
+	role := schema.FindMessageByPath("Role")
+	role.Fields = role.Fields[:1]
+	itemType := schema.FindEnumByPath("ItemType")
+	itemType.Values = itemType.Values[:1]
+	slot := schema.FindMessageByPath("Bag.Slot")
+	slot.Fields[1].EntryName = "kind"
+
+	AddRemovedAsReserved(fileSchema, schema)
+	tests := []struct {
+		name string
+		got  []model.Reserved
+		want []model.Reserved
+	}{
+		{"field removed", role.Reserved, []model.Reserved{{EntryIndex: "2", EntryName: "bag"}}},
+		{"enum value removed", itemType.Reserved, []model.Reserved{{EntryIndex: "5 to 8"}, {EntryIndex: "1", EntryName: "ItemType_Weapon"}}},
+		{"field renamed", slot.Reserved, nil},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
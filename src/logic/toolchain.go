@@ -0,0 +1,273 @@
+package logic
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"protocolgo/src/utils"
+)
+
+// 工具的查找来源, 按优先级: config.xml 配置的路径, data 目录, PATH
+const (
+	ToolSourceConfig   = "config"
+	ToolSourceData     = "data"
+	ToolSourcePath     = "PATH"
+	ToolSourceBuiltin  = "builtin" // protoc 内置的生成器, 不需要插件
+	ToolSourceNotFound = "not found"
+)
+
+// 诊断结果的级别
+type EToolLevel int
+
+const (
+	ToolLevel_Ok EToolLevel = iota + 1
+	ToolLevel_Warn
+	ToolLevel_Error
+)
+
+// protoc 内置的生成器
+var protocBuiltinPlugins = []string{"cpp", "csharp", "java", "kotlin", "objc", "php", "pyi", "python", "ruby", "rust", "upb"}
+
+// 获取版本号的超时时间
+const toolVersionTimeout = 5 * time.Second
+
+// 一个工具(protoc 或插件)的查找结果
+type StToolInfo struct {
+	Name       string // protoc 或插件名, 如 protoc-gen-go
+	Path       string
+	Source     string
+	Version    string // 从 --version 输出中解析的版本号, 获取失败时为空
+	MinVersion string
+	Level      EToolLevel
+	Message    string // 问题描述
+}
+
+// protoc 和所有插件的查找结果
+type StToolchainReport struct {
+	Protoc  StToolInfo
+	Plugins []StToolInfo
+}
+
+func IsProtocBuiltinPlugin(plugin string) bool {
+	for _, v := range protocBuiltinPlugins {
+		if v == plugin {
+			return true
+		}
+	}
+	return false
+}
+
+// 可执行文件名, windows 加 .exe
+func getExecutableName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+// 按 配置路径 -> data 目录 -> PATH 的顺序查找工具, 返回路径和来源
+func FindTool(name string, configPath string) (string, string) {
+	if configPath != "" {
+		if !filepath.IsAbs(configPath) {
+			configPath = filepath.Join(utils.GetWorkRootPath(), configPath)
+		}
+		if PathExists(configPath) {
+			return configPath, ToolSourceConfig
+		}
+		// 配置的路径不存在时, 不再查找其他位置, 避免使用到意外的版本
+		return configPath, ToolSourceNotFound
+	}
+	strDataPath := filepath.Join(utils.GetWorkRootPath(), "data", getExecutableName(name))
+	if PathExists(strDataPath) {
+		return strDataPath, ToolSourceData
+	}
+	if strPath, err := exec.LookPath(name); err == nil {
+		return strPath, ToolSourcePath
+	}
+	return "", ToolSourceNotFound
+}
+
+// 执行 --version 并解析版本号
+func GetToolVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), toolVersionTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return ParseToolVersion(string(output)), nil
+}
+
+var toolVersionRegexp = regexp.MustCompile(`v?(\d+(\.\d+)+)`)
+
+// 从 --version 的输出中解析版本号, 如 libprotoc 25.1, protoc-gen-go v1.31.0
+func ParseToolVersion(output string) string {
+	match := toolVersionRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// 比较版本号, 返回 -1/0/1. 缺少的部分视为 0
+func CompareVersion(versionA string, versionB string) int {
+	partsA := strings.Split(versionA, ".")
+	partsB := strings.Split(versionB, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		numA, numB := 0, 0
+		if i < len(partsA) {
+			numA, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			numB, _ = strconv.Atoi(partsB[i])
+		}
+		if numA != numB {
+			if numA < numB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// protoc 从 3.21 之后的版本号为 22.x, 比较前将 3.x.y 转为 x.y
+func NormalizeProtocVersion(version string) string {
+	if strings.HasPrefix(version, "3.") {
+		return strings.TrimPrefix(version, "3.")
+	}
+	return version
+}
+
+// 查找工具并检查版本, normalize 为比较前对版本号的转换, 可以为 nil
+func checkTool(name string, configPath string, minVersion string, normalize func(string) string) StToolInfo {
+	if normalize == nil {
+		normalize = func(version string) string { return version }
+	}
+	toolInfo := StToolInfo{Name: name, MinVersion: minVersion, Level: ToolLevel_Ok}
+	toolInfo.Path, toolInfo.Source = FindTool(name, configPath)
+	if toolInfo.Source == ToolSourceNotFound {
+		toolInfo.Level = ToolLevel_Error
+		if toolInfo.Path != "" {
+			toolInfo.Message = "configed path is not exist: " + toolInfo.Path
+		} else {
+			toolInfo.Message = "not found in config, data dir or PATH"
+		}
+		return toolInfo
+	}
+	version, err := GetToolVersion(toolInfo.Path)
+	if err != nil {
+		toolInfo.Level = ToolLevel_Warn
+		toolInfo.Message = "get version failed: " + err.Error()
+		return toolInfo
+	}
+	toolInfo.Version = version
+	if minVersion == "" {
+		return toolInfo
+	}
+	if version == "" {
+		toolInfo.Level = ToolLevel_Warn
+		toolInfo.Message = "unknown version, required " + minVersion
+	} else if CompareVersion(normalize(version), normalize(minVersion)) < 0 {
+		toolInfo.Level = ToolLevel_Warn
+		toolInfo.Message = "version " + version + " is below the minimum " + minVersion
+	}
+	return toolInfo
+}
+
+// 检查 protoc 和每个生成目标的插件
+func CheckToolchain(pbConfig StPbConfig) StToolchainReport {
+	var report StToolchainReport
+	report.Protoc = checkTool("protoc", pbConfig.Protoc, pbConfig.ProtocMinVersion, NormalizeProtocVersion)
+	// 可以使用内置生成时, 找不到 protoc 只是提示
+	if report.Protoc.Level == ToolLevel_Error && pbConfig.Mode != PbModeProtoc {
+		report.Protoc.Level = ToolLevel_Warn
+		report.Protoc.Message += ", builtin descriptor generation is used"
+	}
+	checked := map[string]bool{}
+	for _, target := range pbConfig.GetTargets("") {
+		if checked[target.Plugin] {
+			continue
+		}
+		checked[target.Plugin] = true
+		if IsProtocBuiltinPlugin(target.Plugin) && target.Path == "" {
+			toolInfo := StToolInfo{
+				Name:    target.Plugin,
+				Source:  ToolSourceBuiltin,
+				Version: report.Protoc.Version,
+				Level:   ToolLevel_Ok,
+			}
+			// 内置生成不调用 protoc, 这些目标会失败
+			if pbConfig.IsBuiltin(report) {
+				toolInfo.Level = ToolLevel_Warn
+				toolInfo.Message = "protoc is required, not available in builtin mode"
+			}
+			report.Plugins = append(report.Plugins, toolInfo)
+			continue
+		}
+		report.Plugins = append(report.Plugins, checkTool("protoc-gen-"+target.Plugin, target.Path, target.MinVersion, nil))
+	}
+	return report
+}
+
+// 获取插件的查找结果
+func (report *StToolchainReport) FindPlugin(plugin string) *StToolInfo {
+	for i := range report.Plugins {
+		if report.Plugins[i].Name == plugin || report.Plugins[i].Name == "protoc-gen-"+plugin {
+			return &report.Plugins[i]
+		}
+	}
+	return nil
+}
+
+// 所有工具中最严重的级别
+func (report *StToolchainReport) GetLevel() EToolLevel {
+	level := report.Protoc.Level
+	for _, toolInfo := range report.Plugins {
+		if toolInfo.Level > level {
+			level = toolInfo.Level
+		}
+	}
+	return level
+}
+
+func (toolInfo *StToolInfo) GetLevelText() string {
+	switch toolInfo.Level {
+	case ToolLevel_Warn:
+		return "warn"
+	case ToolLevel_Error:
+		return "error"
+	}
+	return "ok"
+}
+
+// 一行展示文本, 如 [ok] protoc 25.1 (data) /path/to/protoc
+func (toolInfo *StToolInfo) String() string {
+	strText := "[" + toolInfo.GetLevelText() + "] " + toolInfo.Name
+	if toolInfo.Version != "" {
+		strText += " " + toolInfo.Version
+	}
+	strText += " (" + toolInfo.Source + ")"
+	if toolInfo.Path != "" {
+		strText += " " + toolInfo.Path
+	}
+	if toolInfo.Message != "" {
+		strText += ": " + toolInfo.Message
+	}
+	return strText
+}
+
+// 展示文本, 每个工具一行
+func (report *StToolchainReport) String() string {
+	lines := []string{report.Protoc.String()}
+	for _, toolInfo := range report.Plugins {
+		lines = append(lines, toolInfo.String())
+	}
+	return strings.Join(lines, "\n")
+}
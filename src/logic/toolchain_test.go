@@ -0,0 +1,127 @@
+package logic
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseToolVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"libprotoc 25.1\n", "25.1"},
+		{"libprotoc 3.21.12", "3.21.12"},
+		{"protoc-gen-go v1.31.0", "1.31.0"},
+		{"protoc-gen-go-grpc 1.3.0", "1.3.0"},
+		{"unknown", ""},
+	}
+	for _, test := range tests {
+		if got := ParseToolVersion(test.output); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.output, got, test.want)
+		}
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		versionA string
+		versionB string
+		want     int
+	}{
+		{"25.1", "25.1", 0},
+		{"25.1", "25.1.0", 0},
+		{"25.10", "25.9", 1},
+		{"1.31.0", "1.32", -1},
+		// 3.x.y 转为 x.y 后比较
+		{NormalizeProtocVersion("3.21.12"), NormalizeProtocVersion("25.1"), -1},
+		{NormalizeProtocVersion("25.1"), NormalizeProtocVersion("3.19"), 1},
+	}
+	for _, test := range tests {
+		if got := CompareVersion(test.versionA, test.versionB); got != test.want {
+			t.Errorf("%s vs %s: got %d, want %d", test.versionA, test.versionB, got, test.want)
+		}
+	}
+}
+
+func TestFindTool(t *testing.T) {
+	strDir := t.TempDir()
+	strPath := writeTestTool(t, strDir, "protoc", testProtocScript)
+	tests := []struct {
+		name       string
+		configPath string
+		wantPath   string
+		wantSource string
+	}{
+		{"configed", strPath, strPath, ToolSourceConfig},
+		// 配置的路径不存在时不再查找其他位置
+		{"configed but not exist", filepath.Join(strDir, "protoc-old"), filepath.Join(strDir, "protoc-old"), ToolSourceNotFound},
+	}
+	for _, test := range tests {
+		gotPath, gotSource := FindTool("protoc", test.configPath)
+		if gotPath != test.wantPath || gotSource != test.wantSource {
+			t.Errorf("%s: got %s %s, want %s %s", test.name, gotPath, gotSource, test.wantPath, test.wantSource)
+		}
+	}
+}
+
+func TestCheckToolchain(t *testing.T) {
+	strDir := t.TempDir()
+	strProtoc := writeTestTool(t, strDir, "protoc", testProtocScript)
+	strPlugin := writeTestTool(t, strDir, "protoc-gen-go", `echo "protoc-gen-go v1.31.0"`)
+	tests := []struct {
+		name        string
+		pbConfig    StPbConfig
+		wantProtoc  EToolLevel
+		wantPlugins []EToolLevel
+	}{
+		{
+			name: "ok",
+			pbConfig: StPbConfig{Protoc: strProtoc, ProtocMinVersion: "3.21", Targets: []StPbTarget{
+				{Name: "go", Plugin: "go", Path: strPlugin, MinVersion: "1.28"},
+				{Name: "cpp", Plugin: "cpp"},
+			}},
+			wantProtoc:  ToolLevel_Ok,
+			wantPlugins: []EToolLevel{ToolLevel_Ok, ToolLevel_Ok},
+		},
+		{
+			name: "version below minimum",
+			pbConfig: StPbConfig{Protoc: strProtoc, ProtocMinVersion: "26.0", Targets: []StPbTarget{
+				{Name: "go", Plugin: "go", Path: strPlugin, MinVersion: "1.32"},
+			}},
+			wantProtoc:  ToolLevel_Warn,
+			wantPlugins: []EToolLevel{ToolLevel_Warn},
+		},
+		{
+			// 可以使用内置生成时找不到 protoc 只是提示, protoc 内置的生成器不可用
+			name: "protoc not found in auto mode",
+			pbConfig: StPbConfig{Protoc: filepath.Join(strDir, "missing"), Targets: []StPbTarget{
+				{Name: "go", Plugin: "go", Path: strPlugin},
+				{Name: "cpp", Plugin: "cpp"},
+				{Name: "missing", Plugin: "missing", Path: filepath.Join(strDir, "protoc-gen-missing")},
+			}},
+			wantProtoc:  ToolLevel_Warn,
+			wantPlugins: []EToolLevel{ToolLevel_Ok, ToolLevel_Warn, ToolLevel_Error},
+		},
+		{
+			name:        "protoc not found in protoc mode",
+			pbConfig:    StPbConfig{Protoc: filepath.Join(strDir, "missing"), Mode: PbModeProtoc, Targets: []StPbTarget{{Name: "go", Plugin: "go", Path: strPlugin}}},
+			wantProtoc:  ToolLevel_Error,
+			wantPlugins: []EToolLevel{ToolLevel_Ok},
+		},
+	}
+	for _, test := range tests {
+		report := CheckToolchain(test.pbConfig)
+		if report.Protoc.Level != test.wantProtoc {
+			t.Errorf("%s: protoc got %s, want level %d", test.name, report.Protoc.String(), test.wantProtoc)
+		}
+		if len(report.Plugins) != len(test.wantPlugins) {
+			t.Fatalf("%s: got %d plugins, want %d", test.name, len(report.Plugins), len(test.wantPlugins))
+		}
+		for i, toolInfo := range report.Plugins {
+			if toolInfo.Level != test.wantPlugins[i] {
+				t.Errorf("%s: plugin got %s, want level %d", test.name, toolInfo.String(), test.wantPlugins[i])
+			}
+		}
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"protocolgo/src/cli"
 	"protocolgo/src/gui"
 	"protocolgo/src/logic"
 	"protocolgo/src/utils"
@@ -10,6 +13,28 @@ import (
 )
 
 func main() {
+	logLevel := flag.String("loglevel", "debug", "sets log level. trace/debug/info/warn/error/fatal/panic, default is "+cli.DefaultLogLevel+" with a command")
+	flag.Usage = func() {
+		cli.PrintUsage(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "不带 command 时启动图形界面, 只使用命令行时可以编译不依赖图形界面的 cmd/protocolgo-cli")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// 带子命令时以命令行模式运行, 不创建窗口, 日志只输出到 stderr
+	if flag.NArg() > 0 {
+		strLogLevel := cli.DefaultLogLevel
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "loglevel" {
+				strLogLevel = *logLevel
+			}
+		})
+		utils.InitCliLogger(strLogLevel)
+		os.Exit(cli.Run(flag.Args()))
+	}
+
+	// 初始化日志
+	utils.InitLogger(*logLevel)
 	InitMainWindow()
 }
 
@@ -22,9 +47,6 @@ func InitMainWindow() {
 	app.App = &application
 	app.CoreMgr = logic.CoreManager{}
 
-	// 初始化日志
-	utils.InitLogger(*flag.String("loglevel", "debug", "sets log level. trace/debug/info/warn/error/fatal/panic"))
-
 	app.MakeUI()
 
 	window.ShowAndRun()
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"sort"
+)
+
+// 类型依赖图, 节点为顶层单元(enum/data/protocol/rpc 的名字), 边为字段引用的类型所在的顶层单元.
+// 嵌套类型的字段计入所在的顶层单元, rpc 的 Req/Ack 计入 rpc. 所有列表按名字排序, 输出稳定
+type DepGraph struct {
+	Nodes      []string            // 所有顶层单元名, 排序
+	Category   map[string]string   // 单元名 -> 分类
+	Edges      map[string][]string // 单元 -> 它引用的单元, 不含自身
+	Dependents map[string][]string // 单元 -> 引用它的单元, 不含自身
+	SelfRefs   map[string]bool     // 引用了自身的单元
+}
+
+// 构建依赖图, 无法解析的类型不计入
+func (schema *Schema) GetDepGraph() *DepGraph {
+	graph := &DepGraph{
+		Category:   map[string]string{},
+		Edges:      map[string][]string{},
+		Dependents: map[string][]string{},
+		SelfRefs:   map[string]bool{},
+	}
+	for _, category := range GetCategoryList() {
+		for _, strName := range schema.GetUnitNames(category) {
+			if _, ok := graph.Category[strName]; !ok {
+				graph.Category[strName] = category
+				graph.Nodes = append(graph.Nodes, strName)
+			}
+		}
+	}
+	sort.Strings(graph.Nodes)
+
+	// 类型全名 -> 所在的顶层单元
+	typeUnitMap := map[string]string{}
+	schema.WalkTypes(func(path string, unitName string, enum *Enum, msg *Message) {
+		typeUnitMap[path] = unitName
+	})
+	typeMap := schema.GetTypeMap()
+	edgeSet := map[string]map[string]bool{}
+	schema.WalkTypes(func(path string, unitName string, enum *Enum, msg *Message) {
+		if msg == nil {
+			return
+		}
+		for _, field := range msg.Fields {
+			strTarget, ok := typeUnitMap[ResolveTypeName(typeMap, path, field.EntryType)]
+			if !ok {
+				continue
+			}
+			if strTarget == unitName {
+				graph.SelfRefs[unitName] = true
+				continue
+			}
+			if edgeSet[unitName] == nil {
+				edgeSet[unitName] = map[string]bool{}
+			}
+			edgeSet[unitName][strTarget] = true
+		}
+	})
+	for strFrom, targets := range edgeSet {
+		for strTo := range targets {
+			graph.Edges[strFrom] = append(graph.Edges[strFrom], strTo)
+			graph.Dependents[strTo] = append(graph.Dependents[strTo], strFrom)
+		}
+	}
+	for _, strName := range graph.Nodes {
+		sort.Strings(graph.Edges[strName])
+		sort.Strings(graph.Dependents[strName])
+	}
+	return graph
+}
+
+// 获取直接或间接引用该单元的所有单元, 排序
+func (graph *DepGraph) GetAllDependents(unitName string) []string {
+	visited := map[string]bool{unitName: true}
+	queue := []string{unitName}
+	result := []string{}
+	for len(queue) > 0 {
+		strName := queue[0]
+		queue = queue[1:]
+		for _, strDependent := range graph.Dependents[strName] {
+			if !visited[strDependent] {
+				visited[strDependent] = true
+				result = append(result, strDependent)
+				queue = append(queue, strDependent)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 对给定的单元做拓扑排序, 被引用的在前, 只考虑这些单元之间的边.
+// 可同时输出的单元按名字排序; 有循环时从剩下的单元中取名字最小的继续, 结果总是包含全部单元
+func (graph *DepGraph) TopoSort(unitNames []string) []string {
+	inSet := map[string]bool{}
+	for _, strName := range unitNames {
+		inSet[strName] = true
+	}
+	// 剩余未输出的依赖数
+	pending := map[string]int{}
+	for strName := range inSet {
+		for _, strTo := range graph.Edges[strName] {
+			if inSet[strTo] {
+				pending[strName]++
+			}
+		}
+	}
+	remain := make([]string, 0, len(inSet))
+	for strName := range inSet {
+		remain = append(remain, strName)
+	}
+	sort.Strings(remain)
+
+	result := []string{}
+	for len(remain) > 0 {
+		index := 0
+		for i, strName := range remain {
+			if pending[strName] == 0 {
+				index = i
+				break
+			}
+		}
+		strName := remain[index]
+		remain = append(remain[:index], remain[index+1:]...)
+		result = append(result, strName)
+		for _, strDependent := range graph.Dependents[strName] {
+			if inSet[strDependent] {
+				pending[strDependent]--
+			}
+		}
+	}
+	return result
+}
+
+// 查找循环引用, 每个强连通分量返回一条从名字最小的单元出发回到自身的最短路径,
+// 如 [A B A]. 结果按起点排序, 不包含只引用自身的单元
+func (graph *DepGraph) FindCycles() [][]string {
+	result := [][]string{}
+	for _, component := range graph.getComponents() {
+		if len(component) < 2 {
+			continue
+		}
+		inComponent := map[string]bool{}
+		for _, strName := range component {
+			inComponent[strName] = true
+		}
+		result = append(result, graph.findCyclePath(component[0], inComponent))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
+
+// 在分量内按 BFS 查找从 start 回到 start 的最短路径
+func (graph *DepGraph) findCyclePath(start string, inComponent map[string]bool) []string {
+	prev := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		strName := queue[0]
+		queue = queue[1:]
+		for _, strTo := range graph.Edges[strName] {
+			if !inComponent[strTo] {
+				continue
+			}
+			if strTo == start {
+				path := []string{start}
+				for strNode := strName; strNode != start; strNode = prev[strNode] {
+					path = append(path, strNode)
+				}
+				path = append(path, start)
+				// 从 start 出发的顺序
+				for i, j := 1, len(path)-2; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			if _, ok := prev[strTo]; !ok {
+				prev[strTo] = strName
+				queue = append(queue, strTo)
+			}
+		}
+	}
+	return []string{start}
+}
+
+// Tarjan 算法求强连通分量, 每个分量内按名字排序
+func (graph *DepGraph) getComponents() [][]string {
+	index := map[string]int{}
+	lowLink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	result := [][]string{}
+	nIndex := 0
+	var visit func(strName string)
+	visit = func(strName string) {
+		index[strName] = nIndex
+		lowLink[strName] = nIndex
+		nIndex++
+		stack = append(stack, strName)
+		onStack[strName] = true
+		for _, strTo := range graph.Edges[strName] {
+			if _, ok := index[strTo]; !ok {
+				visit(strTo)
+				lowLink[strName] = min(lowLink[strName], lowLink[strTo])
+			} else if onStack[strTo] {
+				lowLink[strName] = min(lowLink[strName], index[strTo])
+			}
+		}
+		if lowLink[strName] != index[strName] {
+			return
+		}
+		component := []string{}
+		for {
+			strTop := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[strTop] = false
+			component = append(component, strTop)
+			if strTop == strName {
+				break
+			}
+		}
+		sort.Strings(component)
+		result = append(result, component)
+	}
+	for _, strName := range graph.Nodes {
+		if _, ok := index[strName]; !ok {
+			visit(strName)
+		}
+	}
+	return result
+}
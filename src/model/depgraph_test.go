@@ -0,0 +1,126 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 生成 data 分类的 xml, fields 为 消息名 -> 字段类型列表
+func makeDepXml(names []string, fields map[string][]string) string {
+	var builder strings.Builder
+	builder.WriteString("<enum>\n    <Color>\n        <Color EntryName=\"Color_None\" EntryIndex=\"0\" EntryComment=\"\"/>\n    </Color>\n</enum>\n<data>\n")
+	for _, strName := range names {
+		builder.WriteString("    <" + strName + ">\n")
+		for i, strType := range fields[strName] {
+			builder.WriteString("        <" + strName + ` EntryOption="optional" EntryType="` + strType + `" EntryName="f` + string(rune('a'+i)) + `" EntryIndex="` + string(rune('1'+i)) + `" EntryDefault="" EntryComment=""/>` + "\n")
+		}
+		builder.WriteString("    </" + strName + ">\n")
+	}
+	builder.WriteString("</data>\n<protocol/>\n<rpc/>\n")
+	return builder.String()
+}
+
+func TestDepGraphTopoSort(t *testing.T) {
+	tests := []struct {
+		name   string
+		names  []string
+		fields map[string][]string
+		sort   []string
+		want   []string
+	}{
+		{
+			name:   "dependency first",
+			names:  []string{"A", "B", "C"},
+			fields: map[string][]string{"A": {"B"}, "B": {"C"}, "C": {"Color"}},
+			sort:   []string{"A", "B", "C", "Color"},
+			want:   []string{"Color", "C", "B", "A"},
+		},
+		{
+			name:   "independent by name",
+			names:  []string{"Z", "Y", "X"},
+			fields: map[string][]string{"Z": {"int32"}},
+			sort:   []string{"Z", "Y", "X"},
+			want:   []string{"X", "Y", "Z"},
+		},
+		{
+			name:   "only units in the list",
+			names:  []string{"A", "B"},
+			fields: map[string][]string{"A": {"B", "Color"}},
+			sort:   []string{"A", "B"},
+			want:   []string{"B", "A"},
+		},
+		{
+			name:   "cycle keeps all units",
+			names:  []string{"A", "B", "C"},
+			fields: map[string][]string{"A": {"B"}, "B": {"A"}, "C": {"A"}},
+			sort:   []string{"C", "B", "A"},
+			want:   []string{"A", "B", "C"},
+		},
+		{
+			name:   "self reference",
+			names:  []string{"Node", "Tree"},
+			fields: map[string][]string{"Node": {"Node"}, "Tree": {"Node"}},
+			sort:   []string{"Tree", "Node"},
+			want:   []string{"Node", "Tree"},
+		},
+	}
+	for _, test := range tests {
+		schema, err := LoadSchemaFromReader(strings.NewReader(makeDepXml(test.names, test.fields)))
+		if err != nil {
+			t.Fatalf("%s: LoadSchemaFromReader failed. err: %v", test.name, err)
+		}
+		if got := schema.GetDepGraph().TopoSort(test.sort); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDepGraphFindCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		names  []string
+		fields map[string][]string
+		want   [][]string
+	}{
+		{
+			name:   "no cycle",
+			names:  []string{"A", "B"},
+			fields: map[string][]string{"A": {"B"}},
+			want:   [][]string{},
+		},
+		{
+			name:   "self reference is not a cycle",
+			names:  []string{"Node"},
+			fields: map[string][]string{"Node": {"Node"}},
+			want:   [][]string{},
+		},
+		{
+			name:   "two units",
+			names:  []string{"B", "A"},
+			fields: map[string][]string{"A": {"B"}, "B": {"A"}},
+			want:   [][]string{{"A", "B", "A"}},
+		},
+		{
+			name:   "shortest path",
+			names:  []string{"A", "B", "C"},
+			fields: map[string][]string{"A": {"B"}, "B": {"C", "A"}, "C": {"A"}},
+			want:   [][]string{{"A", "B", "A"}},
+		},
+		{
+			name:   "two components",
+			names:  []string{"A", "B", "C", "D", "E"},
+			fields: map[string][]string{"A": {"B"}, "B": {"A"}, "C": {"D"}, "D": {"E"}, "E": {"C"}},
+			want:   [][]string{{"A", "B", "A"}, {"C", "D", "E", "C"}},
+		},
+	}
+	for _, test := range tests {
+		schema, err := LoadSchemaFromReader(strings.NewReader(makeDepXml(test.names, test.fields)))
+		if err != nil {
+			t.Fatalf("%s: LoadSchemaFromReader failed. err: %v", test.name, err)
+		}
+		if got := schema.GetDepGraph().FindCycles(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
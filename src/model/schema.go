@@ -0,0 +1,693 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// 协议 xml 中的分类名
+const (
+	CategoryEnum     = "enum"
+	CategoryData     = "data"
+	CategoryProtocol = "protocol"
+	CategoryRpc      = "rpc"
+)
+
+// rpc 请求/回包的类型标记
+const (
+	RpcTypeReq = "Req"
+	RpcTypeAck = "Ack"
+)
+
+// 按 xml 中的顺序排列的所有分类
+func GetCategoryList() []string {
+	return []string{CategoryEnum, CategoryData, CategoryProtocol, CategoryRpc}
+}
+
+// 字段(枚举值)的一行数据, 属性名与 xml 中保持一致
+type Field struct {
+	EntryOption  string
+	EntryKeyType string // map 的 key 类型, 只在 EntryOption 为 map 时有效, 此时 EntryType 为 value 类型
+	EntryType    string
+	EntryName    string
+	EntryIndex   string
+	EntryDefault string
+	EntryComment string
+	EntryOneof   string // 所属的 oneof 名字, 为空表示不在 oneof 中
+
+	attrs []string          // 读取时的属性顺序, 用于无损回写
+	extra map[string]string // 无法识别的属性
+}
+
+// 枚举
+type Enum struct {
+	Name     string
+	Comment  string
+	Values   []Field
+	Reserved []Reserved // 保留的枚举值序号和名字
+
+	hasComment bool // 原文件中是否有注释节点(包括空注释)
+}
+
+// 消息, data/protocol 以及 rpc 的 Req/Ack 都使用该结构
+type Message struct {
+	Name     string
+	Comment  string
+	Fields   []Field
+	Enums    []*Enum    // 嵌套枚举
+	Messages []*Message // 嵌套消息
+	Reserved []Reserved // 保留的字段序号和名字
+
+	hasComment bool
+}
+
+// 保留的序号和名字, 删除字段时自动记录, 防止被重新使用.
+// EntryIndex 可以是单个序号, 也可以是 "9 to 11", "9 to max" 形式的范围, 两者都可以为空
+type Reserved struct {
+	EntryIndex string
+	EntryName  string
+}
+
+// rpc, 由一对 Req/Ack 消息组成
+type Rpc struct {
+	Name    string
+	Comment string
+	Req     *Message
+	Ack     *Message
+
+	hasComment bool
+}
+
+// 整个协议 xml 的数据
+type Schema struct {
+	Enums     []*Enum
+	Datas     []*Message
+	Protocols []*Message
+	Rpcs      []*Rpc
+
+	procInsts  [][2]string               // xml 处理指令 target/inst
+	categories []string                  // 原文件中出现过的分类, 按出现顺序
+	others     map[string]*etree.Element // 无法识别的分类, 原样保留
+}
+
+// 创建空的 Schema
+func NewSchema() *Schema {
+	return &Schema{}
+}
+
+// 获取 proto 的标量类型
+func GetScalarTypes() []string {
+	return []string{"int32", "int64", "uint32", "uint64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64", "float", "double", "bool", "string", "bytes"}
+}
+
+// 检查是否是 proto 的标量类型
+func IsScalarType(str string) bool {
+	for _, v := range GetScalarTypes() {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// 字段选项
+const (
+	OptionOptional = "optional"
+	OptionRequired = "required" // 只有 proto2 和 editions 支持
+	OptionRepeated = "repeated"
+	OptionMap      = "map"
+)
+
+// 获取可作为 map key 的类型: 除浮点数和 bytes 以外的标量类型
+func GetMapKeyTypes() []string {
+	return []string{"int32", "int64", "uint32", "uint64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64", "bool", "string"}
+}
+
+// 检查是否可作为 map key
+func IsMapKeyType(str string) bool {
+	for _, v := range GetMapKeyTypes() {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否是 map 字段
+func (field *Field) IsMap() bool {
+	return field.EntryOption == OptionMap
+}
+
+// 是否在 oneof 中
+func (field *Field) IsOneof() bool {
+	return field.EntryOneof != ""
+}
+
+// 获取 proto 中的字段类型, map 字段为 map<K,V>
+func (field *Field) GetProtoType() string {
+	if field.IsMap() {
+		return "map<" + field.EntryKeyType + "," + field.EntryType + ">"
+	}
+	return field.EntryType
+}
+
+// 检查序号是否在保留范围内
+func (reserved *Reserved) ContainsIndex(index string) bool {
+	if reserved.EntryIndex == "" {
+		return false
+	}
+	nIndex, err := strconv.ParseInt(index, 0, 64)
+	if err != nil {
+		return reserved.EntryIndex == index
+	}
+	strStart, strEnd, isRange := strings.Cut(reserved.EntryIndex, " to ")
+	nStart, err := strconv.ParseInt(strStart, 0, 64)
+	if err != nil {
+		return false
+	}
+	if !isRange {
+		return nIndex == nStart
+	}
+	if strEnd == "max" {
+		return nIndex >= nStart
+	}
+	nEnd, err := strconv.ParseInt(strEnd, 0, 64)
+	if err != nil {
+		return false
+	}
+	return nIndex >= nStart && nIndex <= nEnd
+}
+
+// 在保留列表中查找包含序号的项, 找不到返回 nil
+func FindReservedIndex(reservedList []Reserved, index string) *Reserved {
+	for i := range reservedList {
+		if reservedList[i].ContainsIndex(index) {
+			return &reservedList[i]
+		}
+	}
+	return nil
+}
+
+// 在保留列表中查找名字, 找不到返回 nil
+func FindReservedName(reservedList []Reserved, name string) *Reserved {
+	if name == "" {
+		return nil
+	}
+	for i := range reservedList {
+		if reservedList[i].EntryName == name {
+			return &reservedList[i]
+		}
+	}
+	return nil
+}
+
+// 追加保留项, 序号和名字都已保留时不追加. 返回新的列表
+func AddReserved(reservedList []Reserved, reserved Reserved) []Reserved {
+	if (reserved.EntryIndex == "" || FindReservedIndex(reservedList, reserved.EntryIndex) != nil) &&
+		(reserved.EntryName == "" || FindReservedName(reservedList, reserved.EntryName) != nil) {
+		return reservedList
+	}
+	return append(reservedList, reserved)
+}
+
+// 获取保留的序号和名字, 按出现的顺序
+func GetReservedIndexAndName(reservedList []Reserved) ([]string, []string) {
+	indexList := []string{}
+	nameList := []string{}
+	for _, reserved := range reservedList {
+		if reserved.EntryIndex != "" {
+			indexList = append(indexList, reserved.EntryIndex)
+		}
+		if reserved.EntryName != "" {
+			nameList = append(nameList, reserved.EntryName)
+		}
+	}
+	return indexList, nameList
+}
+
+// 设置单元注释
+func (enum *Enum) SetComment(comment string) {
+	enum.Comment = comment
+	enum.hasComment = comment != ""
+}
+
+// 设置单元注释
+func (msg *Message) SetComment(comment string) {
+	msg.Comment = comment
+	msg.hasComment = comment != ""
+}
+
+// 设置单元注释
+func (rpc *Rpc) SetComment(comment string) {
+	rpc.Comment = comment
+	rpc.hasComment = comment != ""
+}
+
+// 根据名字查找枚举值
+func (enum *Enum) FindValue(name string) *Field {
+	for i := range enum.Values {
+		if enum.Values[i].EntryName == name {
+			return &enum.Values[i]
+		}
+	}
+	return nil
+}
+
+// 获取所有枚举值的名字
+func (enum *Enum) GetValueNames() []string {
+	result := []string{}
+	for _, value := range enum.Values {
+		if value.EntryName != "" {
+			result = append(result, value.EntryName)
+		}
+	}
+	return result
+}
+
+// 根据名字查找字段
+func (msg *Message) FindField(name string) *Field {
+	for i := range msg.Fields {
+		if msg.Fields[i].EntryName == name {
+			return &msg.Fields[i]
+		}
+	}
+	return nil
+}
+
+// 获取消息中的 oneof 名字, 按第一次出现的顺序
+func (msg *Message) GetOneofNames() []string {
+	result := []string{}
+	exist := map[string]bool{}
+	for _, field := range msg.Fields {
+		if field.IsOneof() && !exist[field.EntryOneof] {
+			exist[field.EntryOneof] = true
+			result = append(result, field.EntryOneof)
+		}
+	}
+	return result
+}
+
+// 查找嵌套枚举
+func (msg *Message) FindNestedEnum(name string) *Enum {
+	for _, enum := range msg.Enums {
+		if enum.Name == name {
+			return enum
+		}
+	}
+	return nil
+}
+
+// 查找嵌套消息
+func (msg *Message) FindNestedMessage(name string) *Message {
+	for _, nested := range msg.Messages {
+		if nested.Name == name {
+			return nested
+		}
+	}
+	return nil
+}
+
+// 检查嵌套类型名是否已被使用
+func (msg *Message) HasNested(name string) bool {
+	return msg.FindNestedEnum(name) != nil || msg.FindNestedMessage(name) != nil
+}
+
+// 新增或替换嵌套枚举
+func (msg *Message) PutNestedEnum(enum *Enum) {
+	for i, old := range msg.Enums {
+		if old.Name == enum.Name {
+			msg.Enums[i] = enum
+			return
+		}
+	}
+	msg.Enums = append(msg.Enums, enum)
+}
+
+// 新增或替换嵌套消息
+func (msg *Message) PutNestedMessage(nested *Message) {
+	for i, old := range msg.Messages {
+		if old.Name == nested.Name {
+			msg.Messages[i] = nested
+			return
+		}
+	}
+	msg.Messages = append(msg.Messages, nested)
+}
+
+// 删除嵌套类型, 返回是否找到
+func (msg *Message) RemoveNested(name string) bool {
+	for i, enum := range msg.Enums {
+		if enum.Name == name {
+			msg.Enums = append(msg.Enums[:i], msg.Enums[i+1:]...)
+			return true
+		}
+	}
+	for i, nested := range msg.Messages {
+		if nested.Name == name {
+			msg.Messages = append(msg.Messages[:i], msg.Messages[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 获取 rpc 的请求/回包消息
+func (rpc *Rpc) GetMessage(rpcType string) *Message {
+	if rpcType == RpcTypeReq {
+		return rpc.Req
+	} else if rpcType == RpcTypeAck {
+		return rpc.Ack
+	}
+	return nil
+}
+
+// 获取 rpc 中存在的消息, 按 Req/Ack 顺序
+func (rpc *Rpc) GetMessages() []*Message {
+	result := []*Message{}
+	if rpc.Req != nil {
+		result = append(result, rpc.Req)
+	}
+	if rpc.Ack != nil {
+		result = append(result, rpc.Ack)
+	}
+	return result
+}
+
+// 根据名字查找枚举
+func (schema *Schema) FindEnum(name string) *Enum {
+	for _, enum := range schema.Enums {
+		if enum.Name == name {
+			return enum
+		}
+	}
+	return nil
+}
+
+// 根据分类和名字查找消息, 只适用于 data/protocol
+func (schema *Schema) FindMessage(category string, name string) *Message {
+	for _, msg := range schema.GetMessageList(category) {
+		if msg.Name == name {
+			return msg
+		}
+	}
+	return nil
+}
+
+// 根据名字查找 rpc
+func (schema *Schema) FindRpc(name string) *Rpc {
+	for _, rpc := range schema.Rpcs {
+		if rpc.Name == name {
+			return rpc
+		}
+	}
+	return nil
+}
+
+// 获取 data/protocol 分类下的消息列表
+func (schema *Schema) GetMessageList(category string) []*Message {
+	if category == CategoryData {
+		return schema.Datas
+	} else if category == CategoryProtocol {
+		return schema.Protocols
+	}
+	return nil
+}
+
+// 查找名字所在的分类, 找不到返回空字符串
+func (schema *Schema) FindCategory(name string) string {
+	if name == "" {
+		return ""
+	}
+	if schema.FindEnum(name) != nil {
+		return CategoryEnum
+	}
+	if schema.FindMessage(CategoryData, name) != nil {
+		return CategoryData
+	}
+	if schema.FindMessage(CategoryProtocol, name) != nil {
+		return CategoryProtocol
+	}
+	if schema.FindRpc(name) != nil {
+		return CategoryRpc
+	}
+	return ""
+}
+
+// 获取分类下所有单元的名字
+func (schema *Schema) GetUnitNames(category string) []string {
+	result := []string{}
+	if category == CategoryEnum {
+		for _, enum := range schema.Enums {
+			result = append(result, enum.Name)
+		}
+	} else if category == CategoryRpc {
+		for _, rpc := range schema.Rpcs {
+			result = append(result, rpc.Name)
+		}
+	} else {
+		for _, msg := range schema.GetMessageList(category) {
+			result = append(result, msg.Name)
+		}
+	}
+	return result
+}
+
+// 新增或替换单元, 同名单元原地替换, 否则追加到分类末尾
+func (schema *Schema) PutEnum(enum *Enum) {
+	for i, old := range schema.Enums {
+		if old.Name == enum.Name {
+			schema.Enums[i] = enum
+			return
+		}
+	}
+	schema.Enums = append(schema.Enums, enum)
+}
+
+// 新增或替换 data/protocol 消息
+func (schema *Schema) PutMessage(category string, msg *Message) bool {
+	var list *[]*Message
+	if category == CategoryData {
+		list = &schema.Datas
+	} else if category == CategoryProtocol {
+		list = &schema.Protocols
+	} else {
+		return false
+	}
+	for i, old := range *list {
+		if old.Name == msg.Name {
+			(*list)[i] = msg
+			return true
+		}
+	}
+	*list = append(*list, msg)
+	return true
+}
+
+// 新增或替换 rpc
+func (schema *Schema) PutRpc(rpc *Rpc) {
+	for i, old := range schema.Rpcs {
+		if old.Name == rpc.Name {
+			schema.Rpcs[i] = rpc
+			return
+		}
+	}
+	schema.Rpcs = append(schema.Rpcs, rpc)
+}
+
+// 删除单元, 返回是否找到
+func (schema *Schema) RemoveUnit(category string, name string) bool {
+	if category == CategoryEnum {
+		for i, enum := range schema.Enums {
+			if enum.Name == name {
+				schema.Enums = append(schema.Enums[:i], schema.Enums[i+1:]...)
+				return true
+			}
+		}
+	} else if category == CategoryData || category == CategoryProtocol {
+		list := &schema.Datas
+		if category == CategoryProtocol {
+			list = &schema.Protocols
+		}
+		for i, msg := range *list {
+			if msg.Name == name {
+				*list = append((*list)[:i], (*list)[i+1:]...)
+				return true
+			}
+		}
+	} else if category == CategoryRpc {
+		for i, rpc := range schema.Rpcs {
+			if rpc.Name == name {
+				schema.Rpcs = append(schema.Rpcs[:i], schema.Rpcs[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 遍历所有可被引用的类型及其全名, enum/msg 中只有一个不为 nil.
+// 顶层单元的全名为单元名, rpc 的消息为 XxxReq/XxxAck, 嵌套类型为 外层全名.名字.
+// unitName 为类型所在的顶层单元名(rpc 为 rpc 名)
+func (schema *Schema) WalkTypes(fn func(path string, unitName string, enum *Enum, msg *Message)) {
+	for _, enum := range schema.Enums {
+		fn(enum.Name, enum.Name, enum, nil)
+	}
+	for _, msg := range schema.Datas {
+		walkMessage(msg.Name, msg.Name, msg, fn)
+	}
+	for _, msg := range schema.Protocols {
+		walkMessage(msg.Name, msg.Name, msg, fn)
+	}
+	for _, rpc := range schema.Rpcs {
+		if rpc.Req != nil {
+			walkMessage(rpc.Name+RpcTypeReq, rpc.Name, rpc.Req, fn)
+		}
+		if rpc.Ack != nil {
+			walkMessage(rpc.Name+RpcTypeAck, rpc.Name, rpc.Ack, fn)
+		}
+	}
+}
+
+func walkMessage(path string, unitName string, msg *Message, fn func(path string, unitName string, enum *Enum, msg *Message)) {
+	fn(path, unitName, nil, msg)
+	for _, enum := range msg.Enums {
+		fn(path+"."+enum.Name, unitName, enum, nil)
+	}
+	for _, nested := range msg.Messages {
+		walkMessage(path+"."+nested.Name, unitName, nested, fn)
+	}
+}
+
+// 根据全名查找枚举, 包括嵌套枚举
+func (schema *Schema) FindEnumByPath(path string) *Enum {
+	var result *Enum
+	schema.WalkTypes(func(typePath string, unitName string, enum *Enum, msg *Message) {
+		if result == nil && enum != nil && typePath == path {
+			result = enum
+		}
+	})
+	return result
+}
+
+// 根据全名查找消息, 包括 rpc 的 XxxReq/XxxAck 和嵌套消息
+func (schema *Schema) FindMessageByPath(path string) *Message {
+	var result *Message
+	schema.WalkTypes(func(typePath string, unitName string, enum *Enum, msg *Message) {
+		if result == nil && msg != nil && typePath == path {
+			result = msg
+		}
+	})
+	return result
+}
+
+// 获取所有类型的全名集合
+func (schema *Schema) GetTypeMap() map[string]bool {
+	result := map[string]bool{}
+	schema.WalkTypes(func(path string, unitName string, enum *Enum, msg *Message) {
+		result[path] = true
+	})
+	return result
+}
+
+// 获取可作为字段类型的名字: 顶层的 enum/data/protocol 以及所有嵌套类型的全名
+func (schema *Schema) GetTypeNames() []string {
+	result := []string{}
+	result = append(result, schema.GetUnitNames(CategoryEnum)...)
+	result = append(result, schema.GetUnitNames(CategoryData)...)
+	result = append(result, schema.GetUnitNames(CategoryProtocol)...)
+	schema.WalkTypes(func(path string, unitName string, enum *Enum, msg *Message) {
+		if strings.Contains(path, ".") {
+			result = append(result, path)
+		}
+	})
+	return result
+}
+
+// 按 proto 的作用域规则解析类型名, scope 为字段所在消息的全名.
+// 先在 scope 内查找, 再逐层向外. 找不到返回空字符串
+func ResolveTypeName(typeMap map[string]bool, scope string, name string) string {
+	if name == "" || IsScalarType(name) {
+		return ""
+	}
+	if strings.HasPrefix(name, ".") {
+		if typeMap[name[1:]] {
+			return name[1:]
+		}
+		return ""
+	}
+	for scope != "" {
+		if typeMap[scope+"."+name] {
+			return scope + "." + name
+		}
+		if index := strings.LastIndex(scope, "."); index >= 0 {
+			scope = scope[:index]
+		} else {
+			scope = ""
+		}
+	}
+	if typeMap[name] {
+		return name
+	}
+	return ""
+}
+
+// 解析类型名, 规则见 ResolveTypeName
+func (schema *Schema) ResolveType(scope string, name string) string {
+	return ResolveTypeName(schema.GetTypeMap(), scope, name)
+}
+
+// 解析枚举类型, 找不到或不是枚举时返回 nil
+func (schema *Schema) ResolveEnum(scope string, name string) *Enum {
+	path := schema.ResolveType(scope, name)
+	if path == "" {
+		return nil
+	}
+	return schema.FindEnumByPath(path)
+}
+
+// 字段对类型的一处引用
+type FieldRef struct {
+	UnitName string // 字段所在的顶层单元名, rpc 为 rpc 名
+	TypePath string // 字段所在消息的全名
+	Field    string // 字段名
+}
+
+// 计算依赖: 非标量类型的全名 -> 使用它的顶层单元名列表, 每个单元只记录一次. 无法解析的类型按原名记录
+func (schema *Schema) GetReferences() map[string][]string {
+	result := map[string][]string{}
+	for typeName, refList := range schema.GetFieldReferences() {
+		unitMap := map[string]bool{}
+		for _, ref := range refList {
+			if unitMap[ref.UnitName] {
+				continue
+			}
+			unitMap[ref.UnitName] = true
+			result[typeName] = append(result[typeName], ref.UnitName)
+		}
+	}
+	return result
+}
+
+// 计算字段级的依赖: 非标量类型的全名 -> 引用它的字段列表, 按遍历顺序. 无法解析的类型按原名记录
+func (schema *Schema) GetFieldReferences() map[string][]FieldRef {
+	result := map[string][]FieldRef{}
+	typeMap := schema.GetTypeMap()
+	schema.WalkTypes(func(path string, unitName string, enum *Enum, msg *Message) {
+		if msg == nil {
+			return
+		}
+		for _, field := range msg.Fields {
+			if field.EntryType == "" || IsScalarType(field.EntryType) {
+				continue
+			}
+			typeName := ResolveTypeName(typeMap, path, field.EntryType)
+			if typeName == "" {
+				typeName = field.EntryType
+			}
+			result[typeName] = append(result[typeName], FieldRef{UnitName: unitName, TypePath: path, Field: field.EntryName})
+		}
+	})
+	return result
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaGetReferences(t *testing.T) {
+	const strXml = `<enum>
+    <Color>
+        <Color EntryName="Color_None" EntryIndex="0" EntryComment=""/>
+    </Color>
+</enum>
+<data>
+    <Role>
+        <Role EntryOption="optional" EntryType="Color" EntryName="hair" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        <Role EntryOption="optional" EntryType="Color" EntryName="eye" EntryIndex="2" EntryDefault="" EntryComment=""/>
+        <Role EntryOption="optional" EntryType="Unknown" EntryName="other" EntryIndex="3" EntryDefault="" EntryComment=""/>
+    </Role>
+    <Pet>
+        <Skin NestedType="message">
+            <Skin EntryOption="optional" EntryType="Color" EntryName="color" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        </Skin>
+        <Pet EntryOption="optional" EntryType="Color" EntryName="color" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        <Pet EntryOption="optional" EntryType="Role" EntryName="owner" EntryIndex="2" EntryDefault="" EntryComment=""/>
+    </Pet>
+</data>
+`
+	schema, err := LoadSchemaFromReader(strings.NewReader(strXml))
+	if err != nil {
+		t.Fatalf("LoadSchemaFromReader failed. err: %v", err)
+	}
+	// 同一个单元多次引用只记录一次
+	want := map[string][]string{
+		"Color":   {"Role", "Pet"},
+		"Unknown": {"Role"},
+		"Role":    {"Pet"},
+	}
+	if got := schema.GetReferences(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,416 @@
+package model
+
+import (
+	"errors"
+	"io"
+
+	"github.com/beevik/etree"
+)
+
+// xml 中字段的属性名
+const (
+	AttrEntryOption  = "EntryOption"
+	AttrEntryKeyType = "EntryKeyType"
+	AttrEntryType    = "EntryType"
+	AttrEntryName    = "EntryName"
+	AttrEntryIndex   = "EntryIndex"
+	AttrEntryDefault = "EntryDefault"
+	AttrEntryComment = "EntryComment"
+	AttrEntryOneof   = "EntryOneof"
+	AttrRpcType      = "RpcType"
+	AttrNestedType   = "NestedType"
+	AttrReserved     = "Reserved"
+)
+
+// 嵌套类型的标记, 嵌套类型的节点名为类型名, 带有 NestedType 属性
+const (
+	NestedTypeEnum    = "enum"
+	NestedTypeMessage = "message"
+)
+
+// 保留行的标记, 保留行与字段同名, 带有 Reserved="true" 属性, 只使用 EntryIndex/EntryName
+const ReservedTrue = "true"
+
+// 新建字段时写入的属性及顺序
+var enumAttrKeys = []string{AttrEntryName, AttrEntryIndex, AttrEntryComment}
+var messageAttrKeys = []string{AttrEntryOption, AttrEntryKeyType, AttrEntryType, AttrEntryName, AttrEntryIndex, AttrEntryDefault, AttrEntryComment, AttrEntryOneof}
+
+// 为空时不写入的属性, 避免普通字段多出无用的属性
+func isOmitEmptyAttr(key string) bool {
+	return key == AttrEntryKeyType || key == AttrEntryOneof
+}
+
+// 从文件读取 Schema
+func LoadSchemaFromFile(filename string) (*Schema, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(filename); err != nil {
+		return nil, err
+	}
+	return SchemaFromDocument(doc)
+}
+
+// 从 reader 读取 Schema
+func LoadSchemaFromReader(reader io.Reader) (*Schema, error) {
+	doc := etree.NewDocument()
+	if _, err := doc.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	return SchemaFromDocument(doc)
+}
+
+// 保存 Schema 到文件
+func (schema *Schema) SaveToFile(filename string) error {
+	doc := schema.ToDocument()
+	doc.Indent(4)
+	return doc.WriteToFile(filename)
+}
+
+// 转为保存到文件的 xml 内容
+func (schema *Schema) ToBytes() ([]byte, error) {
+	doc := schema.ToDocument()
+	doc.Indent(4)
+	return doc.WriteToBytes()
+}
+
+// 深拷贝 Schema, 经过 xml 文档转换, 注释和属性顺序保持不变
+func (schema *Schema) Clone() (*Schema, error) {
+	return SchemaFromDocument(schema.ToDocument())
+}
+
+// 从 etree 文档解析 Schema
+func SchemaFromDocument(doc *etree.Document) (*Schema, error) {
+	if doc == nil {
+		return nil, errors.New("nil document")
+	}
+	schema := NewSchema()
+	for _, token := range doc.Child {
+		if procInst, ok := token.(*etree.ProcInst); ok {
+			schema.procInsts = append(schema.procInsts, [2]string{procInst.Target, procInst.Inst})
+			continue
+		}
+		cataElem, ok := token.(*etree.Element)
+		if !ok {
+			continue
+		}
+		if !schema.hasCategory(cataElem.Tag) {
+			schema.categories = append(schema.categories, cataElem.Tag)
+		}
+		if !isKnownCategory(cataElem.Tag) {
+			// 无法识别的分类原样保留
+			if schema.others == nil {
+				schema.others = map[string]*etree.Element{}
+			}
+			schema.others[cataElem.Tag] = cataElem.Copy()
+			continue
+		}
+		for _, unitElem := range cataElem.ChildElements() {
+			switch cataElem.Tag {
+			case CategoryEnum:
+				schema.Enums = append(schema.Enums, EnumFromElement(unitElem))
+			case CategoryData:
+				schema.Datas = append(schema.Datas, MessageFromElement(unitElem))
+			case CategoryProtocol:
+				schema.Protocols = append(schema.Protocols, MessageFromElement(unitElem))
+			case CategoryRpc:
+				rpc, err := RpcFromElement(unitElem)
+				if err != nil {
+					return nil, err
+				}
+				schema.Rpcs = append(schema.Rpcs, rpc)
+			}
+		}
+	}
+	return schema, nil
+}
+
+// 将 Schema 转为 etree 文档
+func (schema *Schema) ToDocument() *etree.Document {
+	doc := etree.NewDocument()
+	for _, procInst := range schema.procInsts {
+		doc.CreateProcInst(procInst[0], procInst[1])
+	}
+	categories := append([]string{}, schema.categories...)
+	for _, category := range GetCategoryList() {
+		if !schema.hasCategory(category) && schema.hasUnits(category) {
+			categories = append(categories, category)
+		}
+	}
+	for _, category := range categories {
+		if other, ok := schema.others[category]; ok {
+			doc.AddChild(other.Copy())
+			continue
+		}
+		cataElem := doc.CreateElement(category)
+		switch category {
+		case CategoryEnum:
+			for _, enum := range schema.Enums {
+				cataElem.AddChild(enum.ToElement())
+			}
+		case CategoryData, CategoryProtocol:
+			for _, msg := range schema.GetMessageList(category) {
+				cataElem.AddChild(msg.ToElement())
+			}
+		case CategoryRpc:
+			for _, rpc := range schema.Rpcs {
+				cataElem.AddChild(rpc.ToElement())
+			}
+		}
+	}
+	return doc
+}
+
+// 确保所有分类都会被写出, 即使为空
+func (schema *Schema) EnsureCategories() {
+	for _, category := range GetCategoryList() {
+		if !schema.hasCategory(category) {
+			schema.categories = append(schema.categories, category)
+		}
+	}
+}
+
+func (schema *Schema) hasCategory(category string) bool {
+	for _, v := range schema.categories {
+		if v == category {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownCategory(category string) bool {
+	for _, v := range GetCategoryList() {
+		if v == category {
+			return true
+		}
+	}
+	return false
+}
+
+func (schema *Schema) hasUnits(category string) bool {
+	return len(schema.GetUnitNames(category)) > 0
+}
+
+// 从单元节点解析枚举
+func EnumFromElement(elem *etree.Element) *Enum {
+	enum := &Enum{Name: elem.Tag}
+	enum.Comment, enum.hasComment = readUnitComment(elem)
+	enum.Values = readFieldList(elem)
+	enum.Reserved = readReservedList(elem)
+	return enum
+}
+
+// 将枚举转为单元节点
+func (enum *Enum) ToElement() *etree.Element {
+	elem := etree.NewElement(enum.Name)
+	if enum.hasComment || enum.Comment != "" {
+		elem.CreateComment(enum.Comment)
+	}
+	for _, value := range enum.Values {
+		value.writeAttrs(elem.CreateElement(enum.Name), enumAttrKeys)
+	}
+	writeReservedList(elem, enum.Reserved)
+	return elem
+}
+
+// 从单元节点解析消息
+func MessageFromElement(elem *etree.Element) *Message {
+	msg := &Message{Name: elem.Tag}
+	msg.Comment, msg.hasComment = readUnitComment(elem)
+	msg.Fields = readFieldList(elem)
+	msg.Reserved = readReservedList(elem)
+	for _, child := range elem.ChildElements() {
+		nestedType := child.SelectAttrValue(AttrNestedType, "")
+		if nestedType == NestedTypeEnum {
+			msg.Enums = append(msg.Enums, EnumFromElement(child))
+		} else if nestedType == NestedTypeMessage {
+			msg.Messages = append(msg.Messages, MessageFromElement(child))
+		}
+	}
+	return msg
+}
+
+// 将消息转为单元节点
+func (msg *Message) ToElement() *etree.Element {
+	elem := etree.NewElement(msg.Name)
+	msg.writeBody(elem)
+	return elem
+}
+
+func (msg *Message) writeBody(elem *etree.Element) {
+	if msg.hasComment || msg.Comment != "" {
+		elem.CreateComment(msg.Comment)
+	}
+	// 嵌套类型写在字段前面
+	for _, enum := range msg.Enums {
+		enumElem := enum.ToElement()
+		enumElem.CreateAttr(AttrNestedType, NestedTypeEnum)
+		elem.AddChild(enumElem)
+	}
+	for _, nested := range msg.Messages {
+		nestedElem := nested.ToElement()
+		nestedElem.CreateAttr(AttrNestedType, NestedTypeMessage)
+		elem.AddChild(nestedElem)
+	}
+	for _, field := range msg.Fields {
+		field.writeAttrs(elem.CreateElement(elem.Tag), messageAttrKeys)
+	}
+	writeReservedList(elem, msg.Reserved)
+}
+
+// 从单元节点解析 rpc
+func RpcFromElement(elem *etree.Element) (*Rpc, error) {
+	rpc := &Rpc{Name: elem.Tag}
+	rpc.Comment, rpc.hasComment = readUnitComment(elem)
+	for _, child := range elem.ChildElements() {
+		rpcType := child.SelectAttr(AttrRpcType)
+		if rpcType == nil {
+			return nil, errors.New("rpc " + elem.Tag + " does not have RpcType")
+		}
+		msg := MessageFromElement(child)
+		if rpcType.Value == RpcTypeReq {
+			rpc.Req = msg
+		} else if rpcType.Value == RpcTypeAck {
+			rpc.Ack = msg
+		} else {
+			return nil, errors.New("rpc " + elem.Tag + " has invalid RpcType: " + rpcType.Value)
+		}
+	}
+	return rpc, nil
+}
+
+// 将 rpc 转为单元节点
+func (rpc *Rpc) ToElement() *etree.Element {
+	elem := etree.NewElement(rpc.Name)
+	if rpc.hasComment || rpc.Comment != "" {
+		elem.CreateComment(rpc.Comment)
+	}
+	if rpc.Req != nil {
+		reqElem := elem.CreateElement(rpc.Name)
+		reqElem.CreateAttr(AttrRpcType, RpcTypeReq)
+		rpc.Req.writeBody(reqElem)
+	}
+	if rpc.Ack != nil {
+		ackElem := elem.CreateElement(rpc.Name)
+		ackElem.CreateAttr(AttrRpcType, RpcTypeAck)
+		rpc.Ack.writeBody(ackElem)
+	}
+	return elem
+}
+
+// 读取单元的第一个注释
+func readUnitComment(elem *etree.Element) (string, bool) {
+	for _, child := range elem.Child {
+		if comment, ok := child.(*etree.Comment); ok {
+			return comment.Data, true
+		}
+	}
+	return "", false
+}
+
+// 读取单元中与单元同名的行
+func readFieldList(elem *etree.Element) []Field {
+	result := []Field{}
+	for _, child := range elem.ChildElements() {
+		if child.Tag != elem.Tag || child.SelectAttr(AttrNestedType) != nil || child.SelectAttr(AttrReserved) != nil {
+			continue
+		}
+		result = append(result, fieldFromElement(child))
+	}
+	return result
+}
+
+// 读取单元中的保留行
+func readReservedList(elem *etree.Element) []Reserved {
+	var result []Reserved
+	for _, child := range elem.ChildElements() {
+		if child.Tag != elem.Tag || child.SelectAttr(AttrReserved) == nil {
+			continue
+		}
+		result = append(result, Reserved{
+			EntryIndex: child.SelectAttrValue(AttrEntryIndex, ""),
+			EntryName:  child.SelectAttrValue(AttrEntryName, ""),
+		})
+	}
+	return result
+}
+
+// 保留行写在字段后面, 为空的属性不写入
+func writeReservedList(elem *etree.Element, reservedList []Reserved) {
+	for _, reserved := range reservedList {
+		reservedElem := elem.CreateElement(elem.Tag)
+		reservedElem.CreateAttr(AttrReserved, ReservedTrue)
+		if reserved.EntryIndex != "" {
+			reservedElem.CreateAttr(AttrEntryIndex, reserved.EntryIndex)
+		}
+		if reserved.EntryName != "" {
+			reservedElem.CreateAttr(AttrEntryName, reserved.EntryName)
+		}
+	}
+}
+
+func fieldFromElement(elem *etree.Element) Field {
+	var field Field
+	for _, attr := range elem.Attr {
+		field.attrs = append(field.attrs, attr.Key)
+		if value := field.attrValue(attr.Key); value != nil {
+			*value = attr.Value
+		} else {
+			if field.extra == nil {
+				field.extra = map[string]string{}
+			}
+			field.extra[attr.Key] = attr.Value
+		}
+	}
+	return field
+}
+
+// 获取属性名对应的字段值
+func (field *Field) attrValue(key string) *string {
+	switch key {
+	case AttrEntryOption:
+		return &field.EntryOption
+	case AttrEntryKeyType:
+		return &field.EntryKeyType
+	case AttrEntryType:
+		return &field.EntryType
+	case AttrEntryName:
+		return &field.EntryName
+	case AttrEntryIndex:
+		return &field.EntryIndex
+	case AttrEntryDefault:
+		return &field.EntryDefault
+	case AttrEntryComment:
+		return &field.EntryComment
+	case AttrEntryOneof:
+		return &field.EntryOneof
+	}
+	return nil
+}
+
+// 写入属性. 读取过的字段按原顺序回写, 新字段按 keys 的顺序写入
+func (field Field) writeAttrs(elem *etree.Element, keys []string) {
+	if field.attrs == nil {
+		for _, key := range keys {
+			if *field.attrValue(key) != "" || !isOmitEmptyAttr(key) {
+				elem.CreateAttr(key, *field.attrValue(key))
+			}
+		}
+		return
+	}
+	written := map[string]bool{}
+	for _, key := range field.attrs {
+		written[key] = true
+		if value := field.attrValue(key); value != nil {
+			if *value != "" || !isOmitEmptyAttr(key) {
+				elem.CreateAttr(key, *value)
+			}
+		} else {
+			elem.CreateAttr(key, field.extra[key])
+		}
+	}
+	for _, key := range keys {
+		if !written[key] && *field.attrValue(key) != "" {
+			elem.CreateAttr(key, *field.attrValue(key))
+		}
+	}
+}
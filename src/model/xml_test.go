@@ -0,0 +1,145 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+// 嵌套类型, map, oneof, 保留项和注释
+const testDataXml = `<enum>
+    <ItemType>
+        <!--物品类型-->
+        <ItemType EntryName="ItemType_None" EntryIndex="0" EntryComment=""/>
+        <ItemType EntryName="ItemType_Weapon" EntryIndex="1" EntryComment=""/>
+        <ItemType Reserved="true" EntryIndex="5 to 8"/>
+        <ItemType Reserved="true" EntryIndex="100 to max"/>
+        <ItemType Reserved="true" EntryName="ItemType_Old"/>
+    </ItemType>
+</enum>
+<data>
+    <Bag>
+        <Kind NestedType="enum">
+            <Kind EntryName="Kind_None" EntryIndex="0" EntryComment=""/>
+        </Kind>
+        <Slot NestedType="message">
+            <!--嵌套-->
+            <Slot EntryOption="optional" EntryType="int32" EntryName="pos" EntryIndex="1" EntryDefault="" EntryComment=""/>
+            <Slot EntryOption="optional" EntryType="ItemType" EntryName="type" EntryIndex="2" EntryDefault="ItemType_None" EntryComment=""/>
+        </Slot>
+        <Bag EntryOption="map" EntryKeyType="string" EntryType="Bag.Slot" EntryName="slot_map" EntryIndex="1" EntryDefault="" EntryComment="格子"/>
+        <Bag EntryOption="optional" EntryType="Bag.Kind" EntryName="kind" EntryIndex="3" EntryDefault="Kind_None" EntryComment=""/>
+        <Bag EntryOption="optional" EntryType="uint64" EntryName="role_id" EntryIndex="4" EntryDefault="" EntryComment="" EntryOneof="owner"/>
+        <Bag EntryOption="optional" EntryType="string" EntryName="guild_name" EntryIndex="5" EntryDefault="" EntryComment="" EntryOneof="owner"/>
+        <Bag Reserved="true" EntryIndex="9"/>
+        <Bag Reserved="true" EntryName="old_field"/>
+    </Bag>
+</data>
+<protocol/>
+<rpc/>
+`
+
+// rpc, 属性顺序不同的字段, 无法识别的属性和分类
+const testRpcXml = `<?xml version="1.0" encoding="UTF-8"?>
+<enum/>
+<data>
+    <Role>
+        <Role EntryName="id" EntryOption="optional" EntryType="int32" EntryIndex="1" EntryDefault="" EntryComment="" Extra="keep"/>
+    </Role>
+</data>
+<protocol/>
+<rpc>
+    <CS_GetAccount>
+        <!--获取账号-->
+        <CS_GetAccount RpcType="Req">
+            <CS_GetAccount EntryOption="optional" EntryType="string" EntryName="accountname" EntryIndex="1" EntryDefault="" EntryComment=""/>
+        </CS_GetAccount>
+        <CS_GetAccount RpcType="Ack">
+            <CS_GetAccount EntryOption="repeated" EntryType="Role" EntryName="rolelist" EntryIndex="2" EntryDefault="" EntryComment=""/>
+        </CS_GetAccount>
+    </CS_GetAccount>
+</rpc>
+<other>
+    <Unknown Attr="1"/>
+</other>
+`
+
+func TestSchemaRoundTrip(t *testing.T) {
+	for _, strXml := range []string{testDataXml, testRpcXml} {
+		schema, err := LoadSchemaFromReader(strings.NewReader(strXml))
+		if err != nil {
+			t.Fatalf("LoadSchemaFromReader failed. err: %v", err)
+		}
+		data, err := schema.ToBytes()
+		if err != nil {
+			t.Fatalf("ToBytes failed. err: %v", err)
+		}
+		if string(data) != strXml {
+			t.Errorf("round trip changed the xml.\nwant:\n%s\ngot:\n%s", strXml, data)
+		}
+	}
+}
+
+func TestSchemaFromDocument(t *testing.T) {
+	schema, err := LoadSchemaFromReader(strings.NewReader(testDataXml))
+	if err != nil {
+		t.Fatalf("LoadSchemaFromReader failed. err: %v", err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"enum count", len(schema.Enums), 1},
+		{"enum comment", schema.Enums[0].Comment, "物品类型"},
+		{"enum values", len(schema.Enums[0].Values), 2},
+		{"enum reserved", schema.Enums[0].Reserved[1].EntryIndex, "100 to max"},
+		{"nested enum", schema.FindEnumByPath("Bag.Kind") != nil, true},
+		{"nested message", schema.FindMessageByPath("Bag.Slot").Comment, "嵌套"},
+		{"map key", schema.Datas[0].Fields[0].EntryKeyType, "string"},
+		{"map", schema.Datas[0].Fields[0].IsMap(), true},
+		{"oneof", schema.Datas[0].Fields[2].EntryOneof, "owner"},
+		{"reserved name", schema.Datas[0].Reserved[1].EntryName, "old_field"},
+		{"category", schema.FindCategory("Bag"), CategoryData},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestSchemaClone(t *testing.T) {
+	schema, err := LoadSchemaFromReader(strings.NewReader(testRpcXml))
+	if err != nil {
+		t.Fatalf("LoadSchemaFromReader failed. err: %v", err)
+	}
+	clone, err := schema.Clone()
+	if err != nil {
+		t.Fatalf("Clone failed. err: %v", err)
+	}
+	want, _ := schema.ToBytes()
+	got, _ := clone.ToBytes()
+	if string(got) != string(want) {
+		t.Fatalf("clone is different.\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	// 修改拷贝不影响原数据
+	tests := []struct {
+		name   string
+		modify func(schema *Schema)
+	}{
+		{"field", func(schema *Schema) { schema.Datas[0].Fields[0].EntryName = "changed" }},
+		{"rpc", func(schema *Schema) { schema.Rpcs[0].Ack.Fields = nil }},
+		{"reserved", func(schema *Schema) {
+			schema.Datas[0].Reserved = AddReserved(schema.Datas[0].Reserved, Reserved{EntryIndex: "2"})
+		}},
+		{"comment", func(schema *Schema) { schema.Rpcs[0].SetComment("changed") }},
+	}
+	for _, test := range tests {
+		clone, _ := schema.Clone()
+		test.modify(clone)
+		if got, _ := schema.ToBytes(); string(got) != string(want) {
+			t.Errorf("%s: modifying the clone changed the original", test.name)
+		}
+	}
+}
@@ -26,6 +26,37 @@ var LogConf = struct {
 
 // Init logrus logger.
 func InitLogger(LogLevel string) error {
+	initLogFormatAndLevel(LogLevel)
+
+	// 实现日志滚动。
+	// Refer to https://www.cnblogs.com/jssyjam/p/11845475.html.
+	logger := &lumberjack.Logger{
+		Filename:   fmt.Sprintf("%v/%v", LogConf.Dir, LogConf.Name), // 日志输出文件路径。
+		MaxSize:    LogConf.MaxSize,                                 // 日志文件最大 size(MB)，缺省 100MB。
+		MaxBackups: 100,                                             // 最大过期日志保留的个数。
+		MaxAge:     30,                                              // 保留过期文件的最大时间间隔，单位是天。
+		LocalTime:  true,                                            // 是否使用本地时间来命名备份的日志。
+	}
+	writers := []io.Writer{
+		logger,
+		os.Stdout}
+	//同时写文件和屏幕
+	fileAndStdoutWriter := io.MultiWriter(writers...)
+	logrus.SetOutput(fileAndStdoutWriter)
+	logrus.WithField("LogLevel", LogLevel).Info("InitLogger done.")
+	return nil
+}
+
+// 命令行模式的日志, 只输出到 stderr, 不写日志文件, 不影响命令在 stdout 的输出
+func InitCliLogger(LogLevel string) error {
+	initLogFormatAndLevel(LogLevel)
+	logrus.SetOutput(os.Stderr)
+	logrus.WithField("LogLevel", LogLevel).Info("InitCliLogger done.")
+	return nil
+}
+
+// 设置日志格式和级别
+func initLogFormatAndLevel(LogLevel string) {
 	// 设置日志格式。
 	logrus.SetFormatter(&CustomTextFormatter{
 		TimestampFormat: "2006-01-02 15:04:05.000",
@@ -54,24 +85,6 @@ func InitLogger(LogLevel string) error {
 		logrus.SetLevel(logrus.PanicLevel)
 	}
 	logrus.SetReportCaller(true) // 打印文件、行号和主调函数。
-
-	// 实现日志滚动。
-	// Refer to https://www.cnblogs.com/jssyjam/p/11845475.html.
-	logger := &lumberjack.Logger{
-		Filename:   fmt.Sprintf("%v/%v", LogConf.Dir, LogConf.Name), // 日志输出文件路径。
-		MaxSize:    LogConf.MaxSize,                                 // 日志文件最大 size(MB)，缺省 100MB。
-		MaxBackups: 100,                                             // 最大过期日志保留的个数。
-		MaxAge:     30,                                              // 保留过期文件的最大时间间隔，单位是天。
-		LocalTime:  true,                                            // 是否使用本地时间来命名备份的日志。
-	}
-	writers := []io.Writer{
-		logger,
-		os.Stdout}
-	//同时写文件和屏幕
-	fileAndStdoutWriter := io.MultiWriter(writers...)
-	logrus.SetOutput(fileAndStdoutWriter)
-	logrus.WithField("LogLevel", LogLevel).Info("InitLogger done.")
-	return nil
 }
 
 // 自定义格式化器，继承自 logrus.TextFormatter